@@ -1,6 +1,6 @@
 import numpy as np
 import matplotlib.pyplot as plt
-import cmath, colorsys, math, sys, os
+import cmath, colorsys, math, struct, sys, os
 
 columns = []
 meta = []
@@ -62,9 +62,34 @@ def running_mean(x, N):
    cumsum = np.cumsum(np.insert(x, 0, 0)) 
    return (cumsum[N:] - cumsum[:-N]) / N
 
+# Header written by file.WriteCQHeader, returns (fields, offset of the columns), or (None, 0) if legacy.
+def readHeader(inputFile):
+    with open(inputFile, 'rb') as f:
+        start = f.read(8)
+        if len(start) < 8 or start[:4] != 'GOCQ':
+            return None, 0
+        version, size = struct.unpack('<HH', start[4:])
+        names = ['sampleRate', 'octaves', 'minFrequency', 'bpo', 'q', 'atomHopFactor',
+            'window', 'latency', 'layout', 'columnCount']
+        fields = f.read(size)
+        values = struct.unpack('<dididdiiBq', fields[:57])
+        header = dict(zip(names, values))
+        header['version'] = version
+        header['codec'] = 0
+        if version >= 2:
+            header['codec'], header['magnitudeBits'], header['phaseBits'] = struct.unpack('<BBB', fields[57:60])
+        return header, 8 + size
+
 def readFile(inputFile='out.cq'):
-    global columns
-    values = np.memmap(inputFile, dtype=np.complex64, mode="r")
+    global columns, bpo, octaves, MASK
+    header, offset = readHeader(inputFile)
+    if header is not None:
+        print "Header: %s" % header
+        bpo, octaves = header['bpo'], header['octaves']
+        MASK = 1 << (octaves - 1)
+        if header['codec'] != 0:
+            raise ValueError("Only complex64 CQ files can be read, not codec %d" % header['codec'])
+    values = np.memmap(inputFile, dtype=np.complex64, mode="r", offset=offset)
 
     at = 0
     columnCounter = MASK
@@ -86,30 +111,44 @@ def readFile(inputFile='out.cq'):
     columns = columns[:at]
     print "%d columns read" % (len(columns))
 
+# Peaks written by writecq.go -peaks, as 0/1 per CQ value to line up with the columns.
 def readMeta(inputFile='out.meta'):
     global meta
-    values = np.memmap(inputFile, dtype=np.int8, mode="r")
+    peaks = np.genfromtxt(inputFile, delimiter=',', names=True)
+    peaks = np.atleast_1d(peaks)
+    peakColumns = peaks['column'].astype(int)
+    peakBins = peaks['bin'].astype(int)
 
-    at = 0
+    columnCount = len(columns) if len(columns) > 0 else np.max(peakColumns) + 1
+    meta = []
     columnCounter = MASK
-    columnsRead = 0
-    while at < len(values):
-        samplesInColumn = trailingZeros(columnCounter) * bpo
-        meta.append(values[at:at+samplesInColumn])
+    for i in range(columnCount):
+        meta.append(np.zeros(trailingZeros(columnCounter) * bpo, dtype=np.int8))
         columnCounter = (columnCounter % MASK) + 1
-        at = at + samplesInColumn
-
-        columnsRead = columnsRead + 1
-        if columnsRead % 10000 == 0:
-            print "%d columns read" % columnsRead
-
-    # Normalize: find last full size column
-    at = -1
-    while len(meta[at]) != octaves * bpo:
-        at -= 1
-    meta = meta[:at]
+    for (column, bin) in zip(peakColumns, peakBins):
+        if column < columnCount and bin < len(meta[column]):
+            meta[column][bin] = 1
+    print "%d peaks read" % (len(peaks))
+
+    # Normalize: find last full size column, unless already matching the CQ data.
+    if len(columns) == 0:
+        at = -1
+        while len(meta[at]) != octaves * bpo:
+            at -= 1
+        meta = meta[:at]
     print "%d columns read" % (len(meta))
 
+# Features written by writefeatures.go, e.g. readFeatures()['centroid']
+def readFeatures(inputFile='out.features.csv'):
+    if inputFile.endswith('.json'):
+        import json
+        with open(inputFile) as f:
+            loaded = json.load(f)
+        result = dict((name, np.array(values)) for (name, values) in loaded['features'].items())
+        result['time'] = np.array(loaded['time'])
+        return result
+    return np.genfromtxt(inputFile, delimiter=',', names=True)
+
 def readFileAndMeta():
     readFile()
     readMeta()
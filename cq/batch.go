@@ -0,0 +1,126 @@
+package cq
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ProcessBatch transforms a whole sound at once, splitting it into chunks that are processed
+// in parallel. The output is identical to that of ConstantQ.ProcessChannel on the same samples.
+//
+// Each chunk is processed by its own ConstantQ, starting a little before the chunk so the
+// decimators and buffers warm up with the same input as the sequential transform, and
+// continuing a little after so all of its columns are complete. Chunks are aligned to whole
+// big blocks, so the column heights and decimator phases line up when they're stitched together.
+//
+// For example, to use one chunk per CPU:
+//  columns := cq.ProcessBatch(params, samples, runtime.NumCPU())
+func ProcessBatch(params CQParams, samples []float64, chunks int) [][]complex128 {
+	if chunks < 1 {
+		panic("ProcessBatch needs at least one chunk")
+	}
+	kernel := cachedKernel(params)
+	p := kernel.Properties
+
+	// Input samples consumed, and columns output, by each big block.
+	bigBlockSamples := p.fftHop * unsafeShift(p.octaves-1)
+	bigBlockColumns := p.atomsPerFrame * unsafeShift(p.octaves-1)
+	overlap := roundUpTo(newConstantQ(kernel).warmUpSamples(), bigBlockSamples)
+	chunkSize := roundUpTo((len(samples)+chunks-1)/chunks, bigBlockSamples)
+
+	starts := []int{}
+	for start := 0; start == 0 || start+overlap < len(samples); start += chunkSize {
+		starts = append(starts, start)
+	}
+
+	results := make([][][]complex128, len(starts), len(starts))
+	var wg sync.WaitGroup
+	wg.Add(len(starts))
+	for i := range starts {
+		go func(i int) {
+			defer wg.Done()
+			start, from := starts[i], maxInt(0, starts[i]-overlap)
+			last := i == len(starts)-1
+
+			to := len(samples)
+			if !last {
+				to = minInt(start+chunkSize+overlap, len(samples))
+			}
+			constantQ := newConstantQ(kernel)
+			columns := constantQ.Process(samples[from:to])
+			if last {
+				columns = append(columns, constantQ.GetRemainingOutput()...)
+			}
+
+			skip := (start - from) / bigBlockSamples * bigBlockColumns
+			keep := len(columns) - skip
+			if !last {
+				keep = chunkSize / bigBlockSamples * bigBlockColumns
+			}
+			if skip+keep > len(columns) {
+				panic(fmt.Sprintf("CQ chunk at %d produced %d columns, needed %d", start, len(columns), skip+keep))
+			}
+			results[i] = columns[skip : skip+keep]
+		}(i)
+	}
+	wg.Wait()
+
+	out := [][]complex128{}
+	for _, columns := range results {
+		out = append(out, columns...)
+	}
+	return out
+}
+
+// InverseBatch converts a whole set of raw columns, as output by ProcessBatch, back into samples.
+// Unlike CQInverse, the output is aligned with the original input rather than lagging it, and
+// contains every sample the columns cover, len(columns) * SamplesPerColumn() - OutputLatency.
+func InverseBatch(params CQParams, columns [][]complex128) []float64 {
+	kernel := cachedKernel(params)
+	p := kernel.Properties
+	latency := newConstantQ(kernel).OutputLatency
+	inverse := NewCQInverse(params)
+	length := maxInt(0, len(columns)*p.atomSpacing-latency)
+
+	// Pad the end with silent columns, so the inverse outputs every sample the columns cover,
+	// and only gets whole blocks.
+	blockWidth := p.atomsPerFrame * unsafeShift(p.octaves-1)
+	end := roundUpTo(len(columns)+(inverse.OutputLatency+p.atomSpacing-1)/p.atomSpacing, blockWidth)
+	columns = columns[:len(columns):len(columns)]
+	for at := len(columns); at < end; at++ {
+		columns = append(columns, make([]complex128, RawColumnOctaves(p.octaves, at)*p.binsPerOctave))
+	}
+	samples := append(inverse.Process(columns), inverse.GetRemainingOutput()...)
+
+	// The output lags the original input by both transforms' latencies.
+	samples = samples[minInt(latency+inverse.OutputLatency, len(samples)):]
+	if len(samples) > length {
+		return samples[:length]
+	}
+	return append(samples, make([]float64, length-len(samples))...)
+}
+
+// warmUpSamples is how many input samples it takes before the output no longer depends on
+// the initial state: the latency padding in each octave's buffer, plus the decimator's filter.
+func (cq *ConstantQ) warmUpSamples() int {
+	octaves := cq.kernel.Properties.octaves
+	fftSize := cq.kernel.Properties.fftSize
+
+	warmUp := 0
+	for i := 0; i < octaves; i++ {
+		samples := (len(cq.buffers[i]) + fftSize) * unsafeShift(i)
+		if i > 0 {
+			samples += cq.decimators[i].GetFilterLength()
+		}
+		warmUp = maxInt(warmUp, samples)
+	}
+	return warmUp
+}
+
+// roundUpTo rounds a positive value up to the next multiple of step.
+func roundUpTo(value int, step int) int {
+	if value < step {
+		return step
+	}
+	return (value + step - 1) / step * step
+}
@@ -0,0 +1,96 @@
+package cq
+
+// Column is one output column of a transform, along with where it sits in the input.
+type Column struct {
+	// Values of the bins the column covers, highest frequency first.
+	Values []complex128
+
+	// Position of the column within the stream, starting at 0.
+	Index int
+
+	// Input sample the column is centred on, compensated for the transform's latency.
+	// Negative for the columns output before the start of the input.
+	Sample int
+
+	// Number of octaves the column covers, counting down from the top octave.
+	// Raw ConstantQ columns vary (see RawColumnOctaves), Spectrogram columns cover them all.
+	Octaves int
+}
+
+// ColumnTiming describes where each column of a stream sits in time.
+type ColumnTiming struct {
+	SampleRate       float64
+	SamplesPerColumn int
+
+	// Number of samples the columns lag behind the input.
+	Latency int
+
+	BinsPerOctave int
+}
+
+// NewColumnTiming returns the timing of columns from a transform with the given parameters,
+// that lag the input by latency samples.
+func NewColumnTiming(params CQParams, latency int) ColumnTiming {
+	return ColumnTiming{
+		params.SampleRate(),
+		cachedKernel(params).Properties.atomSpacing,
+		latency,
+		params.BinsPerOctave,
+	}
+}
+
+// Sample returns the input sample that a column is centred on.
+func (t ColumnTiming) Sample(index int) int {
+	return index*t.SamplesPerColumn - t.Latency
+}
+
+// Seconds returns the time within the input, in seconds, that a column is centred on.
+func (t ColumnTiming) Seconds(index int) float64 {
+	return float64(t.Sample(index)) / t.SampleRate
+}
+
+// Index returns the column centred nearest to an input sample.
+func (t ColumnTiming) Index(sample int) int {
+	return round(float64(sample+t.Latency) / float64(t.SamplesPerColumn))
+}
+
+// Annotate attaches the timing to each column of a stream, raw or full height.
+//
+// For example, to find when the loudest top bin happens:
+//  for column := range constantQ.Timing().Annotate(columns) {
+//    if cmplx.Abs(column.Values[0]) > loudest { loudest, when = cmplx.Abs(column.Values[0]), column.Sample }
+//  }
+func (t ColumnTiming) Annotate(columns <-chan []complex128) <-chan Column {
+	result := make(chan Column)
+	go func() {
+		index := 0
+		for values := range columns {
+			result <- Column{values, index, t.Sample(index), len(values) / t.BinsPerOctave}
+			index++
+		}
+		close(result)
+	}()
+	return result
+}
+
+// ColumnValues strips the timing from a stream of columns, leaving just their values.
+func ColumnValues(columns <-chan Column) <-chan []complex128 {
+	result := make(chan []complex128)
+	go func() {
+		for column := range columns {
+			result <- column.Values
+		}
+		close(result)
+	}()
+	return result
+}
+
+// RawColumnOctaves returns how many octaves, from the top, are in a raw ConstantQ column.
+// Every column has the top octave, every second column the next, and so on, until every
+// 2^(octaves - 1)th column, starting with the first, covers all of them.
+func RawColumnOctaves(octaves int, index int) int {
+	if index%unsafeShift(octaves-1) == 0 {
+		return octaves
+	}
+	return TerminalZeros(index) + 1
+}
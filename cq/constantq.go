@@ -5,6 +5,8 @@ import (
 	"math"
 
 	"github.com/mjibson/go-dsp/fft"
+
+	"github.com/padster/go-sound/resample"
 )
 
 const DEBUG_CQ = false
@@ -17,11 +19,15 @@ type ConstantQ struct {
 	buffers       [][]float64
 
 	latencies  []int
-	decimators []*Resampler
+	decimators []*resample.Resampler
 }
 
 func NewConstantQ(params CQParams) *ConstantQ {
-	kernel := NewCQKernel(params)
+	return newConstantQ(cachedKernel(params))
+}
+
+// newConstantQ creates a transform using an existing kernel, which is only ever read so can be shared.
+func newConstantQ(kernel *CQKernel) *ConstantQ {
 	p := kernel.Properties
 
 	// Use exact powers of two for resampling rates. They don't have
@@ -31,7 +37,7 @@ func NewConstantQ(params CQParams) *ConstantQ {
 	// risk getting non-integer rates for lower octaves
 	sourceRate := unsafeShift(p.octaves)
 	latencies := make([]int, p.octaves, p.octaves)
-	decimators := make([]*Resampler, p.octaves, p.octaves)
+	decimators := make([]*resample.Resampler, p.octaves, p.octaves)
 
 	// top octave, no resampling
 	latencies[0] = 0
@@ -40,7 +46,7 @@ func NewConstantQ(params CQParams) *ConstantQ {
 	for i := 1; i < p.octaves; i++ {
 		factor := unsafeShift(i)
 
-		r := NewResampler(sourceRate, sourceRate/factor, 50, 0.05)
+		r := resample.NewResampler(sourceRate, sourceRate/factor, 50, 0.05)
 		if DEBUG_CQ {
 			fmt.Printf("Forward: octave %d: resample from %v to %v\n", i, sourceRate, sourceRate/factor)
 		}
@@ -197,37 +203,43 @@ func (cq *ConstantQ) ProcessChannel(samples <-chan float64) <-chan []complex128
 	return result
 }
 
+// Process adds more samples, and returns the columns that can now be calculated.
+// Each octave is decimated and transformed concurrently, the output is identical to
+// processing them one after the other.
 func (cq *ConstantQ) Process(td []float64) [][]complex128 {
 	apf := cq.kernel.Properties.atomsPerFrame
 	bpo := cq.kernel.Properties.binsPerOctave
 	octaves := cq.kernel.Properties.octaves
-	fftSize := cq.kernel.Properties.fftSize
-
-	cq.buffers[0] = append(cq.buffers[0], td...)
-	for i := 1; i < octaves; i++ {
-		decimated := cq.decimators[i].Process(td)
-		cq.buffers[i] = append(cq.buffers[i], decimated...)
-	}
 
-	out := [][]complex128{}
-	for {
-		// We could have quite different remaining sample counts in
-		// different octaves, because (apart from the predictable
-		// added counts for decimator output on each block) we also
-		// have variable additional latency per octave
-		enough := true
-		for i := 0; i < octaves; i++ {
-			required := fftSize * unsafeShift(octaves-i-1)
-			if len(cq.buffers[i]) < required {
-				enough = false
-			}
+	forEachOctave(octaves, func(octave int) {
+		if octave == 0 {
+			cq.buffers[0] = append(cq.buffers[0], td...)
+		} else {
+			decimated := cq.decimators[octave].Process(td)
+			cq.buffers[octave] = append(cq.buffers[octave], decimated...)
 		}
-		if !enough {
-			break
+	})
+
+	// We could have quite different remaining sample counts in
+	// different octaves, because (apart from the predictable
+	// added counts for decimator output on each block) we also
+	// have variable additional latency per octave
+	bigBlocks := cq.availableBigBlocks()
+
+	// blocks[octave] are all the blocks for that octave, in order.
+	blocks := make([][][][]complex128, octaves, octaves)
+	forEachOctave(octaves, func(octave int) {
+		count := bigBlocks * unsafeShift(octaves-octave-1)
+		blocks[octave] = make([][][]complex128, count, count)
+		for b := 0; b < count; b++ {
+			blocks[octave][b] = cq.processOctaveBlock(octave)
 		}
+	})
 
+	totalColumns := unsafeShift(octaves-1) * apf
+	out := make([][]complex128, 0, bigBlocks*totalColumns)
+	for bigBlock := 0; bigBlock < bigBlocks; bigBlock++ {
 		base := len(out)
-		totalColumns := unsafeShift(octaves-1) * apf
 
 		// Pre-fill totalColumns number of empty arrays
 		out = append(out, make([][]complex128, totalColumns, totalColumns)...)
@@ -236,7 +248,7 @@ func (cq *ConstantQ) Process(td []float64) [][]complex128 {
 			blocksThisOctave := unsafeShift(octaves - octave - 1)
 
 			for b := 0; b < blocksThisOctave; b++ {
-				block := cq.processOctaveBlock(octave)
+				block := blocks[octave][bigBlock*blocksThisOctave+b]
 
 				for j := 0; j < apf; j++ {
 					target := base + (b*(totalColumns/blocksThisOctave) + (j * ((totalColumns / blocksThisOctave) / apf)))
@@ -257,6 +269,28 @@ func (cq *ConstantQ) Process(td []float64) [][]complex128 {
 	return out
 }
 
+// availableBigBlocks is how many times every octave has enough buffered input for all its
+// blocks, i.e. how many sets of totalColumns columns can be output.
+func (cq *ConstantQ) availableBigBlocks() int {
+	octaves := cq.kernel.Properties.octaves
+	fftHop := cq.kernel.Properties.fftHop
+	fftSize := cq.kernel.Properties.fftSize
+
+	available := -1
+	for i := 0; i < octaves; i++ {
+		required := fftSize * unsafeShift(octaves-i-1)
+		consumed := fftHop * unsafeShift(octaves-i-1)
+		count := 0
+		if len(cq.buffers[i]) >= required {
+			count = (len(cq.buffers[i])-required)/consumed + 1
+		}
+		if available == -1 || count < available {
+			available = count
+		}
+	}
+	return available
+}
+
 func (cq *ConstantQ) GetRemainingOutput() [][]complex128 {
 	// Same as padding added at start, though rounded up
 	pad := roundUp(float64(cq.OutputLatency)/float64(cq.bigBlockSize)) * cq.bigBlockSize
@@ -273,7 +307,7 @@ func (cq *ConstantQ) processOctaveBlock(octave int) [][]complex128 {
 	cv := fft.FFTReal(cq.buffers[octave][:fftSize])
 	cq.buffers[octave] = cq.buffers[octave][fftHop:]
 
-	cqrowvec := cq.kernel.processForward(cv)
+	cqrowvec := cq.kernel.processForward(octave, cv)
 	// Reform into a column matrix
 	cqblock := make([][]complex128, apf, apf)
 	for j := 0; j < apf; j++ {
@@ -290,6 +324,23 @@ func (cq *ConstantQ) BinCount() int {
 	return cq.kernel.BinCount()
 }
 
+// SamplesPerColumn returns the number of input samples between consecutive output columns.
+// Column i of the output is centred on input sample (i * SamplesPerColumn() - OutputLatency).
+func (cq *ConstantQ) SamplesPerColumn() int {
+	return cq.kernel.Properties.atomSpacing
+}
+
+// Timing returns where each output column sits in the input.
+func (cq *ConstantQ) Timing() ColumnTiming {
+	p := cq.kernel.Properties
+	return ColumnTiming{p.sampleRate, p.atomSpacing, cq.OutputLatency, p.binsPerOctave}
+}
+
+// ProcessColumns is ProcessChannel, with each column annotated by its timing.
+func (cq *ConstantQ) ProcessColumns(samples <-chan float64) <-chan Column {
+	return cq.Timing().Annotate(cq.ProcessChannel(samples))
+}
+
 func (cq *ConstantQ) bpo() int {
 	return cq.kernel.Properties.binsPerOctave
 }
@@ -0,0 +1,365 @@
+package cq
+
+// go test github.com/padster/go-sound/cq
+
+import (
+	"math"
+	"math/cmplx"
+	"math/rand"
+	"testing"
+)
+
+const testSampleRate = 44100.0
+
+// Default parameters used by the command line tools.
+func testParams() CQParams {
+	return NewCQParams(testSampleRate, 7, 55.0, 24)
+}
+
+// Reference values for the default parameters. If these change, so does every CQ file written.
+func TestKernelReference(t *testing.T) {
+	params := testParams()
+	kernel := NewCQKernel(params)
+	p := kernel.Properties
+
+	expectInt(t, "fftSize", p.fftSize, 512)
+	expectInt(t, "fftHop", p.fftHop, 108)
+	expectInt(t, "atomsPerFrame", p.atomsPerFrame, 2)
+	expectInt(t, "atomSpacing", p.atomSpacing, 54)
+	expectInt(t, "firstCentre", p.firstCentre, 216)
+	expectInt(t, "OutputLatency", NewConstantQ(params).OutputLatency, 32576)
+
+	// First and last values of the sparse kernel rows.
+	data, origin := kernel.kernel.data, kernel.kernel.origin
+	last := len(data) - 1
+	expectInt(t, "kernel rows", len(data), 48)
+	expectInt(t, "first row origin", origin[0], 36)
+	expectInt(t, "first row length", len(data[0]), 13)
+	expectInt(t, "last row origin", origin[last], 67)
+	expectInt(t, "last row length", len(data[last]), 30)
+	expectClose(t, "first kernel value", cmplx.Abs(data[0][0]-complex(0.0003381268569, 0.0002818543944)), 0, 1e-12)
+	expectClose(t, "last kernel value", cmplx.Abs(data[last][len(data[last])-1]-complex(0.0003368244848, 6.401027756e-05)), 0, 1e-12)
+
+	// The kernel's sparsity is what dominates the cost of the transform.
+	nonZero, sumAbs := 0, 0.0
+	for _, row := range kernel.kernel.data {
+		nonZero += len(row)
+		for _, v := range row {
+			sumAbs += cmplx.Abs(v)
+		}
+	}
+	expectInt(t, "kernel non-zero values", nonZero, 1042)
+	expectClose(t, "kernel sum of magnitudes", sumAbs, 45.7688634, 1e-8)
+	expectClose(t, "top bin frequency", params.BinFrequency(0), 7040, 1e-9)
+}
+
+// Sines at the centre of each octave should come back almost exactly.
+func TestRoundTripSines(t *testing.T) {
+	params := testParams()
+	for octave := 0; octave < params.Octaves; octave++ {
+		hz := params.MinFrequency() * math.Pow(2.0, float64(octave)+0.5)
+		input := make([]float64, int(testSampleRate*2))
+		for i := range input {
+			input[i] = 0.5 * math.Sin(2.0*math.Pi*hz*float64(i)/testSampleRate)
+		}
+		snr := roundTripSNR(params, input)
+		t.Logf("Octave %d (%.0fHz): SNR %.1fdB", octave, hz, snr)
+		if snr < 50 {
+			t.Errorf("Octave %d sine reconstructed with SNR %.1fdB, expected at least 50dB", octave, snr)
+		}
+	}
+}
+
+// A chirp sweeping across the whole range.
+func TestRoundTripChirp(t *testing.T) {
+	params := testParams()
+	input := make([]float64, int(testSampleRate*3))
+	low, high := params.MinFrequency()*1.5, params.BinFrequency(0)/1.5
+	phase := 0.0
+	for i := range input {
+		at := float64(i) / float64(len(input))
+		phase += 2.0 * math.Pi * low * math.Pow(high/low, at) / testSampleRate
+		input[i] = 0.5 * math.Sin(phase)
+	}
+	snr := roundTripSNR(params, input)
+	t.Logf("Chirp: SNR %.1fdB", snr)
+	if snr < 50 {
+		t.Errorf("Chirp reconstructed with SNR %.1fdB, expected at least 50dB", snr)
+	}
+}
+
+// Noise made from many random sines within the range of the transform.
+func TestRoundTripNoise(t *testing.T) {
+	params := testParams()
+	r := rand.New(rand.NewSource(1))
+	input := make([]float64, int(testSampleRate*2))
+	for n := 0; n < 50; n++ {
+		hz := params.MinFrequency() * math.Pow(2.0, 0.5+r.Float64()*(float64(params.Octaves)-1.0))
+		phase := r.Float64() * 2.0 * math.Pi
+		for i := range input {
+			input[i] += 0.01 * math.Sin(2.0*math.Pi*hz*float64(i)/testSampleRate+phase)
+		}
+	}
+	snr := roundTripSNR(params, input)
+	t.Logf("Noise: SNR %.1fdB", snr)
+	if snr < 50 {
+		t.Errorf("Noise reconstructed with SNR %.1fdB, expected at least 50dB", snr)
+	}
+}
+
+// Variable-Q atoms are much shorter in the low octaves, but should still reconstruct as well.
+func TestRoundTripVariableQ(t *testing.T) {
+	for _, gamma := range []float64{2, 10} {
+		params := NewCQParams(testSampleRate, 7, 55.0, 24, WithBandwidthOffset(gamma))
+		for octave := 0; octave < params.Octaves; octave++ {
+			hz := params.MinFrequency() * math.Pow(2.0, float64(octave)+0.5)
+			// Longer than the constant Q tests, as the inverse has more latency.
+			input := make([]float64, int(testSampleRate*3))
+			for i := range input {
+				input[i] = 0.5 * math.Sin(2.0*math.Pi*hz*float64(i)/testSampleRate)
+			}
+			snr := roundTripSNR(params, input)
+			t.Logf("Gamma %v, octave %d (%.0fHz): SNR %.1fdB", gamma, octave, hz, snr)
+			if snr < 50 {
+				t.Errorf("Gamma %v, octave %d sine reconstructed with SNR %.1fdB, expected at least 50dB", gamma, octave, snr)
+			}
+		}
+	}
+}
+
+// An impulse should appear in the column centred on it, and be reconstructed at the right time.
+func TestImpulseAlignment(t *testing.T) {
+	params := testParams()
+	at := int(testSampleRate)
+	input := make([]float64, at*2)
+	input[at] = 0.9
+
+	constantQ := NewConstantQ(params)
+	columns := forward(constantQ, input)
+
+	// Column c is centred on sample c * SamplesPerColumn() - OutputLatency.
+	loudest, loudestColumn := 0.0, -1
+	for c, column := range columns {
+		if v := cmplx.Abs(column[0]); v > loudest {
+			loudest, loudestColumn = v, c
+		}
+	}
+	expected := round(float64(at+constantQ.OutputLatency) / float64(constantQ.SamplesPerColumn()))
+	if loudestColumn < expected-1 || loudestColumn > expected+1 {
+		t.Errorf("Impulse at sample %d loudest in column %d, expected %d", at, loudestColumn, expected)
+	}
+
+	inverse := NewCQInverse(params)
+	output := invert(inverse, columns)
+	latency := constantQ.OutputLatency + inverse.OutputLatency
+	peak, peakAt := 0.0, -1
+	for i, v := range output {
+		if math.Abs(v) > peak {
+			peak, peakAt = math.Abs(v), i-latency
+		}
+	}
+	t.Logf("Impulse at %d: loudest column %d (expected %d), reconstructed at %d", at, loudestColumn, expected, peakAt)
+	if peakAt < at-2 || peakAt > at+2 {
+		t.Errorf("Impulse at sample %d reconstructed at %d", at, peakAt)
+	}
+}
+
+// Octaves are processed concurrently, and batches in chunks, but the output must not change.
+func TestDeterministicOutput(t *testing.T) {
+	params := NewCQParams(testSampleRate, 4, 110.0, 12)
+	r := rand.New(rand.NewSource(2))
+	input := make([]float64, int(testSampleRate*4)+123)
+	for i := range input {
+		input[i] = r.Float64()*0.4 - 0.2
+	}
+
+	expected := forward(NewConstantQ(params), input)
+	again := forward(NewConstantQ(params), input)
+	if !sameColumns(expected, again) {
+		t.Errorf("ConstantQ output differs between runs")
+	}
+	for _, chunks := range []int{1, 2, 5} {
+		if batch := ProcessBatch(params, input, chunks); !sameColumns(expected, batch) {
+			t.Errorf("ProcessBatch with %d chunks differs from ConstantQ", chunks)
+		}
+	}
+}
+
+// Annotated columns should match the raw heights and the alignment of the transform.
+func TestColumnTiming(t *testing.T) {
+	params := NewCQParams(testSampleRate, 4, 110.0, 12)
+	input := make([]float64, int(testSampleRate))
+	samples := make(chan float64)
+	go func() {
+		for _, v := range input {
+			samples <- v
+		}
+		close(samples)
+	}()
+
+	constantQ := NewConstantQ(params)
+	timing := constantQ.Timing()
+	expectInt(t, "timing latency", timing.Latency, constantQ.OutputLatency)
+	index := 0
+	for column := range constantQ.ProcessColumns(samples) {
+		expectInt(t, "column index", column.Index, index)
+		expectInt(t, "column octaves", column.Octaves, RawColumnOctaves(params.Octaves, index))
+		expectInt(t, "column height", len(column.Values), column.Octaves*params.BinsPerOctave)
+		expectInt(t, "column sample", column.Sample, index*constantQ.SamplesPerColumn()-constantQ.OutputLatency)
+		expectInt(t, "nearest column", timing.Index(column.Sample), index)
+		index++
+	}
+	if index == 0 {
+		t.Errorf("No columns output")
+	}
+}
+
+// Baseline costs for the default parameters, about 20% above what they measured when set:
+// 1042 kernel non-zeros, ~30 allocations per forward column and ~26 per inverse column.
+// Timings depend on the machine so the benchmarks below are advisory; this is what fails
+// if a change makes the transforms do more work. Lower the budgets when they improve.
+const (
+	kernelNonZeroBudget       = 1250
+	forwardAllocsPerColumnMax = 36.0
+	inverseAllocsPerColumnMax = 31.0
+)
+
+func TestPerformanceBudget(t *testing.T) {
+	params := testParams()
+	nonZeros := 0
+	for _, row := range NewCQKernel(params).kernel.data {
+		nonZeros += len(row)
+	}
+	if nonZeros > kernelNonZeroBudget {
+		t.Errorf("Kernel has %d non-zeros, budget is %d", nonZeros, kernelNonZeroBudget)
+	}
+
+	input := make([]float64, int(testSampleRate))
+	for i := range input {
+		input[i] = math.Sin(float64(i) * 0.03)
+	}
+
+	// Measure once warmed up, so the buffers are already full.
+	constantQ := NewConstantQ(params)
+	constantQ.Process(input)
+	columnCount := 0
+	allocs := testing.AllocsPerRun(5, func() {
+		columnCount = len(constantQ.Process(input))
+	})
+	if perColumn := allocs / float64(columnCount); perColumn > forwardAllocsPerColumnMax {
+		t.Errorf("Forward transform makes %.1f allocations per column, budget is %.1f", perColumn, forwardAllocsPerColumnMax)
+	}
+
+	inverse := NewCQInverse(params)
+	columns := forward(NewConstantQ(params), input)
+	blockWidth := inverse.kernel.Properties.atomsPerFrame * unsafeShift(params.Octaves-1)
+	columns = columns[:len(columns)/blockWidth*blockWidth]
+	inverse.Process(columns)
+	allocs = testing.AllocsPerRun(5, func() {
+		inverse.Process(columns)
+	})
+	if perColumn := allocs / float64(len(columns)); perColumn > inverseAllocsPerColumnMax {
+		t.Errorf("Inverse transform makes %.1f allocations per column, budget is %.1f", perColumn, inverseAllocsPerColumnMax)
+	}
+}
+
+func BenchmarkConstantQ(b *testing.B) {
+	params := testParams()
+	input := make([]float64, int(testSampleRate))
+	for i := range input {
+		input[i] = math.Sin(float64(i) * 0.03)
+	}
+	constantQ := NewConstantQ(params)
+	b.SetBytes(int64(len(input) * 8))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		constantQ.Process(input)
+	}
+}
+
+func BenchmarkCQInverse(b *testing.B) {
+	params := testParams()
+	input := make([]float64, int(testSampleRate))
+	for i := range input {
+		input[i] = math.Sin(float64(i) * 0.03)
+	}
+	columns := forward(NewConstantQ(params), input)
+	inverse := NewCQInverse(params)
+	blockWidth := inverse.kernel.Properties.atomsPerFrame * unsafeShift(params.Octaves-1)
+	columns = columns[:len(columns)/blockWidth*blockWidth]
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		inverse.Process(columns)
+	}
+}
+
+func BenchmarkKernel(b *testing.B) {
+	params := testParams()
+	for i := 0; i < b.N; i++ {
+		NewCQKernel(params)
+	}
+}
+
+// roundTripSNR transforms the input and back, returning the reconstruction's signal to noise
+// ratio in dB, ignoring the first and last quarters to avoid the edges.
+func roundTripSNR(params CQParams, input []float64) float64 {
+	constantQ, inverse := NewConstantQ(params), NewCQInverse(params)
+	output := invert(inverse, forward(constantQ, input))
+	latency := constantQ.OutputLatency + inverse.OutputLatency
+
+	signal, noise := 0.0, 0.0
+	for i := len(input) / 4; i < len(input)*3/4; i++ {
+		diff := output[i+latency] - input[i]
+		signal += input[i] * input[i]
+		noise += diff * diff
+	}
+	return 10.0 * math.Log10(signal/noise)
+}
+
+// forward runs the whole input through a transform, in blocks as ProcessChannel does.
+func forward(constantQ *ConstantQ, input []float64) [][]complex128 {
+	columns := [][]complex128{}
+	for at := 0; at < len(input); at += 4096 {
+		columns = append(columns, constantQ.Process(input[at:minInt(at+4096, len(input))])...)
+	}
+	return append(columns, constantQ.GetRemainingOutput()...)
+}
+
+// invert runs all the columns back through an inverse transform.
+func invert(inverse *CQInverse, columns [][]complex128) []float64 {
+	blockWidth := inverse.kernel.Properties.atomsPerFrame * unsafeShift(inverse.kernel.Properties.octaves-1)
+	output := []float64{}
+	for at := 0; at+blockWidth <= len(columns); at += blockWidth {
+		output = append(output, inverse.Process(columns[at:at+blockWidth])...)
+	}
+	return append(output, inverse.GetRemainingOutput()...)
+}
+
+func sameColumns(a [][]complex128, b [][]complex128) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if len(a[i]) != len(b[i]) {
+			return false
+		}
+		for j := range a[i] {
+			if a[i][j] != b[i][j] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func expectInt(t *testing.T, name string, actual int, expected int) {
+	if actual != expected {
+		t.Errorf("%s = %d, expected %d", name, actual, expected)
+	}
+}
+
+func expectClose(t *testing.T, name string, actual float64, expected float64, tolerance float64) {
+	if math.Abs(actual-expected) > tolerance*math.Max(1.0, math.Abs(expected)) {
+		t.Errorf("%s = %v, expected %v", name, actual, expected)
+	}
+}
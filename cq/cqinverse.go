@@ -5,6 +5,8 @@ import (
 	"math/cmplx"
 
 	"github.com/mjibson/go-dsp/fft"
+
+	"github.com/padster/go-sound/resample"
 )
 
 const DEBUG_CQI = false
@@ -17,11 +19,11 @@ type CQInverse struct {
 	olaBufs       [][]float64
 
 	latencies  []int
-	upsamplers []*Resampler
+	upsamplers []*resample.Resampler
 }
 
 func NewCQInverse(params CQParams) *CQInverse {
-	kernel := NewCQKernel(params)
+	kernel := cachedKernel(params)
 	p := kernel.Properties
 
 	// Use exact powers of two for resampling rates. They don't have
@@ -31,7 +33,7 @@ func NewCQInverse(params CQParams) *CQInverse {
 	// risk getting non-integer rates for lower octaves
 	sourceRate := unsafeShift(p.octaves)
 	latencies := make([]int, p.octaves, p.octaves)
-	upsamplers := make([]*Resampler, p.octaves, p.octaves)
+	upsamplers := make([]*resample.Resampler, p.octaves, p.octaves)
 
 	// top octave, no resampling
 	latencies[0] = 0
@@ -40,7 +42,7 @@ func NewCQInverse(params CQParams) *CQInverse {
 	for i := 1; i < p.octaves; i++ {
 		factor := unsafeShift(i)
 
-		r := NewResampler(sourceRate/factor, sourceRate, 50, 0.05)
+		r := resample.NewResampler(sourceRate/factor, sourceRate, 50, 0.05)
 
 		if DEBUG_CQI {
 			fmt.Printf("Inverse: octave %d: resample from %d to %d\n", i, sourceRate/factor, sourceRate)
@@ -202,7 +204,9 @@ func (cqi *CQInverse) Process(block [][]complex128) []float64 {
 	// rate
 	//
 	// 6. Sum the resampled streams and return
-	for i := 0; i < octaves; i++ {
+	//
+	// Steps 1 to 5 only touch that octave's state, so run concurrently.
+	forEachOctave(octaves, func(i int) {
 		// Step 1
 		oct := make([][]complex128, 0)
 
@@ -218,7 +222,7 @@ func (cqi *CQInverse) Process(block [][]complex128) []float64 {
 
 		// Steps 2, 3, 4, 5
 		cqi.processOctave(i, oct)
-	}
+	})
 
 	// Step 6
 	return cqi.drawFromBuffers()
@@ -255,7 +259,7 @@ func (cqi *CQInverse) GetRemainingOutput() []float64 {
 	fftHop := cqi.kernel.Properties.fftHop
 	octaves := cqi.kernel.Properties.octaves
 
-	for j := 0; j < octaves; j++ {
+	forEachOctave(octaves, func(j int) {
 		factor := unsafeShift(j)
 		latency := 0
 		if j > 0 {
@@ -266,7 +270,7 @@ func (cqi *CQInverse) GetRemainingOutput() []float64 {
 			padding := make([]float64, len(cqi.olaBufs[j]), len(cqi.olaBufs[j]))
 			cqi.overlapAddAndResample(j, padding)
 		}
-	}
+	})
 	return cqi.drawFromBuffers()
 }
 
@@ -312,7 +316,7 @@ func (cqi *CQInverse) processOctaveColumn(octave int, column []complex128) {
 		panic("Invalid argument to inverse processOctaveColumn")
 	}
 
-	transformed := cqi.kernel.ProcessInverse(column)
+	transformed := cqi.kernel.ProcessInverse(octave, column)
 
 	// For inverse real transforms, force symmetric conjugate representation first.
 	for i := fftSize/2 + 1; i < fftSize; i++ {
@@ -1,5 +1,10 @@
 package cq
 
+import (
+	"fmt"
+	"math"
+)
+
 type Window int
 
 const (
@@ -11,6 +16,14 @@ const (
 	Hann
 )
 
+const (
+	// Defaults for the parameters that NewCQParams doesn't require.
+	DefaultQ             = 1.0
+	DefaultAtomHopFactor = 0.25
+	DefaultThreshold     = 0.0005
+	DefaultWindow        = SqrtBlackmanHarris
+)
+
 type CQParams struct {
 	sampleRate    float64
 	Octaves       int
@@ -29,21 +42,140 @@ type CQParams struct {
 
 	// Window shape for kernal atoms.
 	window Window
+
+	// Extra bandwidth (in Hz) added to every bin, making it a variable-Q transform.
+	// 0 is constant Q, larger values shorten the atoms of low bins for better time resolution.
+	bandwidthOffset float64
 }
 
-func NewCQParams(sampleRate float64, octaves int, minFreq float64, binsPerOctave int) CQParams {
-	if minFreq < 0 || octaves < 1 {
-		panic("Requires frequencies 0 < min, octaves ")
-	}
+// CQOption sets one of the optional parameters of a transform.
+type CQOption func(p *CQParams)
+
+// WithQ sets the spectral atom bandwidth scaling, in (0, 1]. Default 1, best for reconstruction.
+func WithQ(q float64) CQOption {
+	return func(p *CQParams) { p.q = q }
+}
+
+// WithAtomHopFactor sets the hop between temporal atoms, as a fraction of the shortest atom.
+func WithAtomHopFactor(atomHopFactor float64) CQOption {
+	return func(p *CQParams) { p.atomHopFactor = atomHopFactor }
+}
 
-	return CQParams{
+// WithThreshold sets the magnitude below which kernel values are zeroed, trading accuracy for speed.
+func WithThreshold(threshold float64) CQOption {
+	return func(p *CQParams) { p.threshold = threshold }
+}
+
+// WithWindow sets the window shape of the kernel atoms.
+func WithWindow(window Window) CQOption {
+	return func(p *CQParams) { p.window = window }
+}
+
+// WithBandwidthOffset turns the transform into a variable-Q transform (VQT), by adding a constant
+// bandwidth in Hz to every bin. This shortens the atoms in the low bins, improving their time
+// resolution at the cost of frequency resolution, while high bins are barely changed.
+func WithBandwidthOffset(hz float64) CQOption {
+	return func(p *CQParams) { p.bandwidthOffset = hz }
+}
+
+// NewCQParams creates the parameters for a transform covering octaves from minFreq upwards,
+// with any options applied over the defaults. Panics if the resulting parameters are invalid.
+//
+// For example, a variable-Q transform with more time resolution in the bass:
+//  params := cq.NewCQParams(44100, 7, 55.0, 24, cq.WithBandwidthOffset(10), cq.WithWindow(cq.SqrtHann))
+func NewCQParams(sampleRate float64, octaves int, minFreq float64, binsPerOctave int, options ...CQOption) CQParams {
+	params := CQParams{
 		sampleRate,
 		octaves,
 		minFreq,
 		binsPerOctave,
-		1.0,                /* Q scaling factor */
-		0.25,               /* hop size of shortest temporal atom. */
-		0.0005,             /* sparcity threshold for resulting kernal. */
-		SqrtBlackmanHarris, /* window shape */
+		DefaultQ,             /* Q scaling factor */
+		DefaultAtomHopFactor, /* hop size of shortest temporal atom. */
+		DefaultThreshold,     /* sparcity threshold for resulting kernal. */
+		DefaultWindow,        /* window shape */
+		0.0,                  /* bandwidth offset */
+	}
+	for _, option := range options {
+		option(&params)
+	}
+	if err := params.validate(); err != nil {
+		panic(err)
 	}
+	return params
+}
+
+// NewCQParamsForRange creates the parameters for a transform covering minFreq to maxFreq,
+// using as many whole octaves above minFreq as needed to include maxFreq.
+func NewCQParamsForRange(sampleRate float64, minFreq float64, maxFreq float64, binsPerOctave int, options ...CQOption) CQParams {
+	if minFreq <= 0 || maxFreq <= minFreq {
+		panic(fmt.Sprintf("CQ range requires 0 < minFreq < maxFreq, not %v to %v", minFreq, maxFreq))
+	}
+	octaves := int(math.Ceil(math.Log2(maxFreq/minFreq) - 1e-9))
+	return NewCQParams(sampleRate, octaves, minFreq, binsPerOctave, options...)
+}
+
+// validate returns an error describing the first invalid parameter, if any.
+func (p CQParams) validate() error {
+	switch {
+	case p.sampleRate <= 0:
+		return fmt.Errorf("CQ sample rate must be positive, not %v", p.sampleRate)
+	case p.Octaves < 1 || p.BinsPerOctave < 1:
+		return fmt.Errorf("CQ requires at least one octave and bin per octave, not %d and %d", p.Octaves, p.BinsPerOctave)
+	case p.minFrequency <= 0:
+		return fmt.Errorf("CQ min frequency must be positive, not %v", p.minFrequency)
+	case p.BinFrequency(0) >= p.sampleRate/2.0:
+		return fmt.Errorf("CQ top frequency %v must be below the Nyquist frequency %v", p.BinFrequency(0), p.sampleRate/2.0)
+	case p.q <= 0 || p.q > 1:
+		return fmt.Errorf("CQ q must be in (0, 1], not %v", p.q)
+	case p.atomHopFactor <= 0 || p.atomHopFactor > 1:
+		return fmt.Errorf("CQ atom hop factor must be in (0, 1], not %v", p.atomHopFactor)
+	case p.threshold < 0:
+		return fmt.Errorf("CQ kernel threshold must not be negative, not %v", p.threshold)
+	case p.window < SqrtBlackmanHarris || p.window > Hann:
+		return fmt.Errorf("Unknown CQ window %d", p.window)
+	case p.bandwidthOffset < 0 || math.IsNaN(p.bandwidthOffset):
+		return fmt.Errorf("CQ bandwidth offset must not be negative, not %v", p.bandwidthOffset)
+	}
+	return nil
+}
+
+// SampleRate returns the sample rate (in Hz) of the input being transformed.
+func (p CQParams) SampleRate() float64 {
+	return p.sampleRate
+}
+
+// MinFrequency returns the frequency (in Hz) at the bottom of the lowest octave.
+func (p CQParams) MinFrequency() float64 {
+	return p.minFrequency
+}
+
+// Q returns the spectral atom bandwidth scaling.
+func (p CQParams) Q() float64 {
+	return p.q
+}
+
+// AtomHopFactor returns the hop size between temporal atoms, as a fraction of the shortest atom.
+func (p CQParams) AtomHopFactor() float64 {
+	return p.atomHopFactor
+}
+
+// Window returns the window shape of the kernel atoms.
+func (p CQParams) Window() Window {
+	return p.window
+}
+
+// Threshold returns the magnitude below which kernel values are zeroed.
+func (p CQParams) Threshold() float64 {
+	return p.threshold
+}
+
+// BandwidthOffset returns the bandwidth (in Hz) added to every bin, 0 for a constant Q transform.
+func (p CQParams) BandwidthOffset() float64 {
+	return p.bandwidthOffset
+}
+
+// BinFrequency returns the centre frequency (in Hz) of a bin within the output columns.
+// Bin 0 is the highest frequency, with each following bin 1/BinsPerOctave octaves lower.
+func (p CQParams) BinFrequency(bin int) float64 {
+	return p.minFrequency * math.Pow(2.0, float64(p.Octaves)-float64(bin)/float64(p.BinsPerOctave))
 }
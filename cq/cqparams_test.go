@@ -0,0 +1,91 @@
+package cq
+
+// go test github.com/padster/go-sound/cq
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCQParamsOptions(t *testing.T) {
+	params := NewCQParams(testSampleRate, 7, 55.0, 24,
+		WithQ(0.8), WithAtomHopFactor(0.5), WithThreshold(0.001), WithWindow(SqrtHann), WithBandwidthOffset(3))
+	if params.Q() != 0.8 || params.AtomHopFactor() != 0.5 || params.Threshold() != 0.001 ||
+		params.Window() != SqrtHann || params.BandwidthOffset() != 3 {
+		t.Errorf("Options not applied: %+v", params)
+	}
+
+	defaults := NewCQParams(testSampleRate, 7, 55.0, 24)
+	if defaults.Q() != DefaultQ || defaults.AtomHopFactor() != DefaultAtomHopFactor || defaults.Threshold() != DefaultThreshold ||
+		defaults.Window() != DefaultWindow || defaults.BandwidthOffset() != 0 {
+		t.Errorf("Unexpected defaults: %+v", defaults)
+	}
+}
+
+func TestCQParamsRejectsInvalid(t *testing.T) {
+	tests := []struct {
+		name          string
+		sampleRate    float64
+		octaves       int
+		minFreq       float64
+		binsPerOctave int
+		option        CQOption
+	}{
+		{"zero sample rate", 0, 7, 55.0, 24, nil},
+		{"no octaves", testSampleRate, 0, 55.0, 24, nil},
+		{"no bins", testSampleRate, 7, 55.0, 0, nil},
+		{"zero min frequency", testSampleRate, 7, 0, 24, nil},
+		{"above Nyquist", testSampleRate, 9, 55.0, 24, nil},
+		{"zero q", testSampleRate, 7, 55.0, 24, WithQ(0)},
+		{"q above 1", testSampleRate, 7, 55.0, 24, WithQ(1.5)},
+		{"zero atom hop", testSampleRate, 7, 55.0, 24, WithAtomHopFactor(0)},
+		{"atom hop above 1", testSampleRate, 7, 55.0, 24, WithAtomHopFactor(1.1)},
+		{"negative threshold", testSampleRate, 7, 55.0, 24, WithThreshold(-1)},
+		{"unknown window", testSampleRate, 7, 55.0, 24, WithWindow(Hann + 1)},
+		{"negative bandwidth offset", testSampleRate, 7, 55.0, 24, WithBandwidthOffset(-1)},
+		{"NaN bandwidth offset", testSampleRate, 7, 55.0, 24, WithBandwidthOffset(math.NaN())},
+	}
+	for _, test := range tests {
+		options := []CQOption{}
+		if test.option != nil {
+			options = append(options, test.option)
+		}
+		if !panics(func() { NewCQParams(test.sampleRate, test.octaves, test.minFreq, test.binsPerOctave, options...) }) {
+			t.Errorf("Expected %s to panic", test.name)
+		}
+	}
+}
+
+// Ranges use as many octaves as needed to reach maxFreq, so an exact number of octaves isn't rounded up.
+func TestCQParamsForRange(t *testing.T) {
+	tests := []struct {
+		minFreq, maxFreq float64
+		octaves          int
+	}{
+		{55.0, 55.0 * 128, 7}, // As used by the mashapp.
+		{55.0, 55.0*128 + 1, 8},
+		{55.0, 110.0, 1},
+		{55.0, 56.0, 1},
+		{27.5, 4186.0, 8}, // A piano.
+	}
+	for _, test := range tests {
+		params := NewCQParamsForRange(testSampleRate, test.minFreq, test.maxFreq, 12, WithQ(0.9))
+		if params.Octaves != test.octaves || params.MinFrequency() != test.minFreq || params.Q() != 0.9 {
+			t.Errorf("%v to %v: got %d octaves from %v, expected %d", test.minFreq, test.maxFreq, params.Octaves, params.MinFrequency(), test.octaves)
+		}
+	}
+
+	for _, bounds := range [][2]float64{{0, 100}, {100, 100}, {200, 100}} {
+		if !panics(func() { NewCQParamsForRange(testSampleRate, bounds[0], bounds[1], 12) }) {
+			t.Errorf("Expected range %v to %v to panic", bounds[0], bounds[1])
+		}
+	}
+}
+
+func panics(f func()) (result bool) {
+	defer func() {
+		result = recover() != nil
+	}()
+	f()
+	return false
+}
@@ -0,0 +1,215 @@
+package cq
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+	"math/rand"
+	"runtime"
+)
+
+// PhaseInit is how GriffinLim picks the phases it starts from.
+type PhaseInit int
+
+const (
+	// Uniformly random phases.
+	RandomPhase PhaseInit = iota
+	// The phases of the input values, e.g. from a lossy file, or a previous reconstruction.
+	KeepPhase
+	// Phase gradient heap integration (see HeapIntegrate), only for STFT columns.
+	HeapPhase
+)
+
+// GriffinLim reconstructs phases for columns where only the magnitudes are reliable, e.g. after
+// editing magnitudes, or reading a file stored without phase. Starting from an initial guess, it
+// repeatedly converts the columns to samples and back, each time keeping the new phases but
+// restoring the original magnitudes, converging towards a signal whose transform has those magnitudes.
+//
+// This uses the fast variant (Perraudin et al. 2013), which adds momentum between iterations.
+type GriffinLim struct {
+	// Iterations is the number of times to convert to samples and back. 0 only applies the
+	// initial phases, which for HeapPhase is often already good enough.
+	Iterations int
+
+	// Momentum of the fast Griffin-Lim algorithm, in [0, 1). 0 is the original algorithm.
+	Momentum float64
+
+	// Init is how the initial phases are chosen.
+	Init PhaseInit
+
+	// Seed for the random phases, so reconstructions are repeatable.
+	Seed int64
+
+	forward func(samples []float64) [][]complex128
+	inverse func(columns [][]complex128) []float64
+	heap    func(columns [][]complex128) [][]complex128
+
+	// Number of samples, for a number of columns.
+	samplesFor func(columns int) int
+}
+
+// NewSTFTGriffinLim creates a reconstruction for columns from an STFT with the given parameters.
+//
+// For example, to quickly turn STFT magnitudes back into a sound:
+//  gl := cq.NewSTFTGriffinLim(2048, 512, cq.SqrtHann)
+//  gl.Init, gl.Iterations = cq.HeapPhase, 0
+//  sound := s.WrapSliceAsSound(gl.ReconstructSamples(columns))
+func NewSTFTGriffinLim(fftSize int, hopSize int, window Window) *GriffinLim {
+	checkSTFTSizes(fftSize, hopSize)
+	return &GriffinLim{
+		32,   /* Iterations */
+		0.99, /* Momentum */
+		RandomPhase,
+		1, /* Seed */
+		func(samples []float64) [][]complex128 {
+			stft := NewSTFT(fftSize, hopSize, window)
+			return append(stft.Process(samples), stft.GetRemainingOutput()...)
+		},
+		func(columns [][]complex128) []float64 {
+			istft := NewISTFT(fftSize, hopSize, window)
+			return append(istft.Process(columns), istft.GetRemainingOutput()...)
+		},
+		func(columns [][]complex128) [][]complex128 {
+			return HeapIntegrate(columns, fftSize, hopSize, window)
+		},
+		func(columns int) int {
+			return columns * hopSize
+		},
+	}
+}
+
+// NewCQGriffinLim creates a reconstruction for raw columns from a ConstantQ with the given
+// parameters, which start at the transform's first output column.
+func NewCQGriffinLim(params CQParams) *GriffinLim {
+	kernel := cachedKernel(params)
+	p := kernel.Properties
+	latency := newConstantQ(kernel).OutputLatency
+
+	return &GriffinLim{
+		32,   /* Iterations */
+		0.99, /* Momentum */
+		RandomPhase,
+		1, /* Seed */
+		func(samples []float64) [][]complex128 {
+			return ProcessBatch(params, samples, runtime.NumCPU())
+		},
+		func(columns [][]complex128) []float64 {
+			return InverseBatch(params, columns)
+		},
+		nil, /* heap */
+		func(columns int) int {
+			return maxInt(0, columns*p.atomSpacing-latency)
+		},
+	}
+}
+
+// Reconstruct returns the columns with their magnitudes unchanged, and phases reconstructed.
+func (gl *GriffinLim) Reconstruct(columns [][]complex128) [][]complex128 {
+	if gl.Momentum < 0 || gl.Momentum >= 1 {
+		panic(fmt.Sprintf("Griffin-Lim momentum must be in [0, 1), not %v", gl.Momentum))
+	}
+	magnitudes := make([][]float64, len(columns), len(columns))
+	for i, column := range columns {
+		magnitudes[i] = make([]float64, len(column), len(column))
+		for j, v := range column {
+			magnitudes[i][j] = cmplx.Abs(v)
+		}
+	}
+
+	current := gl.initialPhases(columns, magnitudes)
+	var previous [][]complex128
+	accelerated := current
+	for i := 0; i < gl.Iterations; i++ {
+		projected := gl.project(withMagnitudes(accelerated, magnitudes), len(columns))
+		accelerated = projected
+		if previous != nil && gl.Momentum > 0 {
+			accelerated = make([][]complex128, len(projected), len(projected))
+			for c, column := range projected {
+				accelerated[c] = make([]complex128, len(column), len(column))
+				for b, v := range column {
+					accelerated[c][b] = v + complex(gl.Momentum, 0)*(v-previous[c][b])
+				}
+			}
+		}
+		previous = projected
+	}
+	return withMagnitudes(accelerated, magnitudes)
+}
+
+// ReconstructSamples reconstructs the phases, then converts the columns to samples.
+func (gl *GriffinLim) ReconstructSamples(columns [][]complex128) []float64 {
+	return gl.samples(gl.Reconstruct(columns), len(columns))
+}
+
+// Inconsistency measures how far columns are from being the transform of any signal, as the
+// relative difference in dB between their magnitudes and those of the transform of their samples.
+// It's -Inf for columns straight from a transform, and falls as reconstruction improves.
+func (gl *GriffinLim) Inconsistency(columns [][]complex128) float64 {
+	projected := gl.project(columns, len(columns))
+	difference, total := 0.0, 0.0
+	for c, column := range columns {
+		for b, v := range column {
+			d := cmplx.Abs(projected[c][b]) - cmplx.Abs(v)
+			difference += d * d
+			total += real(v)*real(v) + imag(v)*imag(v)
+		}
+	}
+	return 10.0 * math.Log10(difference/total)
+}
+
+func (gl *GriffinLim) initialPhases(columns [][]complex128, magnitudes [][]float64) [][]complex128 {
+	switch gl.Init {
+	case KeepPhase:
+		return columns
+	case HeapPhase:
+		if gl.heap == nil {
+			panic("Heap phase integration is only supported for STFT columns")
+		}
+		return gl.heap(columns)
+	}
+
+	r := rand.New(rand.NewSource(gl.Seed))
+	result := make([][]complex128, len(magnitudes), len(magnitudes))
+	for i, column := range magnitudes {
+		result[i] = make([]complex128, len(column), len(column))
+		for j, m := range column {
+			result[i][j] = cmplx.Rect(m, r.Float64()*TAU)
+		}
+	}
+	return result
+}
+
+// project converts columns to samples and back, giving the closest columns that are the transform of a signal.
+func (gl *GriffinLim) project(columns [][]complex128, count int) [][]complex128 {
+	transformed := gl.forward(gl.samples(columns, count))
+	if len(transformed) > count {
+		return transformed[:count]
+	}
+	// Shorter transforms are silent at the end, so pad with the same heights as the input.
+	for c := len(transformed); c < count; c++ {
+		transformed = append(transformed, make([]complex128, len(columns[c]), len(columns[c])))
+	}
+	return transformed
+}
+
+// samples converts columns to samples, exactly as many as the columns cover.
+func (gl *GriffinLim) samples(columns [][]complex128, count int) []float64 {
+	samples := gl.inverse(columns)
+	length := gl.samplesFor(count)
+	if len(samples) > length {
+		return samples[:length]
+	}
+	return append(samples, make([]float64, length-len(samples))...)
+}
+
+// withMagnitudes returns columns with the phases of values, and the given magnitudes.
+func withMagnitudes(values [][]complex128, magnitudes [][]float64) [][]complex128 {
+	result := make([][]complex128, len(values), len(values))
+	for i, column := range values {
+		result[i] = make([]complex128, len(column), len(column))
+		for j, v := range column {
+			result[i][j] = cmplx.Rect(magnitudes[i][j], cmplx.Phase(v))
+		}
+	}
+	return result
+}
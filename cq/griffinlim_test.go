@@ -0,0 +1,78 @@
+package cq
+
+import (
+	"math"
+	"math/cmplx"
+	"testing"
+)
+
+// Heap integration should find phases far closer to consistent than random ones.
+func TestHeapIntegrate(t *testing.T) {
+	columns := testSTFTColumns(1024, 256)
+	gl := NewSTFTGriffinLim(1024, 256, SqrtHann)
+
+	gl.Iterations = 0
+	random := gl.Inconsistency(gl.Reconstruct(columns))
+	heap := gl.Inconsistency(HeapIntegrate(columns, 1024, 256, SqrtHann))
+	if heap > -20 || heap > random-15 {
+		t.Errorf("Heap integration inconsistency is %.1fdB, with %.1fdB for random phases", heap, random)
+	}
+}
+
+func TestSTFTGriffinLimConverges(t *testing.T) {
+	gl := NewSTFTGriffinLim(1024, 256, SqrtHann)
+	expectConverges(t, "STFT", gl, testSTFTColumns(1024, 256), []int{0, 2, 8, 32})
+}
+
+func TestCQGriffinLimConverges(t *testing.T) {
+	params := NewCQParams(testSampleRate, 4, 110, 24)
+	columns := ProcessBatch(params, testGriffinLimSignal(), 4)
+	expectConverges(t, "CQ", NewCQGriffinLim(params), columns, []int{0, 2, 8})
+}
+
+// Columns straight from a transform are already consistent, so iterating shouldn't change them.
+func TestKeepPhaseOfConsistentColumns(t *testing.T) {
+	columns := testSTFTColumns(1024, 256)
+	gl := NewSTFTGriffinLim(1024, 256, SqrtHann)
+	gl.Init, gl.Iterations = KeepPhase, 4
+
+	reconstructed := gl.Reconstruct(columns)
+	for c, column := range columns {
+		for b, v := range column {
+			if cmplx.Abs(reconstructed[c][b]-v) > 1e-9 {
+				t.Fatalf("Column %d bin %d changed from %v to %v", c, b, v, reconstructed[c][b])
+			}
+		}
+	}
+}
+
+// expectConverges checks that more iterations give lower inconsistency, starting from random phases.
+func expectConverges(t *testing.T, name string, gl *GriffinLim, columns [][]complex128, iterations []int) {
+	previous := math.Inf(1)
+	for _, count := range iterations {
+		gl.Iterations = count
+		inconsistency := gl.Inconsistency(gl.Reconstruct(columns))
+		if inconsistency >= previous {
+			t.Errorf("%s: %d iterations give %.1fdB inconsistency, after %.1fdB with fewer", name, count, inconsistency, previous)
+		}
+		previous = inconsistency
+	}
+	if previous > -12 {
+		t.Errorf("%s: only reached %.1fdB inconsistency", name, previous)
+	}
+}
+
+// testGriffinLimSignal is a chirp with a steady tone, so the phases vary in both time and frequency.
+func testGriffinLimSignal() []float64 {
+	samples := make([]float64, 16384, 16384)
+	for i := range samples {
+		x := float64(i) / testSampleRate
+		samples[i] = 0.5*math.Sin(2*math.Pi*(300*x+400*x*x)) + 0.3*math.Sin(2*math.Pi*1250*x)
+	}
+	return samples
+}
+
+func testSTFTColumns(fftSize int, hopSize int) [][]complex128 {
+	stft := NewSTFT(fftSize, hopSize, SqrtHann)
+	return append(stft.Process(testGriffinLimSignal()), stft.GetRemainingOutput()...)
+}
@@ -34,6 +34,9 @@ type Kernel struct {
 type CQKernel struct {
 	Properties Properties
 	kernel     *Kernel
+
+	// For variable-Q transforms, the kernel for each octave. Nil when they all share kernel.
+	octaveKernels []*Kernel
 }
 
 // TODO - clean up a lot.
@@ -48,14 +51,17 @@ func NewCQKernel(params CQParams) *CQKernel {
 	// GenerateKernel
 	q := params.q
 	atomHopFactor := params.atomHopFactor
-	thresh := params.threshold
 	bpo := params.BinsPerOctave
 
 	p.Q = q / (math.Pow(2, 1.0/float64(bpo)) - 1.0)
 
-	maxNK := float64(int(math.Floor(p.Q*p.sampleRate/p.minFrequency + 0.5)))
+	// The top octave has the longest variable-Q atoms, so sets the FFT size for all octaves. Every
+	// octave shares the atom spacing, so it comes from the shortest atom of the bottom octave,
+	// whose offset is scaled up the most by decimation: otherwise its atoms wouldn't overlap.
+	offset := bandwidthOffset(params, 0)
+	maxNK := float64(int(math.Floor(p.Q*p.sampleRate/(p.minFrequency+offset) + 0.5)))
 	minNK := float64(int(math.Floor(p.Q*p.sampleRate/
-		(p.minFrequency*math.Pow(2.0, (float64(bpo)-1.0)/float64(bpo))) + 0.5)))
+		(p.minFrequency*math.Pow(2.0, (float64(bpo)-1.0)/float64(bpo))+bandwidthOffset(params, p.octaves-1)) + 0.5)))
 
 	if minNK == 0 || maxNK == 0 {
 		panic("Kernal minNK or maxNK is 0, can't make kernel")
@@ -78,6 +84,79 @@ func NewCQKernel(params CQParams) *CQKernel {
 		fmt.Printf("fftHop = %v\n", p.fftHop)
 	}
 
+	result := &CQKernel{p, buildKernel(p, params, offset), nil}
+	if params.bandwidthOffset > 0 {
+		result.octaveKernels = make([]*Kernel, p.octaves, p.octaves)
+		result.octaveKernels[0] = result.kernel
+		for octave := 1; octave < p.octaves; octave++ {
+			result.octaveKernels[octave] = buildKernel(p, params, bandwidthOffset(params, octave))
+		}
+		flattenResponse(p, result.octaveKernels)
+	}
+	return result
+}
+
+// flattenResponse rescales variable-Q kernels so that together they have a flat response. The
+// wide atoms of low bins overlap bins of the neighbouring octaves, which each octave's own
+// normalisation can't see, so without this those frequencies are reconstructed too loudly.
+func flattenResponse(p Properties, kernels []*Kernel) {
+	// Response of each octave at each FFT bin, the sum of the squared kernel values there.
+	responses := make([][]float64, len(kernels), len(kernels))
+	for octave, kernel := range kernels {
+		responses[octave] = make([]float64, p.fftSize, p.fftSize)
+		for i, row := range kernel.data {
+			for j, v := range row {
+				responses[octave][j+kernel.origin[i]] += real(v)*real(v) + imag(v)*imag(v)
+			}
+		}
+	}
+
+	// Total response at a frequency, in FFT bins of the top octave.
+	total := func(bin float64) float64 {
+		sum := 0.0
+		for octave, response := range responses {
+			at := bin * float64(unsafeShift(octave))
+			i := int(at)
+			if i+1 <= p.fftSize/2 {
+				frac := at - float64(i)
+				sum += response[i]*(1.0-frac) + response[i+1]*frac
+			}
+		}
+		return sum
+	}
+
+	// Each octave's normalisation makes its response fftHop / fftSize. Frequencies outside the
+	// transform's range fall away from that, and aren't boosted by more than 3dB.
+	target := float64(p.fftHop) / float64(p.fftSize)
+	for octave, kernel := range kernels {
+		gains := make([]float64, p.fftSize/2+1, p.fftSize/2+1)
+		for j := range gains {
+			gains[j] = math.Sqrt(target / math.Max(total(float64(j)/float64(unsafeShift(octave))), target/2.0))
+		}
+		for i, row := range kernel.data {
+			for j := range row {
+				if at := j + kernel.origin[i]; at < len(gains) {
+					row[j] = complexTimes(row[j], gains[at])
+				}
+			}
+		}
+	}
+}
+
+// bandwidthOffset converts the variable-Q bandwidth offset into an offset to the frequencies
+// of the kernel's atoms. Lower octaves are decimated, so need their offset scaled up.
+func bandwidthOffset(params CQParams, octave int) float64 {
+	alpha := math.Pow(2, 1.0/float64(params.BinsPerOctave)) - 1.0
+	return params.bandwidthOffset * float64(unsafeShift(octave)) / alpha
+}
+
+// buildKernel creates the sparse kernel for one octave, where each atom's length is as if
+// its frequency were offset higher.
+func buildKernel(p Properties, params CQParams, offset float64) *Kernel {
+	q := params.q
+	thresh := params.threshold
+	bpo := params.BinsPerOctave
+
 	dataSize := p.binsPerOctave * p.atomsPerFrame
 
 	kernel := Kernel{
@@ -86,14 +165,21 @@ func NewCQKernel(params CQParams) *CQKernel {
 	}
 
 	for k := 1; k <= p.binsPerOctave; k++ {
-		nk := round(p.Q * p.sampleRate / (p.minFrequency * math.Pow(2, ((float64(k)-1.0)/float64(bpo)))))
+		nk := round(p.Q * p.sampleRate / (p.minFrequency*math.Pow(2, ((float64(k)-1.0)/float64(bpo))) + offset))
 		win := makeWindow(params.window, nk)
 		fk := float64(p.minFrequency * math.Pow(2, ((float64(k)-1.0)/float64(bpo))))
 
+		// Shorter atoms overlap more of their neighbours in frequency, so are scaled down to keep
+		// the total response flat. This is 1 for constant Q atoms.
+		scale := math.Sqrt(float64(nk) * fk / (p.Q * p.sampleRate))
+		if offset == 0 {
+			scale = 1.0
+		}
+
 		cmplxs := make([]complex128, nk, nk)
 		for i := 0; i < nk; i++ {
 			arg := (2.0 * math.Pi * fk * float64(i)) / p.sampleRate
-			cmplxs[i] = cmplx.Rect(win[i], arg)
+			cmplxs[i] = cmplx.Rect(win[i]*scale, arg)
 		}
 
 		atomOffset := p.firstCentre - roundUp(float64(nk)/2.0)
@@ -209,14 +295,23 @@ func NewCQKernel(params CQParams) *CQKernel {
 		}
 	}
 
-	return &CQKernel{p, &sk}
+	return &sk
 }
 
-func (k *CQKernel) processForward(cv []complex128) []complex128 {
+// forOctave returns the kernel to use for an octave.
+func (k *CQKernel) forOctave(octave int) *Kernel {
+	if k.octaveKernels != nil {
+		return k.octaveKernels[octave]
+	}
+	return k.kernel
+}
+
+func (k *CQKernel) processForward(octave int, cv []complex128) []complex128 {
 	// straightforward matrix multiply (taking into account m_kernel's
 	// slightly-sparse representation)
 
-	if len(k.kernel.data) == 0 {
+	kernel := k.forOctave(octave)
+	if len(kernel.data) == 0 {
 		panic("Whoops - return empty array? is this even possible?")
 	}
 
@@ -225,19 +320,20 @@ func (k *CQKernel) processForward(cv []complex128) []complex128 {
 	rv := make([]complex128, nrows, nrows)
 	for i := 0; i < nrows; i++ {
 		// rv[i] = complex(0, 0)
-		for j := 0; j < len(k.kernel.data[i]); j++ {
-			rv[i] += cv[j+k.kernel.origin[i]] * k.kernel.data[i][j]
+		for j := 0; j < len(kernel.data[i]); j++ {
+			rv[i] += cv[j+kernel.origin[i]] * kernel.data[i][j]
 		}
 	}
 	return rv
 }
 
-func (k *CQKernel) ProcessInverse(cv []complex128) []complex128 {
+func (k *CQKernel) ProcessInverse(octave int, cv []complex128) []complex128 {
 	// matrix multiply by conjugate transpose of m_kernel. This is
 	// actually the original kernel as calculated, we just stored the
 	// conjugate-transpose of the kernel because we expect to be doing
 	// more forward transforms than inverse ones.
-	if len(k.kernel.data) == 0 {
+	kernel := k.forOctave(octave)
+	if len(kernel.data) == 0 {
 		panic("Whoops - return empty array? is this even possible?")
 	}
 
@@ -246,10 +342,10 @@ func (k *CQKernel) ProcessInverse(cv []complex128) []complex128 {
 
 	rv := make([]complex128, nrows, nrows)
 	for j := 0; j < ncols; j++ {
-		i0 := k.kernel.origin[j]
-		i1 := i0 + len(k.kernel.data[j])
+		i0 := kernel.origin[j]
+		i1 := i0 + len(kernel.data[j])
 		for i := i0; i < i1; i++ {
-			rv[i] += cv[j] * cmplx.Conj(k.kernel.data[j][i-i0])
+			rv[i] += cv[j] * cmplx.Conj(kernel.data[j][i-i0])
 		}
 	}
 	return rv
@@ -264,47 +360,44 @@ func makeWindow(window Window, len int) []float64 {
 		panic("Window too small!")
 	}
 
-	if window != SqrtBlackmanHarris {
-		// HACK - support more?
-		panic("Only SqrtBlackmanHarris window supported currently")
-	}
-
+	// Symmetric window: the last value repeats the first.
 	win := make([]float64, len-1, len-1)
+	n := float64(len - 1)
 	for i := 0; i < len-1; i++ {
-		win[i] = 1.0
+		win[i] = windowShape(window, i, n) / float64(len)
 	}
+	win = append(win, win[0])
+	return win
+}
 
-	// Blackman Harris
-
-	n := float64(len - 1)
-	for i := 0; i < len-1; i++ {
-		win[i] = win[i] * (0.35875 -
+// windowShape returns the value at index i of a window with period n, peaking at 1.
+func windowShape(window Window, i int, n float64) float64 {
+	v := 1.0
+	switch window {
+	case SqrtBlackmanHarris, BlackmanHarris:
+		v = v * (0.35875 -
 			0.48829*math.Cos(2.0*math.Pi*float64(i)/n) +
 			0.14128*math.Cos(4.0*math.Pi*float64(i)/n) -
 			0.01168*math.Cos(6.0*math.Pi*float64(i)/n))
-	}
 
-	win = append(win, win[0])
+	case SqrtBlackman, Blackman:
+		v = v * (0.42 -
+			0.5*math.Cos(2.0*math.Pi*float64(i)/n) +
+			0.08*math.Cos(4.0*math.Pi*float64(i)/n))
 
-	switch window {
-	case SqrtBlackmanHarris:
-		fallthrough
-	case SqrtBlackman:
-		fallthrough
-	case SqrtHann:
-		for i, v := range win {
-			win[i] = math.Sqrt(v) / float64(len)
-		}
+	case SqrtHann, Hann:
+		v = v * (0.5 - 0.5*math.Cos(2.0*math.Pi*float64(i)/n))
 
-	case BlackmanHarris:
-		fallthrough
-	case Blackman:
-		fallthrough
-	case Hann:
-		for i, v := range win {
-			win[i] = v / float64(len)
-		}
+	default:
+		panic(fmt.Sprintf("Unknown window type %d", window))
 	}
 
-	return win
+	// Rounding can leave the edges slightly negative.
+	v = math.Max(0, v)
+
+	switch window {
+	case SqrtBlackmanHarris, SqrtBlackman, SqrtHann:
+		return math.Sqrt(v)
+	}
+	return v
 }
@@ -0,0 +1,276 @@
+package cq
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	// Magic bytes at the start of every cached kernel file.
+	kernelFileMagic = "GOCQK"
+
+	// Version of the kernel file format, and of the kernel generation. Increase whenever either
+	// changes, so older cached kernels are rebuilt rather than used.
+	kernelFileVersion = 2
+)
+
+// KernelCache shares kernels between transforms with the same parameters, as building them
+// is slow. Kernels are kept in memory, and optionally in a directory so they survive restarts.
+// It is safe to use from multiple goroutines, and each kernel is only built once.
+type KernelCache struct {
+	dir string
+
+	lock    sync.Mutex
+	entries map[CQParams]*cacheEntry
+}
+
+type cacheEntry struct {
+	once   sync.Once
+	kernel *CQKernel
+}
+
+// The cache used by NewConstantQ and NewCQInverse. Memory only, unless replaced.
+var kernelCache = NewKernelCache("")
+var kernelCacheLock sync.Mutex
+
+// NewKernelCache creates a cache that stores kernels in dir, or only in memory if dir is empty.
+//
+// For example, to reuse kernels between runs:
+//  cq.SetKernelCache(cq.NewKernelCache(filepath.Join(os.TempDir(), "go-sound-kernels")))
+func NewKernelCache(dir string) *KernelCache {
+	return &KernelCache{dir, sync.Mutex{}, make(map[CQParams]*cacheEntry)}
+}
+
+// SetKernelCache replaces the cache used when creating transforms.
+func SetKernelCache(cache *KernelCache) {
+	kernelCacheLock.Lock()
+	defer kernelCacheLock.Unlock()
+	kernelCache = cache
+}
+
+// cachedKernel returns the kernel for the parameters from the current cache.
+func cachedKernel(params CQParams) *CQKernel {
+	kernelCacheLock.Lock()
+	cache := kernelCache
+	kernelCacheLock.Unlock()
+	return cache.Get(params)
+}
+
+// Get returns the kernel for the parameters, loading or building it if needed.
+// Kernels are shared, so must not be modified.
+func (c *KernelCache) Get(params CQParams) *CQKernel {
+	c.lock.Lock()
+	entry, ok := c.entries[params]
+	if !ok {
+		entry = &cacheEntry{}
+		c.entries[params] = entry
+	}
+	c.lock.Unlock()
+
+	// Other callers for the same parameters wait here until the first has the kernel.
+	entry.once.Do(func() {
+		entry.kernel = c.load(params)
+	})
+	return entry.kernel
+}
+
+// load reads a kernel from disk, or builds (and saves) it if it's missing or invalid.
+func (c *KernelCache) load(params CQParams) *CQKernel {
+	if c.dir == "" {
+		return NewCQKernel(params)
+	}
+
+	path := c.path(params)
+	kernel, err := readKernelFile(path, params)
+	if err == nil {
+		return kernel
+	}
+	if !os.IsNotExist(err) {
+		fmt.Printf("Rebuilding CQ kernel, can't use %s: %v\n", path, err)
+	}
+
+	kernel = NewCQKernel(params)
+	if err := writeKernelFile(c.dir, path, params, kernel); err != nil {
+		fmt.Printf("Can't cache CQ kernel to %s: %v\n", path, err)
+	}
+	return kernel
+}
+
+// path is where the kernel for the parameters is stored, named by a hash of the parameters.
+func (c *KernelCache) path(params CQParams) string {
+	buffer := &bytes.Buffer{}
+	writeKernelParams(buffer, params)
+	return filepath.Join(c.dir, fmt.Sprintf("%x.cqk", sha256.Sum256(buffer.Bytes())))
+}
+
+// writeKernelFile saves a kernel atomically, by writing a temporary file then renaming it.
+// The file is the magic and version, then the parameters, properties and kernels, then a CRC32
+// of everything before it, all little endian.
+func writeKernelFile(dir string, path string, params CQParams, kernel *CQKernel) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(dir, "kernel_")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	checksum := crc32.NewIEEE()
+	out := bufio.NewWriter(io.MultiWriter(file, checksum))
+	out.WriteString(kernelFileMagic)
+	binary.Write(out, binary.LittleEndian, uint16(kernelFileVersion))
+	writeKernelParams(out, params)
+	writeKernelProperties(out, kernel.Properties)
+
+	kernels := []*Kernel{kernel.kernel}
+	if kernel.octaveKernels != nil {
+		kernels = kernel.octaveKernels
+	}
+	binary.Write(out, binary.LittleEndian, int32(len(kernels)))
+	for _, k := range kernels {
+		binary.Write(out, binary.LittleEndian, int32(len(k.data)))
+		for i, row := range k.data {
+			binary.Write(out, binary.LittleEndian, int32(k.origin[i]))
+			binary.Write(out, binary.LittleEndian, int32(len(row)))
+			binary.Write(out, binary.LittleEndian, row)
+		}
+	}
+	if err := out.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := binary.Write(file, binary.LittleEndian, checksum.Sum32()); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// readKernelFile loads a kernel saved by writeKernelFile, checking it's intact and was built
+// for the same parameters.
+func readKernelFile(path string, params CQParams) (*CQKernel, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(contents) < len(kernelFileMagic)+2+4 || string(contents[:len(kernelFileMagic)]) != kernelFileMagic {
+		return nil, errors.New("not a CQ kernel file")
+	}
+	body, stored := contents[:len(contents)-4], binary.LittleEndian.Uint32(contents[len(contents)-4:])
+	if crc32.ChecksumIEEE(body) != stored {
+		return nil, errors.New("checksum mismatch")
+	}
+
+	in := bytes.NewReader(body[len(kernelFileMagic):])
+	var version uint16
+	binary.Read(in, binary.LittleEndian, &version)
+	if version != kernelFileVersion {
+		return nil, fmt.Errorf("kernel file version %d, expected %d", version, kernelFileVersion)
+	}
+
+	expected := &bytes.Buffer{}
+	writeKernelParams(expected, params)
+	actual := make([]byte, expected.Len(), expected.Len())
+	if _, err := io.ReadFull(in, actual); err != nil || !bytes.Equal(actual, expected.Bytes()) {
+		return nil, errors.New("kernel was built for different parameters")
+	}
+
+	result := &CQKernel{}
+	if err := readKernelProperties(in, &result.Properties); err != nil {
+		return nil, err
+	}
+	var count int32
+	if err := binary.Read(in, binary.LittleEndian, &count); err != nil {
+		return nil, err
+	}
+	if count != 1 && int(count) != params.Octaves {
+		return nil, fmt.Errorf("kernel file has %d kernels", count)
+	}
+	kernels := make([]*Kernel, count, count)
+	for k := range kernels {
+		var rows int32
+		if err := binary.Read(in, binary.LittleEndian, &rows); err != nil {
+			return nil, err
+		}
+		if int(rows) != result.Properties.binsPerOctave*result.Properties.atomsPerFrame {
+			return nil, fmt.Errorf("kernel file has %d rows", rows)
+		}
+		kernel := &Kernel{make([]int, rows, rows), make([][]complex128, rows, rows)}
+		for i := range kernel.data {
+			var origin, length int32
+			binary.Read(in, binary.LittleEndian, &origin)
+			if err := binary.Read(in, binary.LittleEndian, &length); err != nil {
+				return nil, err
+			}
+			if origin < 0 || length < 0 || int(origin+length) > result.Properties.fftSize {
+				return nil, fmt.Errorf("kernel row %d out of range", i)
+			}
+			kernel.origin[i] = int(origin)
+			kernel.data[i] = make([]complex128, length, length)
+			if err := binary.Read(in, binary.LittleEndian, kernel.data[i]); err != nil {
+				return nil, err
+			}
+		}
+		kernels[k] = kernel
+	}
+	if in.Len() != 0 {
+		return nil, errors.New("unexpected data at end of kernel file")
+	}
+
+	result.kernel = kernels[0]
+	if count > 1 {
+		result.octaveKernels = kernels
+	}
+	return result, nil
+}
+
+func writeKernelParams(w io.Writer, params CQParams) {
+	fields := []interface{}{
+		params.sampleRate, int32(params.Octaves), params.minFrequency, int32(params.BinsPerOctave),
+		params.q, params.atomHopFactor, params.threshold, int32(params.window), params.bandwidthOffset,
+	}
+	for _, field := range fields {
+		binary.Write(w, binary.LittleEndian, field)
+	}
+}
+
+func writeKernelProperties(w io.Writer, p Properties) {
+	ints := []int{p.octaves, p.binsPerOctave, p.fftSize, p.fftHop, p.atomsPerFrame, p.atomSpacing, p.firstCentre, p.lastCentre}
+	for _, v := range ints {
+		binary.Write(w, binary.LittleEndian, int32(v))
+	}
+	binary.Write(w, binary.LittleEndian, []float64{p.sampleRate, p.minFrequency, p.Q})
+}
+
+func readKernelProperties(r io.Reader, p *Properties) error {
+	ints := make([]int32, 8, 8)
+	floats := make([]float64, 3, 3)
+	if err := binary.Read(r, binary.LittleEndian, ints); err != nil {
+		return err
+	}
+	if err := binary.Read(r, binary.LittleEndian, floats); err != nil {
+		return err
+	}
+	p.octaves, p.binsPerOctave, p.fftSize, p.fftHop = int(ints[0]), int(ints[1]), int(ints[2]), int(ints[3])
+	p.atomsPerFrame, p.atomSpacing, p.firstCentre, p.lastCentre = int(ints[4]), int(ints[5]), int(ints[6]), int(ints[7])
+	p.sampleRate, p.minFrequency, p.Q = floats[0], floats[1], floats[2]
+	if p.fftSize <= 0 || p.atomsPerFrame <= 0 || p.binsPerOctave <= 0 || math.IsNaN(p.Q) {
+		return fmt.Errorf("invalid kernel properties %+v", *p)
+	}
+	return nil
+}
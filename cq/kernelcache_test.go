@@ -0,0 +1,66 @@
+package cq
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestKernelCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kernels_")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s\n", err)
+	}
+	defer os.RemoveAll(dir)
+
+	params := NewCQParams(testSampleRate, 3, 110.0, 12, WithBandwidthOffset(5))
+	built := NewCQKernel(params)
+
+	// Concurrent users share the one kernel.
+	cache := NewKernelCache(dir)
+	kernels := make([]*CQKernel, 4, 4)
+	var wg sync.WaitGroup
+	for i := range kernels {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			kernels[i] = cache.Get(params)
+		}(i)
+	}
+	wg.Wait()
+	for _, kernel := range kernels {
+		if kernel != kernels[0] {
+			t.Errorf("Cache built the same kernel more than once")
+		}
+	}
+	if !reflect.DeepEqual(kernels[0], built) {
+		t.Errorf("Cached kernel differs from a new one")
+	}
+
+	// A new cache loads it from disk.
+	path := cache.path(params)
+	loaded, err := readKernelFile(path, params)
+	if err != nil || !reflect.DeepEqual(loaded, built) {
+		t.Errorf("Kernel read from %s differs from a new one, error %v", path, err)
+	}
+	if _, err := readKernelFile(path, NewCQParams(testSampleRate, 3, 110.0, 12)); err == nil {
+		t.Errorf("Kernel for different parameters was read without error")
+	}
+
+	// Corrupted files are detected, and replaced.
+	contents, _ := ioutil.ReadFile(path)
+	contents[len(contents)/2] ^= 0xff
+	ioutil.WriteFile(path, contents, 0644)
+	if _, err := readKernelFile(path, params); err == nil {
+		t.Errorf("Corrupted kernel file was read without error")
+	}
+	if !reflect.DeepEqual(NewKernelCache(dir).Get(params), built) {
+		t.Errorf("Kernel rebuilt from corrupted file differs from a new one")
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 1 {
+		t.Errorf("Expected just the one kernel file, found %v", files)
+	}
+}
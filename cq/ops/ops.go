@@ -0,0 +1,220 @@
+// Package ops edits sounds in the constant Q domain, as transforms of channels of CQ columns.
+//
+// The transforms accept either raw columns from cq.ConstantQ, whose heights vary by octave as
+// given by cq.RawColumnOctaves, or full height columns from cq.Spectrogram. Where a transform
+// needs a bin that a raw column doesn't include, the most recent value for that bin is used.
+//
+// For example, to pitch a sound up two semitones and remove everything above 4kHz:
+//  edit := ops.Compose(ops.ShiftBins(params, 2*params.BinsPerOctave/12), ops.LowPass(params, 4000))
+//  samples := cq.NewCQInverse(params).ProcessChannel(edit(constantQ.ProcessChannel(input)))
+package ops
+
+import (
+	"math"
+	"math/cmplx"
+
+	"github.com/padster/go-sound/cq"
+)
+
+// Transform converts one channel of CQ columns into another.
+type Transform func(columns <-chan []complex128) <-chan []complex128
+
+// Compose chains transforms together, applying them in the order given.
+func Compose(transforms ...Transform) Transform {
+	return func(columns <-chan []complex128) <-chan []complex128 {
+		for _, transform := range transforms {
+			columns = transform(columns)
+		}
+		return columns
+	}
+}
+
+// Map applies a function to each column in turn. Columns may be shared with the input's producer,
+// so the function should return a new column rather than modify the one it's given.
+func Map(f func(column []complex128) []complex128) Transform {
+	return func(columns <-chan []complex128) <-chan []complex128 {
+		result := make(chan []complex128)
+		go func() {
+			for column := range columns {
+				result <- f(column)
+			}
+			close(result)
+		}()
+		return result
+	}
+}
+
+// ShiftBins moves every value by a number of bins, keeping each column's height.
+// Positive shifts raise the pitch, and bins shifted in from outside the range are zero.
+func ShiftBins(params cq.CQParams, bins int) Transform {
+	return func(columns <-chan []complex128) <-chan []complex128 {
+		held := newHold(params)
+		return Map(func(column []complex128) []complex128 {
+			held.update(column)
+			shifted := make([]complex128, len(column), len(column))
+			for i := range shifted {
+				if from := i + bins; from >= 0 && from < len(held.values) {
+					shifted[i] = held.values[from]
+				}
+			}
+			return shifted
+		})(columns)
+	}
+}
+
+// Flip mirrors each column in frequency, so the highest bin swaps with the lowest.
+// Phases are unwrapped over time, then scaled for the new frequency of their bin.
+func Flip(params cq.CQParams) Transform {
+	return func(columns <-chan []complex128) <-chan []complex128 {
+		held := newHold(params)
+		height := len(held.values)
+		phases := make([]float64, height, height)
+		return Map(func(column []complex128) []complex128 {
+			held.update(column)
+			for i, v := range column {
+				phases[i] = unwrap(phases[i], cmplx.Phase(v))
+			}
+
+			flipped := make([]complex128, len(column), len(column))
+			for i := range flipped {
+				other := height - 1 - i
+				pFactor := float64(params.Octaves) - float64(2*i+1)/float64(params.BinsPerOctave)
+				flipped[i] = cmplx.Rect(cmplx.Abs(held.values[other]), phases[other]/math.Pow(2.0, pFactor))
+			}
+			return flipped
+		})(columns)
+	}
+}
+
+// Gain scales the magnitude of each bin by curve(frequency of the bin, in Hz).
+func Gain(params cq.CQParams, curve func(hz float64) float64) Transform {
+	gains := make([]complex128, params.Octaves*params.BinsPerOctave)
+	for i := range gains {
+		gains[i] = complex(curve(params.BinFrequency(i)), 0)
+	}
+	return Map(func(column []complex128) []complex128 {
+		scaled := make([]complex128, len(column), len(column))
+		for i, v := range column {
+			scaled[i] = v * gains[i]
+		}
+		return scaled
+	})
+}
+
+// Mask zeroes all the bins whose frequency (in Hz) isn't kept.
+func Mask(params cq.CQParams, keep func(hz float64) bool) Transform {
+	return Gain(params, func(hz float64) float64 {
+		if keep(hz) {
+			return 1.0
+		}
+		return 0.0
+	})
+}
+
+// LowPass zeroes all bins above a frequency.
+func LowPass(params cq.CQParams, maxHz float64) Transform {
+	return Mask(params, func(hz float64) bool { return hz <= maxHz })
+}
+
+// HighPass zeroes all bins below a frequency.
+func HighPass(params cq.CQParams, minHz float64) Transform {
+	return Mask(params, func(hz float64) bool { return hz >= minHz })
+}
+
+// TimeOffset delays the columns by inserting silence at the start, or for negative offsets,
+// advances them by dropping columns. Output always has the raw heights of cq.ConstantQ.
+func TimeOffset(params cq.CQParams, columnOffset int) Transform {
+	return func(columns <-chan []complex128) <-chan []complex128 {
+		result := make(chan []complex128)
+		go func() {
+			held := newHold(params)
+			at := 0
+			for ; at < columnOffset; at++ {
+				result <- make([]complex128, cq.RawColumnOctaves(params.Octaves, at)*params.BinsPerOctave)
+			}
+			for i := 0; i > columnOffset; i-- {
+				if column, ok := <-columns; ok {
+					held.update(column)
+				}
+			}
+			for column := range columns {
+				held.update(column)
+				result <- held.take(cq.RawColumnOctaves(params.Octaves, at) * params.BinsPerOctave)
+				at++
+			}
+			close(result)
+		}()
+		return result
+	}
+}
+
+// ToCQHeights cuts full height columns, e.g. from cq.Spectrogram, down to the raw heights
+// expected by cq.CQInverse.
+func ToCQHeights(params cq.CQParams) Transform {
+	return TimeOffset(params, 0)
+}
+
+// CrossSynthesis combines the magnitudes of one input with the phases of another, for example
+// to impose the spectral envelope of one sound onto another. The two must have the same
+// column heights, and the output ends when either input does.
+func CrossSynthesis(magnitudes <-chan []complex128, phases <-chan []complex128) <-chan []complex128 {
+	result := make(chan []complex128)
+	go func() {
+		for {
+			mColumn, mOk := <-magnitudes
+			pColumn, pOk := <-phases
+			if !mOk || !pOk {
+				break
+			}
+			if len(mColumn) != len(pColumn) {
+				panic("Cross synthesis inputs have different column heights")
+			}
+			column := make([]complex128, len(mColumn), len(mColumn))
+			for i := range column {
+				column[i] = cmplx.Rect(cmplx.Abs(mColumn[i]), cmplx.Phase(pColumn[i]))
+			}
+			result <- column
+		}
+		close(result)
+
+		// Let whichever input is left finish, rather than block forever.
+		go drain(magnitudes)
+		go drain(phases)
+	}()
+	return result
+}
+
+// hold keeps the most recent value for each bin, to fill in the bins missing from raw columns.
+type hold struct {
+	values []complex128
+}
+
+func newHold(params cq.CQParams) *hold {
+	height := params.Octaves * params.BinsPerOctave
+	return &hold{make([]complex128, height, height)}
+}
+
+func (h *hold) update(column []complex128) {
+	copy(h.values, column)
+}
+
+// take returns a copy of the top height values.
+func (h *hold) take(height int) []complex128 {
+	column := make([]complex128, height, height)
+	copy(column, h.values)
+	return column
+}
+
+// unwrap returns the closest value to previous which equals phase, modulo 2 pi.
+func unwrap(previous float64, phase float64) float64 {
+	if math.IsNaN(phase) {
+		phase = 0.0
+	}
+	cycles := (previous - phase) / cq.TAU
+	return phase + float64(cq.Round(cycles))*cq.TAU
+}
+
+func drain(columns <-chan []complex128) {
+	for range columns {
+	}
+}
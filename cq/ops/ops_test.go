@@ -0,0 +1,98 @@
+package ops
+
+// go test github.com/padster/go-sound/cq/ops
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/padster/go-sound/cq"
+)
+
+func testParams() cq.CQParams {
+	return cq.NewCQParams(44100.0, 4, 55.0, 12)
+}
+
+// Raw columns must keep the heights cq.CQInverse expects for their position.
+func TestRawHeightsKept(t *testing.T) {
+	params := testParams()
+	transforms := map[string]Transform{
+		"ShiftBins up":       ShiftBins(params, 3),
+		"ShiftBins down":     ShiftBins(params, -3),
+		"Flip":               Flip(params),
+		"TimeOffset delay":   TimeOffset(params, 5),
+		"TimeOffset advance": TimeOffset(params, -3),
+		"Composed":           Compose(Flip(params), TimeOffset(params, 2), LowPass(params, 1000)),
+	}
+	for name, transform := range transforms {
+		i := 0
+		for column := range transform(sliceToChannel(rawColumns(params, 40))) {
+			if expected := cq.RawColumnOctaves(params.Octaves, i) * params.BinsPerOctave; len(column) != expected {
+				t.Errorf("%s: column %d has height %d, expected %d", name, i, len(column), expected)
+			}
+			i++
+		}
+		if i == 0 {
+			t.Errorf("%s: no columns", name)
+		}
+	}
+}
+
+func TestShiftBinsValues(t *testing.T) {
+	params := testParams()
+	full := params.Octaves * params.BinsPerOctave
+	input := [][]complex128{make([]complex128, full, full)}
+	for i := range input[0] {
+		input[0][i] = complex(float64(i+1), 0)
+	}
+	shifted := <-ShiftBins(params, 2)(sliceToChannel(input))
+	for i, v := range shifted {
+		expected := complex128(0)
+		if i+2 < full {
+			expected = input[0][i+2]
+		}
+		if v != expected {
+			t.Errorf("Bin %d is %v, expected %v", i, v, expected)
+		}
+	}
+}
+
+func TestComposedUnityGainIsIdentity(t *testing.T) {
+	params := testParams()
+	unity := func(hz float64) float64 { return 1.0 }
+	input := rawColumns(params, 20)
+	i := 0
+	for column := range Compose(Gain(params, unity), Gain(params, unity))(sliceToChannel(input)) {
+		for b, v := range column {
+			if v != input[i][b] {
+				t.Fatalf("Column %d bin %d changed from %v to %v", i, b, input[i][b], v)
+			}
+		}
+		i++
+	}
+	if i != len(input) {
+		t.Errorf("Got %d columns, expected %d", i, len(input))
+	}
+}
+
+// rawColumns creates random columns, with the varying heights output by cq.ConstantQ.
+func rawColumns(params cq.CQParams, count int) [][]complex128 {
+	r := rand.New(rand.NewSource(1))
+	result := make([][]complex128, count, count)
+	for i := range result {
+		result[i] = make([]complex128, cq.RawColumnOctaves(params.Octaves, i)*params.BinsPerOctave)
+		for b := range result[i] {
+			result[i][b] = complex(r.NormFloat64(), r.NormFloat64())
+		}
+	}
+	return result
+}
+
+func sliceToChannel(columns [][]complex128) <-chan []complex128 {
+	result := make(chan []complex128, len(columns))
+	for _, column := range columns {
+		result <- column
+	}
+	close(result)
+	return result
+}
@@ -0,0 +1,156 @@
+package cq
+
+import (
+	"container/heap"
+	"math"
+	"math/cmplx"
+	"sort"
+)
+
+// Bins quieter than this, relative to the loudest, are given zero phase rather than integrated.
+const heapIntegrateTolerance = 1e-5
+
+// HeapIntegrate estimates the phases of STFT columns from their magnitudes alone, using phase
+// gradient heap integration (Prusa et al. 2017). It's not iterative so is much faster than
+// Griffin-Lim, and works best with plenty of overlap, e.g. a hop of a quarter of the FFT size.
+//
+// The phase's gradients follow from the gradients of the log magnitude, exactly for Gaussian
+// windows and approximately for the others. Starting at the loudest bin, phases are integrated
+// out along those gradients, always continuing from the loudest bin reached so far.
+func HeapIntegrate(columns [][]complex128, fftSize int, hopSize int, window Window) [][]complex128 {
+	checkSTFTSizes(fftSize, hopSize)
+	width := len(columns)
+	height := fftSize/2 + 1
+
+	logs := make([][]float64, width, width)
+	maxLog := math.Inf(-1)
+	for n, column := range columns {
+		if len(column) != height {
+			panic("HeapIntegrate column has the wrong number of bins for the FFT size")
+		}
+		logs[n] = make([]float64, height, height)
+		for m, v := range column {
+			logs[n][m] = math.Log(cmplx.Abs(v) + 1e-300)
+			maxLog = math.Max(maxLog, logs[n][m])
+		}
+	}
+
+	// Phase gradients in time (radians per sample), and frequency (radians per cycle per sample),
+	// with the phase measured relative to each window's centre.
+	lambda := gaussianWidth(makeSTFTWindow(window, fftSize))
+	a, M := float64(hopSize), float64(fftSize)
+	tGrad := make([][]float64, width, width)
+	fGrad := make([][]float64, width, width)
+	for n := range logs {
+		tGrad[n] = make([]float64, height, height)
+		fGrad[n] = make([]float64, height, height)
+		for m := range logs[n] {
+			// The spectrum is symmetric around DC and Nyquist, so their slopes are zero.
+			if m > 0 && m < height-1 {
+				tGrad[n][m] = M * (logs[n][m+1] - logs[n][m-1]) / 2.0 / lambda
+			}
+			tGrad[n][m] += TAU * float64(m) / M
+
+			before, after := maxInt(n-1, 0), minInt(n+1, width-1)
+			if after > before {
+				fGrad[n][m] = -lambda * (logs[after][m] - logs[before][m]) / (float64(after-before) * a)
+			}
+		}
+	}
+
+	// Integrate from the loudest bins outwards, skipping those too quiet to matter.
+	threshold := maxLog + math.Log(heapIntegrateTolerance)
+	phases := make([][]float64, width, width)
+	done := make([][]bool, width, width)
+	remaining := []heapBin{}
+	for n := range logs {
+		phases[n] = make([]float64, height, height)
+		done[n] = make([]bool, height, height)
+		for m, l := range logs[n] {
+			if l < threshold {
+				done[n][m] = true
+			} else {
+				remaining = append(remaining, heapBin{n, m, l})
+			}
+		}
+	}
+	sort.Slice(remaining, func(i, j int) bool { return remaining[i].magnitude > remaining[j].magnitude })
+
+	bins := &binHeap{}
+	for _, start := range remaining {
+		if done[start.n][start.m] {
+			continue
+		}
+		// A new island of loud bins, whose phase is arbitrary.
+		done[start.n][start.m] = true
+		heap.Push(bins, start)
+		for bins.Len() > 0 {
+			at := heap.Pop(bins).(heapBin)
+			n, m := at.n, at.m
+			if n+1 < width && !done[n+1][m] {
+				phases[n+1][m] = phases[n][m] + a*(tGrad[n][m]+tGrad[n+1][m])/2.0
+				done[n+1][m] = true
+				heap.Push(bins, heapBin{n + 1, m, logs[n+1][m]})
+			}
+			if n > 0 && !done[n-1][m] {
+				phases[n-1][m] = phases[n][m] - a*(tGrad[n][m]+tGrad[n-1][m])/2.0
+				done[n-1][m] = true
+				heap.Push(bins, heapBin{n - 1, m, logs[n-1][m]})
+			}
+			if m+1 < height && !done[n][m+1] {
+				phases[n][m+1] = phases[n][m] + (fGrad[n][m]+fGrad[n][m+1])/2.0/M
+				done[n][m+1] = true
+				heap.Push(bins, heapBin{n, m + 1, logs[n][m+1]})
+			}
+			if m > 0 && !done[n][m-1] {
+				phases[n][m-1] = phases[n][m] - (fGrad[n][m]+fGrad[n][m-1])/2.0/M
+				done[n][m-1] = true
+				heap.Push(bins, heapBin{n, m - 1, logs[n][m-1]})
+			}
+		}
+	}
+
+	// STFT frames measure phase from their start rather than the window centre, half a frame
+	// earlier, which adds a half turn per bin.
+	result := make([][]complex128, width, width)
+	for n, column := range columns {
+		result[n] = make([]complex128, height, height)
+		for m, v := range column {
+			result[n][m] = cmplx.Rect(cmplx.Abs(v), phases[n][m]+math.Pi*float64(m))
+		}
+	}
+	return result
+}
+
+// gaussianWidth returns the lambda of the Gaussian window exp(-pi x^2 / lambda) with the same
+// spread of energy in time as the given window.
+func gaussianWidth(window []float64) float64 {
+	centre := float64(len(window)) / 2.0
+	moment, energy := 0.0, 0.0
+	for i, w := range window {
+		d := float64(i) - centre
+		moment += d * d * w * w
+		energy += w * w
+	}
+	return 2.0 * TAU * moment / energy
+}
+
+// heapBin is a bin of an STFT, ordered by its log magnitude.
+type heapBin struct {
+	n, m      int
+	magnitude float64
+}
+
+// binHeap is a max-heap of bins, for container/heap.
+type binHeap []heapBin
+
+func (h binHeap) Len() int            { return len(h) }
+func (h binHeap) Less(i, j int) bool  { return h[i].magnitude > h[j].magnitude }
+func (h binHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *binHeap) Push(x interface{}) { *h = append(*h, x.(heapBin)) }
+func (h *binHeap) Pop() interface{} {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
@@ -75,6 +75,26 @@ func (spec *Spectrogram) GetRemainingOutput() [][]complex128 {
 	return spec.interpolate(spec.cq.GetRemainingOutput(), true)
 }
 
+// SamplesPerColumn returns the number of input samples between consecutive output columns.
+func (spec *Spectrogram) SamplesPerColumn() int {
+	return spec.cq.SamplesPerColumn()
+}
+
+// OutputLatency returns the number of samples the output columns lag behind the input.
+func (spec *Spectrogram) OutputLatency() int {
+	return spec.cq.OutputLatency
+}
+
+// Timing returns where each output column sits in the input.
+func (spec *Spectrogram) Timing() ColumnTiming {
+	return spec.cq.Timing()
+}
+
+// ProcessColumns is ProcessChannel, with each column annotated by its timing.
+func (spec *Spectrogram) ProcessColumns(samples <-chan float64) <-chan Column {
+	return spec.Timing().Annotate(spec.ProcessChannel(samples))
+}
+
 // Post process by writing to linear interpolator
 func (spec *Spectrogram) interpolate(cq [][]complex128, insist bool) [][]complex128 {
 	// TODO: make copy here? currently we copy elsewhere.
@@ -0,0 +1,272 @@
+package cq
+
+import (
+	"fmt"
+
+	"github.com/mjibson/go-dsp/fft"
+)
+
+// STFT is a short-time Fourier transform: windowed FFTs of the input every hopSize samples,
+// giving columns of fftSize/2 + 1 linearly spaced bins, where bin k is at k * sampleRate / fftSize Hz.
+// Unlike ConstantQ, the lowest frequency bin is first.
+type STFT struct {
+	fftSize int
+	hopSize int
+	window  []float64
+
+	// Column i of the output is centred on input sample (i * hopSize - OutputLatency). This is
+	// never negative: hops of more than half a frame are padded so that column 0 is centred on
+	// the first sample, with a latency of zero.
+	OutputLatency int
+
+	buffer []float64
+	// How many of the samples at the end of buffer are input, rather than padding.
+	inputInBuffer int
+}
+
+// NewSTFT creates a forward transform with the given FFT size, hop size and window shape.
+// Any of the windows can be used, though the Sqrt* ones give the best reconstruction from
+// ISTFT after the columns have been modified.
+//
+// For example, a 2048 point transform with 75% overlap:
+//  stft := cq.NewSTFT(2048, 512, cq.SqrtHann)
+//  columns := stft.ProcessChannel(sound.GetSamples())
+func NewSTFT(fftSize int, hopSize int, window Window) *STFT {
+	checkSTFTSizes(fftSize, hopSize)
+
+	padding := stftPadding(fftSize, hopSize)
+	return &STFT{
+		fftSize,
+		hopSize,
+		makeSTFTWindow(window, fftSize),
+		padding - fftSize/2, /* OutputLatency */
+		make([]float64, padding, padding),
+		0, /* inputInBuffer */
+	}
+}
+
+// ProcessChannel transforms a channel of samples, into a channel of columns.
+func (stft *STFT) ProcessChannel(samples <-chan float64) <-chan []complex128 {
+	result := make(chan []complex128)
+
+	go func() {
+		buffer := make([]float64, stft.hopSize, stft.hopSize)
+		at := 0
+		for s := range samples {
+			if at == stft.hopSize {
+				for _, c := range stft.Process(buffer) {
+					result <- c
+				}
+				at = 0
+			}
+			buffer[at] = s
+			at++
+		}
+		for _, c := range stft.Process(buffer[:at]) {
+			result <- c
+		}
+		for _, c := range stft.GetRemainingOutput() {
+			result <- c
+		}
+		close(result)
+	}()
+
+	return result
+}
+
+// Process adds more samples, and returns the columns that can now be calculated.
+func (stft *STFT) Process(td []float64) [][]complex128 {
+	stft.buffer = append(stft.buffer, td...)
+	stft.inputInBuffer += len(td)
+
+	out := [][]complex128{}
+	for len(stft.buffer) >= stft.fftSize {
+		out = append(out, stft.processFrame())
+	}
+	return out
+}
+
+// GetRemainingOutput pads the end of the input with silence, returning the last columns
+// needed for every input sample to be covered by as many frames as every other.
+func (stft *STFT) GetRemainingOutput() [][]complex128 {
+	out := [][]complex128{}
+	if stft.inputInBuffer > 0 {
+		// Frames up to (and including) the one starting at or before the last input sample.
+		frames := (len(stft.buffer)-1)/stft.hopSize + 1
+		padding := (frames-1)*stft.hopSize + stft.fftSize - len(stft.buffer)
+		stft.buffer = append(stft.buffer, make([]float64, padding, padding)...)
+		for i := 0; i < frames; i++ {
+			out = append(out, stft.processFrame())
+		}
+	}
+
+	// Reset, ready for new input.
+	padding := stftPadding(stft.fftSize, stft.hopSize)
+	stft.buffer = make([]float64, padding, padding)
+	stft.inputInBuffer = 0
+	return out
+}
+
+// BinCount returns the number of values in each column.
+func (stft *STFT) BinCount() int {
+	return stft.fftSize/2 + 1
+}
+
+// SamplesPerColumn returns the number of input samples between consecutive output columns.
+func (stft *STFT) SamplesPerColumn() int {
+	return stft.hopSize
+}
+
+// processFrame transforms the frame at the start of the buffer, then moves along by a hop.
+func (stft *STFT) processFrame() []complex128 {
+	frame := make([]float64, stft.fftSize, stft.fftSize)
+	for i, w := range stft.window {
+		frame[i] = stft.buffer[i] * w
+	}
+	stft.buffer = stft.buffer[stft.hopSize:]
+	if stft.inputInBuffer > len(stft.buffer) {
+		stft.inputInBuffer = len(stft.buffer)
+	}
+	return fft.FFTReal(frame)[:stft.fftSize/2+1]
+}
+
+// ISTFT is the inverse short-time Fourier transform, converting STFT columns back into samples
+// by windowed overlap-add. Given the unmodified output of an STFT with the same parameters,
+// it reconstructs the input exactly (to within floating point error).
+type ISTFT struct {
+	fftSize int
+	hopSize int
+	window  []float64
+
+	// Number of samples the output lags behind the original input. The padding the STFT adds
+	// is removed, so this is always zero, and present to mirror CQInverse.
+	OutputLatency int
+
+	// 1 / (sum of squared windows) for each position within a hop.
+	normalisation []float64
+	olaBuffer     []float64
+	toSkip        int
+}
+
+// NewISTFT creates an inverse transform, for columns created by an STFT with the same parameters.
+func NewISTFT(fftSize int, hopSize int, window Window) *ISTFT {
+	checkSTFTSizes(fftSize, hopSize)
+	win := makeSTFTWindow(window, fftSize)
+
+	normalisation := make([]float64, hopSize, hopSize)
+	for i := range normalisation {
+		sum := 0.0
+		for j := i; j < fftSize; j += hopSize {
+			sum += win[j] * win[j]
+		}
+		if sum < 1e-8 {
+			panic(fmt.Sprintf("Window doesn't cover all samples with hop size %d, can't invert", hopSize))
+		}
+		normalisation[i] = 1.0 / sum
+	}
+
+	return &ISTFT{
+		fftSize,
+		hopSize,
+		win,
+		0, /* OutputLatency */
+		normalisation,
+		make([]float64, fftSize, fftSize),
+		stftPadding(fftSize, hopSize), /* toSkip */
+	}
+}
+
+// ProcessChannel converts a channel of columns back into a channel of samples.
+func (istft *ISTFT) ProcessChannel(columns <-chan []complex128) <-chan float64 {
+	result := make(chan float64)
+
+	go func() {
+		for column := range columns {
+			for _, s := range istft.Process([][]complex128{column}) {
+				result <- s
+			}
+		}
+		for _, s := range istft.GetRemainingOutput() {
+			result <- s
+		}
+		close(result)
+	}()
+
+	return result
+}
+
+// Process adds more columns, and returns the samples which are now complete.
+func (istft *ISTFT) Process(columns [][]complex128) []float64 {
+	out := []float64{}
+	for _, column := range columns {
+		if len(column) != istft.fftSize/2+1 {
+			panic(fmt.Sprintf("ISTFT column has %d bins, expected %d", len(column), istft.fftSize/2+1))
+		}
+
+		// Restore the negative frequencies, as the input was real.
+		full := make([]complex128, istft.fftSize, istft.fftSize)
+		copy(full, column)
+		for i := istft.fftSize/2 + 1; i < istft.fftSize; i++ {
+			v := column[istft.fftSize-i]
+			full[i] = complex(real(v), -imag(v))
+		}
+
+		frame := fft.IFFT(full)
+		for i, w := range istft.window {
+			istft.olaBuffer[i] += real(frame[i]) * w
+		}
+
+		for i := 0; i < istft.hopSize; i++ {
+			out = istft.emit(out, istft.olaBuffer[i]*istft.normalisation[i])
+		}
+		istft.olaBuffer = append(istft.olaBuffer[istft.hopSize:], make([]float64, istft.hopSize, istft.hopSize)...)
+	}
+	return out
+}
+
+// GetRemainingOutput returns the rest of the overlap-add buffer, after the last column.
+func (istft *ISTFT) GetRemainingOutput() []float64 {
+	out := []float64{}
+	for i := 0; i < istft.fftSize-istft.hopSize; i++ {
+		out = istft.emit(out, istft.olaBuffer[i]*istft.normalisation[i%istft.hopSize])
+	}
+
+	// Reset, ready for new input.
+	istft.olaBuffer = make([]float64, istft.fftSize, istft.fftSize)
+	istft.toSkip = stftPadding(istft.fftSize, istft.hopSize)
+	return out
+}
+
+// emit appends a sample to the output, unless it's from the padding the STFT added at the start.
+func (istft *ISTFT) emit(out []float64, sample float64) []float64 {
+	if istft.toSkip > 0 {
+		istft.toSkip--
+		return out
+	}
+	return append(out, sample)
+}
+
+func checkSTFTSizes(fftSize int, hopSize int) {
+	if fftSize < 2 || hopSize < 1 || hopSize > fftSize {
+		panic("STFT requires fftSize >= 2 and 0 < hopSize <= fftSize")
+	}
+}
+
+// stftPadding returns the silence added before the input, so the first sample is covered by as many
+// frames as every other. Hops of more than half a frame are padded further, so no column is centred
+// before the first sample.
+func stftPadding(fftSize int, hopSize int) int {
+	if hopSize > fftSize/2 {
+		return fftSize / 2
+	}
+	return fftSize - hopSize
+}
+
+// makeSTFTWindow creates a periodic window, which overlap-adds evenly for most hop sizes.
+func makeSTFTWindow(window Window, size int) []float64 {
+	win := make([]float64, size, size)
+	for i := range win {
+		win[i] = windowShape(window, i, float64(size))
+	}
+	return win
+}
@@ -0,0 +1,76 @@
+package cq
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// Unmodified columns should invert back to exactly the input, with the same alignment.
+func TestSTFTRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	input := make([]float64, 10000, 10000) // Not a multiple of any of the hops.
+	for i := range input {
+		input[i] = r.Float64()*2 - 1
+	}
+
+	for _, window := range []Window{SqrtHann, SqrtBlackmanHarris} {
+		for _, sizes := range [][2]int{{256, 64}, {1024, 256}, {1024, 512}, {2048, 128}, {512, 384}} {
+			fftSize, hopSize := sizes[0], sizes[1]
+			stft, istft := NewSTFT(fftSize, hopSize, window), NewISTFT(fftSize, hopSize, window)
+			columns := append(stft.Process(input), stft.GetRemainingOutput()...)
+			output := append(istft.Process(columns), istft.GetRemainingOutput()...)
+
+			if len(output) < len(input) {
+				t.Errorf("Window %d, %d/%d: %d samples out, expected at least %d", window, fftSize, hopSize, len(output), len(input))
+				continue
+			}
+			worst := 0.0
+			for i, v := range input {
+				worst = math.Max(worst, math.Abs(output[i]-v))
+			}
+			if worst > 1e-12 {
+				t.Errorf("Window %d, %d/%d: error of %v", window, fftSize, hopSize, worst)
+			}
+		}
+	}
+}
+
+// Column i is centred on sample (i * hopSize - OutputLatency), so an impulse there is at the peak of its
+// window. Hops of more than half a frame would centre the first column after the first sample, so are
+// padded to a latency of zero instead of a negative one.
+func TestSTFTLatency(t *testing.T) {
+	tests := []struct {
+		fftSize, hopSize, latency int
+	}{
+		{1024, 256, 256},
+		{1024, 512, 0},
+		{512, 384, 0},
+		{512, 500, 0},
+	}
+	for _, test := range tests {
+		stft := NewSTFT(test.fftSize, test.hopSize, SqrtHann)
+		if stft.OutputLatency != test.latency {
+			t.Errorf("%d/%d: latency %d, expected %d", test.fftSize, test.hopSize, stft.OutputLatency, test.latency)
+			continue
+		}
+
+		column := stft.OutputLatency/test.hopSize + 2
+		input := make([]float64, 4*test.fftSize, 4*test.fftSize)
+		input[column*test.hopSize-stft.OutputLatency] = 1
+		columns := append(stft.Process(input), stft.GetRemainingOutput()...)
+		if dc := columns[column][0]; math.Abs(real(dc)-1) > 1e-12 || math.Abs(imag(dc)) > 1e-12 {
+			t.Errorf("%d/%d: impulse at the centre of column %d gave %v, expected 1", test.fftSize, test.hopSize, column, dc)
+		}
+	}
+}
+
+func TestISTFTRejectsGaps(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected a hop leaving samples outside every window to panic")
+		}
+	}()
+	// The periodic window is zero at its first sample, so without overlap that sample is lost.
+	NewISTFT(1024, 1024, SqrtHann)
+}
@@ -6,16 +6,14 @@ import (
 	"io"
 	"math"
 	"math/cmplx"
+	"sync"
 )
 
+// GenerateHeights returns a generator of the number of octaves in each raw ConstantQ column, in order.
 func GenerateHeights(octaves int) func() int {
-	limit := unsafeShift(octaves - 1)
-	at := limit
+	at := 0
 	return func() int {
-		result := TerminalZeros(at) + 1
-		if at == limit {
-			at = 0
-		}
+		result := RawColumnOctaves(octaves, at)
 		at++
 		return result
 	}
@@ -106,6 +104,19 @@ func realParts(values []complex128) []float64 {
 	return reals
 }
 
+// forEachOctave runs f for every octave concurrently, returning once they've all finished.
+func forEachOctave(octaves int, f func(octave int)) {
+	var wg sync.WaitGroup
+	wg.Add(octaves)
+	for i := 0; i < octaves; i++ {
+		go func(octave int) {
+			defer wg.Done()
+			f(octave)
+		}(i)
+	}
+	wg.Wait()
+}
+
 func minInt(a, b int) int {
 	if a < b {
 		return a
@@ -121,36 +132,6 @@ func maxInt(a, b int) int {
 	}
 }
 
-func calcGcd(a int, b int) int {
-	if b == 0 {
-		return a
-	}
-	return calcGcd(b, a%b)
-}
-
-func bessel0(x float64) float64 {
-	b := 0.0
-	for i := 0; i < 20; i++ {
-		b += besselTerm(x, i)
-	}
-	return b
-}
-
-func besselTerm(x float64, i int) float64 {
-	if i == 0 {
-		return 1.0
-	}
-	f := float64(factorial(i))
-	return math.Pow(x/2.0, float64(i)*2.0) / (f * f)
-}
-
-func factorial(i int) int {
-	if i == 0 {
-		return 1
-	}
-	return i * factorial(i-1)
-}
-
 // IO Utils
 
 func WriteComplexArray(w io.Writer, array []complex128) {
@@ -3,7 +3,6 @@ package main
 import (
 	"flag"
 	"fmt"
-	"runtime"
 	"time"
 
 	"github.com/padster/go-sound/cq"
@@ -15,9 +14,6 @@ import (
 
 // Generates the golden files. See test/sounds_test.go for actual test.
 func main() {
-	// Needs to be at least 2 when doing openGL + sound output at the same time.
-	runtime.GOMAXPROCS(6)
-
 	sampleRate := s.CyclesPerSecond
 	octaves := flag.Int("octaves", 7, "Range in octaves")
 	minFreq := flag.Float64("minFreq", 55.0, "Minimum frequency")
@@ -47,7 +43,10 @@ func main() {
 	if outputFile != "" {
 		columns := spectrogram.ProcessChannel(inputSound.GetSamples())
 		// Write to file
-		f.WriteColumns(outputFile, columns)
+		header := f.NewCQHeader(params, spectrogram.OutputLatency(), f.FullColumns)
+		if err := f.WriteColumns(outputFile, header, columns); err != nil {
+			panic(err)
+		}
 	} else {
 		// No file, so play and show instead:
 		soundChannel, specChannel := splitChannel(inputSound.GetSamples())
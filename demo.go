@@ -69,7 +69,7 @@ func renderMidi() {
 		bpo := flag.Int("bpo", 48, "Buckets per octave")
 		flag.Parse()
 
-		params := cq.NewCQParams(sampleRate, *minFreq, *maxFreq, *bpo)
+		params := cq.NewCQParamsForRange(sampleRate, *minFreq, *maxFreq, *bpo)
 		spectrogram := cq.NewSpectrogram(params)
 
 		midi.Start()
@@ -0,0 +1,273 @@
+package effects
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+	"time"
+
+	"github.com/padster/go-sound/cq"
+	s "github.com/padster/go-sound/sounds"
+)
+
+// NoiseReduction is how a NoiseReducer quietens the bins it decides are noise.
+type NoiseReduction int
+
+const (
+	// Gate fully reduces bins below a threshold above the noise, and leaves the others alone.
+	Gate NoiseReduction = iota
+	// Subtract removes the noise's magnitude from every bin.
+	Subtract
+)
+
+// NoiseReducer removes steady background noise, like hiss, from sounds. The noise's spectrum is
+// either learnt from a region containing only noise, or tracked as the quietest recent level of
+// each bin. Every STFT bin is then gated or has the noise subtracted, and the resulting gains are
+// smoothed across time and frequency to avoid the warbling "musical noise" of isolated bins
+// switching on and off.
+type NoiseReducer struct {
+	fftSize int
+	hopSize int
+	window  cq.Window
+
+	// Profile is the typical magnitude of the noise in each STFT bin, nil to track it adaptively.
+	Profile []float64
+
+	// Mode is how the noise is reduced.
+	Mode NoiseReduction
+
+	// Threshold is how many times louder than the noise a bin must be for the gate to open.
+	Threshold float64
+
+	// OverSubtraction is how many times the noise's magnitude is subtracted, as noise varies around its average.
+	OverSubtraction float64
+
+	// ReductionDB is the most a bin is ever reduced, in dB. Smaller values sound more natural.
+	ReductionDB float64
+
+	// TimeSmoothing is how much of each bin's previous gain remains in the next column, when the gain falls.
+	TimeSmoothing float64
+
+	// FrequencySmoothing is how many bins either side each gain is averaged over.
+	FrequencySmoothing int
+
+	// TrackingAttack and TrackingRelease are the rates (per column) at which an adaptively tracked
+	// noise floor follows magnitudes above the threshold, and those below it. The slow attack stops
+	// it rising to meet sounds.
+	TrackingAttack  float64
+	TrackingRelease float64
+}
+
+// NewNoiseReducer creates a reducer, working with an STFT of the given parameters.
+//
+// For example, to remove the hiss heard in the first second of a field recording:
+//  nr := effects.NewNoiseReducer(2048, 512, cq.SqrtHann)
+//  nr.LearnRegion(f.Read("field.wav"), 0, time.Second)
+//  cleaned := nr.Apply(f.Read("field.wav"))
+func NewNoiseReducer(fftSize int, hopSize int, window cq.Window) *NoiseReducer {
+	// Fail now, rather than when the sound starts.
+	cq.NewSTFT(fftSize, hopSize, window)
+
+	return &NoiseReducer{
+		fftSize,
+		hopSize,
+		window,
+		nil,    /* Profile */
+		Gate,   /* Mode */
+		2.0,    /* Threshold, ~6dB */
+		1.5,    /* OverSubtraction */
+		18.0,   /* ReductionDB */
+		0.8,    /* TimeSmoothing */
+		1,      /* FrequencySmoothing */
+		0.0002, /* TrackingAttack */
+		0.05,   /* TrackingRelease */
+	}
+}
+
+// LearnProfile sets the noise profile to the average magnitudes of samples containing only noise.
+func (nr *NoiseReducer) LearnProfile(noise []float64) {
+	stft := cq.NewSTFT(nr.fftSize, nr.hopSize, nr.window)
+	columns := append(stft.Process(noise), stft.GetRemainingOutput()...)
+	if len(columns) == 0 {
+		panic("Can't learn a noise profile without any noise")
+	}
+
+	profile := make([]float64, stft.BinCount(), stft.BinCount())
+	for _, column := range columns {
+		for i, v := range column {
+			profile[i] += cmplx.Abs(v) / float64(len(columns))
+		}
+	}
+	nr.Profile = profile
+}
+
+// LearnRegion sets the noise profile from a region of a sound that contains only noise.
+func (nr *NoiseReducer) LearnRegion(sound s.Sound, start time.Duration, end time.Duration) {
+	from := int(start.Seconds() * s.CyclesPerSecond)
+	to := int(end.Seconds() * s.CyclesPerSecond)
+	if from < 0 || to <= from {
+		panic(fmt.Sprintf("Invalid noise region from %v to %v", start, end))
+	}
+
+	noise := make([]float64, 0, to-from)
+	sound.Start()
+	at := 0
+	for sample := range sound.GetSamples() {
+		if at >= from {
+			noise = append(noise, sample)
+		}
+		at++
+		if at == to {
+			break
+		}
+	}
+	sound.Stop()
+	nr.LearnProfile(noise)
+}
+
+// Apply wraps a sound, removing its noise.
+func (nr *NoiseReducer) Apply(wrapped s.Sound) s.Sound {
+	if nr.Profile != nil && len(nr.Profile) != nr.fftSize/2+1 {
+		panic("Noise profile doesn't match the FFT size")
+	}
+	data := noiseReduced{wrapped, nr}
+	return s.NewBaseSound(&data, wrapped.Length())
+}
+
+// A noiseReduced is a sound with a NoiseReducer applied.
+type noiseReduced struct {
+	wrapped s.Sound
+	reducer *NoiseReducer
+}
+
+// Run generates the samples by transforming the wrapped sound, applying gains to each column, and transforming back.
+func (r *noiseReduced) Run(base *s.BaseSound) {
+	nr := r.reducer
+	stft := cq.NewSTFT(nr.fftSize, nr.hopSize, nr.window)
+	istft := cq.NewISTFT(nr.fftSize, nr.hopSize, nr.window)
+	gains := nr.newGains()
+
+	r.wrapped.Start()
+	columns := stft.ProcessChannel(r.wrapped.GetSamples())
+	reduced := make(chan []complex128)
+	go func() {
+		for column := range columns {
+			reduced <- gains.apply(column)
+		}
+		close(reduced)
+	}()
+
+	// The inverse pads the end to whole columns, so stop at the wrapped sound's length.
+	samples := istft.ProcessChannel(reduced)
+	written := uint64(0)
+	for sample := range samples {
+		if written == r.wrapped.Length() || !base.WriteSample(sample) {
+			break
+		}
+		written++
+	}
+	go drain(samples)
+}
+
+// Stop cleans up the sound by stopping the underlying sound.
+func (r *noiseReduced) Stop() {
+	r.wrapped.Stop()
+}
+
+// Reset resets the underlying sound.
+func (r *noiseReduced) Reset() {
+	r.wrapped.Reset()
+}
+
+// String returns the textual representation
+func (r *noiseReduced) String() string {
+	return fmt.Sprintf("NoiseReduced[%s]", r.wrapped)
+}
+
+// noiseGains is the state of a reduction as it moves through the columns of a sound.
+type noiseGains struct {
+	nr    *NoiseReducer
+	floor []float64
+	gains []float64
+	raw   []float64
+
+	// Number of columns the adaptive floor has tracked.
+	tracked int
+}
+
+func (nr *NoiseReducer) newGains() *noiseGains {
+	bins := nr.fftSize/2 + 1
+	gains := make([]float64, bins, bins)
+	for i := range gains {
+		gains[i] = 1.0
+	}
+	return &noiseGains{nr, nr.Profile, gains, make([]float64, bins, bins), 0}
+}
+
+// apply returns a column with each bin scaled by its gain, after updating the gains.
+func (g *noiseGains) apply(column []complex128) []complex128 {
+	nr := g.nr
+	minGain := math.Pow(10.0, -nr.ReductionDB/20.0)
+
+	magnitudes := make([]float64, len(column), len(column))
+	for i, v := range column {
+		magnitudes[i] = cmplx.Abs(v)
+	}
+	if nr.Profile == nil {
+		g.track(magnitudes)
+	}
+
+	for i, m := range magnitudes {
+		noise := g.floor[i]
+		switch nr.Mode {
+		case Gate:
+			g.raw[i] = minGain
+			if m > noise*nr.Threshold {
+				g.raw[i] = 1.0
+			}
+		case Subtract:
+			g.raw[i] = 1.0
+			if m > 0 {
+				g.raw[i] = math.Max(minGain, 1.0-nr.OverSubtraction*noise/m)
+			}
+		}
+	}
+
+	result := make([]complex128, len(column), len(column))
+	for i, v := range column {
+		// Average over neighbouring bins, then let the gain open immediately but close slowly.
+		from, to := maxInt(0, i-nr.FrequencySmoothing), minInt(len(column)-1, i+nr.FrequencySmoothing)
+		sum := 0.0
+		for j := from; j <= to; j++ {
+			sum += g.raw[j]
+		}
+		gain := sum / float64(to-from+1)
+		if gain < g.gains[i] {
+			gain = nr.TimeSmoothing*g.gains[i] + (1.0-nr.TimeSmoothing)*gain
+		}
+		g.gains[i] = gain
+		result[i] = v * complex(gain, 0)
+	}
+	return result
+}
+
+// track moves the adaptive noise floor towards the latest magnitudes. Magnitudes well above
+// the floor are probably sound rather than noise, so are followed much more slowly. For the
+// first columns, until the release would have settled, the floor is the average of them all.
+func (g *noiseGains) track(magnitudes []float64) {
+	if g.floor == nil {
+		g.floor = make([]float64, len(magnitudes), len(magnitudes))
+	}
+	g.tracked++
+	settling := 0.0
+	if float64(g.tracked)*g.nr.TrackingRelease <= 1.0 {
+		settling = 1.0 / float64(g.tracked)
+	}
+	for i, m := range magnitudes {
+		rate := g.nr.TrackingRelease
+		if m > g.floor[i]*g.nr.Threshold {
+			rate = g.nr.TrackingAttack
+		}
+		g.floor[i] += math.Max(rate, settling) * (m - g.floor[i])
+	}
+}
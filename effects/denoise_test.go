@@ -0,0 +1,86 @@
+package effects
+
+// go test github.com/padster/go-sound/effects
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/padster/go-sound/cq"
+	s "github.com/padster/go-sound/sounds"
+)
+
+func TestNoiseReducerWithProfile(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	noise := func(length int) []float64 {
+		result := make([]float64, length, length)
+		for i := range result {
+			result[i] = 0.05 * rng.NormFloat64()
+		}
+		return result
+	}
+
+	// Half a second of noise alone, then a tone over the noise.
+	second := int(s.CyclesPerSecond)
+	quiet, length := second/2, 2*second
+	clean := make([]float64, length, length)
+	for i := quiet; i < length; i++ {
+		clean[i] = 0.5 * math.Sin(2.0*math.Pi*1000.0*float64(i)/s.CyclesPerSecond)
+	}
+	in := noise(length)
+	for i := range in {
+		in[i] += clean[i]
+	}
+
+	for _, mode := range []NoiseReduction{Gate, Subtract} {
+		nr := NewNoiseReducer(2048, 512, cq.SqrtHann)
+		nr.Mode = mode
+		nr.LearnProfile(noise(second))
+		out := s.RenderToSlice(nr.Apply(s.WrapSliceAsSound(in)))
+		if len(out) != len(in) {
+			t.Fatalf("Mode %d: noise reduction changed the length from %d to %d", mode, len(in), len(out))
+		}
+
+		// Skip the first and last columns of each part, which overlap the edges.
+		floor := 10.0 * math.Log10(energy(out[2048:quiet-2048])/energy(in[2048:quiet-2048]))
+		if floor > -6 {
+			t.Errorf("Mode %d: noise floor changed by %.2fdB, expected at least 6dB of reduction", mode, floor)
+		}
+
+		tone := out[quiet+2048 : length-2048]
+		if lag := bestLag(tone, clean[quiet+2048:length-2048], 20); lag != 0 {
+			t.Errorf("Mode %d: tone is offset by %d samples, expected it to be aligned", mode, lag)
+		}
+		// The noise under the tone is reduced less, but should still improve on the input's ~17dB.
+		snr := signalToNoise(tone, clean[quiet+2048:])
+		inSNR := signalToNoise(in[quiet+2048:length-2048], clean[quiet+2048:])
+		if snr < inSNR+3 {
+			t.Errorf("Mode %d: tone is %.2fdB above the remaining noise, expected at least 3dB more than the input's %.2fdB", mode, snr, inSNR)
+		}
+	}
+}
+
+// signalToNoise returns how much louder the clean samples are than the difference from them, in dB.
+func signalToNoise(samples []float64, clean []float64) float64 {
+	residual := make([]float64, len(samples), len(samples))
+	for i, v := range samples {
+		residual[i] = v - clean[i]
+	}
+	return 10.0 * math.Log10(energy(clean[:len(samples)])/energy(residual))
+}
+
+// bestLag returns the offset of a from b, within the given number of samples, that correlates them most.
+func bestLag(a []float64, b []float64, within int) int {
+	best, bestSum := 0, math.Inf(-1)
+	for lag := -within; lag <= within; lag++ {
+		sum := 0.0
+		for i := within; i < len(a)-within; i++ {
+			sum += a[i] * b[i-lag]
+		}
+		if sum > bestSum {
+			best, bestSum = lag, sum
+		}
+	}
+	return best
+}
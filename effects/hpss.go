@@ -0,0 +1,189 @@
+// Package effects processes whole sounds, using the spectral transforms of the cq package.
+package effects
+
+import (
+	"math"
+	"math/cmplx"
+	"runtime"
+
+	"github.com/padster/go-sound/cq"
+	s "github.com/padster/go-sound/sounds"
+)
+
+// HPSS separates a sound into its harmonic part (steady tones, horizontal lines in a spectrogram),
+// percussive part (transients, vertical lines), and the residual that's clearly neither. Each bin's
+// magnitude is median filtered across time, which keeps tones but removes transients, and across
+// frequency, which does the opposite, and the two are compared to split the values between parts
+// (Fitzgerald 2010, with the margins of Driedger et al. 2014).
+type HPSS struct {
+	// HarmonicKernel is the number of columns the magnitudes are median filtered over in time.
+	HarmonicKernel int
+
+	// PercussiveKernel is the number of bins the magnitudes are median filtered over in frequency.
+	PercussiveKernel int
+
+	// Margin is how many times larger one filtered magnitude must be than the other for a value
+	// to be harmonic or percussive. 1 leaves no residual, larger values leave more in it.
+	Margin float64
+
+	// Binary gives each value entirely to one part, rather than sharing it between them.
+	Binary bool
+
+	// Power of the soft masks, higher values are closer to binary.
+	Power float64
+}
+
+// NewHPSS creates a separation with median filters of the given sizes, which should be odd.
+// Around a fifth of a second of columns, and a semitone's worth of linear STFT bins or
+// an octave of CQ bins, are good starting points.
+//
+// For example, to pull the drums out of a song:
+//  hpss := effects.NewHPSS(17, 17)
+//  _, drums, _ := hpss.SeparateSTFT(2048, 512, cq.SqrtHann, f.Read("song.wav"))
+func NewHPSS(harmonicKernel int, percussiveKernel int) *HPSS {
+	if harmonicKernel < 1 || percussiveKernel < 1 {
+		panic("HPSS kernels must be at least 1")
+	}
+	return &HPSS{
+		harmonicKernel,
+		percussiveKernel,
+		1.0,   /* Margin */
+		false, /* Binary */
+		2.0,   /* Power */
+	}
+}
+
+// Masks returns the fraction of each value of a magnitude spectrogram, with columns of equal
+// height, belonging to each part. The three always add up to 1.
+func (h *HPSS) Masks(magnitudes [][]float64) (harmonic [][]float64, percussive [][]float64, residual [][]float64) {
+	if h.Margin < 1 {
+		panic("HPSS margin must be at least 1")
+	}
+	width := len(magnitudes)
+	harmonic = make([][]float64, width, width)
+	percussive = make([][]float64, width, width)
+	residual = make([][]float64, width, width)
+	if width == 0 {
+		return
+	}
+
+	height := len(magnitudes[0])
+	alongTime := make([][]float64, width, width)
+	for i := range alongTime {
+		if len(magnitudes[i]) != height {
+			panic("HPSS magnitude columns must all be the same height")
+		}
+		alongTime[i] = make([]float64, height, height)
+	}
+	row := make([]float64, width, width)
+	for b := 0; b < height; b++ {
+		for i := range row {
+			row[i] = magnitudes[i][b]
+		}
+		for i, v := range medianFilter(row, h.HarmonicKernel) {
+			alongTime[i][b] = v
+		}
+	}
+
+	for i, column := range magnitudes {
+		alongFrequency := medianFilter(column, h.PercussiveKernel)
+		harmonic[i] = make([]float64, height, height)
+		percussive[i] = make([]float64, height, height)
+		residual[i] = make([]float64, height, height)
+		for b := range column {
+			harmonic[i][b] = h.mask(alongTime[i][b], alongFrequency[b])
+			percussive[i][b] = h.mask(alongFrequency[b], alongTime[i][b])
+			residual[i][b] = math.Max(0, 1.0-harmonic[i][b]-percussive[i][b])
+		}
+	}
+	return
+}
+
+// mask is the fraction of a value given to the part whose filtered magnitude is x, against y for the other.
+func (h *HPSS) mask(x float64, y float64) float64 {
+	y *= h.Margin
+	if h.Binary {
+		if x > y {
+			return 1.0
+		}
+		return 0.0
+	}
+	if x+y == 0 {
+		// Silence is shared equally, unless the margin sends some of it to the residual.
+		return 0.5 / h.Margin
+	}
+	xp, yp := math.Pow(x, h.Power), math.Pow(y, h.Power)
+	return xp / (xp + yp)
+}
+
+// Separate splits columns into their three parts. Columns may be raw CQ columns of varying
+// height, in which case the bins missing from a column are taken from the previous one.
+func (h *HPSS) Separate(columns [][]complex128) (harmonic [][]complex128, percussive [][]complex128, residual [][]complex128) {
+	harmonicMask, percussiveMask, residualMask := h.Masks(heldMagnitudes(columns))
+	return applyMask(columns, harmonicMask), applyMask(columns, percussiveMask), applyMask(columns, residualMask)
+}
+
+// SeparateCQ separates a sound using a constant Q transform, which resolves low notes well.
+// The percussive kernel is in CQ bins, e.g. params.BinsPerOctave.
+func (h *HPSS) SeparateCQ(params cq.CQParams, sound s.Sound) (harmonic s.Sound, percussive s.Sound, residual s.Sound) {
+	samples := s.RenderToSlice(sound)
+	columns := cq.ProcessBatch(params, samples, runtime.NumCPU())
+	toSound := func(columns [][]complex128) s.Sound {
+		out := cq.InverseBatch(params, columns)
+		return s.WrapSliceAsSound(out[:minInt(len(out), len(samples))])
+	}
+	hColumns, pColumns, rColumns := h.Separate(columns)
+	return toSound(hColumns), toSound(pColumns), toSound(rColumns)
+}
+
+// SeparateSTFT separates a sound using a short-time Fourier transform with the given parameters.
+func (h *HPSS) SeparateSTFT(fftSize int, hopSize int, window cq.Window, sound s.Sound) (harmonic s.Sound, percussive s.Sound, residual s.Sound) {
+	samples := s.RenderToSlice(sound)
+	stft := cq.NewSTFT(fftSize, hopSize, window)
+	columns := append(stft.Process(samples), stft.GetRemainingOutput()...)
+	toSound := func(columns [][]complex128) s.Sound {
+		istft := cq.NewISTFT(fftSize, hopSize, window)
+		out := append(istft.Process(columns), istft.GetRemainingOutput()...)
+		return s.WrapSliceAsSound(out[:minInt(len(out), len(samples))])
+	}
+	hColumns, pColumns, rColumns := h.Separate(columns)
+	return toSound(hColumns), toSound(pColumns), toSound(rColumns)
+}
+
+// heldMagnitudes returns the magnitudes of columns at the height of the tallest, filling bins
+// missing from a column with the most recent value for that bin.
+func heldMagnitudes(columns [][]complex128) [][]float64 {
+	height := 0
+	for _, column := range columns {
+		if len(column) > height {
+			height = len(column)
+		}
+	}
+	result := make([][]float64, len(columns), len(columns))
+	for i, column := range columns {
+		result[i] = make([]float64, height, height)
+		if i > 0 {
+			copy(result[i], result[i-1])
+		}
+		for b, v := range column {
+			if m := cmplx.Abs(v); !math.IsNaN(m) {
+				result[i][b] = m
+			} else {
+				result[i][b] = 0
+			}
+		}
+	}
+	return result
+}
+
+// applyMask scales each value by its mask.
+func applyMask(columns [][]complex128, mask [][]float64) [][]complex128 {
+	result := make([][]complex128, len(columns), len(columns))
+	for i, column := range columns {
+		result[i] = make([]complex128, len(column), len(column))
+		for b, v := range column {
+			result[i][b] = v * complex(mask[i][b], 0)
+		}
+	}
+	return result
+}
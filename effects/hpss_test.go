@@ -0,0 +1,114 @@
+package effects
+
+// go test github.com/padster/go-sound/effects
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/padster/go-sound/cq"
+	s "github.com/padster/go-sound/sounds"
+)
+
+func TestMedianFilter(t *testing.T) {
+	values := []float64{5, 1, 4, 2, 3}
+	tests := []struct {
+		kernel   int
+		expected []float64
+	}{
+		{1, []float64{5, 1, 4, 2, 3}},
+		{3, []float64{3, 4, 2, 3, 2.5}},
+		// Even windows have the extra value before the centre.
+		{4, []float64{3, 4, 3, 2.5, 3}},
+		{5, []float64{4, 3, 3, 2.5, 3}},
+	}
+	for _, test := range tests {
+		for i, v := range medianFilter(values, test.kernel) {
+			if v != test.expected[i] {
+				t.Errorf("Kernel %d: value %d is %v, expected %v", test.kernel, i, v, test.expected[i])
+			}
+		}
+	}
+
+	// Compare against sorting every window, including repeated values.
+	r := rand.New(rand.NewSource(1))
+	random := make([]float64, 50, 50)
+	for i := range random {
+		random[i] = float64(r.Intn(10))
+	}
+	for kernel := 1; kernel <= 8; kernel++ {
+		for i, v := range medianFilter(random, kernel) {
+			window := append([]float64{}, random[maxInt(0, i-kernel/2):minInt(len(random), i+kernel-kernel/2)]...)
+			sort.Float64s(window)
+			n := len(window)
+			expected := (window[(n-1)/2] + window[n/2]) / 2
+			if v != expected {
+				t.Errorf("Kernel %d: value %d is %v, expected %v", kernel, i, v, expected)
+			}
+		}
+	}
+}
+
+func TestMasksSumToOne(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	magnitudes := make([][]float64, 30, 30)
+	for i := range magnitudes {
+		magnitudes[i] = make([]float64, 20, 20)
+		for b := range magnitudes[i] {
+			if i%7 != 0 { // Some silent columns.
+				magnitudes[i][b] = r.ExpFloat64()
+			}
+		}
+	}
+
+	for _, binary := range []bool{false, true} {
+		hpss := NewHPSS(5, 5)
+		hpss.Binary = binary
+		harmonic, percussive, residual := hpss.Masks(magnitudes)
+		for i := range magnitudes {
+			for b := range magnitudes[i] {
+				if sum := harmonic[i][b] + percussive[i][b] + residual[i][b]; math.Abs(sum-1) > 1e-12 {
+					t.Fatalf("Binary %v: masks at %d, %d add up to %v", binary, i, b, sum)
+				}
+			}
+		}
+	}
+}
+
+func TestSeparateTonesFromClicks(t *testing.T) {
+	length := int(s.CyclesPerSecond / 2)
+	sine, clicks := make([]float64, length, length), make([]float64, length, length)
+	for i := range sine {
+		sine[i] = 0.5 * math.Sin(2*math.Pi*440*float64(i)/s.CyclesPerSecond)
+		if i%4410 == 2000 {
+			clicks[i] = 0.9
+		}
+	}
+
+	hpss := NewHPSS(17, 17)
+	harmonic, percussive, _ := hpss.SeparateSTFT(1024, 256, cq.SqrtHann, s.WrapSliceAsSound(sine))
+	if fraction := energy(s.RenderToSlice(harmonic)) / energy(sine); fraction < 0.9 {
+		t.Errorf("Only %.2f of a sine's energy is harmonic", fraction)
+	}
+	if fraction := energy(s.RenderToSlice(percussive)) / energy(sine); fraction > 0.05 {
+		t.Errorf("%.2f of a sine's energy is percussive", fraction)
+	}
+
+	harmonic, percussive, _ = hpss.SeparateSTFT(1024, 256, cq.SqrtHann, s.WrapSliceAsSound(clicks))
+	if fraction := energy(s.RenderToSlice(percussive)) / energy(clicks); fraction < 0.9 {
+		t.Errorf("Only %.2f of a click train's energy is percussive", fraction)
+	}
+	if fraction := energy(s.RenderToSlice(harmonic)) / energy(clicks); fraction > 0.05 {
+		t.Errorf("%.2f of a click train's energy is harmonic", fraction)
+	}
+}
+
+func energy(samples []float64) float64 {
+	sum := 0.0
+	for _, v := range samples {
+		sum += v * v
+	}
+	return sum
+}
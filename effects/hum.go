@@ -0,0 +1,121 @@
+package effects
+
+import (
+	"fmt"
+	"math"
+
+	s "github.com/padster/go-sound/sounds"
+	"github.com/padster/go-sound/types"
+)
+
+const (
+	// Mains frequencies, whose hum is picked up by poorly shielded recordings.
+	Hum50Hz = 50.0
+	Hum60Hz = 60.0
+)
+
+// HumRemover removes mains hum, at a fundamental frequency and its harmonics, using a
+// cascade of narrow notch filters.
+type HumRemover struct {
+	// Fundamental is the hum's frequency in Hz, usually Hum50Hz or Hum60Hz.
+	Fundamental float64
+
+	// Harmonics is the number of multiples of the fundamental to remove, including itself.
+	Harmonics int
+
+	// Q is the fundamental's notch frequency divided by its width, higher values are narrower
+	// and remove less of the sound around the hum, but take longer to settle.
+	// The harmonics' notches all have the same width in Hz.
+	Q float64
+}
+
+// NewHumRemover creates a remover for hum at a fundamental frequency, and its first harmonics.
+//
+// For example, to remove hum from a recording made somewhere with unknown mains frequency:
+//  samples := ...
+//  cleaned := effects.NewHumRemover(effects.DetectHum(samples)).Apply(s.WrapSliceAsSound(samples))
+func NewHumRemover(fundamental float64) *HumRemover {
+	return &HumRemover{
+		fundamental,
+		8,    /* Harmonics */
+		10.0, /* Q */
+	}
+}
+
+// DetectHum returns whichever of 50Hz and 60Hz hum is strongest in the samples, including harmonics.
+func DetectHum(samples []float64) float64 {
+	power := func(fundamental float64) float64 {
+		total := 0.0
+		for h := 1; h <= 4; h++ {
+			total += goertzelPower(samples, fundamental*float64(h))
+		}
+		return total
+	}
+	if power(Hum60Hz) > power(Hum50Hz) {
+		return Hum60Hz
+	}
+	return Hum50Hz
+}
+
+// Apply wraps a sound, removing its hum.
+func (hr *HumRemover) Apply(wrapped s.Sound) s.Sound {
+	if hr.Fundamental <= 0 || hr.Harmonics < 1 || hr.Q <= 0 {
+		panic(fmt.Sprintf("Invalid hum remover %+v", *hr))
+	}
+	data := humRemoved{wrapped, *hr}
+	return s.NewBaseSound(&data, wrapped.Length())
+}
+
+// A humRemoved is a sound passed through a HumRemover's notch filters.
+type humRemoved struct {
+	wrapped s.Sound
+	remover HumRemover
+}
+
+// Run generates the samples by passing the wrapped sound through every notch in turn.
+func (r *humRemoved) Run(base *s.BaseSound) {
+	filters := []*types.Biquad{}
+	width := r.remover.Fundamental / r.remover.Q
+	for h := 1; h <= r.remover.Harmonics; h++ {
+		hz := r.remover.Fundamental * float64(h)
+		if hz >= s.CyclesPerSecond/2.0 {
+			break
+		}
+		filters = append(filters, types.NewNotchBiquad(hz, hz/width, s.CyclesPerSecond))
+	}
+
+	r.wrapped.Start()
+	for sample := range r.wrapped.GetSamples() {
+		for _, filter := range filters {
+			sample = filter.Process(sample)
+		}
+		if !base.WriteSample(sample) {
+			break
+		}
+	}
+}
+
+// Stop cleans up the sound by stopping the underlying sound.
+func (r *humRemoved) Stop() {
+	r.wrapped.Stop()
+}
+
+// Reset resets the underlying sound. Filter state is only kept within Run.
+func (r *humRemoved) Reset() {
+	r.wrapped.Reset()
+}
+
+// String returns the textual representation
+func (r *humRemoved) String() string {
+	return fmt.Sprintf("HumRemoved[%s @ %vHz]", r.wrapped, r.remover.Fundamental)
+}
+
+// goertzelPower returns the power of the samples at a single frequency.
+func goertzelPower(samples []float64, hz float64) float64 {
+	coefficient := 2.0 * math.Cos(2.0*math.Pi*hz/s.CyclesPerSecond)
+	prev, prev2 := 0.0, 0.0
+	for _, x := range samples {
+		prev, prev2 = x+coefficient*prev-prev2, prev
+	}
+	return prev*prev + prev2*prev2 - coefficient*prev*prev2
+}
@@ -0,0 +1,62 @@
+package effects
+
+// go test github.com/padster/go-sound/effects
+
+import (
+	"math"
+	"testing"
+
+	s "github.com/padster/go-sound/sounds"
+)
+
+func TestHumRemoverNotches(t *testing.T) {
+	second := int(s.CyclesPerSecond)
+	tones := []float64{50, 100, 150, 440}
+	in := sumOfSines(2*second, tones, 0.2)
+	out := s.RenderToSlice(NewHumRemover(Hum50Hz).Apply(s.WrapSliceAsSound(in)))
+	if len(out) != len(in) {
+		t.Fatalf("Hum removal changed the length from %d to %d", len(in), len(out))
+	}
+
+	// Compare the last second, once the notches have settled. It has a whole number
+	// of cycles of every tone, so each is measured without the others leaking in.
+	for _, hz := range tones {
+		change := 10.0 * math.Log10(goertzelPower(out[second:], hz)/goertzelPower(in[second:], hz))
+		if hz == 440 && math.Abs(change) > 0.5 {
+			t.Errorf("%vHz changed by %.2fdB, expected to be passed", hz, change)
+		}
+		if hz != 440 && change > -30 {
+			t.Errorf("%vHz changed by %.2fdB, expected at least 30dB of attenuation", hz, change)
+		}
+	}
+}
+
+func TestDetectHum(t *testing.T) {
+	second := int(s.CyclesPerSecond)
+	tests := []struct {
+		tones    []float64
+		expected float64
+	}{
+		{[]float64{50, 440}, Hum50Hz},
+		{[]float64{60, 440}, Hum60Hz},
+		// Harmonics alone identify the hum, even when the fundamental has been filtered out.
+		{[]float64{100, 150, 330}, Hum50Hz},
+		{[]float64{120, 180, 330}, Hum60Hz},
+	}
+	for _, test := range tests {
+		if hum := DetectHum(sumOfSines(second, test.tones, 0.2)); hum != test.expected {
+			t.Errorf("Tones %v detected %vHz hum, expected %vHz", test.tones, hum, test.expected)
+		}
+	}
+}
+
+// sumOfSines returns samples of sine waves at each frequency, all with the same amplitude.
+func sumOfSines(length int, tones []float64, amplitude float64) []float64 {
+	result := make([]float64, length, length)
+	for i := range result {
+		for _, hz := range tones {
+			result[i] += amplitude * math.Sin(2.0*math.Pi*hz*float64(i)/s.CyclesPerSecond)
+		}
+	}
+	return result
+}
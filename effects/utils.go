@@ -0,0 +1,54 @@
+package effects
+
+import (
+	"sort"
+)
+
+// medianFilter returns the median of the kernel values centred on each value, with the
+// window shrinking to fit at the ends. Even sized windows have one more value before the
+// centre than after it. The window is kept sorted as it slides along.
+func medianFilter(values []float64, kernel int) []float64 {
+	half := kernel / 2
+	result := make([]float64, len(values), len(values))
+	window := make([]float64, 0, kernel)
+	added, removed := 0, 0
+	for i := range values {
+		for ; added < len(values) && added < i+kernel-half; added++ {
+			at := sort.SearchFloat64s(window, values[added])
+			window = append(window, 0)
+			copy(window[at+1:], window[at:])
+			window[at] = values[added]
+		}
+		for ; removed < i-half; removed++ {
+			at := sort.SearchFloat64s(window, values[removed])
+			window = append(window[:at], window[at+1:]...)
+		}
+		n := len(window)
+		if n%2 == 1 {
+			result[i] = window[n/2]
+		} else {
+			result[i] = (window[n/2-1] + window[n/2]) / 2.0
+		}
+	}
+	return result
+}
+
+// drain reads the rest of a channel, so its producer can finish.
+func drain(samples <-chan float64) {
+	for range samples {
+	}
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,237 @@
+package features
+
+import (
+	"math"
+)
+
+const (
+	// Rate (values per second) the detection function is reduced to before tracking.
+	beatFrameRate = 100.0
+
+	// Tempo most likely a priori, and the spread (in octaves) of the tempo preference.
+	preferredBPM    = 120.0
+	preferredSpread = 1.0
+)
+
+// Beat is a single beat placed by the BeatTracker.
+type Beat struct {
+	// Column index in the spectral stream the beat was placed at.
+	Column int
+	// Sample index in the original input where the beat occurs.
+	Sample int
+}
+
+// BeatTracker estimates the tempo of an onset detection function, and then places
+// beats using dynamic programming, trading off onset strength against keeping a
+// steady tempo (see Ellis, "Beat Tracking by Dynamic Programming", 2007).
+type BeatTracker struct {
+	detector *OnsetDetector
+
+	// MinBPM and MaxBPM bound the tempo that can be detected.
+	MinBPM float64
+	MaxBPM float64
+
+	// Tightness is how strongly beats are kept to the estimated tempo, larger is stricter.
+	Tightness float64
+}
+
+// NewBeatTracker creates a tracker for detection functions produced by a given onset detector.
+//
+// For example, to find the tempo and beats of a spectrogram's columns:
+//  od := features.NewOnsetDetector(features.SpectralFlux, hop, latency, s.CyclesPerSecond)
+//  bpm, beats := features.NewBeatTracker(od).Track(od.Process(columns))
+func NewBeatTracker(detector *OnsetDetector) *BeatTracker {
+	return &BeatTracker{
+		detector,
+		60.0,  /* MinBPM */
+		200.0, /* MaxBPM */
+		100.0, /* Tightness */
+	}
+}
+
+// EstimateTempo returns the most likely tempo, in beats per minute, of a detection function.
+func (bt *BeatTracker) EstimateTempo(odf []float64) float64 {
+	pooled, _, rate := bt.pool(odf)
+	return 60.0 * rate / bt.beatPeriod(pooled, rate)
+}
+
+// Track estimates the tempo of a detection function, then places the beats, from the
+// first stronger than average onset to the last.
+func (bt *BeatTracker) Track(odf []float64) (float64, []Beat) {
+	pooled, maxAt, rate := bt.pool(odf)
+	if len(pooled) == 0 {
+		return 0, []Beat{}
+	}
+	period := bt.beatPeriod(pooled, rate)
+
+	// Dynamic programming: best score of a beat sequence ending at each frame.
+	n := len(pooled)
+	score := make([]float64, n, n)
+	backlink := make([]int, n, n)
+	for t := 0; t < n; t++ {
+		backlink[t] = -1
+		best := 0.0
+		from, to := t-round(2*period), t-round(period/2)
+		for prev := maxInt(0, from); prev <= to; prev++ {
+			penalty := math.Log(float64(t-prev) / period)
+			candidate := score[prev] - bt.Tightness*penalty*penalty
+			if backlink[t] == -1 || candidate > best {
+				best, backlink[t] = candidate, prev
+			}
+		}
+		if backlink[t] == -1 || best < 0 {
+			best, backlink[t] = 0, -1
+		}
+		score[t] = pooled[t] + best
+	}
+
+	// Final beat is the best scoring frame within the last period, then walk back.
+	last := n - 1
+	for t := maxInt(0, n-round(period)); t < n; t++ {
+		if score[t] > score[last] {
+			last = t
+		}
+	}
+	reversed := []int{}
+	for t := last; t >= 0; t = backlink[t] {
+		reversed = append(reversed, t)
+	}
+
+	// Beats before the first onset or after the last are only there to keep the tempo, so trim
+	// those at either end with no more than the average onset strength.
+	for len(reversed) > 1 && pooled[reversed[0]] <= 0 {
+		reversed = reversed[1:]
+	}
+	for len(reversed) > 1 && pooled[reversed[len(reversed)-1]] <= 0 {
+		reversed = reversed[:len(reversed)-1]
+	}
+
+	beats := make([]Beat, len(reversed), len(reversed))
+	for i, t := range reversed {
+		column := maxAt[t]
+		beats[len(reversed)-1-i] = Beat{column, bt.detector.ColumnToSample(column)}
+	}
+	return 60.0 * rate / period, beats
+}
+
+// pool reduces a detection function to roughly beatFrameRate by taking the max of
+// consecutive groups, returning the pooled values normalized to unit variance,
+// the original index of each max, and the resulting frame rate.
+func (bt *BeatTracker) pool(odf []float64) ([]float64, []int, float64) {
+	factor := maxInt(1, round(bt.detector.FrameRate()/beatFrameRate))
+	n := len(odf) / factor
+
+	pooled := make([]float64, n, n)
+	maxAt := make([]int, n, n)
+	for i := 0; i < n; i++ {
+		maxAt[i] = i * factor
+		for j := i * factor; j < (i+1)*factor; j++ {
+			if odf[j] > odf[maxAt[i]] {
+				maxAt[i] = j
+			}
+		}
+		pooled[i] = odf[maxAt[i]]
+	}
+
+	if n > 0 {
+		pooled = normalize(pooled)
+	}
+	return pooled, maxAt, bt.detector.FrameRate() / float64(factor)
+}
+
+// beatPeriod finds the beat period (in frames) with the strongest autocorrelation,
+// weighted towards the preferred tempo.
+func (bt *BeatTracker) beatPeriod(pooled []float64, rate float64) float64 {
+	minLag := maxInt(1, round(60.0*rate/bt.MaxBPM))
+	maxLag := round(60.0 * rate / bt.MinBPM)
+	preferredLag := 60.0 * rate / preferredBPM
+
+	n := len(pooled)
+	if maxLag >= n-1 {
+		return preferredLag
+	}
+
+	weighted := make([]float64, maxLag+2, maxLag+2)
+	for lag := minLag - 1; lag <= maxLag+1; lag++ {
+		if lag < 1 {
+			continue
+		}
+		ac := 0.0
+		for i := 0; i+lag < n; i++ {
+			ac += pooled[i] * pooled[i+lag]
+		}
+		ac /= float64(n - lag)
+
+		octaves := math.Log2(float64(lag)/preferredLag) / preferredSpread
+		weighted[lag] = ac * math.Exp(-0.5*octaves*octaves)
+	}
+
+	best := minLag
+	for lag := minLag; lag <= maxLag; lag++ {
+		if weighted[lag] > weighted[best] {
+			best = lag
+		}
+	}
+
+	// Parabolic interpolation for a fractional lag.
+	period := float64(best)
+	if best > 1 {
+		a, b, c := weighted[best-1], weighted[best], weighted[best+1]
+		if denom := a - 2*b + c; denom < 0 {
+			period += 0.5 * (a - c) / denom
+		}
+	}
+	return period
+}
+
+// BeatSamples returns just the sample indexes of a collection of beats.
+func BeatSamples(beats []Beat) []int {
+	result := make([]int, len(beats), len(beats))
+	for i, b := range beats {
+		result[i] = b.Sample
+	}
+	return result
+}
+
+// EventChannel converts sorted sample indexes into a sample-indexed stream of length
+// values, which is the marker at each given sample and nil elsewhere. This matches the
+// events channel used by util.Screen, so beats or onsets can be drawn over a waveform.
+//
+// For example, to draw beats in red over a sound:
+//  events := features.EventChannel(features.BeatSamples(beats), n, util.Event{1, 0, 0})
+func EventChannel(samples []int, length int, marker interface{}) <-chan interface{} {
+	result := make(chan interface{})
+	go func() {
+		next := 0
+		for i := 0; i < length; i++ {
+			for next < len(samples) && samples[next] < i {
+				next++
+			}
+			if next < len(samples) && samples[next] == i {
+				result <- marker
+			} else {
+				result <- nil
+			}
+		}
+		close(result)
+	}()
+	return result
+}
+
+// SliceAt cuts samples into consecutive pieces starting at each of the given sorted
+// sample indexes (e.g. beats), which can then be rearranged or time-stretched to
+// match another tempo. Samples before the first cut are dropped.
+func SliceAt(samples []float64, cuts []int) [][]float64 {
+	result := [][]float64{}
+	for i, cut := range cuts {
+		end := len(samples)
+		if i+1 < len(cuts) {
+			end = minInt(end, cuts[i+1])
+		}
+		cut = maxInt(0, cut)
+		if cut < end {
+			result = append(result, samples[cut:end])
+		}
+	}
+	return result
+}
@@ -0,0 +1,264 @@
+package features
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	// NoChord is the label used for sections without harmony, e.g. silence.
+	NoChord = "N"
+
+	// Rate (frames per second) chroma is averaged down to before recognition.
+	chordFrameRate = 10.0
+
+	// Frames with less than this fraction of the average energy are considered silent.
+	silenceFraction = 0.01
+)
+
+// chordType is a chord modifier (as used by util.ParseChord) and its semitone offsets.
+type chordType struct {
+	modifier string
+	offsets  []int
+}
+
+// The chord vocabulary recognised, a subset of those accepted by util.ParseChord.
+var chordTypes = []chordType{
+	{"", []int{0, 4, 7}},
+	{"m", []int{0, 3, 7}},
+	{"7", []int{0, 4, 7, 10}},
+	{"M7", []int{0, 4, 7, 11}},
+	{"m7", []int{0, 3, 7, 10}},
+	{"dim", []int{0, 3, 6}},
+	{"aug", []int{0, 4, 8}},
+	{"sus2", []int{0, 2, 7}},
+	{"sus4", []int{0, 5, 7}},
+}
+
+// ChordSegment is a section of the input with a single chord.
+type ChordSegment struct {
+	// Start (inclusive) and end (exclusive) sample indexes of the chord.
+	StartSample int
+	EndSample   int
+	// Chord name, e.g. "G#sus4", which can be passed to util.ParseChord, or NoChord.
+	Label string
+}
+
+// ChordRecognizer labels chroma with chords by matching each frame against chord
+// templates, then smoothing the result over time with a hidden Markov model, so
+// that short-lived changes (passing notes, transients) do not split a chord.
+type ChordRecognizer struct {
+	hopSize    int
+	latency    int
+	sampleRate float64
+
+	// Sharpness scales template similarity into log likelihoods, larger trusts each frame more.
+	Sharpness float64
+
+	// SwitchPenalty is the log probability cost of changing chord between frames.
+	SwitchPenalty float64
+
+	// NoChordSimilarity is the template similarity assigned to NoChord; frames
+	// matching no chord better than this are labelled NoChord.
+	NoChordSimilarity float64
+
+	labels    []string
+	templates [][]float64
+}
+
+// NewChordRecognizer creates a recognizer for chroma whose columns are hopSize samples
+// apart, and lag the input by latency samples.
+//
+// For example, to write the chords of a spectrogram's columns to a text file:
+//  chroma := features.NewChromagram(params).Process(columns)
+//  cr := features.NewChordRecognizer(spectrogram.SamplesPerColumn(), spectrogram.OutputLatency(), s.CyclesPerSecond)
+//  features.WriteChordTimeline(w, cr.Recognize(chroma), s.CyclesPerSecond)
+func NewChordRecognizer(hopSize int, latency int, sampleRate float64) *ChordRecognizer {
+	labels, templates := []string{NoChord}, [][]float64{nil}
+	for root := 0; root < 12; root++ {
+		for _, t := range chordTypes {
+			template := make([]float64, 12, 12)
+			for _, offset := range t.offsets {
+				template[(root+offset)%12] = 1.0
+			}
+			labels = append(labels, NoteNames[root]+t.modifier)
+			templates = append(templates, unitVector(template))
+		}
+	}
+
+	return &ChordRecognizer{
+		hopSize,
+		latency,
+		sampleRate,
+		20.0, /* Sharpness */
+		4.0,  /* SwitchPenalty */
+		0.5,  /* NoChordSimilarity */
+		labels,
+		templates,
+	}
+}
+
+// Recognize returns the chord timeline for chroma vectors, one per column.
+func (cr *ChordRecognizer) Recognize(chroma [][]float64) []ChordSegment {
+	if len(chroma) == 0 {
+		return []ChordSegment{}
+	}
+
+	frameSize := maxInt(1, round(cr.sampleRate/float64(cr.hopSize)/chordFrameRate))
+	frames := (len(chroma) + frameSize - 1) / frameSize
+	states := len(cr.labels)
+
+	frameChroma := make([][]float64, frames, frames)
+	energies := make([]float64, frames, frames)
+	for f := 0; f < frames; f++ {
+		frameChroma[f] = sumChroma(chroma[f*frameSize : minInt(len(chroma), (f+1)*frameSize)])
+		for _, v := range frameChroma[f] {
+			energies[f] += v
+		}
+	}
+	// Frames much quieter than average are treated as silence, not normalized up.
+	silence := silenceFraction * meanOf(energies)
+
+	// Viterbi, with uniform switching cost between any two chords, so the best
+	// previous state is either staying the same, or the overall best so far.
+	score := make([]float64, states, states)
+	backlinks := make([][]int, frames, frames)
+	for f := 0; f < frames; f++ {
+		if energies[f] <= silence {
+			frameChroma[f] = make([]float64, 12, 12)
+		}
+		emission := cr.emission(frameChroma[f])
+
+		bestPrev := 0
+		for s := 1; s < states; s++ {
+			if score[s] > score[bestPrev] {
+				bestPrev = s
+			}
+		}
+
+		backlinks[f] = make([]int, states, states)
+		next := make([]float64, states, states)
+		for s := 0; s < states; s++ {
+			stay, change := score[s], score[bestPrev]-cr.SwitchPenalty
+			if f == 0 || stay >= change {
+				next[s], backlinks[f][s] = stay, s
+			} else {
+				next[s], backlinks[f][s] = change, bestPrev
+			}
+			next[s] += emission[s]
+		}
+		score = next
+	}
+
+	// Walk back the best path, then merge runs of the same chord.
+	path := make([]int, frames, frames)
+	for s := 1; s < states; s++ {
+		if score[s] > score[path[frames-1]] {
+			path[frames-1] = s
+		}
+	}
+	for f := frames - 1; f > 0; f-- {
+		path[f-1] = backlinks[f][path[f]]
+	}
+
+	result := []ChordSegment{}
+	for f := 0; f < frames; f++ {
+		start := cr.columnToSample(f * frameSize)
+		end := cr.columnToSample(minInt(len(chroma), (f+1)*frameSize))
+		label := cr.labels[path[f]]
+		if end <= start {
+			continue
+		}
+		if n := len(result); n > 0 && result[n-1].Label == label {
+			result[n-1].EndSample = end
+		} else {
+			result = append(result, ChordSegment{start, end, label})
+		}
+	}
+	return result
+}
+
+// emission returns the log likelihood of a chroma vector for each chord.
+func (cr *ChordRecognizer) emission(chroma []float64) []float64 {
+	normalized := unitVector(chroma)
+	result := make([]float64, len(cr.templates), len(cr.templates))
+	for i, template := range cr.templates {
+		similarity := cr.NoChordSimilarity
+		if template != nil {
+			similarity = 0
+			for j, v := range template {
+				similarity += v * normalized[j]
+			}
+		}
+		result[i] = cr.Sharpness * similarity
+	}
+	return result
+}
+
+func (cr *ChordRecognizer) columnToSample(column int) int {
+	return maxInt(0, column*cr.hopSize-cr.latency)
+}
+
+// WriteChordTimeline writes chords as text, one "<start> <end> <label>" line per chord
+// with times in seconds, the same layout as .lab annotation files.
+func WriteChordTimeline(w io.Writer, segments []ChordSegment, sampleRate float64) error {
+	for _, segment := range segments {
+		_, err := fmt.Fprintf(w, "%.3f\t%.3f\t%s\n",
+			float64(segment.StartSample)/sampleRate, float64(segment.EndSample)/sampleRate, segment.Label)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadChordTimeline parses chords written by WriteChordTimeline.
+func ReadChordTimeline(r io.Reader, sampleRate float64) ([]ChordSegment, error) {
+	result := []ChordSegment{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("Line %d: expected <start> <end> <label>, got %q", line, text)
+		}
+		start, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: bad start time: %v", line, err)
+		}
+		end, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: bad end time: %v", line, err)
+		}
+		result = append(result, ChordSegment{
+			int(math.Floor(start*sampleRate + 0.5)),
+			int(math.Floor(end*sampleRate + 0.5)),
+			fields[2],
+		})
+	}
+	return result, scanner.Err()
+}
+
+// unitVector returns a copy of values scaled to have length 1, or zeros if silent.
+func unitVector(values []float64) []float64 {
+	length := 0.0
+	for _, v := range values {
+		length += v * v
+	}
+	length = math.Sqrt(length)
+
+	result := make([]float64, len(values), len(values))
+	if length > 0 {
+		for i, v := range values {
+			result[i] = v / length
+		}
+	}
+	return result
+}
@@ -0,0 +1,151 @@
+package features
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+
+	"github.com/padster/go-sound/cq"
+)
+
+// NoteNames are the pitch classes in chroma order, in the form util.ParseChord accepts.
+var NoteNames = []string{"C", "C#", "D", "D#", "E", "F", "F#", "G", "G#", "A", "A#", "B"}
+
+// Chromagram folds constant Q columns into 12 pitch classes (C, C#, ..., B), summing
+// the energy of each note across all octaves.
+//
+// Columns may be either raw constant Q output or interpolated Spectrogram columns,
+// though the latter give smoother results as every column then covers all octaves.
+type Chromagram struct {
+	params cq.CQParams
+
+	// Offset (in semitones) of the tuning from A440, in [-0.5, 0.5).
+	tuning float64
+}
+
+// NewChromagram creates a chroma extractor for columns generated with the given parameters,
+// which must have a multiple of 12 bins per octave.
+func NewChromagram(params cq.CQParams) *Chromagram {
+	if params.BinsPerOctave%12 != 0 {
+		panic(fmt.Sprintf("Chromagram requires bins per octave to be a multiple of 12, not %d", params.BinsPerOctave))
+	}
+	return &Chromagram{
+		params,
+		0.0, /* tuning */
+	}
+}
+
+// Tuning returns the tuning offset in semitones currently used.
+func (c *Chromagram) Tuning() float64 {
+	return c.tuning
+}
+
+// SetTuning changes the tuning offset, e.g. 0.1 to map notes a tenth of a semitone sharp.
+func (c *Chromagram) SetTuning(semitones float64) {
+	c.tuning = semitones
+}
+
+// EstimateTuning finds the offset from A440 tuning of the spectral peaks in the columns,
+// as the energy-weighted circular mean of their distance from the nearest semitone.
+// The result is also used as the tuning for subsequent processing.
+func (c *Chromagram) EstimateTuning(columns [][]complex128) float64 {
+	sumX, sumY := 0.0, 0.0
+	for _, column := range columns {
+		for i := 1; i+1 < len(column); i++ {
+			a, b, d := cmplx.Abs(column[i-1]), cmplx.Abs(column[i]), cmplx.Abs(column[i+1])
+			if b <= a || b < d || b == 0 || math.IsNaN(a+b+d) {
+				continue
+			}
+
+			// Parabolic interpolation on log magnitude for a sub-bin position.
+			la, lb, ld := math.Log(a+1e-12), math.Log(b), math.Log(d+1e-12)
+			offset := 0.0
+			if denom := la - 2*lb + ld; denom < 0 {
+				offset = 0.5 * (la - ld) / denom
+			}
+
+			deviation := c.binToMidi(float64(i)+offset, 0.0)
+			angle := 2 * math.Pi * (deviation - math.Floor(deviation+0.5))
+			sumX += b * b * math.Cos(angle)
+			sumY += b * b * math.Sin(angle)
+		}
+	}
+
+	if sumX != 0 || sumY != 0 {
+		c.tuning = math.Atan2(sumY, sumX) / (2 * math.Pi)
+	}
+	return c.tuning
+}
+
+// ProcessChannel converts a stream of constant Q columns into 12-value chroma vectors.
+func (c *Chromagram) ProcessChannel(columns <-chan []complex128) <-chan []float64 {
+	result := make(chan []float64)
+	go func() {
+		for column := range columns {
+			result <- c.processColumn(column)
+		}
+		close(result)
+	}()
+	return result
+}
+
+// Process converts constant Q columns into 12-value chroma vectors.
+func (c *Chromagram) Process(columns [][]complex128) [][]float64 {
+	result := make([][]float64, len(columns), len(columns))
+	for i, column := range columns {
+		result[i] = c.processColumn(column)
+	}
+	return result
+}
+
+func (c *Chromagram) processColumn(column []complex128) []float64 {
+	chroma := make([]float64, 12, 12)
+	for i, v := range column {
+		// Bins between two semitones are shared between both, by proximity.
+		midi := c.binToMidi(float64(i), c.tuning)
+		lower := math.Floor(midi)
+		upperWeight := midi - lower
+
+		energy := real(v)*real(v) + imag(v)*imag(v)
+		if math.IsNaN(energy) {
+			continue
+		}
+		pitchClass := mod12(int(lower))
+		chroma[pitchClass] += energy * (1 - upperWeight)
+		chroma[(pitchClass+1)%12] += energy * upperWeight
+	}
+	return chroma
+}
+
+// binToMidi converts a (possibly fractional) bin index into a fractional midi note number.
+func (c *Chromagram) binToMidi(bin float64, tuning float64) float64 {
+	hz := c.params.BinFrequency(0) * math.Pow(2.0, -bin/float64(c.params.BinsPerOctave))
+	return hzToMidi(hz) - tuning
+}
+
+// NormalizeChroma scales each chroma vector to sum to 1, leaving silent ones as zero.
+func NormalizeChroma(chroma [][]float64) [][]float64 {
+	result := make([][]float64, len(chroma), len(chroma))
+	for i, vector := range chroma {
+		sum := 0.0
+		for _, v := range vector {
+			sum += v
+		}
+		result[i] = make([]float64, len(vector), len(vector))
+		if sum > 0 {
+			for j, v := range vector {
+				result[i][j] = v / sum
+			}
+		}
+	}
+	return result
+}
+
+// hzToMidi converts a frequency to a fractional midi note number, with A4 = 440Hz = 69.
+func hzToMidi(hz float64) float64 {
+	return 69.0 + 12.0*math.Log2(hz/440.0)
+}
+
+func mod12(v int) int {
+	return ((v % 12) + 12) % 12
+}
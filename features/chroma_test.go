@@ -0,0 +1,102 @@
+package features
+
+// go test github.com/padster/go-sound/features
+
+import (
+	"math"
+	"testing"
+
+	"github.com/padster/go-sound/cq"
+	s "github.com/padster/go-sound/sounds"
+)
+
+func TestChromaOfTriad(t *testing.T) {
+	// C4, E4 and G4.
+	samples := notes(2.0, 60, 64, 67)
+	chroma := sumChroma(NewChromagram(testChromaParams()).Process(testChromaColumns(samples)))
+
+	triad, others := math.Inf(1), 0.0
+	for i, v := range chroma {
+		if i == 0 || i == 4 || i == 7 {
+			triad = math.Min(triad, v)
+		} else {
+			others = math.Max(others, v)
+		}
+	}
+	if others > triad/10 {
+		t.Errorf("Expected C, E and G to be the strongest pitch classes by 10x, got %v", chroma)
+	}
+}
+
+func TestEstimateTuning(t *testing.T) {
+	for _, cents := range []float64{0, 20, -30} {
+		samples := tone(2.0, 440.0*math.Pow(2.0, cents/1200.0))
+		chromagram := NewChromagram(testChromaParams())
+		if tuning := chromagram.EstimateTuning(testChromaColumns(samples)); math.Abs(tuning-cents/100) > 0.03 {
+			t.Errorf("A4 %v cents sharp estimated as %.3f semitones", cents, tuning)
+		}
+		if chromagram.Tuning() != chromagram.EstimateTuning(nil) {
+			t.Errorf("Expected the estimated tuning to be kept")
+		}
+	}
+}
+
+func TestKeyOfScale(t *testing.T) {
+	// Scales up from the tonic, ending on it an octave higher.
+	tests := []struct {
+		notes    []int
+		expected string
+	}{
+		{[]int{60, 62, 64, 65, 67, 69, 71, 72}, "C"},
+		{[]int{57, 59, 60, 62, 64, 65, 68, 69}, "Am"},
+		{[]int{66, 68, 70, 71, 73, 75, 77, 78}, "F#"},
+	}
+	for _, test := range tests {
+		samples := []float64{}
+		for _, note := range test.notes {
+			samples = append(samples, notes(0.5, note)...)
+		}
+		chroma := NewChromagram(testChromaParams()).Process(testChromaColumns(samples))
+		for _, profiles := range []KeyProfiles{KrumhanslProfiles, TemperleyProfiles} {
+			kd := NewKeyDetector(profiles)
+			if key := kd.EstimateKey(chroma); key.String() != test.expected {
+				t.Errorf("Scale %v is in %v, expected %s", test.notes, key, test.expected)
+			}
+			windows := kd.EstimateKeys(chroma, len(chroma)/2, len(chroma)/4)
+			if last := windows[len(windows)-1]; last.EndColumn != len(chroma) {
+				t.Errorf("Key windows end at column %d, expected %d", last.EndColumn, len(chroma))
+			}
+		}
+	}
+}
+
+func testChromaParams() cq.CQParams {
+	return cq.NewCQParams(s.CyclesPerSecond, 5, 55.0, 36)
+}
+
+func testChromaColumns(samples []float64) [][]complex128 {
+	spectrogram := cq.NewSpectrogram(testChromaParams())
+	return append(spectrogram.Process(samples), spectrogram.GetRemainingOutput()...)
+}
+
+// notes returns sines at midi note numbers, played together for the given number of seconds.
+func notes(seconds float64, midi ...int) []float64 {
+	result := make([]float64, int(seconds*s.CyclesPerSecond))
+	for _, note := range midi {
+		for i, v := range tone(seconds, 440.0*math.Pow(2.0, float64(note-69)/12.0)) {
+			result[i] += v / float64(len(midi))
+		}
+	}
+	return result
+}
+
+// tone returns a sine at hz, faded in and out to avoid clicks between notes.
+func tone(seconds float64, hz float64) []float64 {
+	result := make([]float64, int(seconds*s.CyclesPerSecond))
+	fade := int(0.01 * s.CyclesPerSecond)
+	for i := range result {
+		gain := math.Min(1.0, float64(minInt(i, len(result)-1-i))/float64(fade))
+		result[i] = gain * math.Sin(2.0*math.Pi*hz*float64(i)/s.CyclesPerSecond)
+	}
+	return result
+}
@@ -0,0 +1,191 @@
+package features
+
+import (
+	"math"
+	"math/cmplx"
+
+	"github.com/mjibson/go-dsp/fft"
+)
+
+// Frame is a single window of input, passed to each Extractor.
+type Frame struct {
+	// Index of the frame, and the sample index of its first sample.
+	Index  int
+	Sample int
+
+	// Samples are the raw (unwindowed) input samples of the frame.
+	Samples []float64
+
+	// Spectrum is the magnitude spectrum of the Hann-windowed samples, from 0Hz up to
+	// and including Nyquist, with bin i at frequency i * SampleRate / (2 * (len(Spectrum) - 1)).
+	Spectrum []float64
+
+	SampleRate float64
+}
+
+// BinFrequency returns the frequency (in Hz) of a bin in the frame's spectrum.
+func (f *Frame) BinFrequency(bin int) float64 {
+	return float64(bin) * f.SampleRate / float64(2*(len(f.Spectrum)-1))
+}
+
+// Extractor calculates one or more feature values from each frame.
+// Extractors may keep state between frames (e.g. flux), so each should only
+// be used by one FeatureExtractor at a time.
+type Extractor interface {
+	// Names are the column names of the values returned by Extract.
+	Names() []string
+
+	// Extract returns the feature values for the next frame.
+	Extract(frame *Frame) []float64
+}
+
+// FeatureRow is the values of all features for a single frame.
+type FeatureRow struct {
+	Sample int
+	Values []float64
+}
+
+// FeatureExtractor splits samples into overlapping frames, and runs every extractor on each.
+type FeatureExtractor struct {
+	frameSize  int
+	hopSize    int
+	sampleRate float64
+	extractors []Extractor
+
+	window  []float64
+	fftSize int
+}
+
+// NewFeatureExtractor creates an extractor with frames of frameSize samples, every hopSize samples.
+//
+// For example, to write the loudness and brightness of a sound every ~12ms:
+//  fe := features.NewFeatureExtractor(2048, 512, s.CyclesPerSecond,
+//    features.NewRMS(), features.NewSpectralCentroid())
+//  features.WriteFeaturesCSV(w, fe, fe.ProcessChannel(sound.GetSamples()))
+func NewFeatureExtractor(frameSize int, hopSize int, sampleRate float64, extractors ...Extractor) *FeatureExtractor {
+	if frameSize < 2 || hopSize < 1 {
+		panic("Feature extraction requires frame size of at least 2, and positive hop size")
+	}
+
+	window := make([]float64, frameSize, frameSize)
+	for i := range window {
+		window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(frameSize))
+	}
+
+	fftSize := 1
+	for fftSize < frameSize {
+		fftSize *= 2
+	}
+
+	return &FeatureExtractor{
+		frameSize,
+		hopSize,
+		sampleRate,
+		extractors,
+		window,
+		fftSize,
+	}
+}
+
+// Names returns the column names of all the extracted feature values, in order.
+func (fe *FeatureExtractor) Names() []string {
+	result := []string{}
+	for _, e := range fe.extractors {
+		result = append(result, e.Names()...)
+	}
+	return result
+}
+
+// HopSize returns the number of samples between the start of each frame.
+func (fe *FeatureExtractor) HopSize() int {
+	return fe.hopSize
+}
+
+// SampleRate returns the sample rate of the input.
+func (fe *FeatureExtractor) SampleRate() float64 {
+	return fe.sampleRate
+}
+
+// ProcessChannel splits the samples into frames and returns the features of each.
+// The end of the input is zero-padded, so every sample is covered by a frame start.
+func (fe *FeatureExtractor) ProcessChannel(samples <-chan float64) <-chan FeatureRow {
+	result := make(chan FeatureRow)
+
+	go func() {
+		buffer := make([]float64, 0, fe.frameSize)
+		index, start, read := 0, 0, 0
+		// Samples to discard before the next frame starts, when frames don't overlap.
+		skip := 0
+		for s := range samples {
+			read++
+			if skip > 0 {
+				skip--
+				continue
+			}
+			buffer = append(buffer, s)
+			if len(buffer) == fe.frameSize {
+				result <- fe.processFrame(index, start, buffer)
+				index, start = index+1, start+fe.hopSize
+				buffer = dropFront(buffer, fe.hopSize)
+				skip = maxInt(0, fe.hopSize-fe.frameSize)
+			}
+		}
+		for start < read {
+			padded := append(buffer, make([]float64, fe.frameSize-len(buffer))...)
+			result <- fe.processFrame(index, start, padded)
+			index, start = index+1, start+fe.hopSize
+			buffer = dropFront(buffer, fe.hopSize)
+		}
+		close(result)
+	}()
+
+	return result
+}
+
+// Process returns the features of each frame within a slice of samples.
+func (fe *FeatureExtractor) Process(samples []float64) []FeatureRow {
+	channel := make(chan float64)
+	go func() {
+		for _, s := range samples {
+			channel <- s
+		}
+		close(channel)
+	}()
+
+	result := []FeatureRow{}
+	for row := range fe.ProcessChannel(channel) {
+		result = append(result, row)
+	}
+	return result
+}
+
+func (fe *FeatureExtractor) processFrame(index int, start int, samples []float64) FeatureRow {
+	frameSamples := make([]float64, fe.frameSize, fe.frameSize)
+	copy(frameSamples, samples)
+
+	windowed := make([]float64, fe.fftSize, fe.fftSize)
+	for i, v := range frameSamples {
+		windowed[i] = v * fe.window[i]
+	}
+	transformed := fft.FFTReal(windowed)
+	spectrum := make([]float64, fe.fftSize/2+1, fe.fftSize/2+1)
+	for i := range spectrum {
+		spectrum[i] = cmplx.Abs(transformed[i])
+	}
+
+	frame := &Frame{index, start, frameSamples, spectrum, fe.sampleRate}
+	values := []float64{}
+	for _, e := range fe.extractors {
+		values = append(values, e.Extract(frame)...)
+	}
+	return FeatureRow{start, values}
+}
+
+// dropFront removes the first n values from a buffer, reusing its storage.
+func dropFront(buffer []float64, n int) []float64 {
+	if n >= len(buffer) {
+		return buffer[:0]
+	}
+	remaining := copy(buffer, buffer[n:])
+	return buffer[:remaining]
+}
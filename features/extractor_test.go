@@ -0,0 +1,181 @@
+package features
+
+// go test github.com/padster/go-sound/features
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"math"
+	"strconv"
+	"testing"
+)
+
+const testSampleRate = 44100.0
+
+// Frames start every hop, whether they overlap or leave gaps, until the input runs out.
+func TestFrameStarts(t *testing.T) {
+	tests := []struct {
+		length, frameSize, hopSize int
+		starts                     []int
+	}{
+		{20, 4, 8, []int{0, 8, 16}},
+		{20, 4, 4, []int{0, 4, 8, 12, 16}},
+		{20, 8, 4, []int{0, 4, 8, 12, 16}},
+		{21, 4, 10, []int{0, 10, 20}},
+		{19, 4, 10, []int{0, 10}},
+		{3, 4, 2, []int{0, 2}},
+	}
+	for _, test := range tests {
+		samples := make([]float64, test.length, test.length)
+		for i := range samples {
+			samples[i] = float64(i + 1)
+		}
+		fe := NewFeatureExtractor(test.frameSize, test.hopSize, testSampleRate, &firstSample{})
+		rows := fe.Process(samples)
+
+		if len(rows) != len(test.starts) {
+			t.Errorf("%+v: got %d frames, expected %d", test, len(rows), len(test.starts))
+			continue
+		}
+		for i, row := range rows {
+			// Each frame should hold the samples from where it's labelled.
+			if row.Sample != test.starts[i] || row.Values[0] != float64(test.starts[i]+1) {
+				t.Errorf("%+v: frame %d labelled %d starts with sample %v", test, i, row.Sample, row.Values[0])
+			}
+		}
+	}
+}
+
+func TestSpectralCentroidOfSine(t *testing.T) {
+	// A sine at the centre of a bin, so the Hann window spreads it symmetrically.
+	frameSize, bin := 2048, 100
+	hz := float64(bin) * testSampleRate / float64(frameSize)
+	samples := make([]float64, frameSize, frameSize)
+	for i := range samples {
+		samples[i] = math.Sin(2 * math.Pi * hz * float64(i) / testSampleRate)
+	}
+
+	rows := NewFeatureExtractor(frameSize, frameSize, testSampleRate,
+		NewSpectralCentroid(), NewSpectralSpread(), NewRMS()).Process(samples)
+	centroid, spread, rms := rows[0].Values[0], rows[0].Values[1], rows[0].Values[2]
+	if math.Abs(centroid-hz) > 0.01 {
+		t.Errorf("Centroid is %vHz, expected %vHz", centroid, hz)
+	}
+	// Half weight either side, one bin away.
+	binHz := testSampleRate / float64(frameSize)
+	if expected := binHz / math.Sqrt(2); math.Abs(spread-expected) > 0.01 {
+		t.Errorf("Spread is %vHz, expected %vHz", spread, expected)
+	}
+	if math.Abs(rms-1/math.Sqrt(2)) > 1e-9 {
+		t.Errorf("RMS is %v, expected %v", rms, 1/math.Sqrt(2))
+	}
+}
+
+func TestZeroCrossingRateOfSquare(t *testing.T) {
+	// 50 samples high then 50 low, so 19 sign changes between the 1000 samples of each frame.
+	samples := make([]float64, 3000, 3000)
+	for i := range samples {
+		samples[i] = 0.5
+		if i%100 >= 50 {
+			samples[i] = -0.5
+		}
+	}
+	for _, row := range NewFeatureExtractor(1000, 1000, testSampleRate, NewZeroCrossingRate()).Process(samples) {
+		if expected := 19.0 / 999.0; math.Abs(row.Values[0]-expected) > 1e-12 {
+			t.Errorf("Frame at %d has rate %v, expected %v", row.Sample, row.Values[0], expected)
+		}
+	}
+}
+
+func TestMFCCOfSilence(t *testing.T) {
+	// Every filter has the same log energy, so only the first coefficient is non-zero.
+	filters := 26
+	rows := NewFeatureExtractor(1024, 1024, testSampleRate, NewMFCC(filters, 13, 0, 8000)).Process(make([]float64, 1024))
+	for i, v := range rows[0].Values {
+		expected := 0.0
+		if i == 0 {
+			expected = math.Sqrt(float64(filters)) * math.Log(1e-10)
+		}
+		if math.Abs(v-expected) > 1e-9 {
+			t.Errorf("mfcc%d is %v, expected %v", i, v, expected)
+		}
+	}
+}
+
+func TestFeatureFiles(t *testing.T) {
+	samples := make([]float64, 5000, 5000)
+	for i := range samples {
+		samples[i] = math.Sin(float64(i) * 0.1 * (1 + float64(i)/5000))
+	}
+	fe := NewFeatureExtractor(1024, 512, testSampleRate, NewRMS(), NewSpectralCentroid(), NewSpectralFlux())
+	expected := fe.Process(samples)
+
+	var csvOut bytes.Buffer
+	if err := WriteFeaturesCSV(&csvOut, fe, sliceToRows(expected)); err != nil {
+		t.Fatalf("Can't write CSV: %v", err)
+	}
+	lines, err := csv.NewReader(&csvOut).ReadAll()
+	if err != nil {
+		t.Fatalf("Can't read CSV: %v", err)
+	}
+	if header := lines[0]; len(header) != 5 || header[0] != "time" || header[1] != "sample" || header[3] != "centroid" {
+		t.Errorf("CSV header is %v", header)
+	}
+	if len(lines) != len(expected)+1 {
+		t.Fatalf("CSV has %d lines, expected %d", len(lines), len(expected)+1)
+	}
+	for i, row := range expected {
+		line := lines[i+1]
+		if line[1] != strconv.Itoa(row.Sample) {
+			t.Errorf("CSV row %d is for sample %s, expected %d", i, line[1], row.Sample)
+		}
+		for j, v := range row.Values {
+			if read, _ := strconv.ParseFloat(line[j+2], 64); math.Abs(read-v) > 1e-7*math.Abs(v) {
+				t.Errorf("CSV row %d value %d is %v, expected %v", i, j, read, v)
+			}
+		}
+	}
+
+	var jsonOut bytes.Buffer
+	if err := WriteFeaturesJSON(&jsonOut, fe, sliceToRows(expected)); err != nil {
+		t.Fatalf("Can't write JSON: %v", err)
+	}
+	file := FeatureFile{}
+	if err := json.Unmarshal(jsonOut.Bytes(), &file); err != nil {
+		t.Fatalf("Can't read JSON: %v", err)
+	}
+	if file.SampleRate != testSampleRate || file.HopSize != 512 || len(file.Time) != len(expected) {
+		t.Fatalf("JSON has rate %v, hop %d, %d frames", file.SampleRate, file.HopSize, len(file.Time))
+	}
+	for i, row := range expected {
+		if file.Time[i] != float64(row.Sample)/testSampleRate {
+			t.Errorf("JSON frame %d at %v, expected %v", i, file.Time[i], float64(row.Sample)/testSampleRate)
+		}
+		for j, name := range file.Names {
+			if file.Features[name][i] != row.Values[j] {
+				t.Errorf("JSON %s at frame %d is %v, expected %v", name, i, file.Features[name][i], row.Values[j])
+			}
+		}
+	}
+}
+
+// firstSample is an extractor returning the first raw sample of each frame.
+type firstSample struct{}
+
+func (e *firstSample) Names() []string {
+	return []string{"first"}
+}
+
+func (e *firstSample) Extract(frame *Frame) []float64 {
+	return []float64{frame.Samples[0]}
+}
+
+func sliceToRows(rows []FeatureRow) <-chan FeatureRow {
+	result := make(chan FeatureRow, len(rows))
+	for _, row := range rows {
+		result <- row
+	}
+	close(result)
+	return result
+}
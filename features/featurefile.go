@@ -0,0 +1,66 @@
+package features
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// FeatureFile is the JSON layout of extracted features: one array per feature,
+// indexed by frame, so each loads directly as a numpy array.
+type FeatureFile struct {
+	SampleRate float64              `json:"sampleRate"`
+	HopSize    int                  `json:"hopSize"`
+	Names      []string             `json:"names"`
+	Time       []float64            `json:"time"`
+	Features   map[string][]float64 `json:"features"`
+}
+
+// WriteFeaturesCSV writes one line per frame, with a header line of "time,sample,<names...>".
+func WriteFeaturesCSV(w io.Writer, fe *FeatureExtractor, rows <-chan FeatureRow) error {
+	out := bufio.NewWriter(w)
+	names := fe.Names()
+	if _, err := fmt.Fprintf(out, "time,sample,%s\n", strings.Join(names, ",")); err != nil {
+		return err
+	}
+
+	for row := range rows {
+		fields := make([]string, 0, len(row.Values)+2)
+		fields = append(fields,
+			strconv.FormatFloat(float64(row.Sample)/fe.SampleRate(), 'f', 6, 64),
+			strconv.Itoa(row.Sample))
+		for _, v := range row.Values {
+			fields = append(fields, strconv.FormatFloat(v, 'g', 8, 64))
+		}
+		if _, err := fmt.Fprintln(out, strings.Join(fields, ",")); err != nil {
+			return err
+		}
+	}
+	return out.Flush()
+}
+
+// WriteFeaturesJSON writes all frames as a single FeatureFile JSON object.
+func WriteFeaturesJSON(w io.Writer, fe *FeatureExtractor, rows <-chan FeatureRow) error {
+	names := fe.Names()
+	file := FeatureFile{
+		fe.SampleRate(),
+		fe.HopSize(),
+		names,
+		[]float64{},
+		make(map[string][]float64),
+	}
+	for _, name := range names {
+		file.Features[name] = []float64{}
+	}
+
+	for row := range rows {
+		file.Time = append(file.Time, float64(row.Sample)/fe.SampleRate())
+		for i, name := range names {
+			file.Features[name] = append(file.Features[name], row.Values[i])
+		}
+	}
+	return json.NewEncoder(w).Encode(file)
+}
@@ -0,0 +1,141 @@
+package features
+
+import (
+	"fmt"
+	"math"
+)
+
+// KeyProfiles are the expected relative weights of each pitch class for a major and a
+// minor key with tonic C, used to score how well some chroma matches each key.
+type KeyProfiles struct {
+	Major []float64
+	Minor []float64
+}
+
+var (
+	// KrumhanslProfiles are the probe-tone ratings from Krumhansl & Kessler (1982).
+	KrumhanslProfiles = KeyProfiles{
+		[]float64{6.35, 2.23, 3.48, 2.33, 4.38, 4.09, 2.52, 5.19, 2.39, 3.66, 2.29, 2.88},
+		[]float64{6.33, 2.68, 3.52, 5.38, 2.60, 3.53, 2.54, 4.75, 3.98, 2.69, 3.34, 3.17},
+	}
+
+	// TemperleyProfiles are the Kostka-Payne corpus profiles from Temperley (2001).
+	TemperleyProfiles = KeyProfiles{
+		[]float64{0.748, 0.060, 0.488, 0.082, 0.670, 0.460, 0.096, 0.715, 0.104, 0.366, 0.057, 0.400},
+		[]float64{0.712, 0.084, 0.474, 0.618, 0.049, 0.460, 0.105, 0.747, 0.404, 0.067, 0.133, 0.330},
+	}
+)
+
+// Key is an estimated musical key.
+type Key struct {
+	// Pitch class of the tonic, 0 = C, 1 = C#, ..., 11 = B.
+	Tonic int
+	Minor bool
+	// Correlation in [-1, 1] between the chroma and the key's profile.
+	Score float64
+}
+
+// String returns the key name, e.g. "F#m" for F sharp minor.
+func (k Key) String() string {
+	if k.Minor {
+		return NoteNames[k.Tonic] + "m"
+	}
+	return NoteNames[k.Tonic]
+}
+
+// KeyWindow is a key estimate for the columns in [StartColumn, EndColumn).
+type KeyWindow struct {
+	StartColumn int
+	EndColumn   int
+	Key         Key
+}
+
+// KeyDetector scores chroma against all 24 major and minor keys.
+type KeyDetector struct {
+	profiles KeyProfiles
+}
+
+// NewKeyDetector creates a key detector using the given key profiles.
+//
+// For example, to find the key of a whole file of spectrogram columns:
+//  chroma := features.NewChromagram(params).Process(columns)
+//  key := features.NewKeyDetector(features.KrumhanslProfiles).EstimateKey(chroma)
+func NewKeyDetector(profiles KeyProfiles) *KeyDetector {
+	if len(profiles.Major) != 12 || len(profiles.Minor) != 12 {
+		panic("Key profiles require 12 values each")
+	}
+	return &KeyDetector{profiles}
+}
+
+// EstimateKey returns the single key that best matches all of the chroma.
+func (kd *KeyDetector) EstimateKey(chroma [][]float64) Key {
+	return kd.bestKey(sumChroma(chroma))
+}
+
+// EstimateKeys returns a key estimate for each window of the given number of columns,
+// with windows starting every hop columns. The last window may be shorter.
+func (kd *KeyDetector) EstimateKeys(chroma [][]float64, window int, hop int) []KeyWindow {
+	if window < 1 || hop < 1 {
+		panic("Key windows require positive size and hop")
+	}
+
+	result := []KeyWindow{}
+	for start := 0; start < len(chroma); start += hop {
+		end := minInt(len(chroma), start+window)
+		result = append(result, KeyWindow{start, end, kd.bestKey(sumChroma(chroma[start:end]))})
+		if end == len(chroma) {
+			break
+		}
+	}
+	return result
+}
+
+// bestKey returns the key whose profile best correlates with a single chroma vector.
+func (kd *KeyDetector) bestKey(chroma []float64) Key {
+	best := Key{0, false, math.Inf(-1)}
+	for tonic := 0; tonic < 12; tonic++ {
+		for _, minor := range []bool{false, true} {
+			profile := kd.profiles.Major
+			if minor {
+				profile = kd.profiles.Minor
+			}
+			score := rotatedCorrelation(chroma, profile, tonic)
+			if score > best.Score {
+				best = Key{tonic, minor, score}
+			}
+		}
+	}
+	return best
+}
+
+// sumChroma adds together all chroma vectors.
+func sumChroma(chroma [][]float64) []float64 {
+	result := make([]float64, 12, 12)
+	for _, vector := range chroma {
+		if len(vector) != 12 {
+			panic(fmt.Sprintf("Chroma vectors must have 12 values, not %d", len(vector)))
+		}
+		for i, v := range vector {
+			result[i] += v
+		}
+	}
+	return result
+}
+
+// rotatedCorrelation returns the Pearson correlation between chroma, and a C-based
+// profile rotated to start on the given tonic.
+func rotatedCorrelation(chroma []float64, profile []float64, tonic int) float64 {
+	chromaMean, profileMean := meanOf(chroma), meanOf(profile)
+	sumCP, sumCC, sumPP := 0.0, 0.0, 0.0
+	for i := 0; i < 12; i++ {
+		c := chroma[i] - chromaMean
+		p := profile[mod12(i-tonic)] - profileMean
+		sumCP += c * p
+		sumCC += c * c
+		sumPP += p * p
+	}
+	if sumCC == 0 || sumPP == 0 {
+		return 0
+	}
+	return sumCP / math.Sqrt(sumCC*sumPP)
+}
@@ -0,0 +1,277 @@
+package features
+
+import (
+	"math"
+	"sort"
+
+	s "github.com/padster/go-sound/sounds"
+	"github.com/padster/go-sound/types"
+)
+
+const (
+	// Gating thresholds from ITU-R BS.1770 / EBU Tech 3342.
+	absoluteGateLUFS    = -70.0
+	integratedGateLU    = -10.0
+	loudnessRangeGateLU = -20.0
+
+	// Lengths of the loudness windows, in 100ms blocks.
+	momentaryBlocks = 4
+	shortTermBlocks = 30
+
+	// True-peak oversampling factor, and taps per phase in the interpolation filter.
+	truePeakFactor = 4
+	truePeakTaps   = 12
+)
+
+// Loudness is the EBU R128 measurement of a sound.
+type Loudness struct {
+	// Momentary (400ms window) and short-term (3s window) loudness in LUFS, every 100ms.
+	Momentary []float64
+	ShortTerm []float64
+
+	// Integrated is the gated loudness of the whole sound, in LUFS.
+	Integrated float64
+
+	// Range is the loudness range (LRA), in LU.
+	Range float64
+
+	// TruePeak is the maximum level of the 4x oversampled signal, in dBTP.
+	TruePeak float64
+}
+
+// LoudnessMeter measures loudness as defined in ITU-R BS.1770 and EBU R128.
+// Sounds are mono, so the single channel has a weighting of 1.
+//
+// Silence has a loudness of -Inf LUFS.
+type LoudnessMeter struct {
+	// K-weighting filters: a high shelf for the head, then a high pass.
+	shelf    *types.Biquad
+	highPass *types.Biquad
+
+	// Mean square of the weighted input for each completed 100ms block.
+	blocks    []float64
+	blockSize int
+	blockAt   int
+	blockSum  float64
+
+	// Recent input, newest first, for true-peak interpolation.
+	history []float64
+	filter  []float64
+	peak    float64
+}
+
+// NewLoudnessMeter creates a meter for input at the given sample rate.
+//
+// For example, to measure a sound:
+//  meter := features.NewLoudnessMeter(s.CyclesPerSecond)
+//  meter.AddChannel(sound.GetSamples())
+//  fmt.Printf("%.1f LUFS\n", meter.Result().Integrated)
+func NewLoudnessMeter(sampleRate float64) *LoudnessMeter {
+	blockSize := round(sampleRate / 10.0)
+	if blockSize < 1 {
+		panic("Loudness meter sample rate too low")
+	}
+	return &LoudnessMeter{
+		newShelfFilter(sampleRate),
+		newHighPassFilter(sampleRate),
+		[]float64{},
+		blockSize,
+		0,   /* blockAt */
+		0.0, /* blockSum */
+		make([]float64, truePeakTaps, truePeakTaps),
+		truePeakFilter(),
+		0.0, /* peak */
+	}
+}
+
+// MeasureLoudness plays through a sound and returns its loudness.
+func MeasureLoudness(sound s.Sound) Loudness {
+	meter := NewLoudnessMeter(s.CyclesPerSecond)
+	sound.Start()
+	meter.AddChannel(sound.GetSamples())
+	sound.Stop()
+	return meter.Result()
+}
+
+// AddChannel adds all samples from a channel, until it is closed.
+func (m *LoudnessMeter) AddChannel(samples <-chan float64) {
+	for sample := range samples {
+		m.Add(sample)
+	}
+}
+
+// Add adds a single sample to the measurement.
+func (m *LoudnessMeter) Add(sample float64) {
+	weighted := m.highPass.Process(m.shelf.Process(sample))
+	m.blockSum += weighted * weighted
+	m.blockAt++
+	if m.blockAt == m.blockSize {
+		m.blocks = append(m.blocks, m.blockSum/float64(m.blockSize))
+		m.blockAt, m.blockSum = 0, 0.0
+	}
+
+	copy(m.history[1:], m.history[:len(m.history)-1])
+	m.history[0] = sample
+	m.peak = math.Max(m.peak, math.Abs(sample))
+	for p := 0; p < truePeakFactor; p++ {
+		value := 0.0
+		for k, v := range m.history {
+			value += m.filter[k*truePeakFactor+p] * v
+		}
+		m.peak = math.Max(m.peak, math.Abs(value))
+	}
+}
+
+// Result returns the loudness of all samples added so far. Partial blocks at the end are ignored.
+func (m *LoudnessMeter) Result() Loudness {
+	momentary := windowEnergies(m.blocks, momentaryBlocks)
+	shortTerm := windowEnergies(m.blocks, shortTermBlocks)
+
+	return Loudness{
+		toLUFSAll(momentary),
+		toLUFSAll(shortTerm),
+		integratedLoudness(momentary),
+		loudnessRange(shortTerm),
+		20.0 * math.Log10(m.peak),
+	}
+}
+
+// Reset clears the meter, ready to measure a new sound.
+func (m *LoudnessMeter) Reset() {
+	m.shelf.Reset()
+	m.highPass.Reset()
+	m.blocks = []float64{}
+	m.blockAt, m.blockSum = 0, 0.0
+	for i := range m.history {
+		m.history[i] = 0
+	}
+	m.peak = 0.0
+}
+
+// integratedLoudness gates the 400ms energies absolutely, then relative to their loudness.
+func integratedLoudness(energies []float64) float64 {
+	gated := gateEnergies(energies, absoluteGateLUFS)
+	if len(gated) == 0 {
+		return math.Inf(-1)
+	}
+	gated = gateEnergies(gated, toLUFS(meanOf(gated))+integratedGateLU)
+	if len(gated) == 0 {
+		return math.Inf(-1)
+	}
+	return toLUFS(meanOf(gated))
+}
+
+// loudnessRange is the spread between the 10th and 95th percentiles of the gated 3s loudness.
+func loudnessRange(energies []float64) float64 {
+	gated := gateEnergies(energies, absoluteGateLUFS)
+	if len(gated) == 0 {
+		return 0
+	}
+	gated = gateEnergies(gated, toLUFS(meanOf(gated))+loudnessRangeGateLU)
+	if len(gated) == 0 {
+		return 0
+	}
+	values := toLUFSAll(gated)
+	sort.Float64s(values)
+	return percentile(values, 0.95) - percentile(values, 0.10)
+}
+
+// windowEnergies returns the mean energy of each full window of blocks, stepping one block at a time.
+func windowEnergies(blocks []float64, window int) []float64 {
+	result := []float64{}
+	sum := 0.0
+	for i, e := range blocks {
+		sum += e
+		if i >= window {
+			sum -= blocks[i-window]
+		}
+		if i >= window-1 {
+			result = append(result, math.Max(0, sum)/float64(window))
+		}
+	}
+	return result
+}
+
+// gateEnergies returns only the energies whose loudness is above the gate.
+func gateEnergies(energies []float64, gateLUFS float64) []float64 {
+	result := []float64{}
+	for _, e := range energies {
+		if toLUFS(e) > gateLUFS {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// toLUFS converts a mean square of K-weighted samples into loudness.
+func toLUFS(energy float64) float64 {
+	if energy <= 0 {
+		return math.Inf(-1)
+	}
+	return -0.691 + 10.0*math.Log10(energy)
+}
+
+func toLUFSAll(energies []float64) []float64 {
+	result := make([]float64, len(energies), len(energies))
+	for i, e := range energies {
+		result[i] = toLUFS(e)
+	}
+	return result
+}
+
+// percentile linearly interpolates within sorted values.
+func percentile(sorted []float64, fraction float64) float64 {
+	at := fraction * float64(len(sorted)-1)
+	lower := int(at)
+	if lower+1 >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[lower] + (at-float64(lower))*(sorted[lower+1]-sorted[lower])
+}
+
+// truePeakFilter builds a Hann windowed-sinc interpolation filter for oversampling,
+// laid out so phase p of input tap k is at k*truePeakFactor + p.
+func truePeakFilter() []float64 {
+	size := truePeakTaps * truePeakFactor
+	result := make([]float64, size, size)
+	centre := float64(size-1) / 2.0
+	for i := range result {
+		x := (float64(i) - centre) / truePeakFactor
+		sinc := 1.0
+		if x != 0 {
+			sinc = math.Sin(math.Pi*x) / (math.Pi * x)
+		}
+		window := 0.5 - 0.5*math.Cos(2.0*math.Pi*(float64(i)+0.5)/float64(size))
+		result[i] = sinc * window
+	}
+	return result
+}
+
+// newShelfFilter creates the BS.1770 pre-filter modelling the acoustic effect of the head,
+// recalculated from its analog prototype so that any sample rate is supported.
+func newShelfFilter(sampleRate float64) *types.Biquad {
+	f0, gain, q := 1681.974450955533, 3.999843853973347, 0.7071752369554196
+	k := math.Tan(math.Pi * f0 / sampleRate)
+	vh := math.Pow(10.0, gain/20.0)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1.0 + k/q + k*k
+	return types.NewBiquad(
+		(vh+vb*k/q+k*k)/a0,
+		2.0*(k*k-vh)/a0,
+		(vh-vb*k/q+k*k)/a0,
+		2.0*(k*k-1.0)/a0,
+		(1.0-k/q+k*k)/a0,
+	)
+}
+
+// newHighPassFilter creates the BS.1770 RLB weighting high pass filter.
+func newHighPassFilter(sampleRate float64) *types.Biquad {
+	f0, q := 38.13547087602444, 0.5003270373238773
+	k := math.Tan(math.Pi * f0 / sampleRate)
+	a0 := 1.0 + k/q + k*k
+	return types.NewBiquad(
+		1.0, -2.0, 1.0,
+		2.0*(k*k-1.0)/a0,
+		(1.0-k/q+k*k)/a0,
+	)
+}
@@ -0,0 +1,113 @@
+package features
+
+// go test github.com/padster/go-sound/features
+
+import (
+	"math"
+	"testing"
+
+	s "github.com/padster/go-sound/sounds"
+)
+
+// Reference levels from EBU Tech 3341: a full scale 997Hz sine is -3.01 LUFS.
+func TestSineLoudness(t *testing.T) {
+	tests := []struct {
+		sampleRate float64
+		amplitude  float64
+		expected   float64
+	}{
+		{48000, 1.0, -3.01},
+		{48000, 0.1, -23.01},
+		{44100, 1.0, -3.01},
+		{44100, 0.1, -23.01},
+	}
+	for _, test := range tests {
+		meter := NewLoudnessMeter(test.sampleRate)
+		for _, v := range sine(int(5*test.sampleRate), 997, test.amplitude, 0, test.sampleRate) {
+			meter.Add(v)
+		}
+		result := meter.Result()
+		if math.Abs(result.Integrated-test.expected) > 0.02 {
+			t.Errorf("%vHz, amplitude %v: loudness %.3f LUFS, expected %.2f", test.sampleRate, test.amplitude, result.Integrated, test.expected)
+		}
+		for i, v := range result.Momentary {
+			if math.Abs(v-test.expected) > 0.02 {
+				t.Errorf("%vHz, amplitude %v: momentary loudness %d is %.3f LUFS, expected %.2f", test.sampleRate, test.amplitude, i, v, test.expected)
+				break
+			}
+		}
+		if result.Range > 0.02 {
+			t.Errorf("%vHz, amplitude %v: loudness range %.3f LU, expected 0", test.sampleRate, test.amplitude, result.Range)
+		}
+	}
+}
+
+// Silence is removed by the absolute gate, and much quieter sections by the relative gate.
+// The three 400ms blocks overlapping the end of the loud section are kept, and hold 3/4, 1/2
+// and 1/4 of its energy, so the 50 blocks average 48.5/50 of it: 0.13dB below -23.01.
+func TestLoudnessGating(t *testing.T) {
+	length := int(5 * s.CyclesPerSecond)
+	loud := sine(length, 997, 0.1, 0, s.CyclesPerSecond)
+	quiet := sine(length, 997, 0.01, 0, s.CyclesPerSecond)
+	silence := make([]float64, 2*length, 2*length)
+
+	tests := []struct {
+		name    string
+		samples []float64
+	}{
+		{"silence", append(append([]float64{}, loud...), silence...)},
+		{"quiet", append(append([]float64{}, loud...), quiet...)},
+	}
+	for _, test := range tests {
+		if integrated := measureSamples(test.samples).Integrated; math.Abs(integrated+23.14) > 0.01 {
+			t.Errorf("Loud then %s: loudness %.3f LUFS, expected -23.14", test.name, integrated)
+		}
+	}
+	if integrated := measureSamples(silence).Integrated; !math.IsInf(integrated, -1) {
+		t.Errorf("Silence has loudness %v LUFS, expected -Inf", integrated)
+	}
+}
+
+// A quarter sample rate sine sampled half way between its peaks only reaches 0.707 at the
+// samples, but the 4x oversampled true peak finds the full scale peaks between them.
+func TestTruePeakBetweenSamples(t *testing.T) {
+	samples := sine(int(s.CyclesPerSecond), s.CyclesPerSecond/4, 1.0, math.Pi/4, s.CyclesPerSecond)
+	samplePeak := 0.0
+	for _, v := range samples {
+		samplePeak = math.Max(samplePeak, math.Abs(v))
+	}
+	if math.Abs(20*math.Log10(samplePeak)+3.01) > 0.01 {
+		t.Fatalf("Sample peak %v, expected -3.01dB", 20*math.Log10(samplePeak))
+	}
+	if truePeak := measureSamples(samples).TruePeak; math.Abs(truePeak) > 0.2 {
+		t.Errorf("True peak %.3f dBTP, expected 0", truePeak)
+	}
+}
+
+func TestNormalizeLoudness(t *testing.T) {
+	samples := sine(int(5*s.CyclesPerSecond), 997, 0.1, 0, s.CyclesPerSecond)
+	tests := []struct {
+		target, maxTruePeak    float64
+		expected, expectedPeak float64
+	}{
+		{-14, 0, -14.0, -11.0},
+		// Limited by the peak, a sine's true peak is 3dB above its loudness.
+		{-6, -6, -9.0, -6.0},
+	}
+	for _, test := range tests {
+		result := measureSamples(s.RenderToSlice(NormalizeLoudness(s.WrapSliceAsSound(samples), test.target, test.maxTruePeak)))
+		if math.Abs(result.Integrated-test.expected) > 0.05 || math.Abs(result.TruePeak-test.expectedPeak) > 0.05 {
+			t.Errorf("Normalizing to %v LUFS under %v dBTP gave %.3f LUFS and %.3f dBTP, expected %v and %v",
+				test.target, test.maxTruePeak, result.Integrated, result.TruePeak, test.expected, test.expectedPeak)
+		}
+	}
+}
+
+// sine returns samples of a sine wave starting at the given phase.
+func sine(length int, hz float64, amplitude float64, phase float64, sampleRate float64) []float64 {
+	result := make([]float64, length, length)
+	for i := range result {
+		result[i] = amplitude * math.Sin(2.0*math.Pi*hz*float64(i)/sampleRate+phase)
+	}
+	return result
+}
@@ -0,0 +1,113 @@
+package features
+
+import (
+	"fmt"
+	"math"
+)
+
+// An mfcc extractor calculates mel-frequency cepstral coefficients, a compact
+// description of the spectral envelope commonly used for timbre.
+type mfcc struct {
+	filters      int
+	coefficients int
+	minHz        float64
+	maxHz        float64
+
+	// Triangular mel filter weights per spectrum bin, lazily built for the frame size.
+	filterBank [][]float64
+}
+
+// NewMFCC creates an extractor for the first few cepstral coefficients of the log
+// energies in a bank of mel-spaced triangular filters covering [minHz, maxHz].
+//
+// For example, the common speech setup of 13 coefficients from 26 filters:
+//  features.NewMFCC(26, 13, 0, 8000)
+func NewMFCC(filters int, coefficients int, minHz float64, maxHz float64) Extractor {
+	if coefficients < 1 || filters < coefficients {
+		panic("MFCC requires at least one coefficient, and at least as many filters as coefficients")
+	}
+	if minHz < 0 || maxHz <= minHz {
+		panic("MFCC requires 0 <= minHz < maxHz")
+	}
+	return &mfcc{
+		filters,
+		coefficients,
+		minHz,
+		maxHz,
+		nil, /* filterBank */
+	}
+}
+
+func (e *mfcc) Names() []string {
+	result := make([]string, e.coefficients, e.coefficients)
+	for i := range result {
+		result[i] = fmt.Sprintf("mfcc%d", i)
+	}
+	return result
+}
+
+func (e *mfcc) Extract(frame *Frame) []float64 {
+	if e.filterBank == nil || len(e.filterBank[0]) != len(frame.Spectrum) {
+		e.filterBank = e.buildFilterBank(frame)
+	}
+
+	logEnergies := make([]float64, e.filters, e.filters)
+	for f, weights := range e.filterBank {
+		energy := 0.0
+		for i, w := range weights {
+			energy += w * frame.Spectrum[i] * frame.Spectrum[i]
+		}
+		logEnergies[f] = math.Log(energy + 1e-10)
+	}
+
+	// Orthonormal DCT-II of the log energies.
+	n := float64(e.filters)
+	result := make([]float64, e.coefficients, e.coefficients)
+	for k := range result {
+		sum := 0.0
+		for j, v := range logEnergies {
+			sum += v * math.Cos(math.Pi*float64(k)*(float64(j)+0.5)/n)
+		}
+		scale := math.Sqrt(2.0 / n)
+		if k == 0 {
+			scale = math.Sqrt(1.0 / n)
+		}
+		result[k] = scale * sum
+	}
+	return result
+}
+
+// buildFilterBank creates triangular filters with centres equally spaced in mel.
+func (e *mfcc) buildFilterBank(frame *Frame) [][]float64 {
+	minMel, maxMel := hzToMel(e.minHz), hzToMel(e.maxHz)
+	edges := make([]float64, e.filters+2, e.filters+2)
+	for i := range edges {
+		edges[i] = melToHz(minMel + (maxMel-minMel)*float64(i)/float64(e.filters+1))
+	}
+
+	bank := make([][]float64, e.filters, e.filters)
+	for f := range bank {
+		lower, centre, upper := edges[f], edges[f+1], edges[f+2]
+		bank[f] = make([]float64, len(frame.Spectrum), len(frame.Spectrum))
+		for i := range frame.Spectrum {
+			hz := frame.BinFrequency(i)
+			switch {
+			case hz > lower && hz <= centre:
+				bank[f][i] = (hz - lower) / (centre - lower)
+			case hz > centre && hz < upper:
+				bank[f][i] = (upper - hz) / (upper - centre)
+			}
+		}
+	}
+	return bank
+}
+
+// hzToMel converts a frequency into the (HTK) mel scale.
+func hzToMel(hz float64) float64 {
+	return 2595.0 * math.Log10(1.0+hz/700.0)
+}
+
+// melToHz converts a mel value back into a frequency.
+func melToHz(mel float64) float64 {
+	return 700.0 * (math.Pow(10.0, mel/2595.0) - 1.0)
+}
@@ -0,0 +1,55 @@
+package features
+
+import (
+	"math"
+
+	s "github.com/padster/go-sound/sounds"
+)
+
+// NormalizeLoudness renders a sound and returns a copy with gain applied so that its
+// integrated loudness is targetLUFS, reduced if needed to keep its true peak at or
+// below maxTruePeak dBTP. Silent sounds are returned unchanged.
+//
+// For example, to deliver a mix at -14 LUFS with -1 dBTP of headroom:
+//  output.WriteSoundToWav(features.NormalizeLoudness(mix, -14, -1), "mix.wav")
+func NormalizeLoudness(sound s.Sound, targetLUFS float64, maxTruePeak float64) s.Sound {
+	samples := s.RenderToSlice(sound)
+	loudness := measureSamples(samples)
+	if math.IsInf(loudness.Integrated, -1) {
+		return s.WrapSliceAsSound(samples)
+	}
+
+	gainDB := targetLUFS - loudness.Integrated
+	if loudness.TruePeak+gainDB > maxTruePeak {
+		gainDB = maxTruePeak - loudness.TruePeak
+	}
+	return s.WrapSliceAsSound(applyGain(samples, gainDB))
+}
+
+// NormalizePeak renders a sound and returns a copy with gain applied so that its
+// true peak is targetDBTP. Silent sounds are returned unchanged.
+func NormalizePeak(sound s.Sound, targetDBTP float64) s.Sound {
+	samples := s.RenderToSlice(sound)
+	loudness := measureSamples(samples)
+	if math.IsInf(loudness.TruePeak, -1) {
+		return s.WrapSliceAsSound(samples)
+	}
+	return s.WrapSliceAsSound(applyGain(samples, targetDBTP-loudness.TruePeak))
+}
+
+func measureSamples(samples []float64) Loudness {
+	meter := NewLoudnessMeter(s.CyclesPerSecond)
+	for _, sample := range samples {
+		meter.Add(sample)
+	}
+	return meter.Result()
+}
+
+func applyGain(samples []float64, gainDB float64) []float64 {
+	gain := math.Pow(10.0, gainDB/20.0)
+	result := make([]float64, len(samples), len(samples))
+	for i, v := range samples {
+		result[i] = v * gain
+	}
+	return result
+}
@@ -0,0 +1,193 @@
+package features
+
+import (
+	"math/cmplx"
+)
+
+// OnsetFunction selects how the novelty between consecutive spectral columns is measured.
+type OnsetFunction int
+
+const (
+	// SpectralFlux sums the increases in magnitude across all bins.
+	SpectralFlux OnsetFunction = iota
+
+	// ComplexDomain measures the distance of each bin from the value predicted by
+	// a steady magnitude and phase advance, so also catches soft (pitched) onsets.
+	// This needs true phases, so use raw ConstantQ columns rather than the
+	// interpolated Spectrogram ones.
+	ComplexDomain
+)
+
+// Onset is a single detected note or percussive attack.
+type Onset struct {
+	// Column index in the spectral stream the onset was picked from.
+	Column int
+	// Sample index in the original input where the onset occurs.
+	Sample int
+	// Value of the detection function at the onset.
+	Strength float64
+}
+
+// OnsetDetector takes spectral columns (either constant Q, or STFT), and converts
+// them into an onset detection function, with one value per column.
+//
+// Column heights may vary, as in the raw constant Q output: bins that are missing
+// from a column keep their previous value, so contribute nothing to that column.
+type OnsetDetector struct {
+	function   OnsetFunction
+	hopSize    int
+	latency    int
+	sampleRate float64
+
+	// Delta is the amount (in standard deviations of the detection function) a
+	// peak must exceed its local mean to be picked as an onset.
+	Delta float64
+
+	// MinGapSeconds is the shortest time allowed between two picked onsets.
+	MinGapSeconds float64
+
+	prevMag   []float64
+	prevPhase []float64
+	prevDiff  []float64
+}
+
+// NewOnsetDetector creates a detector for columns that are hopSize samples apart,
+// and lag the input by latency samples (e.g. ConstantQ.OutputLatency).
+//
+// For example, for the output of a constant Q transform:
+//  od := features.NewOnsetDetector(features.ComplexDomain,
+//    constantQ.SamplesPerColumn(), constantQ.OutputLatency, s.CyclesPerSecond)
+func NewOnsetDetector(function OnsetFunction, hopSize int, latency int, sampleRate float64) *OnsetDetector {
+	if hopSize < 1 {
+		panic("Onset detection requires a positive hop size")
+	}
+	return &OnsetDetector{
+		function,
+		hopSize,
+		latency,
+		sampleRate,
+		1.0,  /* Delta */
+		0.05, /* MinGapSeconds */
+		nil,  /* prevMag */
+		nil,  /* prevPhase */
+		nil,  /* prevDiff */
+	}
+}
+
+// FrameRate returns the number of detection function values per second.
+func (od *OnsetDetector) FrameRate() float64 {
+	return od.sampleRate / float64(od.hopSize)
+}
+
+// ColumnToSample converts a column index into the sample index of the input it represents.
+func (od *OnsetDetector) ColumnToSample(column int) int {
+	return column*od.hopSize - od.latency
+}
+
+// ProcessChannel converts a stream of columns into the onset detection function.
+func (od *OnsetDetector) ProcessChannel(columns <-chan []complex128) <-chan float64 {
+	result := make(chan float64)
+	go func() {
+		for column := range columns {
+			result <- od.processColumn(column)
+		}
+		close(result)
+	}()
+	return result
+}
+
+// Process converts a slice of columns into the onset detection function.
+func (od *OnsetDetector) Process(columns [][]complex128) []float64 {
+	result := make([]float64, len(columns), len(columns))
+	for i, column := range columns {
+		result[i] = od.processColumn(column)
+	}
+	return result
+}
+
+// Reset clears the state kept between columns.
+func (od *OnsetDetector) Reset() {
+	od.prevMag, od.prevPhase, od.prevDiff = nil, nil, nil
+}
+
+func (od *OnsetDetector) processColumn(column []complex128) float64 {
+	if len(column) > len(od.prevMag) {
+		od.prevMag = growFloats(od.prevMag, len(column))
+		od.prevPhase = growFloats(od.prevPhase, len(column))
+		od.prevDiff = growFloats(od.prevDiff, len(column))
+	}
+
+	value := 0.0
+	for i, v := range column {
+		mag, phase := cmplx.Polar(v)
+		diff := princarg(phase - od.prevPhase[i])
+
+		switch od.function {
+		case SpectralFlux:
+			if rise := mag - od.prevMag[i]; rise > 0 {
+				value += rise
+			}
+		case ComplexDomain:
+			// Expected value assumes constant magnitude and phase velocity.
+			expected := cmplx.Rect(od.prevMag[i], od.prevPhase[i]+od.prevDiff[i])
+			value += cmplx.Abs(v - expected)
+		default:
+			panic("Unknown onset function")
+		}
+
+		od.prevMag[i], od.prevPhase[i], od.prevDiff[i] = mag, phase, diff
+	}
+	return value
+}
+
+// PickOnsets finds the peaks in an onset detection function: values that are the
+// maximum within a small window, exceed the local mean by Delta standard
+// deviations, and are at least MinGapSeconds after the previous onset.
+func (od *OnsetDetector) PickOnsets(odf []float64) []Onset {
+	n := len(odf)
+	if n == 0 {
+		return []Onset{}
+	}
+
+	// Local maximum over roughly +/- 30ms, mean over the prior 100ms.
+	maxWindow := maxInt(1, round(0.03*od.FrameRate()))
+	meanWindow := maxInt(1, round(0.1*od.FrameRate()))
+	minGap := round(od.MinGapSeconds * od.FrameRate())
+
+	normalized := normalize(odf)
+
+	result := []Onset{}
+	lastOnset := -minGap - 1
+	for i := 0; i < n; i++ {
+		v := normalized[i]
+		isMax := true
+		for j := maxInt(0, i-maxWindow); j <= minInt(n-1, i+maxWindow) && isMax; j++ {
+			if normalized[j] > v || (normalized[j] == v && j < i) {
+				isMax = false
+			}
+		}
+		if !isMax {
+			continue
+		}
+
+		from, to := maxInt(0, i-meanWindow), minInt(n-1, i+maxWindow)
+		if v < meanOf(normalized[from:to+1])+od.Delta {
+			continue
+		}
+		if i-lastOnset <= minGap {
+			continue
+		}
+		result = append(result, Onset{i, od.ColumnToSample(i), odf[i]})
+		lastOnset = i
+	}
+	return result
+}
+
+// OnsetSamples returns just the sample indexes of a collection of onsets.
+func OnsetSamples(onsets []Onset) []int {
+	result := make([]int, len(onsets), len(onsets))
+	for i, o := range onsets {
+		result[i] = o.Sample
+	}
+	return result
+}
@@ -0,0 +1,92 @@
+package features
+
+// go test github.com/padster/go-sound/features
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/padster/go-sound/cq"
+	s "github.com/padster/go-sound/sounds"
+)
+
+const (
+	testFFTSize = 1024
+	testHopSize = 512
+)
+
+func TestOnsetsOfClickTrain(t *testing.T) {
+	clicks, samples := clickTrain(120, 10)
+	for _, function := range []OnsetFunction{SpectralFlux, ComplexDomain} {
+		od, columns := testOnsetColumns(function, samples)
+		onsets := od.PickOnsets(od.Process(columns))
+		if len(onsets) != len(clicks) {
+			t.Errorf("Function %d: found %d onsets, expected %d", function, len(onsets), len(clicks))
+			continue
+		}
+		for i, onset := range onsets {
+			if expected := clickColumn(od, clicks[i]); math.Abs(float64(onset.Column-expected)) > 1 {
+				t.Errorf("Function %d: onset %d at column %d, expected within a column of %d", function, i, onset.Column, expected)
+			}
+			if od.ColumnToSample(onset.Column) != onset.Sample {
+				t.Errorf("Function %d: onset %d at column %d doesn't match sample %d", function, i, onset.Column, onset.Sample)
+			}
+		}
+	}
+}
+
+func TestBeatsOfClickTrain(t *testing.T) {
+	for _, bpm := range []float64{90, 120, 140} {
+		clicks, samples := clickTrain(bpm, 20)
+		od, columns := testOnsetColumns(SpectralFlux, samples)
+		bt := NewBeatTracker(od)
+		odf := od.Process(columns)
+
+		if tempo := bt.EstimateTempo(odf); math.Abs(tempo-bpm) > 1 {
+			t.Errorf("%v BPM: estimated tempo %.2f", bpm, tempo)
+		}
+		tempo, beats := bt.Track(odf)
+		if math.Abs(tempo-bpm) > 1 {
+			t.Errorf("%v BPM: tracked tempo %.2f", bpm, tempo)
+		}
+		if len(beats) != len(clicks) {
+			t.Errorf("%v BPM: tracked %d beats, expected %d", bpm, len(beats), len(clicks))
+			continue
+		}
+		for i, beat := range beats {
+			if expected := clickColumn(od, clicks[i]); math.Abs(float64(beat.Column-expected)) > 1 {
+				t.Errorf("%v BPM: beat %d at column %d, expected within a column of %d", bpm, i, beat.Column, expected)
+			}
+		}
+	}
+}
+
+// clickTrain returns the sample indexes of clicks at a tempo, over quiet noise, and the samples.
+func clickTrain(bpm float64, seconds int) ([]int, []float64) {
+	rng := rand.New(rand.NewSource(1))
+	samples := make([]float64, seconds*int(s.CyclesPerSecond))
+	for i := range samples {
+		samples[i] = 0.001 * rng.NormFloat64()
+	}
+
+	// Start part way into the first column, so clicks don't line up with the columns.
+	clicks := []int{}
+	for at := 300.0; int(at) < len(samples); at += 60.0 * s.CyclesPerSecond / bpm {
+		clicks = append(clicks, int(at))
+		samples[int(at)] = 1.0
+	}
+	return clicks, samples
+}
+
+// clickColumn returns the column centred closest to a click.
+func clickColumn(od *OnsetDetector, click int) int {
+	return round(float64(click+od.latency) / float64(od.hopSize))
+}
+
+// testOnsetColumns returns an onset detector for STFT columns, and the columns of the samples.
+func testOnsetColumns(function OnsetFunction, samples []float64) (*OnsetDetector, [][]complex128) {
+	stft := cq.NewSTFT(testFFTSize, testHopSize, cq.SqrtHann)
+	columns := append(stft.Process(samples), stft.GetRemainingOutput()...)
+	return NewOnsetDetector(function, testHopSize, stft.OutputLatency, s.CyclesPerSecond), columns
+}
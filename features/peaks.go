@@ -1,34 +1,143 @@
 package features
 
 import (
-	"bytes"
+	"bufio"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"math"
 	"math/cmplx"
+	"os"
+	"sort"
 
 	"github.com/padster/go-sound/cq"
 )
 
-const (
-	THRESHOLD = 1.5
-)
+// Peak is a local maximum in the constant Q magnitudes, across both frequency and time.
+type Peak struct {
+	// Time of the peak in seconds, and as a sample index, within the original input.
+	Time   float64
+	Sample int
+
+	// Column and bin index of the peak within the constant Q output.
+	Column int
+	Bin    int
 
-// PeakDetector takes the constant Q output, and for each sample bin, returns
-// whether that sample is a 'peak' in the music.
+	// Frequency in Hz and magnitude, both refined by parabolic interpolation across bins.
+	Frequency float64
+	Magnitude float64
+}
+
+// peakCandidate is the most recent value seen for a single bin.
+type peakCandidate struct {
+	column    int
+	magnitude float64
+	// Whether this was a peak across frequency and above threshold within its column.
+	possible bool
+	peak     Peak
+}
+
+// PeakDetector takes the constant Q output, and finds the spectral peaks within it:
+// bins that are louder than their neighbours in both frequency and time, and that
+// stand out above both a running per-bin noise floor and the recent overall loudness.
+//
+// Column heights may vary, as in the raw constant Q output, in which case time
+// neighbours are the previous and next columns that contain that bin.
 type PeakDetector struct {
+	params     cq.CQParams
+	hopSize    int
+	latency    int
+	sampleRate float64
+
+	// FloorRatio is how many times louder than the bin's noise floor a peak must be.
+	FloorRatio float64
+
+	// RelativeThreshold is the fraction of the recent loudest magnitude a peak must exceed.
+	RelativeThreshold float64
+
+	// LoudnessDecay is how much the recent loudest magnitude decays per column.
+	LoudnessDecay float64
+
+	// FloorAttack and FloorRelease are the rates (per bin update) at which the noise
+	// floor follows magnitudes above and below it. The slow attack stops the floor
+	// rising to meet sustained notes.
+	FloorAttack  float64
+	FloorRelease float64
+
+	floor      []float64
+	latest     []peakCandidate
+	previous   []float64
+	loudness   float64
+	nextColumn int
+}
+
+// NewPeakDetector creates a detector for constant Q columns generated with the given
+// parameters, that are hopSize samples apart and lag the input by latency samples.
+//
+// For example, to find peaks in the output of a constant Q transform:
+//  pd := features.NewPeakDetector(params, constantQ.SamplesPerColumn(), constantQ.OutputLatency)
+//  features.WritePeaks("out.meta", pd.ProcessColumns(constantQ.ProcessColumns(samples)))
+func NewPeakDetector(params cq.CQParams, hopSize int, latency int) *PeakDetector {
+	// Recent loudness halves each second.
+	loudnessDecay := math.Pow(0.5, float64(hopSize)/params.SampleRate())
+
+	return &PeakDetector{
+		params,
+		hopSize,
+		latency,
+		params.SampleRate(),
+		4.0,           /* FloorRatio, ~12dB */
+		0.05,          /* RelativeThreshold, ~-26dB */
+		loudnessDecay, /* LoudnessDecay */
+		0.001,         /* FloorAttack */
+		0.1,           /* FloorRelease */
+		nil,           /* floor */
+		nil,           /* latest */
+		nil,           /* previous */
+		0,             /* loudness */
+		0,             /* nextColumn */
+	}
+}
+
+// ProcessChannel finds the peaks within a stream of columns. Each peak is only known
+// once the next value for its bin arrives, so lower octaves of raw constant Q output
+// are reported slightly later, and peaks are not strictly in time order.
+func (pd *PeakDetector) ProcessChannel(columns <-chan []complex128) <-chan Peak {
+	result := make(chan Peak)
+
+	go func() {
+		for column := range columns {
+			if pd.nextColumn%10000 == 0 {
+				fmt.Printf("Finding peaks in column %d\n", pd.nextColumn)
+			}
+			for _, peak := range pd.processColumn(column) {
+				result <- peak
+			}
+		}
+		for _, peak := range pd.flush() {
+			result <- peak
+		}
+		close(result)
+	}()
+
+	return result
 }
 
-func (pd *PeakDetector) ProcessChannel(samples <-chan []complex128) <-chan []byte {
-	result := make(chan []byte)
+// ProcessColumns is ProcessChannel for columns that carry their own timing, which is used for
+// the peaks instead of the detector's hop size and latency.
+func (pd *PeakDetector) ProcessColumns(columns <-chan cq.Column) <-chan Peak {
+	result := make(chan Peak)
 
 	go func() {
-		i := 0
-		for sample := range samples {
-			if i%10000 == 0 {
-				fmt.Printf("Writing peaks %d\n", i)
+		for column := range columns {
+			if column.Index%10000 == 0 {
+				fmt.Printf("Finding peaks in column %d\n", column.Index)
+			}
+			for _, peak := range pd.processTimedColumn(column.Values, column.Index, column.Sample) {
+				result <- peak
 			}
-			i++
-			result <- pd.processColumn(sample)
+		}
+		for _, peak := range pd.flush() {
+			result <- peak
 		}
 		close(result)
 	}()
@@ -36,37 +145,170 @@ func (pd *PeakDetector) ProcessChannel(samples <-chan []complex128) <-chan []byt
 	return result
 }
 
-func (pd *PeakDetector) processColumn(column []complex128) []byte {
+// Process finds the peaks within columns, sorted by time then frequency.
+func (pd *PeakDetector) Process(columns [][]complex128) []Peak {
+	result := []Peak{}
+	for _, column := range columns {
+		result = append(result, pd.processColumn(column)...)
+	}
+	result = append(result, pd.flush()...)
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Sample != result[j].Sample {
+			return result[i].Sample < result[j].Sample
+		}
+		return result[i].Frequency < result[j].Frequency
+	})
+	return result
+}
+
+func (pd *PeakDetector) processColumn(column []complex128) []Peak {
+	at := pd.nextColumn
+	return pd.processTimedColumn(column, at, at*pd.hopSize-pd.latency)
+}
+
+// processTimedColumn finds peaks, given the column's index and the input sample it's centred on.
+func (pd *PeakDetector) processTimedColumn(column []complex128, at int, sample int) []Peak {
+	pd.nextColumn = at + 1
+
 	size := len(column)
-	result := make([]byte, size, size)
+	for len(pd.floor) < size {
+		pd.floor = append(pd.floor, -1)
+		pd.latest = append(pd.latest, peakCandidate{-1, 0, false, Peak{}})
+		pd.previous = append(pd.previous, 0)
+	}
 
+	magnitudes := make([]float64, size, size)
+	pd.loudness *= pd.LoudnessDecay
 	for i, v := range column {
-		power, _ := cmplx.Polar(v)
-		if power > THRESHOLD {
-			result[i] = 1
-		} else {
-			result[i] = 0
+		magnitudes[i] = cmplx.Abs(v)
+		if math.IsNaN(magnitudes[i]) {
+			magnitudes[i] = 0
 		}
+		pd.loudness = math.Max(pd.loudness, magnitudes[i])
 	}
 
-	// TODO
+	// The last bin of a short raw column isn't the lowest frequency: its lower neighbour is the
+	// top bin of the next octave down, so the latest value of that stands in for it.
+	neighbours := magnitudes
+	if size < len(pd.latest) && pd.latest[size].column >= 0 {
+		neighbours = append(magnitudes[:size:size], pd.latest[size].magnitude)
+	}
+
+	result := []Peak{}
+	for i, m := range magnitudes {
+		// The latest value for this bin is now known to be a peak in time if this is smaller.
+		latest := pd.latest[i]
+		if latest.possible && latest.magnitude > pd.previous[i] && latest.magnitude >= m {
+			result = append(result, latest.peak)
+		}
+
+		// Is this a peak in frequency, above both thresholds?
+		possible := (i == 0 || m > neighbours[i-1]) && (i == len(neighbours)-1 || m >= neighbours[i+1])
+		if pd.floor[i] >= 0 {
+			possible = possible && m > pd.floor[i]*pd.FloorRatio
+		}
+		possible = possible && m > pd.loudness*pd.RelativeThreshold && m > 0
+
+		candidate := peakCandidate{at, m, possible, Peak{}}
+		if possible {
+			candidate.peak = pd.makePeak(at, sample, i, neighbours)
+		}
+		pd.previous[i] = latest.magnitude
+		pd.latest[i] = candidate
+		pd.updateFloor(i, m)
+	}
 	return result
 }
 
-func WritePeaks(outputFile string, peaks <-chan []byte) {
-	ioutil.WriteFile(outputFile, PeaksToBytes(peaks), 0644)
+// flush returns the peaks still waiting on a next value at the end of the input.
+func (pd *PeakDetector) flush() []Peak {
+	result := []Peak{}
+	for i, latest := range pd.latest {
+		if latest.possible && latest.magnitude > pd.previous[i] {
+			result = append(result, latest.peak)
+		}
+		pd.latest[i].possible = false
+	}
+	return result
 }
 
-func PeaksToBytes(peaks <-chan []byte) []byte {
-	outputBuffer := bytes.NewBuffer(make([]byte, 0, 1024))
-	width, height := 0, 0
-	for col := range peaks {
-		for _, c := range col {
-			cq.WriteByte(outputBuffer, c)
+// makePeak builds the peak at a bin, interpolating a parabola through the log
+// magnitudes of the bin and its neighbours to get sub-bin frequency and magnitude.
+func (pd *PeakDetector) makePeak(column int, sample int, bin int, magnitudes []float64) Peak {
+	offset, magnitude := 0.0, magnitudes[bin]
+	if bin > 0 && bin+1 < len(magnitudes) {
+		a := math.Log(magnitudes[bin-1] + 1e-12)
+		b := math.Log(magnitudes[bin])
+		c := math.Log(magnitudes[bin+1] + 1e-12)
+		if denom := a - 2*b + c; denom < 0 {
+			offset = 0.5 * (a - c) / denom
+			magnitude = math.Exp(b - 0.25*(a-c)*offset)
 		}
-		width++
-		height = len(col)
 	}
-	fmt.Printf("Done! - %d by %d\n", width, height)
-	return outputBuffer.Bytes()
+
+	bpo := float64(pd.params.BinsPerOctave)
+	frequency := pd.params.BinFrequency(0) * math.Pow(2.0, -(float64(bin)+offset)/bpo)
+	return Peak{
+		float64(sample) / pd.sampleRate,
+		sample,
+		column,
+		bin,
+		frequency,
+		magnitude,
+	}
+}
+
+// updateFloor moves the bin's noise floor towards the latest magnitude.
+func (pd *PeakDetector) updateFloor(bin int, magnitude float64) {
+	floor := pd.floor[bin]
+	switch {
+	case floor < 0:
+		floor = magnitude
+	case magnitude > floor:
+		floor += pd.FloorAttack * (magnitude - floor)
+	default:
+		floor += pd.FloorRelease * (magnitude - floor)
+	}
+	pd.floor[bin] = floor
+}
+
+// WritePeaks writes peaks to a CSV file, one "time,sample,column,bin,frequency,magnitude" line each.
+// On failure the rest of the peaks are drained, so their producer can finish.
+func WritePeaks(outputFile string, peaks <-chan Peak) error {
+	file, err := os.Create(outputFile)
+	if err != nil {
+		go drainPeaks(peaks)
+		return err
+	}
+
+	err = writePeaks(file, peaks)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// writePeaks writes the header line, then a line for each peak.
+func writePeaks(w io.Writer, peaks <-chan Peak) error {
+	out := bufio.NewWriter(w)
+	fmt.Fprintln(out, "time,sample,column,bin,frequency,magnitude")
+	count := 0
+	for p := range peaks {
+		_, err := fmt.Fprintf(out, "%.6f,%d,%d,%d,%.3f,%.6f\n",
+			p.Time, p.Sample, p.Column, p.Bin, p.Frequency, p.Magnitude)
+		if err != nil {
+			go drainPeaks(peaks)
+			return err
+		}
+		count++
+	}
+	fmt.Printf("Done! - %d peaks\n", count)
+	return out.Flush()
+}
+
+// drainPeaks reads the rest of a channel, so its producer can finish.
+func drainPeaks(peaks <-chan Peak) {
+	for range peaks {
+	}
 }
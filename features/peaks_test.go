@@ -0,0 +1,146 @@
+package features
+
+// go test github.com/padster/go-sound/features
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/padster/go-sound/cq"
+	s "github.com/padster/go-sound/sounds"
+)
+
+func TestPickLocalMaxima(t *testing.T) {
+	params := cq.NewCQParams(s.CyclesPerSecond, 2, 110.0, 12)
+	pd := NewPeakDetector(params, 100, 0)
+
+	// Silence, with a peak at column 4 bin 6, and a larger one at column 6 bin 15. Bin 20
+	// is a peak in time but not frequency, as bin 21 is louder, and bin 6 at column 8 is a
+	// peak in frequency but not time, as it's louder at column 9.
+	columns := make([][]complex128, 12, 12)
+	for c := range columns {
+		columns[c] = make([]complex128, 24, 24)
+	}
+	columns[3][6], columns[4][6], columns[5][6] = 0.5, 1.0, 0.5
+	columns[5][15], columns[6][15], columns[7][15] = 1.0, 2.0, 1.0
+	columns[6][14], columns[6][16] = 1.0, 1.0
+	columns[6][20], columns[6][21] = 0.5, 0.6
+	columns[8][6], columns[9][6], columns[10][6] = 0.5, 0.8, 0.5
+
+	peaks := pd.Process(columns)
+	expected := []struct{ column, bin int }{{4, 6}, {6, 21}, {6, 15}, {9, 6}}
+	if len(peaks) != len(expected) {
+		t.Fatalf("Found peaks %+v, expected %v", peaks, expected)
+	}
+	for i, peak := range peaks {
+		if peak.Column != expected[i].column || peak.Bin != expected[i].bin {
+			t.Errorf("Peak %d at column %d bin %d, expected column %d bin %d",
+				i, peak.Column, peak.Bin, expected[i].column, expected[i].bin)
+		}
+		if peak.Sample != peak.Column*100 || peak.Time != float64(peak.Sample)/s.CyclesPerSecond {
+			t.Errorf("Peak %d at sample %d (%vs), expected column %d * 100", i, peak.Sample, peak.Time, peak.Column)
+		}
+	}
+	// A symmetric peak is exactly at its bin.
+	if math.Abs(peaks[2].Frequency-params.BinFrequency(15)) > 1e-9 || peaks[2].Magnitude != 2.0 {
+		t.Errorf("Symmetric peak at %vHz with magnitude %v, expected %vHz and 2", peaks[2].Frequency, peaks[2].Magnitude, params.BinFrequency(15))
+	}
+}
+
+// In raw columns, the last bin of a short column is compared with the latest value of the
+// top bin of the octave below, rather than treated as the lowest frequency.
+func TestPickLocalMaximaAtOctaveBoundary(t *testing.T) {
+	params := cq.NewCQParams(s.CyclesPerSecond, 2, 110.0, 12)
+	pd := NewPeakDetector(params, 100, 0)
+
+	// Every even column has both octaves, and odd ones only the top. Bin 11 peaks in time at
+	// columns 5 and 9, but at 9 the octave below was last louder, so only bin 12 at 8 is a peak.
+	columns := make([][]complex128, 12, 12)
+	for c := range columns {
+		columns[c] = make([]complex128, cq.RawColumnOctaves(params.Octaves, c)*params.BinsPerOctave)
+	}
+	columns[4][11], columns[5][11], columns[6][11] = 0.5, 1.0, 0.5
+	columns[4][12], columns[5][10] = 0.3, 0.2
+	columns[8][11], columns[9][11], columns[10][11] = 0.5, 1.0, 0.5
+	columns[8][12] = 1.5
+
+	peaks := pd.Process(columns)
+	expected := []struct{ column, bin int }{{5, 11}, {8, 12}}
+	if len(peaks) != len(expected) {
+		t.Fatalf("Found peaks %+v, expected %v", peaks, expected)
+	}
+	for i, peak := range peaks {
+		if peak.Column != expected[i].column || peak.Bin != expected[i].bin {
+			t.Errorf("Peak %d at column %d bin %d, expected column %d bin %d",
+				i, peak.Column, peak.Bin, expected[i].column, expected[i].bin)
+		}
+	}
+	// The peak at the boundary is interpolated towards its lower neighbour, which is louder than bin 10.
+	if peaks[0].Frequency >= params.BinFrequency(11) || peaks[0].Frequency <= params.BinFrequency(12) {
+		t.Errorf("Boundary peak at %vHz, expected between bins 11 and 12 at %vHz and %vHz",
+			peaks[0].Frequency, params.BinFrequency(11), params.BinFrequency(12))
+	}
+}
+
+func TestInterpolatePeakBetweenBins(t *testing.T) {
+	params := cq.NewCQParams(s.CyclesPerSecond, 4, 110.0, 24)
+	constantQ := cq.NewConstantQ(params)
+
+	// A sine half way between two bins, swelling so its peak in time is in the middle.
+	hz := 440.0 * math.Pow(2.0, 0.5/24.0)
+	samples := make([]float64, int(s.CyclesPerSecond))
+	for i := range samples {
+		swell := math.Sin(math.Pi * float64(i) / float64(len(samples)))
+		samples[i] = swell * math.Sin(2.0*math.Pi*hz*float64(i)/s.CyclesPerSecond)
+	}
+	columns := append(constantQ.Process(samples), constantQ.GetRemainingOutput()...)
+	peaks := NewPeakDetector(params, constantQ.SamplesPerColumn(), constantQ.OutputLatency).Process(columns)
+
+	found := false
+	for _, peak := range peaks {
+		semitones := 12.0 * math.Log2(peak.Frequency/hz)
+		if math.Abs(semitones) > 1 {
+			continue
+		}
+		found = true
+		if math.Abs(semitones) > 0.02 {
+			t.Errorf("Peak at %vHz, %.3f semitones from the sine at %vHz", peak.Frequency, semitones, hz)
+		}
+		if math.Abs(float64(peak.Sample-len(samples)/2)) > float64(constantQ.SamplesPerColumn()) {
+			t.Errorf("Peak at sample %d, expected within a column of %d", peak.Sample, len(samples)/2)
+		}
+	}
+	if !found {
+		t.Errorf("Expected a peak at %vHz, got %+v", hz, peaks)
+	}
+}
+
+func TestWritePeaksDrainsOnError(t *testing.T) {
+	// Enough peaks to fill the write buffer, so the error is seen before the last.
+	peaks, done := make(chan Peak), make(chan bool)
+	go func() {
+		for i := 0; i < 1000; i++ {
+			peaks <- Peak{}
+		}
+		close(done)
+	}()
+
+	if err := writePeaks(failingWriter{}, peaks); err == nil {
+		t.Fatalf("Expected writing peaks to fail")
+	}
+	// The producer only finishes once every peak has been taken.
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("Peaks were left undrained after the write failed")
+	}
+}
+
+// failingWriter fails every write.
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
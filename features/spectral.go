@@ -0,0 +1,189 @@
+package features
+
+import (
+	"fmt"
+	"math"
+)
+
+// An rms extractor calculates the root mean square amplitude of each frame.
+type rms struct{}
+
+// NewRMS creates an extractor for the root mean square amplitude of the samples.
+func NewRMS() Extractor {
+	return &rms{}
+}
+
+func (e *rms) Names() []string {
+	return []string{"rms"}
+}
+
+func (e *rms) Extract(frame *Frame) []float64 {
+	sum := 0.0
+	for _, v := range frame.Samples {
+		sum += v * v
+	}
+	return []float64{math.Sqrt(sum / float64(len(frame.Samples)))}
+}
+
+// A zeroCrossingRate extractor counts how often the samples change sign.
+type zeroCrossingRate struct{}
+
+// NewZeroCrossingRate creates an extractor for the fraction of consecutive samples that change sign.
+func NewZeroCrossingRate() Extractor {
+	return &zeroCrossingRate{}
+}
+
+func (e *zeroCrossingRate) Names() []string {
+	return []string{"zcr"}
+}
+
+func (e *zeroCrossingRate) Extract(frame *Frame) []float64 {
+	crossings := 0
+	for i := 1; i < len(frame.Samples); i++ {
+		if (frame.Samples[i-1] >= 0) != (frame.Samples[i] >= 0) {
+			crossings++
+		}
+	}
+	return []float64{float64(crossings) / float64(len(frame.Samples)-1)}
+}
+
+// A spectralCentroid extractor finds the magnitude-weighted mean frequency.
+type spectralCentroid struct{}
+
+// NewSpectralCentroid creates an extractor for the 'centre of mass' of the spectrum in Hz,
+// which correlates with the perceived brightness of a sound.
+func NewSpectralCentroid() Extractor {
+	return &spectralCentroid{}
+}
+
+func (e *spectralCentroid) Names() []string {
+	return []string{"centroid"}
+}
+
+func (e *spectralCentroid) Extract(frame *Frame) []float64 {
+	return []float64{centroid(frame)}
+}
+
+// A spectralSpread extractor finds the magnitude-weighted deviation around the centroid.
+type spectralSpread struct{}
+
+// NewSpectralSpread creates an extractor for the standard deviation (in Hz) of the spectrum around its centroid.
+func NewSpectralSpread() Extractor {
+	return &spectralSpread{}
+}
+
+func (e *spectralSpread) Names() []string {
+	return []string{"spread"}
+}
+
+func (e *spectralSpread) Extract(frame *Frame) []float64 {
+	c := centroid(frame)
+	weighted, total := 0.0, 0.0
+	for i, m := range frame.Spectrum {
+		d := frame.BinFrequency(i) - c
+		weighted += d * d * m
+		total += m
+	}
+	if total == 0 {
+		return []float64{0}
+	}
+	return []float64{math.Sqrt(weighted / total)}
+}
+
+// A spectralFlatness extractor compares the geometric and arithmetic means of the power spectrum.
+type spectralFlatness struct{}
+
+// NewSpectralFlatness creates an extractor for how noise-like (near 1) or tonal (near 0) the spectrum is.
+func NewSpectralFlatness() Extractor {
+	return &spectralFlatness{}
+}
+
+func (e *spectralFlatness) Names() []string {
+	return []string{"flatness"}
+}
+
+func (e *spectralFlatness) Extract(frame *Frame) []float64 {
+	logSum, sum := 0.0, 0.0
+	for _, m := range frame.Spectrum {
+		power := m*m + 1e-20
+		logSum += math.Log(power)
+		sum += power
+	}
+	n := float64(len(frame.Spectrum))
+	return []float64{math.Exp(logSum/n) / (sum / n)}
+}
+
+// A spectralRolloff extractor finds the frequency below which most of the energy lies.
+type spectralRolloff struct {
+	fraction float64
+}
+
+// NewSpectralRolloff creates an extractor for the frequency in Hz below which the
+// given fraction (commonly 0.85) of the spectral energy lies.
+func NewSpectralRolloff(fraction float64) Extractor {
+	if fraction <= 0 || fraction > 1 {
+		panic("Rolloff fraction must be in (0, 1]")
+	}
+	return &spectralRolloff{fraction}
+}
+
+func (e *spectralRolloff) Names() []string {
+	return []string{fmt.Sprintf("rolloff%d", int(e.fraction*100+0.5))}
+}
+
+func (e *spectralRolloff) Extract(frame *Frame) []float64 {
+	total := 0.0
+	for _, m := range frame.Spectrum {
+		total += m * m
+	}
+	target, sum := total*e.fraction, 0.0
+	for i, m := range frame.Spectrum {
+		sum += m * m
+		if sum >= target && total > 0 {
+			return []float64{frame.BinFrequency(i)}
+		}
+	}
+	return []float64{0}
+}
+
+// A spectralFlux extractor measures the change in spectrum since the previous frame.
+type spectralFlux struct {
+	previous []float64
+}
+
+// NewSpectralFlux creates an extractor for the euclidean distance between the
+// normalized spectrum of each frame and that of the frame before.
+func NewSpectralFlux() Extractor {
+	return &spectralFlux{nil}
+}
+
+func (e *spectralFlux) Names() []string {
+	return []string{"flux"}
+}
+
+func (e *spectralFlux) Extract(frame *Frame) []float64 {
+	current := unitVector(frame.Spectrum)
+	if e.previous == nil {
+		e.previous = make([]float64, len(current), len(current))
+	}
+	sum := 0.0
+	for i, v := range current {
+		d := v - e.previous[i]
+		sum += d * d
+	}
+	e.previous = current
+	return []float64{math.Sqrt(sum)}
+}
+
+// centroid returns the magnitude-weighted mean frequency of a frame's spectrum.
+func centroid(frame *Frame) float64 {
+	weighted, total := 0.0, 0.0
+	for i, m := range frame.Spectrum {
+		weighted += frame.BinFrequency(i) * m
+		total += m
+	}
+	if total == 0 {
+		return 0
+	}
+	return weighted / total
+}
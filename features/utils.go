@@ -0,0 +1,64 @@
+package features
+
+import (
+	"math"
+)
+
+// normalize rescales values to have zero mean and unit standard deviation.
+func normalize(values []float64) []float64 {
+	mean := meanOf(values)
+	variance := 0.0
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	std := math.Sqrt(variance / float64(len(values)))
+	if std == 0 {
+		std = 1
+	}
+
+	result := make([]float64, len(values), len(values))
+	for i, v := range values {
+		result[i] = (v - mean) / std
+	}
+	return result
+}
+
+// Math Utils
+
+func meanOf(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// princarg maps a phase into the range (-pi, pi]
+func princarg(phase float64) float64 {
+	return phase - 2*math.Pi*math.Floor((phase+math.Pi)/(2*math.Pi))
+}
+
+func growFloats(values []float64, size int) []float64 {
+	return append(values, make([]float64, size-len(values))...)
+}
+
+func round(x float64) int {
+	return int(math.Floor(x + 0.5))
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,214 @@
+package soundfile
+
+import (
+	"bufio"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/cmplx"
+)
+
+const (
+	// Range of magnitudes (as powers of two) representable by the polar codec.
+	// Anything quieter is stored as zero, anything louder is clamped.
+	polarMinLog2 = -24.0
+	polarMaxLog2 = 8.0
+)
+
+// CQCodecKind is how each complex value is stored.
+type CQCodecKind uint8
+
+const (
+	// Complex64 stores the real and imaginary parts as little endian float32s.
+	Complex64 CQCodecKind = iota
+	// Polar stores log-quantised magnitudes and uniformly quantised phases, bit packed.
+	Polar
+)
+
+// CQCodec is how the values within CQ file columns are encoded.
+type CQCodec struct {
+	Kind CQCodecKind
+
+	// For Polar, the bits used for each magnitude and phase. No phase bits stores magnitudes only.
+	MagnitudeBits int
+	PhaseBits     int
+}
+
+// Complex64Codec stores the values losslessly (at float32 precision), 8 bytes per value.
+var Complex64Codec = CQCodec{Complex64, 0, 0}
+
+// NewPolarCodec creates a lossy codec that stores each value in magnitudeBits + phaseBits bits.
+// Magnitudes are spaced evenly in log scale, so error is relative to the value's size.
+//
+// For example, 16 bit magnitudes and 8 bit phases use 3 bytes per value, compared to 8:
+//  header.Codec = f.NewPolarCodec(16, 8)
+func NewPolarCodec(magnitudeBits int, phaseBits int) CQCodec {
+	codec := CQCodec{Polar, magnitudeBits, phaseBits}
+	if err := codec.validate(); err != nil {
+		panic(err)
+	}
+	return codec
+}
+
+func (c CQCodec) validate() error {
+	switch c.Kind {
+	case Complex64:
+		return nil
+	case Polar:
+		if c.MagnitudeBits < 2 || c.MagnitudeBits > 16 || c.PhaseBits < 0 || c.PhaseBits > 16 {
+			return fmt.Errorf("Polar codec needs 2-16 magnitude bits and 0-16 phase bits, not %d and %d",
+				c.MagnitudeBits, c.PhaseBits)
+		}
+		return nil
+	}
+	return fmt.Errorf("Unknown CQ codec %d", c.Kind)
+}
+
+// columnBytes is how many bytes a column of the given height is encoded into.
+func (c CQCodec) columnBytes(height int) int {
+	if c.Kind == Polar {
+		return (height*(c.MagnitudeBits+c.PhaseBits) + 7) / 8
+	}
+	return height * 8
+}
+
+// encodeColumn converts a column of values into bytes.
+func (c CQCodec) encodeColumn(column []complex128) []byte {
+	raw := make([]byte, c.columnBytes(len(column)), c.columnBytes(len(column)))
+	if c.Kind == Complex64 {
+		for i, v := range column {
+			binary.LittleEndian.PutUint32(raw[i*8:], math.Float32bits(float32(real(v))))
+			binary.LittleEndian.PutUint32(raw[i*8+4:], math.Float32bits(float32(imag(v))))
+		}
+		return raw
+	}
+
+	bits := bitWriter{raw, 0}
+	magnitudeSteps := float64(uint(1)<<uint(c.MagnitudeBits) - 2)
+	phaseSteps := float64(uint(1) << uint(c.PhaseBits))
+	for _, v := range column {
+		// Magnitude 0 is reserved for silence, 1 upwards are log spaced.
+		quantised := uint(0)
+		if log2 := math.Log2(cmplx.Abs(v)); log2 >= polarMinLog2 {
+			at := (math.Min(log2, polarMaxLog2) - polarMinLog2) / (polarMaxLog2 - polarMinLog2)
+			quantised = 1 + uint(at*magnitudeSteps+0.5)
+		}
+		bits.write(quantised, c.MagnitudeBits)
+
+		if c.PhaseBits > 0 {
+			at := (cmplx.Phase(v) + math.Pi) / (2.0 * math.Pi)
+			bits.write(uint(at*phaseSteps+0.5)%uint(phaseSteps), c.PhaseBits)
+		}
+	}
+	return raw
+}
+
+// decodeColumn converts bytes back into a column of values.
+func (c CQCodec) decodeColumn(raw []byte, height int) []complex128 {
+	column := make([]complex128, height, height)
+	if c.Kind == Complex64 {
+		for i := range column {
+			re := math.Float32frombits(binary.LittleEndian.Uint32(raw[i*8:]))
+			im := math.Float32frombits(binary.LittleEndian.Uint32(raw[i*8+4:]))
+			column[i] = complex(float64(re), float64(im))
+		}
+		return column
+	}
+
+	bits := bitReader{raw, 0}
+	magnitudeSteps := float64(uint(1)<<uint(c.MagnitudeBits) - 2)
+	phaseSteps := float64(uint(1) << uint(c.PhaseBits))
+	for i := range column {
+		magnitude := 0.0
+		if quantised := bits.read(c.MagnitudeBits); quantised > 0 {
+			at := float64(quantised-1) / magnitudeSteps
+			magnitude = math.Exp2(polarMinLog2 + at*(polarMaxLog2-polarMinLog2))
+		}
+		phase := 0.0
+		if c.PhaseBits > 0 {
+			phase = float64(bits.read(c.PhaseBits))/phaseSteps*2.0*math.Pi - math.Pi
+		}
+		column[i] = cmplx.Rect(magnitude, phase)
+	}
+	return column
+}
+
+// bitWriter packs values into bytes, most significant bit first.
+type bitWriter struct {
+	data []byte
+	at   int
+}
+
+func (w *bitWriter) write(value uint, bits int) {
+	for b := bits - 1; b >= 0; b-- {
+		if value&(1<<uint(b)) != 0 {
+			w.data[w.at/8] |= 0x80 >> uint(w.at%8)
+		}
+		w.at++
+	}
+}
+
+// bitReader unpacks values written by a bitWriter.
+type bitReader struct {
+	data []byte
+	at   int
+}
+
+func (r *bitReader) read(bits int) uint {
+	value := uint(0)
+	for b := 0; b < bits; b++ {
+		value <<= 1
+		if r.data[r.at/8]&(0x80>>uint(r.at%8)) != 0 {
+			value |= 1
+		}
+		r.at++
+	}
+	return value
+}
+
+// decompressIfNeeded checks whether the stream starts with a zlib header, and if so
+// returns a reader of the decompressed content. A CQ header never looks like one, and
+// the chance of a legacy file's first float doing so is very small.
+func decompressIfNeeded(r *bufio.Reader) (*bufio.Reader, error) {
+	start, err := r.Peek(2)
+	if err != nil {
+		return r, nil // Too short to be compressed.
+	}
+	cmf, flg := start[0], start[1]
+	isZlib := cmf&0x0f == 8 && cmf>>4 <= 7 && (uint(cmf)<<8|uint(flg))%31 == 0 && flg&0x20 == 0
+	if !isZlib {
+		return r, nil
+	}
+	decompressed, err := zlib.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return bufio.NewReader(decompressed), nil
+}
+
+// CQColumnWriter writes a header, then encodes columns with the header's codec.
+type CQColumnWriter struct {
+	w       io.Writer
+	codec   CQCodec
+	Columns int
+}
+
+// NewCQColumnWriter writes the header, and returns a writer for the columns that follow.
+func NewCQColumnWriter(w io.Writer, header CQHeader) (*CQColumnWriter, error) {
+	if err := header.Codec.validate(); err != nil {
+		return nil, err
+	}
+	if err := WriteCQHeader(w, header); err != nil {
+		return nil, err
+	}
+	return &CQColumnWriter{w, header.Codec, 0}, nil
+}
+
+// Write encodes a single column.
+func (cw *CQColumnWriter) Write(column []complex128) error {
+	_, err := cw.w.Write(cw.codec.encodeColumn(column))
+	cw.Columns++
+	return err
+}
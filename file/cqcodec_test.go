@@ -0,0 +1,109 @@
+package soundfile
+
+// go test github.com/padster/go-sound/file
+
+import (
+	"math"
+	"math/cmplx"
+	"math/rand"
+	"testing"
+)
+
+// Relative errors of values within the polar codec's range, at a few sizes. Phase error dominates:
+// 8 phase bits are within half a step, pi/256 or about 1.2%, and 4 bits within pi/16, about 20%.
+func TestPolarCodecRoundTrip(t *testing.T) {
+	tests := []struct {
+		magnitudeBits, phaseBits    int
+		maxError, maxMagnitudeError float64
+	}{
+		{16, 8, 0.013, 0.0003},
+		{12, 6, 0.05, 0.003},
+		{8, 4, 0.21, 0.045},
+	}
+	column := randomColumn(rand.New(rand.NewSource(1)), 1000)
+	for _, test := range tests {
+		codec := NewPolarCodec(test.magnitudeBits, test.phaseBits)
+		raw := codec.encodeColumn(column)
+		if expected := (len(column)*(test.magnitudeBits+test.phaseBits) + 7) / 8; len(raw) != expected {
+			t.Errorf("%d+%d bits: encoded into %d bytes, expected %d", test.magnitudeBits, test.phaseBits, len(raw), expected)
+		}
+
+		decoded := codec.decodeColumn(raw, len(column))
+		worst, worstMagnitude := 0.0, 0.0
+		for i, v := range column {
+			worst = math.Max(worst, cmplx.Abs(decoded[i]-v)/cmplx.Abs(v))
+			worstMagnitude = math.Max(worstMagnitude, math.Abs(cmplx.Abs(decoded[i])/cmplx.Abs(v)-1))
+		}
+		if worst > test.maxError || worstMagnitude > test.maxMagnitudeError {
+			t.Errorf("%d+%d bits: relative error up to %.4f, %.4f in magnitude, expected at most %v and %v",
+				test.magnitudeBits, test.phaseBits, worst, worstMagnitude, test.maxError, test.maxMagnitudeError)
+		}
+	}
+}
+
+// Without phase bits, only magnitudes are kept, as positive real values.
+func TestPolarCodecMagnitudeOnly(t *testing.T) {
+	codec := NewPolarCodec(16, 0)
+	column := randomColumn(rand.New(rand.NewSource(2)), 100)
+	decoded := codec.decodeColumn(codec.encodeColumn(column), len(column))
+	if len(codec.encodeColumn(column)) != 200 {
+		t.Errorf("Expected 2 bytes per value")
+	}
+	for i, v := range column {
+		if imag(decoded[i]) != 0 || real(decoded[i]) < 0 || math.Abs(real(decoded[i])/cmplx.Abs(v)-1) > 0.0003 {
+			t.Errorf("Value %v decoded as %v, expected just its magnitude", v, decoded[i])
+		}
+	}
+}
+
+// Values below 2^-24 are silence, and those above 2^8 are clamped, keeping their phase.
+func TestPolarCodecLimits(t *testing.T) {
+	codec := NewPolarCodec(16, 8)
+	column := []complex128{0, complex(math.Exp2(-25), 0), complex(0, 1000), cmplx.Rect(math.Exp2(8), 1)}
+	decoded := codec.decodeColumn(codec.encodeColumn(column), len(column))
+	if decoded[0] != 0 || decoded[1] != 0 {
+		t.Errorf("Silence decoded as %v and %v, expected 0", decoded[0], decoded[1])
+	}
+	if math.Abs(cmplx.Abs(decoded[2])-256) > 1e-9 || math.Abs(cmplx.Phase(decoded[2])-math.Pi/2) > 0.013 {
+		t.Errorf("1000i decoded as %v, expected 256i", decoded[2])
+	}
+	if math.Abs(cmplx.Abs(decoded[3])-256) > 1e-9 {
+		t.Errorf("Largest value decoded as %v, expected magnitude 256", decoded[3])
+	}
+}
+
+func TestComplex64CodecRoundTrip(t *testing.T) {
+	column := randomColumn(rand.New(rand.NewSource(3)), 100)
+	raw := Complex64Codec.encodeColumn(column)
+	if len(raw) != 800 {
+		t.Errorf("Encoded into %d bytes, expected 8 per value", len(raw))
+	}
+	for i, v := range Complex64Codec.decodeColumn(raw, len(column)) {
+		expected := complex(float64(float32(real(column[i]))), float64(float32(imag(column[i]))))
+		if v != expected {
+			t.Errorf("Value %v decoded as %v, expected %v", column[i], v, expected)
+		}
+	}
+}
+
+func TestNewPolarCodecRejectsInvalid(t *testing.T) {
+	for _, bits := range [][2]int{{1, 8}, {17, 8}, {16, -1}, {16, 17}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected %d+%d bits to panic", bits[0], bits[1])
+				}
+			}()
+			NewPolarCodec(bits[0], bits[1])
+		}()
+	}
+}
+
+// randomColumn makes values with random phases, and magnitudes spread across most of the polar range.
+func randomColumn(r *rand.Rand, height int) []complex128 {
+	column := make([]complex128, height, height)
+	for i := range column {
+		column[i] = cmplx.Rect(math.Exp2(-20+r.Float64()*27), r.Float64()*2*math.Pi-math.Pi)
+	}
+	return column
+}
@@ -1,19 +1,284 @@
 package soundfile
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"math"
+	"os"
 
 	"github.com/padster/go-sound/cq"
 )
 
-// Writes the result of a constant Q transform to file.
-func WriteColumns(outputFile string, columns <-chan []complex128) {
-	ioutil.WriteFile(outputFile, ColumnsToBytes(columns), 0644)
+const (
+	// Magic bytes at the start of every CQ file with a header.
+	cqMagic = "GOCQ"
+
+	// CQFileVersion is the version of the header written by this code.
+	CQFileVersion = 3
+
+	// Size of the version 1 header fields after the magic, version and size.
+	cqHeaderSizeV1 = 8 + 4 + 8 + 4 + 8 + 8 + 4 + 4 + 1 + 8
+	// Version 2 adds the codec kind, magnitude bits and phase bits.
+	cqHeaderSizeV2 = cqHeaderSizeV1 + 3
+	// Version 3 adds the kernel threshold and variable-Q bandwidth offset.
+	cqHeaderSizeV3 = cqHeaderSizeV2 + 8 + 8
+)
+
+// CQLayout is how the columns are stored within a CQ file.
+type CQLayout uint8
+
+const (
+	// RawColumns are the varying height columns output by cq.ConstantQ.
+	RawColumns CQLayout = iota
+	// FullColumns are the interpolated full height columns output by cq.Spectrogram.
+	FullColumns
+)
+
+// ErrNoCQHeader is returned when reading the header of a legacy, headerless, CQ file.
+var ErrNoCQHeader = errors.New("CQ file has no header")
+
+// CQHeader describes the contents of a CQ file.
+type CQHeader struct {
+	Version int
+
+	// Parameters of the transform used to create the columns.
+	SampleRate    float64
+	Octaves       int
+	MinFrequency  float64
+	BinsPerOctave int
+	Q             float64
+	AtomHopFactor float64
+	Window        cq.Window
+
+	// Column i is centred on input sample (i * samples per column - Latency).
+	Latency int
+	Layout  CQLayout
+
+	// Number of columns in the file, or -1 if unknown.
+	ColumnCount int64
+
+	// How the values in each column are encoded. Version 1 files are always Complex64Codec.
+	Codec CQCodec
+
+	// More transform parameters, older versions always used the defaults.
+	Threshold       float64
+	BandwidthOffset float64
+}
+
+// NewCQHeader creates the header for columns from a transform with the given parameters.
+func NewCQHeader(params cq.CQParams, latency int, layout CQLayout) CQHeader {
+	return CQHeader{
+		CQFileVersion,
+		params.SampleRate(),
+		params.Octaves,
+		params.MinFrequency(),
+		params.BinsPerOctave,
+		params.Q(),
+		params.AtomHopFactor(),
+		params.Window(),
+		latency,
+		layout,
+		-1, /* ColumnCount */
+		Complex64Codec,
+		params.Threshold(),
+		params.BandwidthOffset(),
+	}
+}
+
+// Params returns the transform parameters described by the header.
+func (h CQHeader) Params() (params cq.CQParams, err error) {
+	// NewCQParams panics on invalid values, which here are a problem with the file.
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("CQ file has invalid parameters: %v", r)
+		}
+	}()
+	params = cq.NewCQParams(h.SampleRate, h.Octaves, h.MinFrequency, h.BinsPerOctave,
+		cq.WithQ(h.Q),
+		cq.WithAtomHopFactor(h.AtomHopFactor),
+		cq.WithWindow(h.Window),
+		cq.WithThreshold(h.Threshold),
+		cq.WithBandwidthOffset(h.BandwidthOffset),
+	)
+	return params, nil
+}
+
+// Timing returns where each column of the file sits in the input it was generated from.
+func (h CQHeader) Timing() (cq.ColumnTiming, error) {
+	params, err := h.Params()
+	if err != nil {
+		return cq.ColumnTiming{}, err
+	}
+	return cq.NewColumnTiming(params, h.Latency), nil
+}
+
+// Validate returns an error if the header doesn't match the given transform parameters.
+func (h CQHeader) Validate(params cq.CQParams) error {
+	expected := NewCQHeader(params, h.Latency, h.Layout)
+	expected.Version, expected.ColumnCount, expected.Codec = h.Version, h.ColumnCount, h.Codec
+	if h != expected {
+		return fmt.Errorf("CQ file parameters %+v don't match expected %+v", h, expected)
+	}
+	return nil
+}
+
+// WriteCQHeader writes the header, little endian, to the start of a CQ file.
+func WriteCQHeader(w io.Writer, h CQHeader) error {
+	buffer := bytes.NewBuffer(make([]byte, 0, 64))
+	buffer.WriteString(cqMagic)
+	fields := []interface{}{
+		uint16(CQFileVersion),
+		uint16(cqHeaderSizeV3),
+		h.SampleRate,
+		int32(h.Octaves),
+		h.MinFrequency,
+		int32(h.BinsPerOctave),
+		h.Q,
+		h.AtomHopFactor,
+		int32(h.Window),
+		int32(h.Latency),
+		uint8(h.Layout),
+		h.ColumnCount,
+		uint8(h.Codec.Kind),
+		uint8(h.Codec.MagnitudeBits),
+		uint8(h.Codec.PhaseBits),
+		h.Threshold,
+		h.BandwidthOffset,
+	}
+	for _, field := range fields {
+		binary.Write(buffer, binary.LittleEndian, field)
+	}
+	_, err := w.Write(buffer.Bytes())
+	return err
+}
+
+// ReadCQHeader reads the header from the start of a CQ file. If the file has no header,
+// ErrNoCQHeader is returned and nothing is consumed from the reader.
+func ReadCQHeader(r *bufio.Reader) (CQHeader, error) {
+	h := CQHeader{}
+	magic, err := r.Peek(len(cqMagic))
+	if err != nil || string(magic) != cqMagic {
+		return h, ErrNoCQHeader
+	}
+	r.Discard(len(cqMagic))
+
+	var version, size uint16
+	if err := binary.Read(r, binary.LittleEndian, &version); err != nil {
+		return h, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return h, err
+	}
+	if version < 1 || version > CQFileVersion {
+		return h, fmt.Errorf("Unsupported CQ file version %d", version)
+	}
+
+	fields := make([]byte, size, size)
+	if _, err := io.ReadFull(r, fields); err != nil {
+		return h, err
+	}
+	var octaves, bpo, window, latency int32
+	var layout, codec, magnitudeBits, phaseBits uint8
+	values := []interface{}{
+		&h.SampleRate, &octaves, &h.MinFrequency, &bpo, &h.Q, &h.AtomHopFactor,
+		&window, &latency, &layout, &h.ColumnCount,
+	}
+	if version >= 2 {
+		values = append(values, &codec, &magnitudeBits, &phaseBits)
+	}
+	h.Threshold = cq.DefaultThreshold
+	if version >= 3 {
+		values = append(values, &h.Threshold, &h.BandwidthOffset)
+	}
+	fieldReader := bytes.NewReader(fields)
+	for _, value := range values {
+		if err := binary.Read(fieldReader, binary.LittleEndian, value); err != nil {
+			return h, err
+		}
+	}
+	h.Version = int(version)
+	h.Octaves, h.BinsPerOctave = int(octaves), int(bpo)
+	h.Window, h.Latency, h.Layout = cq.Window(window), int(latency), CQLayout(layout)
+	h.Codec = CQCodec{CQCodecKind(codec), int(magnitudeBits), int(phaseBits)}
+
+	if h.SampleRate <= 0 || h.Octaves < 1 || h.BinsPerOctave < 1 || h.MinFrequency <= 0 ||
+		math.IsNaN(h.MinFrequency) || h.Layout > FullColumns {
+		return h, fmt.Errorf("Invalid CQ file header %+v", h)
+	}
+	return h, h.Codec.validate()
+}
+
+// ReadCQFileHeader reads just the header of a CQ file, returning ErrNoCQHeader for legacy files.
+// Compressed files are decompressed as needed.
+func ReadCQFileHeader(inputFile string) (CQHeader, error) {
+	file, err := os.Open(inputFile)
+	if err != nil {
+		return CQHeader{}, err
+	}
+	defer file.Close()
+	reader, err := decompressIfNeeded(bufio.NewReader(file))
+	if err != nil {
+		return CQHeader{}, err
+	}
+	return ReadCQHeader(reader)
 }
 
-// Converts the result of a constant Q transform to a byte stream.
+// Writes the result of a constant Q transform to file, after its header, using the header's codec.
+// The header's column count is filled in once all the columns are written. On failure the rest
+// of the columns are drained, so their producer can finish.
+func WriteColumns(outputFile string, header CQHeader, columns <-chan []complex128) error {
+	file, err := os.Create(outputFile)
+	if err != nil {
+		go drainColumns(columns)
+		return err
+	}
+
+	err = writeColumns(file, header, columns)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// writeColumns writes the header and columns, then seeks back to rewrite the header with the column count.
+func writeColumns(file io.WriteSeeker, header CQHeader, columns <-chan []complex128) error {
+	out := bufio.NewWriter(file)
+	writer, err := NewCQColumnWriter(out, header)
+	if err != nil {
+		go drainColumns(columns)
+		return err
+	}
+
+	width, height := 0, 0
+	for col := range columns {
+		if err := writer.Write(col); err != nil {
+			go drainColumns(columns)
+			return err
+		}
+		if width%10000 == 0 {
+			fmt.Printf("At frame: %d\n", width)
+		}
+		width++
+		height = len(col)
+	}
+	fmt.Printf("Done! - %d by %d\n", width, height)
+	if err := out.Flush(); err != nil {
+		return err
+	}
+
+	// Now the number of columns is known, rewrite the header.
+	header.ColumnCount = int64(width)
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return WriteCQHeader(file, header)
+}
+
+// Converts the result of a constant Q transform to a headerless byte stream.
 func ColumnsToBytes(columns <-chan []complex128) []byte {
 	outputBuffer := bytes.NewBuffer(make([]byte, 0, 1024))
 	width, height := 0, 0
@@ -31,26 +296,114 @@ func ColumnsToBytes(columns <-chan []complex128) []byte {
 	return outputBuffer.Bytes()
 }
 
-// Reads a file and converts back into a CQ channel.
+// OpenCQColumns reads a CQ file with a header, returning the header and a channel of its columns.
+// Compressed files are detected from their content, and decompressed while streaming.
+func OpenCQColumns(inputFile string) (CQHeader, <-chan []complex128, error) {
+	file, reader, err := openCQStream(inputFile)
+	if err != nil {
+		return CQHeader{}, nil, err
+	}
+	header, err := ReadCQHeader(reader)
+	if err != nil {
+		file.Close()
+		return header, nil, err
+	}
+	columns := streamColumns(file, reader, header.Octaves, header.BinsPerOctave, header.Layout, header.Codec)
+	return header, columns, nil
+}
+
+// OpenCQTimedColumns is OpenCQColumns, with each column annotated by its timing.
+func OpenCQTimedColumns(inputFile string) (CQHeader, <-chan cq.Column, error) {
+	header, columns, err := OpenCQColumns(inputFile)
+	if err != nil {
+		return header, nil, err
+	}
+	timing, err := header.Timing()
+	if err != nil {
+		go drainColumns(columns)
+		return header, nil, err
+	}
+	return header, timing.Annotate(columns), nil
+}
+
+// Reads a file and converts back into a CQ channel. Files with a header are checked against
+// the parameters, and legacy files without one are assumed to match them.
 func ReadCQColumns(inputFile string, params cq.CQParams) <-chan []complex128 {
-	loaded, err := ioutil.ReadFile(inputFile)
+	header, columns, err := OpenCQColumns(inputFile)
+	switch {
+	case err == ErrNoCQHeader:
+		fmt.Printf("No header in %s, reading as legacy CQ file\n", inputFile)
+		return ReadLegacyCQColumns(inputFile, params)
+	case err != nil:
+		panic(err)
+	}
+	if err := header.Validate(params); err != nil {
+		panic(err)
+	}
+	return columns
+}
+
+// ReadLegacyCQColumns reads a headerless CQ file, of raw columns generated with the given parameters.
+func ReadLegacyCQColumns(inputFile string, params cq.CQParams) <-chan []complex128 {
+	file, reader, err := openCQStream(inputFile)
 	if err != nil {
 		panic("Can't load file " + inputFile)
 	}
-	complexEntries := len(loaded) / 8 // complex stored as two float32s.
-	fmt.Printf("Reading %d entries\n", complexEntries)
+	return streamColumns(file, reader, params.Octaves, params.BinsPerOctave, RawColumns, Complex64Codec)
+}
 
-	asReader := bytes.NewReader(loaded)
+// openCQStream opens a CQ file, decompressing its content if needed.
+func openCQStream(inputFile string) (*os.File, *bufio.Reader, error) {
+	file, err := os.Open(inputFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader, err := decompressIfNeeded(bufio.NewReader(file))
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return file, reader, nil
+}
 
+// streamColumns reads columns of the given layout until the end of the reader, then closes it.
+func streamColumns(closer io.Closer, r io.Reader, octaves int, bpo int, layout CQLayout, codec CQCodec) <-chan []complex128 {
 	result := make(chan []complex128)
 	go func() {
-		heightGen := cq.GenerateHeights(params.Octaves)
-		for at := 0; at < complexEntries; {
-			nextSize := heightGen() * params.BinsPerOctave
-			result <- cq.ReadComplexArray(asReader, nextSize)
-			at += nextSize
+		defer closer.Close()
+		for at := 0; ; at++ {
+			height := octaves * bpo
+			if layout == RawColumns {
+				height = cq.RawColumnOctaves(octaves, at) * bpo
+			}
+			column, err := readColumn(r, height, codec)
+			if err != nil {
+				if err != io.EOF {
+					fmt.Printf("Stopped reading CQ columns: %v\n", err)
+				}
+				break
+			}
+			result <- column
 		}
 		close(result)
 	}()
 	return result
 }
+
+// drainColumns reads the rest of a channel, so its producer can finish.
+func drainColumns(columns <-chan []complex128) {
+	for range columns {
+	}
+}
+
+// readColumn reads a column of complex values, encoded with the given codec.
+func readColumn(r io.Reader, height int, codec CQCodec) ([]complex128, error) {
+	raw := make([]byte, codec.columnBytes(height), codec.columnBytes(height))
+	if _, err := io.ReadFull(r, raw); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("truncated column: %v", err)
+		}
+		return nil, err
+	}
+	return codec.decodeColumn(raw, height), nil
+}
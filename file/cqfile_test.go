@@ -0,0 +1,216 @@
+package soundfile
+
+// go test github.com/padster/go-sound/file
+
+import (
+	"compress/zlib"
+	"io/ioutil"
+	"math/cmplx"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/padster/go-sound/cq"
+)
+
+func TestWriteColumnsSetsCount(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cqfile_")
+	if err != nil {
+		t.Fatalf("Can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	params := cq.NewCQParams(44100, 2, 110, 12)
+	header := NewCQHeader(params, 0, FullColumns)
+	path := filepath.Join(dir, "out.cq")
+	if err := WriteColumns(path, header, testColumns(5, 24)); err != nil {
+		t.Fatalf("Can't write columns: %v", err)
+	}
+
+	read, columns, err := OpenCQColumns(path)
+	if err != nil {
+		t.Fatalf("Can't read columns: %v", err)
+	}
+	if read.ColumnCount != 5 {
+		t.Errorf("Header has %d columns, expected 5", read.ColumnCount)
+	}
+	count := 0
+	for column := range columns {
+		if len(column) != 24 || column[3] != complex(float64(count), 3) {
+			t.Errorf("Column %d read as %v", count, column)
+		}
+		count++
+	}
+	if count != 5 {
+		t.Errorf("Read %d columns, expected 5", count)
+	}
+}
+
+func TestWriteColumnsDrainsOnError(t *testing.T) {
+	params := cq.NewCQParams(44100, 2, 110, 12)
+	header := NewCQHeader(params, 0, FullColumns)
+	columns, done := make(chan []complex128), make(chan bool)
+	go func() {
+		for i := 0; i < 5; i++ {
+			columns <- make([]complex128, 24, 24)
+		}
+		close(done)
+	}()
+
+	path := filepath.Join(os.DevNull, "missing", "out.cq")
+	if err := WriteColumns(path, header, columns); err == nil {
+		t.Fatalf("Expected writing to %s to fail", path)
+	}
+	// The producer only finishes once every column has been taken.
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("Columns were left undrained after the write failed")
+	}
+}
+
+// Compressed files, as written by writecq -zip, are detected and decompressed when read. Their
+// column count can't be filled in after the columns, so is left unknown.
+func TestCompressedColumnsRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cqfile_")
+	if err != nil {
+		t.Fatalf("Can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	params := cq.NewCQParams(44100, 2, 110, 12)
+	for _, codec := range []CQCodec{Complex64Codec, NewPolarCodec(16, 8)} {
+		header := NewCQHeader(params, 0, FullColumns)
+		header.Codec = codec
+		path := filepath.Join(dir, "out.cq")
+		writeCompressedColumns(t, path, header, testColumns(5, 24))
+
+		read, columns, err := OpenCQColumns(path)
+		if err != nil {
+			t.Fatalf("Can't read compressed columns: %v", err)
+		}
+		if read.Codec != codec || read.ColumnCount != -1 {
+			t.Errorf("Read header %+v", read)
+		}
+		expectColumns(t, columns, 5, 24, 0.013)
+		expectColumns(t, ReadCQColumns(path, params), 5, 24, 0.013)
+	}
+}
+
+// Files from before CQ headers are raw columns of complex64s, and may be compressed too.
+func TestReadLegacyColumns(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cqfile_")
+	if err != nil {
+		t.Fatalf("Can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	params := cq.NewCQParams(44100, 2, 110, 12)
+	plain, compressed := filepath.Join(dir, "plain.cq"), filepath.Join(dir, "compressed.cq")
+	contents := ColumnsToBytes(testRawColumns(8, params))
+	ioutil.WriteFile(plain, contents, 0644)
+	file, _ := os.Create(compressed)
+	zip := zlib.NewWriter(file)
+	zip.Write(contents)
+	zip.Close()
+	file.Close()
+
+	for _, path := range []string{plain, compressed} {
+		if _, _, err := OpenCQColumns(path); err != ErrNoCQHeader {
+			t.Errorf("%s: expected no header, got %v", path, err)
+		}
+		expectRawColumns(t, ReadCQColumns(path, params), 8, params)
+		expectRawColumns(t, ReadLegacyCQColumns(path, params), 8, params)
+	}
+}
+
+// writeCompressedColumns writes a header and columns through zlib, as writecq -zip does.
+func writeCompressedColumns(t *testing.T, path string, header CQHeader, columns <-chan []complex128) {
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Can't create %s: %v", path, err)
+	}
+	defer file.Close()
+	zip := zlib.NewWriter(file)
+	writer, err := NewCQColumnWriter(zip, header)
+	if err != nil {
+		t.Fatalf("Can't write header: %v", err)
+	}
+	for column := range columns {
+		if err := writer.Write(column); err != nil {
+			t.Fatalf("Can't write column: %v", err)
+		}
+	}
+	if err := zip.Close(); err != nil {
+		t.Fatalf("Can't compress: %v", err)
+	}
+}
+
+// expectColumns checks columns read back match testColumns, within a relative error.
+func expectColumns(t *testing.T, columns <-chan []complex128, width int, height int, tolerance float64) {
+	count := 0
+	for column := range columns {
+		if len(column) != height {
+			t.Errorf("Column %d has height %d, expected %d", count, len(column), height)
+		}
+		for b, v := range column {
+			expected := complex(float64(count), float64(b))
+			if cmplx.Abs(v-expected) > tolerance*cmplx.Abs(expected) {
+				t.Errorf("Column %d bin %d read as %v, expected %v", count, b, v, expected)
+				break
+			}
+		}
+		count++
+	}
+	if count != width {
+		t.Errorf("Read %d columns, expected %d", count, width)
+	}
+}
+
+// testRawColumns sends columns with the heights of raw constant Q output, whose values are their
+// column and bin indices.
+func testRawColumns(width int, params cq.CQParams) <-chan []complex128 {
+	result := make(chan []complex128)
+	go func() {
+		for i := 0; i < width; i++ {
+			column := make([]complex128, cq.RawColumnOctaves(params.Octaves, i)*params.BinsPerOctave)
+			for b := range column {
+				column[b] = complex(float64(i), float64(b))
+			}
+			result <- column
+		}
+		close(result)
+	}()
+	return result
+}
+
+func expectRawColumns(t *testing.T, columns <-chan []complex128, width int, params cq.CQParams) {
+	count := 0
+	for column := range columns {
+		height := cq.RawColumnOctaves(params.Octaves, count) * params.BinsPerOctave
+		if len(column) != height || column[height-1] != complex(float64(count), float64(height-1)) {
+			t.Errorf("Column %d read as %v, expected height %d", count, column, height)
+		}
+		count++
+	}
+	if count != width {
+		t.Errorf("Read %d columns, expected %d", count, width)
+	}
+}
+
+// testColumns sends columns whose values are their column and bin indices.
+func testColumns(width int, height int) <-chan []complex128 {
+	result := make(chan []complex128)
+	go func() {
+		for i := 0; i < width; i++ {
+			column := make([]complex128, height, height)
+			for b := range column {
+				column[b] = complex(float64(i), float64(b))
+			}
+			result <- column
+		}
+		close(result)
+	}()
+	return result
+}
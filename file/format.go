@@ -0,0 +1,106 @@
+package soundfile
+
+import (
+	"io"
+	"os"
+)
+
+// AudioFormat is the encoding of a sound file, as detected from its contents.
+type AudioFormat uint8
+
+const (
+	// UnknownAudio is any file that isn't one of the formats below.
+	UnknownAudio AudioFormat = iota
+	Wav
+	Flac
+	Aiff
+	OggVorbis
+	Mp3
+)
+
+// Number of bytes needed to recognise a format, after any ID3 tag.
+const sniffSize = 36
+
+func (f AudioFormat) String() string {
+	switch f {
+	case Wav:
+		return "WAV"
+	case Flac:
+		return "FLAC"
+	case Aiff:
+		return "AIFF"
+	case OggVorbis:
+		return "Ogg Vorbis"
+	case Mp3:
+		return "MP3"
+	}
+	return "unknown"
+}
+
+// DetectAudioFormat reads the start of a file to find its format, regardless of its name.
+func DetectAudioFormat(path string) (AudioFormat, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return UnknownAudio, err
+	}
+	defer file.Close()
+
+	header, err := readHeader(file, 0)
+	if err != nil {
+		return UnknownAudio, err
+	}
+
+	// MP3s, and occasionally other formats, start with an ID3v2 tag of a known size.
+	if len(header) >= 10 && string(header[0:3]) == "ID3" {
+		size := int64(header[6])<<21 | int64(header[7])<<14 | int64(header[8])<<7 | int64(header[9])
+		if header[5]&0x10 != 0 {
+			size += 10 // Footer.
+		}
+		if header, err = readHeader(file, 10+size); err != nil {
+			return UnknownAudio, err
+		}
+	}
+	return sniffAudioFormat(header), nil
+}
+
+// readHeader reads up to sniffSize bytes from an offset into a file.
+func readHeader(file *os.File, offset int64) ([]byte, error) {
+	header := make([]byte, sniffSize, sniffSize)
+	n, err := file.ReadAt(header, offset)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return header[:n], nil
+}
+
+// sniffAudioFormat recognises a format from the first bytes of its data.
+func sniffAudioFormat(b []byte) AudioFormat {
+	has := func(offset int, magic string) bool {
+		return len(b) >= offset+len(magic) && string(b[offset:offset+len(magic)]) == magic
+	}
+
+	switch {
+	case has(0, "RIFF") && has(8, "WAVE"):
+		return Wav
+	case has(0, "fLaC"):
+		return Flac
+	case has(0, "FORM") && (has(8, "AIFF") || has(8, "AIFC")):
+		return Aiff
+	case has(0, "OggS"):
+		// The first packet is the codec's identification header, after the page's segment table.
+		if len(b) > 26 && has(27+int(b[26]), "\x01vorbis") {
+			return OggVorbis
+		}
+	case len(b) >= 4 && isMp3FrameHeader(b):
+		return Mp3
+	}
+	return UnknownAudio
+}
+
+// isMp3FrameHeader returns whether bytes start with the header of an MPEG audio layer III frame.
+func isMp3FrameHeader(b []byte) bool {
+	sync := b[0] == 0xFF && b[1]&0xE0 == 0xE0
+	version, layer := b[1]>>3&0x03, b[1]>>1&0x03
+	bitrate, rate := b[2]>>4, b[2]>>2&0x03
+	return sync && version != 1 && layer == 1 && bitrate != 0x0F && rate != 0x03
+}
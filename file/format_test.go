@@ -0,0 +1,58 @@
+package soundfile
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestSniffAudioFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		format AudioFormat
+	}{
+		{"wav", "RIFF\x24\x00\x00\x00WAVEfmt ", Wav},
+		{"flac", "fLaC\x00\x00\x00\x22", Flac},
+		{"aiff", "FORM\x00\x00\x10\x00AIFFCOMM", Aiff},
+		{"aifc", "FORM\x00\x00\x10\x00AIFCFVER", Aiff},
+		{"ogg vorbis", "OggS\x00\x02" + zeros(20) + "\x01\x1e\x01vorbis", OggVorbis},
+		{"ogg opus", "OggS\x00\x02" + zeros(20) + "\x01\x13OpusHead", UnknownAudio},
+		{"mp3 frame", "\xff\xfb\x90\x64", Mp3},
+		{"mpeg 2 mp3 frame", "\xff\xf3\x48\xc4", Mp3},
+		{"mp2 frame", "\xff\xfd\x90\x64", UnknownAudio},
+		{"riff avi", "RIFF\x24\x00\x00\x00AVI LIST", UnknownAudio},
+		{"text", "Not a sound file at all", UnknownAudio},
+		{"short", "RI", UnknownAudio},
+		{"empty", "", UnknownAudio},
+	}
+	for _, test := range tests {
+		if format := sniffAudioFormat([]byte(test.header)); format != test.format {
+			t.Errorf("%s: detected %v, expected %v", test.name, format, test.format)
+		}
+	}
+}
+
+// MP3s usually start with an ID3 tag, which should be skipped regardless of extension.
+func TestDetectAudioFormatAfterID3(t *testing.T) {
+	f, err := ioutil.TempFile("", "sniff_")
+	if err != nil {
+		t.Fatalf("Can't create temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	// 0x81 byte tag, as a syncsafe integer.
+	f.WriteString("ID3\x04\x00\x00\x00\x00\x01\x01" + zeros(0x81) + "\xff\xfb\x90\x64" + zeros(100))
+	f.Close()
+
+	format, err := DetectAudioFormat(f.Name())
+	if err != nil || format != Mp3 {
+		t.Errorf("Detected %v, %v, expected MP3", format, err)
+	}
+	if _, err := DetectAudioFormat(f.Name() + ".missing"); err == nil {
+		t.Errorf("Expected an error for a missing file")
+	}
+}
+
+func zeros(n int) string {
+	return string(make([]byte, n, n))
+}
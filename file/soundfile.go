@@ -9,33 +9,50 @@ import (
 	s "github.com/padster/go-sound/sounds"
 )
 
+// Read loads a sound file as the average of its channels. The format is detected from the
+// file's contents, so its extension doesn't matter.
 func Read(path string) s.Sound {
-	switch {
-	case strings.HasSuffix(path, ".flac"):
+	format, err := DetectAudioFormat(path)
+	if err != nil {
+		panic(err)
+	}
+	switch format {
+	case Flac:
 		return s.LoadFlacAsSound(path)
-	case strings.HasSuffix(path, ".wav"):
-		return s.LoadWavAsSound(path, 0 /* TODO: average all channels. */)
+	case Wav:
+		return s.LoadWavAsSound(path, s.MixDown)
+	case Aiff:
+		return s.LoadAiffAsSound(path, s.MixDown)
+	case OggVorbis:
+		return s.LoadOggAsSound(path, s.MixDown)
+	case Mp3:
+		return s.LoadMp3AsSound(path, s.MixDown)
 	default:
 		panic("Unsupported file type: " + path)
 	}
 }
 
 func Write(sound s.Sound, path string) {
+	var err error
 	switch {
 	case strings.HasSuffix(path, ".flac"):
-		panic("FLAC support currently broken, please use something else")
-		o.WriteSoundToFlac(sound, path)
+		err = o.WriteSoundToFlac(sound, path)
 	case strings.HasSuffix(path, ".wav"):
-		o.WriteSoundToWav(sound, path)
+		err = o.WriteSoundToWav(sound, path)
 	default:
 		panic("Unsupported file type: " + path)
 	}
+	if err != nil {
+		panic(err)
+	}
 }
 
-func ReadCQ(path string, params cq.CQParams, zip bool) s.Sound {
-	if zip {
-		// TODO: Implement
-		panic("Zip read CQ unsupported for now.")
+// ReadCQ reads a CQ file and inverts it back into a sound. Compressed files are detected
+// from their content, and decompressed while streaming.
+func ReadCQ(path string, params cq.CQParams) s.Sound {
+	header, err := ReadCQFileHeader(path)
+	if err == nil && header.Layout != RawColumns {
+		panic("Only raw CQ columns can be inverted, not interpolated ones: " + path)
 	}
 
 	fmt.Printf("Reading columms from %s\n", path)
@@ -0,0 +1,83 @@
+package flac
+
+import (
+	"io"
+)
+
+// bitReader reads values, most significant bit first, a byte at a time so that it never
+// reads past the end of a frame.
+type bitReader struct {
+	r io.Reader
+
+	// Bits read but not yet used, the low n bits of acc.
+	acc uint64
+	n   uint
+	buf [1]byte
+}
+
+func (br *bitReader) fill() error {
+	if _, err := io.ReadFull(br.r, br.buf[:]); err != nil {
+		return err
+	}
+	br.acc = br.acc<<8 | uint64(br.buf[0])
+	br.n += 8
+	return nil
+}
+
+// read returns the next bits, up to 56 at a time. io.EOF means the stream ended cleanly
+// between bytes, before any of the bits.
+func (br *bitReader) read(bits uint) (uint64, error) {
+	for br.n < bits {
+		if err := br.fill(); err != nil {
+			if err == io.EOF && br.n == 0 {
+				return 0, io.EOF
+			}
+			return 0, io.ErrUnexpectedEOF
+		}
+	}
+	br.n -= bits
+	v := br.acc >> br.n
+	br.acc &= 1<<br.n - 1
+	return v, nil
+}
+
+// readSigned returns the next bits as a two's complement value.
+func (br *bitReader) readSigned(bits uint) (int64, error) {
+	v, err := br.read(bits)
+	if err != nil || bits == 0 {
+		return 0, err
+	}
+	if v>>(bits-1) == 1 {
+		return int64(v) - int64(1)<<bits, nil
+	}
+	return int64(v), nil
+}
+
+// readUnary returns the number of zero bits before the next one bit.
+func (br *bitReader) readUnary() (uint64, error) {
+	zeros := uint64(0)
+	for {
+		if br.n == 0 {
+			if err := br.fill(); err != nil {
+				return 0, io.ErrUnexpectedEOF
+			}
+		}
+		if br.acc == 0 {
+			zeros += uint64(br.n)
+			br.n = 0
+			continue
+		}
+		for br.acc>>(br.n-1) == 0 {
+			br.n--
+			zeros++
+		}
+		br.n--
+		br.acc &= 1<<br.n - 1
+		return zeros, nil
+	}
+}
+
+// align skips to the start of the next byte.
+func (br *bitReader) align() {
+	br.acc, br.n = 0, 0
+}
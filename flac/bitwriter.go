@@ -0,0 +1,101 @@
+package flac
+
+// bitWriter packs values, most significant bit first, into bytes.
+type bitWriter struct {
+	bytes []byte
+
+	// Bits not yet making up a whole byte, the low n bits of acc.
+	acc uint64
+	n   uint
+}
+
+// write appends the low bits of a value, up to 32 at a time.
+func (w *bitWriter) write(value uint64, bits uint) {
+	if bits > 32 {
+		panic("Can't write more than 32 bits at once")
+	}
+	w.acc = w.acc<<bits | value&(1<<bits-1)
+	w.n += bits
+	for w.n >= 8 {
+		w.n -= 8
+		w.bytes = append(w.bytes, byte(w.acc>>w.n))
+	}
+	w.acc &= 1<<w.n - 1
+}
+
+// writeSigned appends a two's complement value.
+func (w *bitWriter) writeSigned(value int64, bits uint) {
+	w.write(uint64(value), bits)
+}
+
+// writeUnary appends a number of zero bits, then a one.
+func (w *bitWriter) writeUnary(zeros uint64) {
+	for ; zeros >= 32; zeros -= 32 {
+		w.write(0, 32)
+	}
+	w.write(1, uint(zeros)+1)
+}
+
+// writeRice appends a folded residual as a unary quotient and binary remainder.
+func (w *bitWriter) writeRice(residual int32, parameter uint) {
+	folded := fold(residual)
+	w.writeUnary(folded >> parameter)
+	w.write(folded, parameter)
+}
+
+// align pads with zeros to a whole byte.
+func (w *bitWriter) align() {
+	if w.n > 0 {
+		w.write(0, 8-w.n)
+	}
+}
+
+// fold maps signed residuals to unsigned, 0, -1, 1, -2, ... to 0, 1, 2, 3, ...
+func fold(residual int32) uint64 {
+	if residual < 0 {
+		return uint64(-int64(residual))<<1 - 1
+	}
+	return uint64(residual) << 1
+}
+
+var crc8Table, crc16Table = crcTables()
+
+// crcTables builds the lookup tables for the frame header's CRC-8 (polynomial 0x07)
+// and the frame's CRC-16 (polynomial 0x8005).
+func crcTables() (*[256]uint8, *[256]uint16) {
+	var t8 [256]uint8
+	var t16 [256]uint16
+	for i := 0; i < 256; i++ {
+		c8, c16 := uint8(i), uint16(i)<<8
+		for b := 0; b < 8; b++ {
+			if c8&0x80 != 0 {
+				c8 = c8<<1 ^ 0x07
+			} else {
+				c8 <<= 1
+			}
+			if c16&0x8000 != 0 {
+				c16 = c16<<1 ^ 0x8005
+			} else {
+				c16 <<= 1
+			}
+		}
+		t8[i], t16[i] = c8, c16
+	}
+	return &t8, &t16
+}
+
+func crc8(data []byte) uint8 {
+	crc := uint8(0)
+	for _, b := range data {
+		crc = crc8Table[crc^b]
+	}
+	return crc
+}
+
+func crc16(data []byte) uint16 {
+	crc := uint16(0)
+	for _, b := range data {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^b]
+	}
+	return crc
+}
@@ -0,0 +1,491 @@
+package flac
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+)
+
+// Frame is a block of decoded samples, interleaved across channels.
+type Frame struct {
+	Channels int
+	Depth    int
+	Rate     int
+	Buffer   []int32
+}
+
+// Decoder reads the samples of a FLAC stream, a frame at a time.
+type Decoder struct {
+	r    *bufio.Reader
+	info StreamInfo
+	tags map[string]string
+
+	// MD5 of the samples read so far, checked against the STREAMINFO at the end of the stream.
+	md5  hash.Hash
+	read int64
+}
+
+// NewDecoder reads the metadata at the start of a FLAC stream, leaving the reader at its first frame.
+func NewDecoder(r io.Reader) (*Decoder, error) {
+	d := &Decoder{bufio.NewReader(r), StreamInfo{}, map[string]string{}, md5.New(), 0}
+	magic := make([]byte, 4, 4)
+	if _, err := io.ReadFull(d.r, magic); err != nil {
+		return nil, fmt.Errorf("Can't read FLAC header: %v", err)
+	}
+	if string(magic) != "fLaC" {
+		return nil, errors.New("Not a FLAC stream")
+	}
+
+	for last, first := false, true; !last; first = false {
+		header := make([]byte, 4, 4)
+		if _, err := io.ReadFull(d.r, header); err != nil {
+			return nil, fmt.Errorf("Can't read FLAC metadata: %v", err)
+		}
+		last = header[0]&0x80 != 0
+		blockType := int(header[0] & 0x7F)
+		size := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+		body := make([]byte, size, size)
+		if _, err := io.ReadFull(d.r, body); err != nil {
+			return nil, fmt.Errorf("Can't read FLAC metadata: %v", err)
+		}
+
+		if first != (blockType == blockStreamInfo) {
+			return nil, errors.New("FLAC stream must start with a STREAMINFO block")
+		}
+		switch blockType {
+		case blockStreamInfo:
+			if size < streamInfoSize {
+				return nil, errors.New("FLAC STREAMINFO too short")
+			}
+			d.info = parseStreamInfo(body)
+		case blockVorbisComment:
+			parseVorbisComment(body, d.tags)
+		}
+	}
+	return d, nil
+}
+
+// Info returns the stream's STREAMINFO.
+func (d *Decoder) Info() StreamInfo {
+	return d.info
+}
+
+// Tags returns the stream's Vorbis comments, with upper case names.
+func (d *Decoder) Tags() map[string]string {
+	return d.tags
+}
+
+// ReadFrame decodes the next frame, returning io.EOF at the end of the stream.
+func (d *Decoder) ReadFrame() (*Frame, error) {
+	frameBytes := &bytes.Buffer{}
+	br := &bitReader{r: io.TeeReader(d.r, frameBytes)}
+
+	sync, err := br.read(15)
+	if err == io.EOF {
+		return nil, d.checkMD5()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if sync != 0x7FFC {
+		return nil, fmt.Errorf("Lost FLAC frame sync at sample %d", d.read)
+	}
+	br.read(1) // Blocking strategy, which only matters for seeking.
+
+	sizeCode, _ := br.read(4)
+	rateCode, _ := br.read(4)
+	assignment, _ := br.read(4)
+	sampleSizeCode, _ := br.read(3)
+	br.read(1)
+	if _, err := readCodedNumber(br); err != nil {
+		return nil, err
+	}
+
+	blockSize := 0
+	switch {
+	case sizeCode == 1:
+		blockSize = 192
+	case sizeCode >= 2 && sizeCode <= 5:
+		blockSize = 576 << (sizeCode - 2)
+	case sizeCode == 6:
+		v, _ := br.read(8)
+		blockSize = int(v) + 1
+	case sizeCode == 7:
+		v, _ := br.read(16)
+		blockSize = int(v) + 1
+	case sizeCode >= 8:
+		blockSize = 256 << (sizeCode - 8)
+	default:
+		return nil, errors.New("Reserved FLAC block size")
+	}
+
+	rate := d.info.SampleRate
+	rates := []int{0, 88200, 176400, 192000, 8000, 16000, 22050, 24000, 32000, 44100, 48000, 96000}
+	switch {
+	case rateCode > 0 && rateCode < 12:
+		rate = rates[rateCode]
+	case rateCode == 12:
+		v, _ := br.read(8)
+		rate = int(v) * 1000
+	case rateCode == 13:
+		v, _ := br.read(16)
+		rate = int(v)
+	case rateCode == 14:
+		v, _ := br.read(16)
+		rate = int(v) * 10
+	case rateCode == 15:
+		return nil, errors.New("Invalid FLAC sample rate")
+	}
+
+	depth := d.info.BitsPerSample
+	depths := []int{0, 8, 12, 0, 16, 20, 24, 32}
+	if sampleSizeCode != 0 {
+		if depth = depths[sampleSizeCode]; depth == 0 {
+			return nil, errors.New("Reserved FLAC sample size")
+		}
+	}
+
+	channels := int(assignment) + 1
+	if assignment >= 8 && assignment <= 10 {
+		channels = 2
+	} else if assignment > 10 {
+		return nil, errors.New("Reserved FLAC channel assignment")
+	}
+
+	headerCRC := crc8(frameBytes.Bytes())
+	if crc, err := br.read(8); err != nil {
+		return nil, err
+	} else if uint8(crc) != headerCRC {
+		return nil, fmt.Errorf("FLAC frame header CRC mismatch at sample %d", d.read)
+	}
+
+	decoded := make([][]int64, channels, channels)
+	for c := range decoded {
+		// Side channels have an extra bit.
+		bps := uint(depth)
+		if (assignment == 8 && c == 1) || (assignment == 9 && c == 0) || (assignment == 10 && c == 1) {
+			bps++
+		}
+		if decoded[c], err = readSubframe(br, blockSize, bps); err != nil {
+			return nil, err
+		}
+	}
+
+	br.align()
+	frameCRC := crc16(frameBytes.Bytes())
+	if crc, err := br.read(16); err != nil {
+		return nil, err
+	} else if uint16(crc) != frameCRC {
+		return nil, fmt.Errorf("FLAC frame CRC mismatch at sample %d", d.read)
+	}
+
+	switch assignment {
+	case 8: // Left, side.
+		for i, side := range decoded[1] {
+			decoded[1][i] = decoded[0][i] - side
+		}
+	case 9: // Side, right.
+		for i, side := range decoded[0] {
+			decoded[0][i] = decoded[1][i] + side
+		}
+	case 10: // Mid, side.
+		for i, side := range decoded[1] {
+			mid := decoded[0][i]<<1 | side&1
+			decoded[0][i], decoded[1][i] = (mid+side)>>1, (mid-side)>>1
+		}
+	}
+
+	frame := &Frame{channels, depth, rate, make([]int32, blockSize*channels, blockSize*channels)}
+	bytesPerSample := (depth + 7) / 8
+	b := make([]byte, 0, len(frame.Buffer)*bytesPerSample)
+	for i := 0; i < blockSize; i++ {
+		for c := 0; c < channels; c++ {
+			v := int32(decoded[c][i])
+			frame.Buffer[i*channels+c] = v
+			for j := 0; j < bytesPerSample; j++ {
+				b = append(b, byte(v>>uint(8*j)))
+			}
+		}
+	}
+	d.md5.Write(b)
+	d.read += int64(blockSize)
+	return frame, nil
+}
+
+// checkMD5 returns io.EOF if the samples read match the STREAMINFO's MD5, if it has one.
+func (d *Decoder) checkMD5() error {
+	if d.info.MD5 == [16]byte{} {
+		return io.EOF
+	}
+	var sum [16]byte
+	copy(sum[:], d.md5.Sum(nil))
+	if sum != d.info.MD5 {
+		return errors.New("FLAC samples don't match their MD5")
+	}
+	return io.EOF
+}
+
+// readSubframe decodes one channel of a frame.
+func readSubframe(br *bitReader, blockSize int, bps uint) ([]int64, error) {
+	if padding, err := br.read(1); err != nil {
+		return nil, err
+	} else if padding != 0 {
+		return nil, errors.New("Invalid FLAC subframe padding")
+	}
+	kind, _ := br.read(6)
+
+	// Wasted bits are zeros at the bottom of every sample, left out of the subframe.
+	wasted := uint(0)
+	if flag, _ := br.read(1); flag == 1 {
+		zeros, err := br.readUnary()
+		if err != nil {
+			return nil, err
+		}
+		wasted = uint(zeros) + 1
+		bps -= wasted
+	}
+
+	x := make([]int64, blockSize, blockSize)
+	var err error
+	switch {
+	case kind == 0:
+		v, err := br.readSigned(bps)
+		if err != nil {
+			return nil, err
+		}
+		for i := range x {
+			x[i] = v
+		}
+	case kind == 1:
+		for i := range x {
+			if x[i], err = br.readSigned(bps); err != nil {
+				return nil, err
+			}
+		}
+	case kind >= 8 && kind <= 12:
+		order := int(kind - 8)
+		if err := readWarmup(br, x, order, bps); err != nil {
+			return nil, err
+		}
+		if err := readResidual(br, x, order); err != nil {
+			return nil, err
+		}
+		restoreFixed(x, order)
+	case kind >= 32:
+		order := int(kind - 31)
+		if err := readWarmup(br, x, order, bps); err != nil {
+			return nil, err
+		}
+		precision, _ := br.read(4)
+		if precision == 15 {
+			return nil, errors.New("Invalid FLAC LPC precision")
+		}
+		shift, err := br.readSigned(5)
+		if err != nil {
+			return nil, err
+		}
+		if shift < 0 {
+			return nil, errors.New("Negative FLAC LPC shift")
+		}
+		coefficients := make([]int64, order, order)
+		for i := range coefficients {
+			if coefficients[i], err = br.readSigned(uint(precision) + 1); err != nil {
+				return nil, err
+			}
+		}
+		if err := readResidual(br, x, order); err != nil {
+			return nil, err
+		}
+		for i := order; i < len(x); i++ {
+			sum := int64(0)
+			for j, c := range coefficients {
+				sum += c * x[i-1-j]
+			}
+			x[i] += sum >> uint(shift)
+		}
+	default:
+		return nil, fmt.Errorf("Reserved FLAC subframe type %d", kind)
+	}
+
+	if wasted > 0 {
+		for i := range x {
+			x[i] <<= wasted
+		}
+	}
+	return x, nil
+}
+
+func readWarmup(br *bitReader, x []int64, order int, bps uint) error {
+	if order > len(x) {
+		return errors.New("FLAC predictor order longer than block")
+	}
+	var err error
+	for i := 0; i < order; i++ {
+		if x[i], err = br.readSigned(bps); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readResidual reads the residual into x after the warmup samples.
+func readResidual(br *bitReader, x []int64, order int) error {
+	method, err := br.read(2)
+	if err != nil {
+		return err
+	}
+	parameterBits := uint(4)
+	if method == 1 {
+		parameterBits = 5
+	} else if method > 1 {
+		return errors.New("Reserved FLAC residual coding method")
+	}
+	partitionOrder, _ := br.read(4)
+	partitions := 1 << partitionOrder
+	size := len(x) >> partitionOrder
+	if size<<partitionOrder != len(x) || size < order {
+		return errors.New("Invalid FLAC partition order")
+	}
+	escape := uint64(1)<<parameterBits - 1
+
+	i := order
+	for p := 0; p < partitions; p++ {
+		parameter, err := br.read(parameterBits)
+		if err != nil {
+			return err
+		}
+		end := (p + 1) * size
+		if parameter == escape {
+			bits, _ := br.read(5)
+			for ; i < end; i++ {
+				if bits == 0 {
+					x[i] = 0
+				} else if x[i], err = br.readSigned(uint(bits)); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		for ; i < end; i++ {
+			quotient, err := br.readUnary()
+			if err != nil {
+				return err
+			}
+			remainder, err := br.read(uint(parameter))
+			if err != nil {
+				return err
+			}
+			folded := quotient<<parameter | remainder
+			x[i] = int64(folded >> 1)
+			if folded&1 == 1 {
+				x[i] = -x[i] - 1
+			}
+		}
+	}
+	return nil
+}
+
+// restoreFixed adds the prediction of a fixed polynomial predictor to each residual.
+func restoreFixed(x []int64, order int) {
+	for i := order; i < len(x); i++ {
+		switch order {
+		case 1:
+			x[i] += x[i-1]
+		case 2:
+			x[i] += 2*x[i-1] - x[i-2]
+		case 3:
+			x[i] += 3*x[i-1] - 3*x[i-2] + x[i-3]
+		case 4:
+			x[i] += 4*x[i-1] - 6*x[i-2] + 4*x[i-3] - x[i-4]
+		}
+	}
+}
+
+func parseStreamInfo(b []byte) StreamInfo {
+	br := &bitReader{r: bytes.NewReader(b)}
+	field := func(bits uint) int64 {
+		v, _ := br.read(bits)
+		return int64(v)
+	}
+	info := StreamInfo{
+		int(field(16)),
+		int(field(16)),
+		int(field(24)),
+		int(field(24)),
+		int(field(20)),
+		int(field(3)) + 1,
+		int(field(5)) + 1,
+		field(36),
+		[16]byte{},
+	}
+	copy(info.MD5[:], b[18:34])
+	return info
+}
+
+func parseVorbisComment(b []byte, tags map[string]string) {
+	next := func() (string, bool) {
+		if len(b) < 4 {
+			return "", false
+		}
+		size := int(binary.LittleEndian.Uint32(b))
+		if size > len(b)-4 {
+			return "", false
+		}
+		s := string(b[4 : 4+size])
+		b = b[4+size:]
+		return s, true
+	}
+	if _, ok := next(); !ok { // Vendor.
+		return
+	}
+	if len(b) < 4 {
+		return
+	}
+	count := int(binary.LittleEndian.Uint32(b))
+	b = b[4:]
+	for i := 0; i < count; i++ {
+		comment, ok := next()
+		if !ok {
+			return
+		}
+		if eq := strings.IndexByte(comment, '='); eq > 0 {
+			tags[strings.ToUpper(comment[:eq])] = comment[eq+1:]
+		}
+	}
+}
+
+// readCodedNumber reads a number with UTF-8's variable length coding, extended to 36 bits.
+func readCodedNumber(br *bitReader) (uint64, error) {
+	first, err := br.read(8)
+	if err != nil {
+		return 0, err
+	}
+	extra := 0
+	for mask := uint64(0x80); first&mask != 0 && mask > 1; mask >>= 1 {
+		extra++
+	}
+	if extra == 1 || extra > 7 {
+		return 0, errors.New("Invalid FLAC frame number")
+	}
+	if extra > 0 {
+		extra--
+	}
+	n := first & (0x7F >> uint(extra))
+	for i := 0; i < extra; i++ {
+		b, err := br.read(8)
+		if err != nil {
+			return 0, err
+		}
+		if b&0xC0 != 0x80 {
+			return 0, errors.New("Invalid FLAC frame number")
+		}
+		n = n<<6 | b&0x3F
+	}
+	return n, nil
+}
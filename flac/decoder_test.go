@@ -0,0 +1,254 @@
+package flac
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Decoding what the encoder wrote should give back exactly the same samples.
+func TestRoundTrip(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for _, bits := range []int{4, 8, 16, 24} {
+		for level := 0; level <= 8; level++ {
+			for _, length := range []int{0, 1, 4097, 10000} {
+				for _, channels := range []int{1, 2} {
+					samples := testSignal(random, length, channels, bits)
+					stream := encodeSamples(t, samples, channels, bits, level, nil)
+					read := decodeSamples(t, stream)
+					if !equalSamples(read, samples) {
+						t.Errorf("%d bits, level %d, %d samples of %d channels didn't round trip",
+							bits, level, length, channels)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestDecodeTags(t *testing.T) {
+	tags := map[string]string{"TITLE": "Tagged", "artist": "go-sound"}
+	stream := encodeSamples(t, []int32{1, 2, 3}, 1, 16, DefaultCompressionLevel, tags)
+	decoder, err := NewDecoder(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatalf("Can't decode: %v", err)
+	}
+	read := decoder.Tags()
+	if len(read) != 2 || read["TITLE"] != "Tagged" || read["ARTIST"] != "go-sound" {
+		t.Errorf("Read tags %v", read)
+	}
+	if info := decoder.Info(); info.TotalSamples != 3 || info.BitsPerSample != 16 || info.Channels != 1 {
+		t.Errorf("Read STREAMINFO %+v", info)
+	}
+}
+
+func TestDecodeCorruption(t *testing.T) {
+	samples := testSignal(rand.New(rand.NewSource(2)), 10000, 1, 16)
+	stream := encodeSamples(t, samples, 1, 16, DefaultCompressionLevel, nil)
+
+	// A flipped bit in the last frame's residual should fail its CRC.
+	corrupt := append([]byte{}, stream...)
+	corrupt[len(corrupt)-10] ^= 0x10
+	if _, err := readAll(corrupt); err == nil {
+		t.Errorf("Expected corrupt frame to fail")
+	}
+
+	// Changing the MD5 in the STREAMINFO should fail at the end of the stream.
+	corrupt = append([]byte{}, stream...)
+	corrupt[4+4+streamInfoSize-1] ^= 0x01
+	if _, err := readAll(corrupt); err == nil {
+		t.Errorf("Expected MD5 mismatch to fail")
+	}
+}
+
+// Files from testdata, see testdata/README. Decoding checks their MD5s, and the samples are
+// also compared with the matching .raw file, of little endian int32s, if there is one.
+func TestDecodeReferenceFiles(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "*.flac"))
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("No reference files in testdata, error %v", err)
+	}
+	for _, path := range paths {
+		stream, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Can't read %s: %v", path, err)
+		}
+		decoder, err := NewDecoder(bytes.NewReader(stream))
+		if err != nil {
+			t.Fatalf("Can't decode %s: %v", path, err)
+		}
+		info := decoder.Info()
+		if info.TotalSamples%int64(info.MaxBlockSize) == 0 {
+			t.Errorf("%s has no short last frame, see testdata/README", path)
+		}
+
+		read, err := readAll(stream)
+		if err != nil {
+			t.Errorf("Can't decode %s: %v", path, err)
+			continue
+		}
+		if int64(len(read)) != info.TotalSamples*int64(info.Channels) {
+			t.Errorf("%s has %d samples, expected %d of %d channels", path, len(read), info.TotalSamples, info.Channels)
+		}
+
+		raw, err := ioutil.ReadFile(strings.TrimSuffix(path, ".flac") + ".raw")
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			t.Fatalf("Can't read samples for %s: %v", path, err)
+		}
+		expected := make([]int32, len(raw)/4, len(raw)/4)
+		binary.Read(bytes.NewReader(raw), binary.LittleEndian, expected)
+		if !equalSamples(read, expected) {
+			t.Errorf("%s decoded to different samples than its .raw file", path)
+		}
+	}
+}
+
+// A frame unlike those the encoder writes: mid/side stereo, with wasted bits in the side channel.
+func TestDecodeMidSide(t *testing.T) {
+	left := []int64{100, -50, 7, 0}
+	right := []int64{40, -60, 3, -8}
+	mid, side := make([]int64, 4, 4), make([]int64, 4, 4)
+	for i := range left {
+		mid[i], side[i] = (left[i]+right[i])>>1, left[i]-right[i]
+	}
+
+	w := &bitWriter{}
+	w.write(0xFFF8, 16)
+	w.write(6, 4)  // 8 bit block size follows.
+	w.write(9, 4)  // 44.1kHz.
+	w.write(10, 4) // Mid/side.
+	w.write(4, 3)  // 16 bits.
+	w.write(0, 1)
+	writeCodedNumber(w, 0)
+	w.write(uint64(len(left)-1), 8)
+	w.write(uint64(crc8(w.bytes)), 8)
+
+	// Verbatim mid channel.
+	w.write(1<<1, 8)
+	for _, v := range mid {
+		w.writeSigned(v, 16)
+	}
+	// Verbatim side channel, of 17 bits, with its lowest always zero.
+	w.write(1<<1|1, 8)
+	w.writeUnary(0)
+	for _, v := range side {
+		w.writeSigned(v>>1, 16)
+	}
+	w.align()
+	w.write(uint64(crc16(w.bytes)), 16)
+
+	info := StreamInfo{len(left), len(left), 0, 0, 44100, 2, 16, int64(len(left)), [16]byte{}}
+	stream := append([]byte("fLaC"), metadataHeader(blockStreamInfo, streamInfoSize, true)...)
+	stream = append(append(stream, info.bytes()...), w.bytes...)
+
+	read, err := readAll(stream)
+	if err != nil {
+		t.Fatalf("Can't decode: %v", err)
+	}
+	for i := range left {
+		if int64(read[2*i]) != left[i] || int64(read[2*i+1]) != right[i] {
+			t.Errorf("Sample %d is %d, %d, expected %d, %d", i, read[2*i], read[2*i+1], left[i], right[i])
+		}
+	}
+}
+
+// testSignal returns interleaved samples of a sine per channel plus noise, at full scale.
+func testSignal(random *rand.Rand, length int, channels int, bits int) []int32 {
+	max := float64(int64(1)<<uint(bits-1) - 1)
+	samples := make([]int32, length*channels, length*channels)
+	for i := range samples {
+		v := 0.8*math.Sin(float64(i/channels)*0.01*float64(i%channels+1)) + 0.1*(random.Float64()-0.5)
+		samples[i] = int32(math.Floor(v*max + 0.5))
+	}
+	return samples
+}
+
+func encodeSamples(t *testing.T, samples []int32, channels int, bits int, level int, tags map[string]string) []byte {
+	out := &seekBuffer{}
+	encoder, err := NewEncoder(out, channels, bits, 44100)
+	if err != nil {
+		t.Fatalf("Can't create encoder: %v", err)
+	}
+	encoder.CompressionLevel, encoder.Tags = level, tags
+	if err := encoder.Write(samples); err != nil {
+		t.Fatalf("Can't encode: %v", err)
+	}
+	if err := encoder.Close(); err != nil {
+		t.Fatalf("Can't close encoder: %v", err)
+	}
+	return out.bytes
+}
+
+func decodeSamples(t *testing.T, stream []byte) []int32 {
+	read, err := readAll(stream)
+	if err != nil {
+		t.Fatalf("Can't decode: %v", err)
+	}
+	return read
+}
+
+// readAll decodes every sample in a stream, interleaved.
+func readAll(stream []byte) ([]int32, error) {
+	decoder, err := NewDecoder(bytes.NewReader(stream))
+	if err != nil {
+		return nil, err
+	}
+	read := []int32{}
+	for {
+		frame, err := decoder.ReadFrame()
+		if err == io.EOF {
+			return read, nil
+		}
+		if err != nil {
+			return read, err
+		}
+		read = append(read, frame.Buffer...)
+	}
+}
+
+func equalSamples(a []int32, b []int32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// seekBuffer is an in memory io.WriteSeeker.
+type seekBuffer struct {
+	bytes []byte
+	at    int
+}
+
+func (b *seekBuffer) Write(p []byte) (int, error) {
+	if end := b.at + len(p); end > len(b.bytes) {
+		b.bytes = append(b.bytes, make([]byte, end-len(b.bytes))...)
+	}
+	b.at += copy(b.bytes[b.at:], p)
+	return len(p), nil
+}
+
+func (b *seekBuffer) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		b.at = int(offset)
+	case io.SeekCurrent:
+		b.at += int(offset)
+	case io.SeekEnd:
+		b.at = len(b.bytes) + int(offset)
+	}
+	return int64(b.at), nil
+}
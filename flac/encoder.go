@@ -0,0 +1,479 @@
+package flac
+
+import (
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+)
+
+// DefaultCompressionLevel is the compression level of new encoders, the same as the flac tool's.
+const DefaultCompressionLevel = 5
+
+// compressionLevel is how hard an encoder searches for the smallest encoding of each block,
+// at each of the flac tool's levels, -0 to -8.
+type compressionLevel struct {
+	blockSize         int
+	maxFixedOrder     int
+	maxLPCOrder       int
+	maxPartitionOrder int
+
+	// exhaustive tries every LPC order, rather than only the one expected to be best.
+	exhaustive bool
+}
+
+var compressionLevels = []compressionLevel{
+	{1152, 2, 0, 3, false},
+	{1152, 4, 0, 3, false},
+	{1152, 4, 0, 4, false},
+	{4096, 4, 6, 4, false},
+	{4096, 4, 8, 4, false},
+	{4096, 4, 8, 5, false},
+	{4096, 4, 8, 6, false},
+	{4096, 4, 8, 6, true},
+	{4096, 4, 12, 6, true},
+}
+
+// Encoder writes samples to a FLAC stream. Each channel is compressed independently, using
+// whichever of FLAC's fixed and linear predictors makes each block smallest.
+type Encoder struct {
+	// CompressionLevel is from 0, fastest, to 8, smallest. Change before writing any samples.
+	CompressionLevel int
+
+	// Tags are written as Vorbis comments, e.g. "TITLE" and "ARTIST". Change before writing any samples.
+	Tags map[string]string
+
+	out     io.WriteSeeker
+	info    StreamInfo
+	level   compressionLevel
+	md5     hash.Hash
+	started bool
+	closed  bool
+
+	// Position of the start of the stream in out.
+	start int64
+
+	// Interleaved samples not yet written in a frame.
+	pending []int32
+	frames  uint64
+}
+
+// NewEncoder creates an encoder writing to out, which must be seekable so that the STREAMINFO
+// can be completed once all the samples are known.
+//
+// For example, to write a second of silence:
+//  encoder, err := flac.NewEncoder(file, 1, 16, 44100)
+//  encoder.Write(make([]int32, 44100))
+//  encoder.Close()
+func NewEncoder(out io.WriteSeeker, channels int, bitsPerSample int, sampleRate int) (*Encoder, error) {
+	switch {
+	case channels < 1 || channels > 8:
+		return nil, fmt.Errorf("FLAC supports 1 to 8 channels, not %d", channels)
+	case bitsPerSample < 4 || bitsPerSample > 24:
+		return nil, fmt.Errorf("Unsupported FLAC bit depth %d, must be 4 to 24", bitsPerSample)
+	case sampleRate < 1 || sampleRate >= 1<<20:
+		return nil, fmt.Errorf("Unsupported FLAC sample rate %d", sampleRate)
+	}
+	return &Encoder{
+		DefaultCompressionLevel,
+		nil, /* Tags */
+		out,
+		StreamInfo{
+			0, 0, /* block sizes */
+			0, 0, /* frame sizes */
+			sampleRate,
+			channels,
+			bitsPerSample,
+			0, /* TotalSamples */
+			[16]byte{},
+		},
+		compressionLevel{},
+		md5.New(),
+		false, /* started */
+		false, /* closed */
+		0,     /* start */
+		nil,   /* pending */
+		0,     /* frames */
+	}, nil
+}
+
+// Info returns the stream's STREAMINFO, which is only complete once the encoder is closed.
+func (e *Encoder) Info() StreamInfo {
+	return e.info
+}
+
+// Write encodes interleaved samples, which must fit within the encoder's bit depth.
+// They're buffered until there are enough for a whole frame.
+func (e *Encoder) Write(samples []int32) error {
+	if e.closed {
+		return errors.New("Can't write to a closed FLAC encoder")
+	}
+	if len(samples)%e.info.Channels != 0 {
+		return fmt.Errorf("Can't write %d samples, not a whole number of frames of %d channels", len(samples), e.info.Channels)
+	}
+	if err := e.writeMetadata(); err != nil {
+		return err
+	}
+
+	bits := uint(e.info.BitsPerSample)
+	bytesPerSample := int(bits+7) / 8
+	limit := int32(1) << (bits - 1)
+	b := make([]byte, 0, len(samples)*bytesPerSample)
+	for _, v := range samples {
+		if v < -limit || v >= limit {
+			return fmt.Errorf("Sample %d doesn't fit in %d bits", v, bits)
+		}
+		for i := 0; i < bytesPerSample; i++ {
+			b = append(b, byte(v>>uint(8*i)))
+		}
+	}
+	e.md5.Write(b)
+
+	e.pending = append(e.pending, samples...)
+	size := e.level.blockSize * e.info.Channels
+	written := 0
+	for ; len(e.pending)-written >= size; written += size {
+		if err := e.writeFrame(e.pending[written : written+size]); err != nil {
+			return err
+		}
+	}
+	e.pending = append(e.pending[:0:0], e.pending[written:]...)
+	return nil
+}
+
+// Close writes the final, possibly shorter, frame and completes the STREAMINFO.
+// It doesn't close the underlying writer.
+func (e *Encoder) Close() error {
+	if e.closed {
+		return nil
+	}
+	if err := e.writeMetadata(); err != nil {
+		return err
+	}
+	e.closed = true
+	if len(e.pending) > 0 {
+		if err := e.writeFrame(e.pending); err != nil {
+			return err
+		}
+		e.pending = nil
+	}
+	copy(e.info.MD5[:], e.md5.Sum(nil))
+
+	end, err := e.out.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := e.out.Seek(e.start+8, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := e.out.Write(e.info.bytes()); err != nil {
+		return err
+	}
+	_, err = e.out.Seek(end, io.SeekStart)
+	return err
+}
+
+// writeMetadata starts the stream, if it hasn't been already, with a STREAMINFO to be
+// completed when closing, followed by the Vorbis comments.
+func (e *Encoder) writeMetadata() error {
+	if e.started {
+		return nil
+	}
+	if e.CompressionLevel < 0 || e.CompressionLevel >= len(compressionLevels) {
+		return fmt.Errorf("FLAC compression level must be 0 to %d, not %d", len(compressionLevels)-1, e.CompressionLevel)
+	}
+	e.started = true
+	e.level = compressionLevels[e.CompressionLevel]
+	e.info.MinBlockSize, e.info.MaxBlockSize = e.level.blockSize, e.level.blockSize
+
+	start, err := e.out.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	e.start = start
+
+	comments := vorbisComment(e.Tags)
+	b := []byte("fLaC")
+	b = append(b, metadataHeader(blockStreamInfo, streamInfoSize, false)...)
+	b = append(b, e.info.bytes()...)
+	b = append(b, metadataHeader(blockVorbisComment, len(comments), true)...)
+	b = append(b, comments...)
+	_, err = e.out.Write(b)
+	return err
+}
+
+// writeFrame encodes a block of interleaved samples.
+func (e *Encoder) writeFrame(samples []int32) error {
+	size := len(samples) / e.info.Channels
+	w := &bitWriter{}
+
+	// Sync code, for a stream with a fixed block size.
+	w.write(0xFFF8, 16)
+	sizeCode, sizeBits := blockSizeCode(size)
+	rateCode, rateBits := sampleRateCode(e.info.SampleRate)
+	w.write(sizeCode, 4)
+	w.write(rateCode, 4)
+	w.write(uint64(e.info.Channels-1), 4) // Independent channels.
+	w.write(sampleSizeCode(e.info.BitsPerSample), 3)
+	w.write(0, 1)
+	writeCodedNumber(w, e.frames)
+	if sizeBits > 0 {
+		w.write(uint64(size-1), sizeBits)
+	}
+	switch rateCode {
+	case 12:
+		w.write(uint64(e.info.SampleRate/1000), rateBits)
+	case 13:
+		w.write(uint64(e.info.SampleRate), rateBits)
+	case 14:
+		w.write(uint64(e.info.SampleRate/10), rateBits)
+	}
+	w.write(uint64(crc8(w.bytes)), 8)
+
+	channel := make([]int32, size, size)
+	for c := 0; c < e.info.Channels; c++ {
+		for i := range channel {
+			channel[i] = samples[i*e.info.Channels+c]
+		}
+		e.writeSubframe(w, channel)
+	}
+	w.align()
+	w.write(uint64(crc16(w.bytes)), 16)
+
+	if _, err := e.out.Write(w.bytes); err != nil {
+		return err
+	}
+	if e.info.MinFrameSize == 0 || len(w.bytes) < e.info.MinFrameSize {
+		e.info.MinFrameSize = len(w.bytes)
+	}
+	if len(w.bytes) > e.info.MaxFrameSize {
+		e.info.MaxFrameSize = len(w.bytes)
+	}
+	e.info.TotalSamples += int64(size)
+	e.frames++
+	return nil
+}
+
+// subframe is one way of encoding a channel's block.
+type subframe struct {
+	// Subframe type, 1 for verbatim, 8 + order for fixed, or 31 + order for LPC.
+	kind  uint64
+	order int
+
+	coefficients []int32
+	precision    uint
+	shift        uint
+
+	residual []int32
+	plan     residualPlan
+	bits     int
+}
+
+// writeSubframe encodes one channel of a block, in whichever way takes fewest bits.
+func (e *Encoder) writeSubframe(w *bitWriter, x []int32) {
+	bps := uint(e.info.BitsPerSample)
+
+	constant := true
+	for _, v := range x {
+		constant = constant && v == x[0]
+	}
+	if constant {
+		w.write(0, 8)
+		w.writeSigned(int64(x[0]), bps)
+		return
+	}
+
+	n := len(x)
+	best := subframe{1, 0, nil, 0, 0, nil, residualPlan{}, n * int(bps)}
+	consider := func(candidate subframe) {
+		if candidate.bits < best.bits {
+			best = candidate
+		}
+	}
+
+	for order := 0; order <= e.level.maxFixedOrder && order < n; order++ {
+		if residual, ok := fixedResidual(x, order); ok {
+			plan := planResidual(residual, n, order, e.level.maxPartitionOrder)
+			consider(subframe{8 + uint64(order), order, nil, 0, 0, residual, plan, order*int(bps) + plan.bits})
+		}
+	}
+
+	if maxOrder := e.level.maxLPCOrder; maxOrder > 0 && n > 2*maxOrder {
+		coefficients, errors := lpcCoefficients(x, maxOrder)
+		precision := lpcPrecision(e.level.blockSize)
+		orders := []int{}
+		if e.level.exhaustive {
+			for order := 1; order < len(coefficients); order++ {
+				orders = append(orders, order)
+			}
+		} else if len(coefficients) > 1 {
+			// Estimate the bits each order takes from its expected error, for Laplacian residuals.
+			bestOrder, fewest := 1, math.Inf(1)
+			for order := 1; order < len(coefficients); order++ {
+				estimate := 0.5*float64(n)*math.Log2(errors[order]/float64(n)) + float64(order*int(precision+bps))
+				if estimate < fewest {
+					bestOrder, fewest = order, estimate
+				}
+			}
+			orders = append(orders, bestOrder)
+		}
+
+		for _, order := range orders {
+			quantized, shift, ok := quantizeCoefficients(coefficients[order], precision)
+			if !ok {
+				continue
+			}
+			if residual, ok := lpcResidual(x, quantized, shift); ok {
+				plan := planResidual(residual, n, order, e.level.maxPartitionOrder)
+				bits := order*int(bps) + 4 + 5 + order*int(precision) + plan.bits
+				consider(subframe{31 + uint64(order), order, quantized, precision, shift, residual, plan, bits})
+			}
+		}
+	}
+
+	// Zero padding bit, type, and no wasted bits.
+	w.write(0, 1)
+	w.write(best.kind, 6)
+	w.write(0, 1)
+	if best.kind == 1 {
+		for _, v := range x {
+			w.writeSigned(int64(v), bps)
+		}
+		return
+	}
+	for _, v := range x[:best.order] {
+		w.writeSigned(int64(v), bps)
+	}
+	if best.coefficients != nil {
+		w.write(uint64(best.precision-1), 4)
+		w.writeSigned(int64(best.shift), 5)
+		for _, c := range best.coefficients {
+			w.writeSigned(int64(c), best.precision)
+		}
+	}
+	best.plan.write(w, best.residual)
+}
+
+// residualPlan is how a residual is split into partitions, and how each is coded.
+type residualPlan struct {
+	order          int
+	partitionOrder uint
+	rice2          bool
+
+	// Rice parameter of each partition, or the escape code for those written raw with rawBits each.
+	parameters []uint
+	rawBits    []uint
+
+	// Number of bits needed for the whole residual, approximate for rice coded partitions.
+	bits int
+}
+
+// planResidual finds the partitioning and rice parameters that code a residual in fewest bits.
+func planResidual(residual []int32, blockSize int, order int, maxPartitionOrder int) residualPlan {
+	var best residualPlan
+	for p := uint(0); p <= uint(maxPartitionOrder); p++ {
+		size := blockSize >> p
+		if blockSize%(1<<p) != 0 || size <= order {
+			break
+		}
+		plan := residualPlan{order, p, false, nil, nil, 2 + 4}
+		partitions := 1 << p
+		sums := make([]uint64, partitions, partitions)
+		counts := make([]int, partitions, partitions)
+		widths := make([]uint, partitions, partitions)
+		for i, r := range residual {
+			j := (i + order) / size
+			sums[j] += fold(r)
+			counts[j]++
+			widths[j] = maxUint(widths[j], signedWidth(r))
+		}
+
+		ricePlan := func(rice2 bool) residualPlan {
+			plan := plan
+			plan.rice2 = rice2
+			maxParameter, parameterBits := uint(14), 4
+			if rice2 {
+				maxParameter, parameterBits = 30, 5
+			}
+			for j := range sums {
+				k := uint(0)
+				for k < maxParameter && uint64(counts[j])<<(k+1) < sums[j] {
+					k++
+				}
+				riceBits := counts[j]*int(k+1) + int(sums[j]>>k)
+				rawBits := 5 + counts[j]*int(widths[j])
+				if widths[j] < 32 && rawBits < riceBits {
+					plan.parameters = append(plan.parameters, maxParameter+1)
+					plan.rawBits = append(plan.rawBits, widths[j])
+					plan.bits += parameterBits + rawBits
+				} else {
+					plan.parameters = append(plan.parameters, k)
+					plan.rawBits = append(plan.rawBits, 0)
+					plan.bits += parameterBits + riceBits
+				}
+			}
+			return plan
+		}
+
+		for _, candidate := range []residualPlan{ricePlan(false), ricePlan(true)} {
+			if best.parameters == nil || candidate.bits < best.bits {
+				best = candidate
+			}
+		}
+	}
+	return best
+}
+
+// write encodes the residual following the plan.
+func (plan residualPlan) write(w *bitWriter, residual []int32) {
+	parameterBits := uint(4)
+	if plan.rice2 {
+		w.write(1, 2)
+		parameterBits = 5
+	} else {
+		w.write(0, 2)
+	}
+	w.write(uint64(plan.partitionOrder), 4)
+
+	escape := uint(1)<<parameterBits - 1
+	size := (len(residual) + plan.order) >> plan.partitionOrder
+	start := 0
+	for j, parameter := range plan.parameters {
+		// The first partition is shorter by the predictor's warmup samples.
+		end := (j+1)*size - plan.order
+		w.write(uint64(parameter), parameterBits)
+		if parameter == escape {
+			w.write(uint64(plan.rawBits[j]), 5)
+			for _, r := range residual[start:end] {
+				w.writeSigned(int64(r), plan.rawBits[j])
+			}
+		} else {
+			for _, r := range residual[start:end] {
+				w.writeRice(r, parameter)
+			}
+		}
+		start = end
+	}
+}
+
+// signedWidth returns the number of bits needed to store a value in two's complement.
+func signedWidth(v int32) uint {
+	if v < 0 {
+		v = ^v
+	}
+	width := uint(1)
+	for v != 0 {
+		v >>= 1
+		width++
+	}
+	return width
+}
+
+func maxUint(a uint, b uint) uint {
+	if a > b {
+		return a
+	}
+	return b
+}
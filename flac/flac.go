@@ -0,0 +1,160 @@
+// Package flac reads and writes FLAC (Free Lossless Audio Codec) files in pure Go, without libFLAC.
+package flac
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+const (
+	// Metadata block types.
+	blockStreamInfo    = 0
+	blockVorbisComment = 4
+
+	streamInfoSize = 34
+
+	// Vendor string written in Vorbis comments.
+	vendor = "go-sound"
+)
+
+// StreamInfo is the STREAMINFO metadata that starts every FLAC file.
+type StreamInfo struct {
+	// MinBlockSize and MaxBlockSize are the range of samples per channel in each frame,
+	// excluding the last, which may be shorter.
+	MinBlockSize int
+	MaxBlockSize int
+
+	// MinFrameSize and MaxFrameSize are the range of frame sizes in bytes, 0 if unknown.
+	MinFrameSize int
+	MaxFrameSize int
+
+	SampleRate    int
+	Channels      int
+	BitsPerSample int
+
+	// TotalSamples is the number of samples in each channel, 0 if unknown.
+	TotalSamples int64
+
+	// MD5 of the samples as little endian, interleaved, signed integers of whole bytes.
+	MD5 [16]byte
+}
+
+// bytes returns the STREAMINFO block's contents.
+func (si StreamInfo) bytes() []byte {
+	w := bitWriter{}
+	w.write(uint64(si.MinBlockSize), 16)
+	w.write(uint64(si.MaxBlockSize), 16)
+	w.write(uint64(si.MinFrameSize), 24)
+	w.write(uint64(si.MaxFrameSize), 24)
+	w.write(uint64(si.SampleRate), 20)
+	w.write(uint64(si.Channels-1), 3)
+	w.write(uint64(si.BitsPerSample-1), 5)
+	w.write(uint64(si.TotalSamples>>32), 4)
+	w.write(uint64(si.TotalSamples), 32)
+	return append(w.bytes, si.MD5[:]...)
+}
+
+// vorbisComment returns a VORBIS_COMMENT block's contents, with the tags in order of name.
+func vorbisComment(tags map[string]string) []byte {
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Unlike the rest of FLAC, lengths here are little endian.
+	b := make([]byte, 4, 4)
+	binary.LittleEndian.PutUint32(b, uint32(len(vendor)))
+	b = append(b, vendor...)
+	b = appendUint32LE(b, uint32(len(names)))
+	for _, name := range names {
+		comment := name + "=" + tags[name]
+		b = appendUint32LE(b, uint32(len(comment)))
+		b = append(b, comment...)
+	}
+	return b
+}
+
+func appendUint32LE(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+// metadataHeader returns the header preceding a metadata block.
+func metadataHeader(blockType int, size int, last bool) []byte {
+	flag := byte(0)
+	if last {
+		flag = 0x80
+	}
+	return []byte{flag | byte(blockType), byte(size >> 16), byte(size >> 8), byte(size)}
+}
+
+// blockSizeCode returns the frame header code for a block size, and the number of bits of
+// block size that follow the header when the code doesn't imply it.
+func blockSizeCode(size int) (uint64, uint) {
+	switch size {
+	case 192:
+		return 1, 0
+	case 576, 1152, 2304, 4608:
+		return 2 + uint64(log2(size/576)), 0
+	case 256, 512, 1024, 2048, 4096, 8192, 16384, 32768:
+		return 8 + uint64(log2(size/256)), 0
+	}
+	if size <= 256 {
+		return 6, 8
+	}
+	return 7, 16
+}
+
+// sampleRateCode returns the frame header code for a sample rate, and the number of bits of
+// rate that follow the header. Rates without a code are read from the STREAMINFO.
+func sampleRateCode(rate int) (uint64, uint) {
+	codes := map[int]uint64{
+		88200: 1, 176400: 2, 192000: 3, 8000: 4, 16000: 5, 22050: 6,
+		24000: 7, 32000: 8, 44100: 9, 48000: 10, 96000: 11,
+	}
+	if code, ok := codes[rate]; ok {
+		return code, 0
+	}
+	switch {
+	case rate%1000 == 0 && rate/1000 < 256:
+		return 12, 8
+	case rate < 65536:
+		return 13, 16
+	case rate%10 == 0 && rate/10 < 65536:
+		return 14, 16
+	}
+	return 0, 0
+}
+
+// sampleSizeCode returns the frame header code for a bit depth, 0 to read it from the STREAMINFO.
+func sampleSizeCode(bits int) uint64 {
+	codes := map[int]uint64{8: 1, 12: 2, 16: 4, 20: 5, 24: 6, 32: 7}
+	return codes[bits]
+}
+
+// writeCodedNumber appends a number with the variable length coding of UTF-8, extended to 36 bits.
+func writeCodedNumber(w *bitWriter, n uint64) {
+	if n < 0x80 {
+		w.write(n, 8)
+		return
+	}
+	// Count the continuation bytes of 6 bits each needed after the first.
+	extra := uint(1)
+	for n >= 1<<(6*extra+6-extra) && extra < 6 {
+		extra++
+	}
+	lead := uint64(0xFF) << (7 - extra) & 0xFF
+	w.write(lead|n>>(6*extra), 8)
+	for i := int(extra) - 1; i >= 0; i-- {
+		w.write(0x80|(n>>(6*uint(i)))&0x3F, 8)
+	}
+}
+
+func log2(n int) int {
+	result := 0
+	for n > 1 {
+		n >>= 1
+		result++
+	}
+	return result
+}
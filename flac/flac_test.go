@@ -0,0 +1,52 @@
+package flac
+
+// go test github.com/padster/go-sound/flac
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Standard check values, of the CRCs of "123456789".
+func TestCRCs(t *testing.T) {
+	check := []byte("123456789")
+	if crc := crc8(check); crc != 0xF4 {
+		t.Errorf("CRC-8 is %#x, expected 0xf4", crc)
+	}
+	if crc := crc16(check); crc != 0xFEE8 {
+		t.Errorf("CRC-16 is %#x, expected 0xfee8", crc)
+	}
+}
+
+func TestCodedNumbers(t *testing.T) {
+	tests := []struct {
+		n     uint64
+		coded []byte
+	}{
+		{0x00, []byte{0x00}},
+		{0x7F, []byte{0x7F}},
+		{0x80, []byte{0xC2, 0x80}},
+		{0x7FF, []byte{0xDF, 0xBF}},
+		{0x800, []byte{0xE0, 0xA0, 0x80}},
+		{0x10000, []byte{0xF0, 0x90, 0x80, 0x80}},
+		{1<<36 - 1, []byte{0xFE, 0xBF, 0xBF, 0xBF, 0xBF, 0xBF, 0xBF}},
+	}
+	for _, test := range tests {
+		w := &bitWriter{}
+		writeCodedNumber(w, test.n)
+		if !bytes.Equal(w.bytes, test.coded) {
+			t.Errorf("%#x coded as % x, expected % x", test.n, w.bytes, test.coded)
+		}
+	}
+}
+
+func TestRice(t *testing.T) {
+	w := &bitWriter{}
+	// 3 folds to 6, quotient 1 and remainder 2. -2 folds to 3, quotient 0 and remainder 3.
+	w.writeRice(3, 2)
+	w.writeRice(-2, 2)
+	w.align()
+	if !bytes.Equal(w.bytes, []byte{0x6E}) { // 01 10, 1 11, then padding.
+		t.Errorf("Rice coded as % x, expected 6e", w.bytes)
+	}
+}
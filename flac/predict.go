@@ -0,0 +1,168 @@
+package flac
+
+import (
+	"math"
+)
+
+const (
+	maxFixedOrder = 4
+
+	// Largest shift and precision of quantized LPC coefficients.
+	maxLPCShift     = 15
+	maxLPCPrecision = 15
+)
+
+// fixedResidual returns the residual of one of FLAC's fixed polynomial predictors, or false
+// if it doesn't fit in 32 bits. The first order samples are warmup, and left out.
+func fixedResidual(x []int32, order int) ([]int32, bool) {
+	residual := make([]int32, 0, len(x)-order)
+	for i := order; i < len(x); i++ {
+		var predicted int64
+		switch order {
+		case 1:
+			predicted = int64(x[i-1])
+		case 2:
+			predicted = 2*int64(x[i-1]) - int64(x[i-2])
+		case 3:
+			predicted = 3*int64(x[i-1]) - 3*int64(x[i-2]) + int64(x[i-3])
+		case 4:
+			predicted = 4*int64(x[i-1]) - 6*int64(x[i-2]) + 4*int64(x[i-3]) - int64(x[i-4])
+		}
+		r := int64(x[i]) - predicted
+		if r < math.MinInt32 || r > math.MaxInt32 {
+			return nil, false
+		}
+		residual = append(residual, int32(r))
+	}
+	return residual, true
+}
+
+// lpcResidual returns the residual of a quantized linear predictor, whose first coefficient
+// applies to the most recent sample, or false if it doesn't fit in 32 bits.
+func lpcResidual(x []int32, coefficients []int32, shift uint) ([]int32, bool) {
+	order := len(coefficients)
+	residual := make([]int32, 0, len(x)-order)
+	for i := order; i < len(x); i++ {
+		sum := int64(0)
+		for j, c := range coefficients {
+			sum += int64(c) * int64(x[i-1-j])
+		}
+		r := int64(x[i]) - sum>>shift
+		if r < math.MinInt32 || r > math.MaxInt32 {
+			return nil, false
+		}
+		residual = append(residual, int32(r))
+	}
+	return residual, true
+}
+
+// lpcCoefficients returns linear predictors for every order up to maxOrder, from the
+// Tukey windowed samples, along with each one's expected error. Nil if the samples are silent.
+func lpcCoefficients(x []int32, maxOrder int) ([][]float64, []float64) {
+	n := len(x)
+	windowed := make([]float64, n, n)
+	taper := n / 4 // Tukey window with half of the samples tapered, as the flac tool uses.
+	for i, v := range x {
+		w := 1.0
+		if i < taper {
+			w = 0.5 - 0.5*math.Cos(math.Pi*float64(i)/float64(taper))
+		} else if i >= n-taper {
+			w = 0.5 - 0.5*math.Cos(math.Pi*float64(n-1-i)/float64(taper))
+		}
+		windowed[i] = float64(v) * w
+	}
+
+	autocorrelation := make([]float64, maxOrder+1, maxOrder+1)
+	for lag := range autocorrelation {
+		for i := lag; i < n; i++ {
+			autocorrelation[lag] += windowed[i] * windowed[i-lag]
+		}
+	}
+	if autocorrelation[0] == 0 {
+		return nil, nil
+	}
+
+	// Levinson-Durbin recursion.
+	coefficients := make([][]float64, maxOrder+1, maxOrder+1)
+	errors := make([]float64, maxOrder+1, maxOrder+1)
+	a := []float64{}
+	e := autocorrelation[0]
+	for order := 1; order <= maxOrder; order++ {
+		k := autocorrelation[order]
+		for j, c := range a {
+			k -= c * autocorrelation[order-1-j]
+		}
+		k /= e
+
+		next := make([]float64, order, order)
+		for j := range a {
+			next[j] = a[j] - k*a[order-2-j]
+		}
+		next[order-1] = k
+		a = next
+		e = math.Max(0, e*(1.0-k*k))
+		coefficients[order], errors[order] = a, e
+		if e == 0 {
+			// Perfectly predictable, higher orders can't do better.
+			return coefficients[:order+1], errors[:order+1]
+		}
+	}
+	return coefficients, errors
+}
+
+// quantizeCoefficients converts predictor coefficients to integers of the given precision,
+// scaled up by the returned shift, or false if they're too large to represent.
+func quantizeCoefficients(coefficients []float64, precision uint) ([]int32, uint, bool) {
+	cmax := 0.0
+	for _, c := range coefficients {
+		cmax = math.Max(cmax, math.Abs(c))
+	}
+	if cmax == 0 || math.IsNaN(cmax) || math.IsInf(cmax, 0) {
+		return nil, 0, false
+	}
+
+	// Largest shift that keeps the biggest coefficient within precision bits.
+	_, exponent := math.Frexp(cmax)
+	shift := int(precision) - exponent - 1
+	if shift > maxLPCShift {
+		shift = maxLPCShift
+	} else if shift < 0 {
+		return nil, 0, false
+	}
+
+	// Round each coefficient, carrying the rounding error into the next.
+	qmax := int64(1)<<(precision-1) - 1
+	quantized := make([]int32, len(coefficients), len(coefficients))
+	carried := 0.0
+	for i, c := range coefficients {
+		carried += c * float64(int64(1)<<uint(shift))
+		q := int64(math.Floor(carried + 0.5))
+		if q > qmax {
+			q = qmax
+		} else if q < -qmax-1 {
+			q = -qmax - 1
+		}
+		carried -= float64(q)
+		quantized[i] = int32(q)
+	}
+	return quantized, uint(shift), true
+}
+
+// lpcPrecision returns the coefficient precision to use for a block size, as the flac tool does.
+func lpcPrecision(blockSize int) uint {
+	switch {
+	case blockSize <= 192:
+		return 7
+	case blockSize <= 384:
+		return 8
+	case blockSize <= 576:
+		return 9
+	case blockSize <= 1152:
+		return 10
+	case blockSize <= 2304:
+		return 11
+	case blockSize <= 4608:
+		return 12
+	}
+	return 13
+}
@@ -0,0 +1,516 @@
+//go:build ignore
+// +build ignore
+
+// Generates the FLAC files in this directory, with matching .raw files of their samples.
+// Run from this directory with:
+//  go run generate.go
+//
+// This is written from the format specification alone, and shares no code with the flac
+// package, so that the decoder is checked against something other than its own encoder.
+// Each frame picks its subframe types and coding by hand, to cover what a real encoder
+// writes but this package's encoder doesn't: LPC up to order 32 at full precision, every
+// stereo decorrelation, wasted bits, escaped and 5 bit rice partitions, 24 bit samples,
+// and block sizes and sample rates stored after the frame header.
+package main
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+)
+
+// stream is a FLAC stream being built, and the samples it holds.
+type stream struct {
+	rate, channels, bps int
+	blockSize           int
+	frames              [][]byte
+	samples             [][]int64 // Per channel.
+	minFrame, maxFrame  int
+}
+
+// subframe describes how to code one channel of a frame.
+type subframe struct {
+	kind      string // constant, verbatim, fixed or lpc.
+	order     int
+	precision uint // LPC coefficient precision, in bits.
+	shift     uint // LPC quantization shift.
+	wasted    uint
+	partition uint // Partition order.
+	method5   bool // Whether to use 5 bit rice parameters.
+	escape    int  // Index of a partition to write unencoded, -1 for none.
+}
+
+// frame describes how to code a block of samples.
+type frame struct {
+	assignment int // Channel assignment: channels - 1, or 8, 9 or 10 for left/side, side/right and mid/side.
+	subframes  []subframe
+	// Whether to leave the sample rate and size for the STREAMINFO to give.
+	fromStreamInfo bool
+}
+
+func main() {
+	writeStereo()
+	writeMono()
+}
+
+// writeStereo writes 16 bit stereo with 192 sample blocks, and a short last block.
+func writeStereo() {
+	s := &stream{rate: 44100, channels: 2, bps: 16, blockSize: 192}
+	next := newNoise(1)
+	n := 192*3 + 50
+	left, right := make([]int64, n, n), make([]int64, n, n)
+	for i := range left {
+		t := float64(i) / 44100.0
+		l := 8000*math.Sin(2*math.Pi*440*t) + 3000*math.Sin(2*math.Pi*1234*t) + next(50)
+		left[i] = int64(math.Floor(l + 0.5))
+		right[i] = int64(math.Floor(0.6*l + 4000*math.Sin(2*math.Pi*660*t) + next(50) + 0.5))
+	}
+	s.samples = [][]int64{left, right}
+
+	s.addFrame(0, frame{1, []subframe{
+		{kind: "fixed", order: 2, partition: 2, escape: -1},
+		{kind: "fixed", order: 3, partition: 3, escape: 1},
+	}, false})
+	s.addFrame(1, frame{8, []subframe{
+		{kind: "lpc", order: 8, precision: 12, shift: 10, partition: 1, escape: -1},
+		{kind: "fixed", order: 1, partition: 2, method5: true, escape: -1},
+	}, false})
+	s.addFrame(2, frame{9, []subframe{
+		{kind: "lpc", order: 2, precision: 15, shift: 13, partition: 0, escape: -1},
+		{kind: "fixed", order: 4, partition: 4, escape: -1},
+	}, false})
+	s.addFrame(3, frame{10, []subframe{
+		{kind: "lpc", order: 12, precision: 14, shift: 12, partition: 1, escape: -1},
+		{kind: "verbatim"},
+	}, false})
+	s.write("stereo16")
+}
+
+// writeMono writes 24 bit mono at 48kHz with 300 sample blocks, whose size is stored after the
+// frame header, with wasted bits, silence and a short last block.
+func writeMono() {
+	s := &stream{rate: 48000, channels: 1, bps: 24, blockSize: 300}
+	next := newNoise(2)
+	n := 300*2 + 120
+	x := make([]int64, n, n)
+	for i := range x {
+		t := float64(i) / 48000.0
+		v := 3000000*math.Sin(2*math.Pi*100*t)*math.Exp(-10*t) + 500000*math.Sin(2*math.Pi*2500*t) + next(20000)
+		switch {
+		case i < 300:
+			x[i] = int64(math.Floor(v/8+0.5)) * 8 // Three wasted bits.
+		case i < 600:
+			x[i] = -8 // Silence, with a DC offset.
+		default:
+			x[i] = int64(math.Floor(v/2+0.5)) * 2 // One wasted bit.
+		}
+	}
+	s.samples = [][]int64{x}
+
+	s.addFrame(0, frame{0, []subframe{
+		{kind: "lpc", order: 32, precision: 15, shift: 14, wasted: 3, partition: 2, escape: -1},
+	}, false})
+	s.addFrame(1, frame{0, []subframe{{kind: "constant"}}, false})
+	s.addFrame(2, frame{0, []subframe{
+		{kind: "fixed", order: 0, wasted: 1, partition: 0, escape: -1},
+	}, true})
+	s.write("mono24")
+}
+
+// addFrame codes the given frame of the stream's samples.
+func (s *stream) addFrame(number int, f frame) {
+	start := number * s.blockSize
+	end := start + s.blockSize
+	if end > len(s.samples[0]) {
+		end = len(s.samples[0])
+	}
+	size := end - start
+
+	w := &bitWriter{}
+	w.write(0xFFF8, 16) // Sync, and fixed block sizes.
+	switch size {
+	case 192:
+		w.write(1, 4)
+	case 576:
+		w.write(2, 4)
+	default:
+		if size <= 256 {
+			w.write(6, 4)
+		} else {
+			w.write(7, 4)
+		}
+	}
+	rateCodes := map[int]uint64{44100: 9, 48000: 10}
+	sizeCodes := map[int]uint64{8: 1, 12: 2, 16: 4, 20: 5, 24: 6}
+	if f.fromStreamInfo {
+		w.write(0, 4)
+	} else {
+		w.write(rateCodes[s.rate], 4)
+	}
+	w.write(uint64(f.assignment), 4)
+	if f.fromStreamInfo {
+		w.write(0, 3)
+	} else {
+		w.write(sizeCodes[s.bps], 3)
+	}
+	w.write(0, 1)
+	if number >= 128 {
+		panic("Frame numbers above 127 need more than one byte")
+	}
+	w.write(uint64(number), 8)
+	if size != 192 && size != 576 {
+		if size <= 256 {
+			w.write(uint64(size-1), 8)
+		} else {
+			w.write(uint64(size-1), 16)
+		}
+	}
+	w.write(uint64(crc8(w.bytes)), 8)
+
+	channels := make([][]int64, s.channels, s.channels)
+	for c := range channels {
+		channels[c] = s.samples[c][start:end]
+	}
+	bps := []uint{uint(s.bps), uint(s.bps)}
+	if s.channels == 2 {
+		left, right := channels[0], channels[1]
+		side := make([]int64, size, size)
+		for i := range side {
+			side[i] = left[i] - right[i]
+		}
+		switch f.assignment {
+		case 8:
+			channels, bps[1] = [][]int64{left, side}, bps[1]+1
+		case 9:
+			channels, bps[0] = [][]int64{side, right}, bps[0]+1
+		case 10:
+			mid := make([]int64, size, size)
+			for i := range mid {
+				mid[i] = (left[i] + right[i]) >> 1
+			}
+			channels, bps[1] = [][]int64{mid, side}, bps[1]+1
+		}
+	}
+	for c, sub := range f.subframes {
+		writeSubframe(w, sub, channels[c], bps[c])
+	}
+	w.align()
+	w.write(uint64(crc16(w.bytes)), 16)
+
+	s.frames = append(s.frames, w.bytes)
+	if s.minFrame == 0 || len(w.bytes) < s.minFrame {
+		s.minFrame = len(w.bytes)
+	}
+	if len(w.bytes) > s.maxFrame {
+		s.maxFrame = len(w.bytes)
+	}
+}
+
+func writeSubframe(w *bitWriter, sub subframe, x []int64, bps uint) {
+	if sub.wasted > 0 {
+		shifted := make([]int64, len(x), len(x))
+		for i, v := range x {
+			if v&(1<<sub.wasted-1) != 0 {
+				panic("Sample doesn't have the wasted bits")
+			}
+			shifted[i] = v >> sub.wasted
+		}
+		x, bps = shifted, bps-sub.wasted
+	}
+
+	kinds := map[string]uint64{"constant": 0, "verbatim": 1, "fixed": 8 + uint64(sub.order), "lpc": 31 + uint64(sub.order)}
+	w.write(0, 1)
+	w.write(kinds[sub.kind], 6)
+	if sub.wasted > 0 {
+		w.write(1, 1)
+		w.write(1, sub.wasted) // wasted - 1 zeros, then a one.
+	} else {
+		w.write(0, 1)
+	}
+
+	switch sub.kind {
+	case "constant":
+		for _, v := range x {
+			if v != x[0] {
+				panic("Constant subframe isn't constant")
+			}
+		}
+		w.writeSigned(x[0], bps)
+	case "verbatim":
+		for _, v := range x {
+			w.writeSigned(v, bps)
+		}
+	case "fixed":
+		for _, v := range x[:sub.order] {
+			w.writeSigned(v, bps)
+		}
+		writeResidual(w, sub, fixedResidual(x, sub.order))
+	case "lpc":
+		for _, v := range x[:sub.order] {
+			w.writeSigned(v, bps)
+		}
+		coefficients := quantize(levinson(x, sub.order), sub.precision, sub.shift)
+		w.write(uint64(sub.precision-1), 4)
+		w.writeSigned(int64(sub.shift), 5)
+		for _, c := range coefficients {
+			w.writeSigned(c, sub.precision)
+		}
+		residual := make([]int64, len(x), len(x))
+		for i := sub.order; i < len(x); i++ {
+			prediction := int64(0)
+			for j, c := range coefficients {
+				prediction += c * x[i-1-j]
+			}
+			residual[i] = x[i] - prediction>>sub.shift
+		}
+		writeResidual(w, sub, residual)
+	}
+}
+
+// fixedResidual is the difference from the fixed polynomial predictor of an order, after the warmup.
+func fixedResidual(x []int64, order int) []int64 {
+	residual := make([]int64, len(x), len(x))
+	for i := order; i < len(x); i++ {
+		switch order {
+		case 0:
+			residual[i] = x[i]
+		case 1:
+			residual[i] = x[i] - x[i-1]
+		case 2:
+			residual[i] = x[i] - 2*x[i-1] + x[i-2]
+		case 3:
+			residual[i] = x[i] - 3*x[i-1] + 3*x[i-2] - x[i-3]
+		case 4:
+			residual[i] = x[i] - 4*x[i-1] + 6*x[i-2] - 4*x[i-3] + x[i-4]
+		}
+	}
+	return residual
+}
+
+// levinson returns the linear predictor of an order that best fits x, by the autocorrelation method.
+func levinson(x []int64, order int) []float64 {
+	r := make([]float64, order+1, order+1)
+	for lag := range r {
+		for i := lag; i < len(x); i++ {
+			r[lag] += float64(x[i]) * float64(x[i-lag])
+		}
+	}
+	a := make([]float64, order, order)
+	err := r[0]
+	for i := 0; i < order && err > 0; i++ {
+		k := r[i+1]
+		for j := 0; j < i; j++ {
+			k -= a[j] * r[i-j]
+		}
+		k /= err
+		previous := append([]float64{}, a...)
+		a[i] = k
+		for j := 0; j < i; j++ {
+			a[j] = previous[j] - k*previous[i-1-j]
+		}
+		err *= 1 - k*k
+	}
+	return a
+}
+
+// quantize rounds predictor coefficients to integers of a precision, scaled up by shift bits.
+func quantize(a []float64, precision uint, shift uint) []int64 {
+	limit := int64(1)<<(precision-1) - 1
+	result := make([]int64, len(a), len(a))
+	for i, v := range a {
+		c := int64(math.Floor(v*float64(int64(1)<<shift) + 0.5))
+		if c > limit {
+			c = limit
+		} else if c < -limit-1 {
+			c = -limit - 1
+		}
+		result[i] = c
+	}
+	return result
+}
+
+// writeResidual writes a residual with the partitioning and coding the subframe asks for, and the
+// rice parameter that needs fewest bits in each partition.
+func writeResidual(w *bitWriter, sub subframe, residual []int64) {
+	parameterBits, escape := uint(4), uint64(15)
+	if sub.method5 {
+		parameterBits, escape = 5, 31
+		w.write(1, 2)
+	} else {
+		w.write(0, 2)
+	}
+	w.write(uint64(sub.partition), 4)
+
+	size := len(residual) >> sub.partition
+	if size<<sub.partition != len(residual) || size < sub.order {
+		panic(fmt.Sprintf("Partition order %d doesn't fit %d samples", sub.partition, len(residual)))
+	}
+	for p := 0; p < 1<<sub.partition; p++ {
+		from := p * size
+		if p == 0 {
+			from = sub.order
+		}
+		values := residual[from : (p+1)*size]
+
+		if p == sub.escape {
+			bits := uint(0)
+			for _, v := range values {
+				for v < -(int64(1)<<bits>>1) || v >= int64(1)<<bits>>1 {
+					bits++
+				}
+			}
+			w.write(escape, parameterBits)
+			w.write(uint64(bits), 5)
+			for _, v := range values {
+				if bits > 0 {
+					w.writeSigned(v, bits)
+				}
+			}
+			continue
+		}
+
+		best, bestBits := uint64(0), uint64(math.MaxUint64)
+		for k := uint64(0); k < escape; k++ {
+			total := uint64(0)
+			for _, v := range values {
+				total += zigzag(v)>>k + 1 + k
+			}
+			if total < bestBits {
+				best, bestBits = k, total
+			}
+		}
+		w.write(best, parameterBits)
+		for _, v := range values {
+			u := zigzag(v)
+			for q := u >> best; q > 0; q-- {
+				w.write(0, 1)
+			}
+			w.write(1, 1)
+			w.write(u&(1<<best-1), uint(best))
+		}
+	}
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+// write saves the stream as name.flac, and its interleaved samples as little endian int32s in name.raw.
+func (s *stream) write(name string) {
+	bytesPerSample := (s.bps + 7) / 8
+	sum := md5.New()
+	raw := []byte{}
+	for i := range s.samples[0] {
+		for c := range s.samples {
+			v := s.samples[c][i]
+			for j := 0; j < bytesPerSample; j++ {
+				sum.Write([]byte{byte(v >> uint(8*j))})
+			}
+			raw = append(raw, 0, 0, 0, 0)
+			binary.LittleEndian.PutUint32(raw[len(raw)-4:], uint32(int32(v)))
+		}
+	}
+
+	w := &bitWriter{}
+	for _, b := range []byte("fLaC") {
+		w.write(uint64(b), 8)
+	}
+	w.write(1, 1) // Last metadata block.
+	w.write(0, 7) // STREAMINFO.
+	w.write(34, 24)
+	w.write(uint64(s.blockSize), 16)
+	w.write(uint64(s.blockSize), 16)
+	w.write(uint64(s.minFrame), 24)
+	w.write(uint64(s.maxFrame), 24)
+	w.write(uint64(s.rate), 20)
+	w.write(uint64(s.channels-1), 3)
+	w.write(uint64(s.bps-1), 5)
+	w.write(uint64(len(s.samples[0])), 36)
+	for _, b := range sum.Sum(nil) {
+		w.write(uint64(b), 8)
+	}
+	contents := w.bytes
+	for _, f := range s.frames {
+		contents = append(contents, f...)
+	}
+
+	if err := ioutil.WriteFile(name+".flac", contents, 0644); err != nil {
+		panic(err)
+	}
+	if err := ioutil.WriteFile(name+".raw", raw, 0644); err != nil {
+		panic(err)
+	}
+	fmt.Printf("Wrote %s.flac, %d bytes, %d frames\n", name, len(contents), len(s.frames))
+}
+
+// newNoise returns a generator of repeatable uniform noise within +/- a given amplitude.
+func newNoise(seed uint32) func(amplitude float64) float64 {
+	state := seed
+	return func(amplitude float64) float64 {
+		state = state*1664525 + 1013904223
+		return amplitude * (float64(state)/float64(math.MaxUint32)*2 - 1)
+	}
+}
+
+// bitWriter packs values most significant bit first.
+type bitWriter struct {
+	bytes []byte
+	bits  uint // Bits used in the last byte, 0 if it's full.
+}
+
+func (w *bitWriter) write(v uint64, n uint) {
+	for i := int(n) - 1; i >= 0; i-- {
+		if w.bits == 0 {
+			w.bytes = append(w.bytes, 0)
+		}
+		if v>>uint(i)&1 == 1 {
+			w.bytes[len(w.bytes)-1] |= 0x80 >> w.bits
+		}
+		w.bits = (w.bits + 1) % 8
+	}
+}
+
+func (w *bitWriter) writeSigned(v int64, n uint) {
+	if v < -(int64(1)<<n>>1) || v >= int64(1)<<n>>1 {
+		panic(fmt.Sprintf("%d doesn't fit in %d bits", v, n))
+	}
+	w.write(uint64(v)&(1<<n-1), n)
+}
+
+func (w *bitWriter) align() {
+	w.bits = 0
+}
+
+// crc8 uses the polynomial x^8 + x^2 + x + 1.
+func crc8(b []byte) uint8 {
+	crc := uint8(0)
+	for _, v := range b {
+		crc ^= v
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// crc16 uses the polynomial x^16 + x^15 + x^2 + 1.
+func crc16(b []byte) uint16 {
+	crc := uint16(0)
+	for _, v := range b {
+		crc ^= uint16(v) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x8005
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -6,7 +6,9 @@ import (
 	"html/template"
 	"io/ioutil"
 	"net/http"
-	"strings"
+	"path/filepath"
+
+	"github.com/padster/go-sound/file"
 )
 
 type MashAppServer struct {
@@ -104,13 +106,15 @@ func listMusicFiles(fromDirectory string) []string {
 
 	result := make([]string, 0)
 	for _, info := range infos {
-		if !info.IsDir() && isMusicFile(info.Name()) {
+		if !info.IsDir() && isMusicFile(filepath.Join(fromDirectory, info.Name())) {
 			result = append(result, info.Name())
 		}
 	}
 	return result
 }
 
-func isMusicFile(name string) bool {
-	return strings.HasSuffix(name, ".wav") || strings.HasSuffix(name, ".flac")
+// isMusicFile returns whether a file's contents are in a format soundfile.Read supports.
+func isMusicFile(path string) bool {
+	format, err := soundfile.DetectAudioFormat(path)
+	return err == nil && format != soundfile.UnknownAudio
 }
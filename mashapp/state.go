@@ -4,6 +4,7 @@ import (
 	// "fmt"
 
 	"github.com/padster/go-sound/cq"
+	"github.com/padster/go-sound/cq/ops"
 	"github.com/padster/go-sound/file"
 	// "github.com/padster/go-sound/output"
 	s "github.com/padster/go-sound/sounds"
@@ -65,13 +66,14 @@ func (state *ServerState) shiftInput(input InputMeta) InputSound {
 	beforeSound.Start()
 
 	// HACK: Only pitch shift for now.
-	paramsIn := cq.NewCQParams(SAMPLE_RATE, MIN_FREQ, MAX_FREQ, BPO)
-	paramsOut := cq.NewCQParams(SAMPLE_RATE, MIN_FREQ, MAX_FREQ, BPO)
+	paramsIn := cq.NewCQParamsForRange(SAMPLE_RATE, MIN_FREQ, MAX_FREQ, BPO)
+	paramsOut := cq.NewCQParamsForRange(SAMPLE_RATE, MIN_FREQ, MAX_FREQ, BPO)
 	spectrogram := cq.NewSpectrogram(paramsIn)
 	cqInverse := cq.NewCQInverse(paramsOut)
 
 	columns := spectrogram.ProcessChannel(beforeSound.GetSamples())
-	outColumns := shiftSpectrogram(input.FinalPitch*(BINS_PER_SEMITONE), 0, columns, OCTAVES, BPO)
+	edit := ops.Compose(ops.ShiftBins(paramsIn, input.FinalPitch*BINS_PER_SEMITONE), ops.ToCQHeights(paramsIn))
+	outColumns := edit(columns)
 	soundChannel := cqInverse.ProcessChannel(outColumns)
 	resultSound := s.WrapChannelAsSound(soundChannel)
 
@@ -82,53 +84,3 @@ func (state *ServerState) shiftInput(input InputMeta) InputSound {
 		afterSamples,
 	}
 }
-
-func shiftSpectrogram(binOffset int, sampleOffset int, samples <-chan []complex128, octaves int, bpo int) <-chan []complex128 {
-	result := make(chan []complex128)
-
-	go func() {
-		sRead, sWrite := 0, 0
-
-		ignoreSamples := sampleOffset
-		at := 0
-		for s := range samples {
-			sRead++
-			if ignoreSamples > 0 {
-				ignoreSamples--
-				continue
-			}
-
-			octaveCount := octaves
-			if at > 0 {
-				octaveCount = numOctaves(at)
-				if octaveCount == octaves {
-					at = 0
-				}
-			}
-			at++
-
-			toFill := octaveCount * bpo
-			column := make([]complex128, toFill, toFill)
-
-			// NOTE: Zero-padded, not the best...
-			if binOffset >= 0 {
-				copy(column, s[binOffset:])
-			} else {
-				copy(column[-binOffset:], s)
-			}
-			sWrite++
-			result <- column
-		}
-		close(result)
-	}()
-	return result
-}
-
-func numOctaves(at int) int {
-	result := 1
-	for at%2 == 0 {
-		at /= 2
-		result++
-	}
-	return result
-}
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"os"
+	"path/filepath"
+
+	"github.com/padster/go-sound/cq"
 	"github.com/padster/go-sound/mashapp"
 )
 
 func main() {
+	// Every shift builds new transforms, so keep their kernels between runs too.
+	cq.SetKernelCache(cq.NewKernelCache(filepath.Join(os.TempDir(), "go-sound-kernels")))
 	mashapp.NewServer(8080, "mashapp", ".").Serve()
 }
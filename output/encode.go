@@ -0,0 +1,110 @@
+package output
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+
+	"github.com/padster/go-sound/sounds"
+)
+
+// ClipPolicy is what a file writer does with samples outside [-1, 1].
+type ClipPolicy int
+
+const (
+	// Clip limits samples to [-1, 1], counting how many were changed.
+	Clip ClipPolicy = iota
+	// FailOnClip stops writing at the first sample outside [-1, 1], returning ErrClipped.
+	FailOnClip
+)
+
+// ErrClipped is returned when writing a sample that clips, with the FailOnClip policy.
+var ErrClipped = errors.New("Sample outside [-1, 1]")
+
+// WriteStats reports what happened while writing a sound to a file.
+type WriteStats struct {
+	// Samples is the number of samples written.
+	Samples uint64
+
+	// Clipped is the number of those that were outside [-1, 1].
+	Clipped uint64
+}
+
+// encodeSound runs a sound, passing each of its samples to encode after applying the clipping policy.
+func encodeSound(s sounds.Sound, clipping ClipPolicy, encode func(sample float64) error) (WriteStats, error) {
+	stats := WriteStats{}
+	s.Start()
+	defer s.Stop()
+
+	samples := s.GetSamples()
+	for sample := range samples {
+		if sample > 1 || sample < -1 || math.IsNaN(sample) {
+			stats.Clipped++
+			if clipping == FailOnClip {
+				// Let the sound finish, rather than blocking forever on its next sample.
+				go func() {
+					for range samples {
+					}
+				}()
+				return stats, fmt.Errorf("%v: %v at sample %d", ErrClipped, sample, stats.Samples)
+			}
+			if math.IsNaN(sample) {
+				sample = 0
+			}
+			sample = math.Max(-1, math.Min(1, sample))
+		}
+
+		if err := encode(sample); err != nil {
+			return stats, err
+		}
+		stats.Samples++
+	}
+	return stats, nil
+}
+
+// createFile opens a file to write to, failing if it exists unless overwriting.
+func createFile(path string, overwrite bool) (*os.File, error) {
+	flags := os.O_RDWR | os.O_CREATE | os.O_TRUNC
+	if !overwrite {
+		flags |= os.O_EXCL
+	}
+	return os.OpenFile(path, flags, 0666)
+}
+
+// quantizer converts [-1, 1] samples to integers, optionally dithered and noise shaped.
+type quantizer struct {
+	dither       bool
+	noiseShaping bool
+	scale        float64
+	random       *rand.Rand
+
+	// The two previous rounding errors, for noise shaping.
+	error1, error2 float64
+}
+
+func newQuantizer(bits int, dither bool, noiseShaping bool, seed int64) *quantizer {
+	scale := float64(int64(1)<<uint(bits-1) - 1)
+	return &quantizer{dither, noiseShaping, scale, rand.New(rand.NewSource(seed)), 0, 0}
+}
+
+func (q *quantizer) quantize(sample float64) int32 {
+	value := sample * q.scale
+	if !q.dither {
+		// Truncate towards zero, as the 16 bit files written before dithering existed did.
+		return int32(value)
+	}
+
+	if q.noiseShaping {
+		// Error filter of (1 - z^-1)^2, pushing the noise up towards the Nyquist frequency.
+		value -= 2.0*q.error1 - q.error2
+	}
+	tpdf := q.random.Float64() - q.random.Float64()
+	rounded := math.Max(-q.scale-1, math.Min(q.scale, math.Floor(value+tpdf+0.5)))
+
+	// Errors are normally within a few steps, but can be huge when a loud sample is limited,
+	// which would make the feedback unstable.
+	q.error1, q.error2 = math.Max(-4, math.Min(4, rounded-value)), q.error1
+	return int32(rounded)
+}
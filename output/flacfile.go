@@ -1,84 +1,115 @@
-// Write a sound to a .wav file
+// Write a sound to a .flac file
 package output
 
 import (
-	"fmt"
-	"os"
+	"io"
 	"strings"
 
-	flac "github.com/cocoonlife/goflac"
-	// flac "github.com/padster/go-sound/fakeflac"
+	"github.com/padster/go-sound/flac"
 	s "github.com/padster/go-sound/sounds"
 )
 
-// WriteSoundToFlac creates a file at a path, and writes the given sound in the .flac format.
+// Number of samples passed to the encoder at once.
+const flacChunkSize = 4096
+
+// FlacWriter writes sounds losslessly compressed in the .flac format, as mono integers.
+type FlacWriter struct {
+	// BitDepth is the size of each sample, from 4 to 24 bits, usually 16 or 24.
+	BitDepth int
+
+	// CompressionLevel is from 0, fastest, to 8, smallest, as for the flac command line tool.
+	CompressionLevel int
+
+	// Tags are written as Vorbis comments, e.g. {"TITLE": "...", "ARTIST": "..."}.
+	Tags map[string]string
+
+	// Clipping is what happens to samples outside [-1, 1].
+	Clipping ClipPolicy
+
+	// Dither and NoiseShaping reduce the distortion from converting to integers, as for WavWriter.
+	Dither       bool
+	NoiseShaping bool
+
+	// Overwrite allows WriteFile to replace an existing file.
+	Overwrite bool
+
+	// Seed for the dither noise, so files are repeatable.
+	Seed int64
+}
+
+// NewFlacWriter creates a writer for undithered 24 bit files at the default compression level.
+//
+// For example, to write a tagged 16 bit file as small as possible:
+//  writer := output.NewFlacWriter()
+//  writer.BitDepth, writer.CompressionLevel, writer.Dither = 16, 8, true
+//  writer.Tags = map[string]string{"TITLE": "Demo"}
+//  stats, err := writer.WriteFile(sound, "demo.flac")
+func NewFlacWriter() *FlacWriter {
+	return &FlacWriter{
+		24, /* BitDepth */
+		flac.DefaultCompressionLevel,
+		nil, /* Tags */
+		Clip,
+		false, /* Dither */
+		false, /* NoiseShaping */
+		false, /* Overwrite */
+		1,     /* Seed */
+	}
+}
+
+// WriteSoundToFlac creates a file at a path, and writes the given sound in the .flac format,
+// with the default NewFlacWriter settings. Fails if the file exists. Loud samples are clipped
+// silently, use FlacWriter.WriteFile to find out how many were.
 func WriteSoundToFlac(sound s.Sound, path string) error {
 	if !strings.HasSuffix(path, ".flac") {
 		panic("Output file must be .flac")
 	}
+	_, err := NewFlacWriter().WriteFile(sound, path)
+	return err
+}
 
-	if _, err := os.Stat(path); err == nil {
-		panic("File already exists! Please delete first")
-		return os.ErrExist
-	}
-
-	sampleRate := int(s.CyclesPerSecond)
-	depth := 24
-
-	fileWriter, err := flac.NewEncoder(path, 1, depth, sampleRate)
+// WriteFile creates a file at a path, and writes the sound to it.
+func (w *FlacWriter) WriteFile(sound s.Sound, path string) (WriteStats, error) {
+	file, err := createFile(path, w.Overwrite)
 	if err != nil {
-		fmt.Printf("Error opening file to write to! %v\n", err)
-		panic("Can't write file")
+		return WriteStats{}, err
 	}
-	defer fileWriter.Close()
-
-	// Starts the sound, and accesses its sample stream.
-	sound.Start()
-	samples := sound.GetSamples()
-	defer sound.Stop()
-
-	// TODO: Make a common utility for this, it's used here and in both CQ and CQI.
-	frameSize := 44100
-	buffer := make([]float64, frameSize, frameSize)
-	at := 0
-	for s := range samples {
-		if at == frameSize {
-			writeFrame(fileWriter, buffer)
-			at = 0
-		}
-		buffer[at] = s
-		at++
-	}
-	writeFrame(fileWriter, buffer[:at])
 
-	return nil
+	stats, err := w.Write(sound, file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return stats, err
 }
 
-func writeFrame(fileWriter *flac.Encoder, samples []float64) {
-	n := len(samples)
-
-	frameBuffer := make([]int32, n, n)
-	for i, v := range samples {
-		frameBuffer[i] = intFromFloatWithDepth(v, fileWriter.Depth)
+// Write writes the whole sound in the .flac format. The STREAMINFO at the start is
+// completed, with the length and MD5 of the samples, once the sound has finished.
+func (w *FlacWriter) Write(sound s.Sound, out io.WriteSeeker) (WriteStats, error) {
+	encoder, err := flac.NewEncoder(out, 1, w.BitDepth, int(s.CyclesPerSecond))
+	if err != nil {
+		return WriteStats{}, err
 	}
+	encoder.CompressionLevel = w.CompressionLevel
+	encoder.Tags = w.Tags
 
-	frame := flac.Frame{
-		1,                /* channels */
-		fileWriter.Depth, /* depth */
-		fileWriter.Rate,  /* rate */
-		frameBuffer,
+	quantizer := newQuantizer(w.BitDepth, w.Dither, w.NoiseShaping, w.Seed)
+	chunk := make([]int32, 0, flacChunkSize)
+	stats, err := encodeSound(sound, w.Clipping, func(sample float64) error {
+		chunk = append(chunk, quantizer.quantize(sample))
+		if len(chunk) < flacChunkSize {
+			return nil
+		}
+		err := encoder.Write(chunk)
+		chunk = chunk[:0]
+		return err
+	})
+	if err != nil {
+		return stats, err
 	}
 
-	if err := fileWriter.WriteFrame(frame); err != nil {
-		fmt.Printf("Error writing frame to file :( %v\n", err)
-		panic("Can't write to file")
+	// The encoder writes the last, shorter, frame when closed.
+	if err := encoder.Write(chunk); err != nil {
+		return stats, err
 	}
-}
-
-// HACK - should share with cq/utils.go
-func intFromFloatWithDepth(input float64, depth int) int32 {
-	return int32(input * (float64(unsafeShift(depth)) - 1.0))
-}
-func unsafeShift(s int) int {
-	return 1 << uint(s)
+	return stats, encoder.Close()
 }
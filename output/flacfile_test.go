@@ -0,0 +1,129 @@
+package output
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/padster/go-sound/sounds"
+)
+
+// Samples decoded by LoadFlacAsSound should be exactly those the writer quantized.
+func TestFlacRoundTrip(t *testing.T) {
+	tests := []struct {
+		bits   int
+		level  int
+		length int
+	}{
+		{16, 0, 1152 * 3}, // Exactly whole frames.
+		{16, 5, 10000},    // Shorter final frame.
+		{24, 5, 4096 + 1}, // Single sample final frame.
+		{24, 8, 5000},     // Exhaustive search.
+		{16, 2, 100},      // Only a final frame.
+		{8, 3, 3 * 4096},  // Low bit depth.
+		{16, 5, 0},        // Empty.
+	}
+	for _, test := range tests {
+		samples := make([]float64, test.length, test.length)
+		for i := range samples {
+			samples[i] = 0.6*math.Sin(float64(i)*0.05) + 0.3*math.Sin(float64(i)*0.31)
+		}
+
+		writer := NewFlacWriter()
+		writer.BitDepth, writer.CompressionLevel = test.bits, test.level
+		writer.Tags = map[string]string{"TITLE": "Round trip", "ARTIST": "go-sound"}
+		path := tempFlac(t)
+		defer os.Remove(path)
+		stats, err := writer.WriteFile(sounds.WrapSliceAsSound(samples), path)
+		if err != nil {
+			t.Fatalf("%+v: can't write flac: %v", test, err)
+		}
+		if stats.Samples != uint64(test.length) || stats.Clipped != 0 {
+			t.Errorf("%+v: wrote %+v", test, stats)
+		}
+
+		// Expected samples, as scaled by the writer then the reader.
+		quantizer := newQuantizer(test.bits, false, false, 1)
+		expected := make([]int32, len(samples), len(samples))
+		for i, v := range samples {
+			expected[i] = quantizer.quantize(v)
+		}
+		read := []float64{}
+		sound := sounds.LoadFlacAsSound(path)
+		sound.Start()
+		for sample := range sound.GetSamples() {
+			read = append(read, sample)
+		}
+		if len(read) != len(expected) {
+			t.Errorf("%+v: read %d samples, expected %d", test, len(read), len(expected))
+			continue
+		}
+		scale := float64(int64(1) << uint(test.bits-1))
+		for i, v := range expected {
+			if read[i] != float64(v)/scale {
+				t.Errorf("%+v: sample %d is %v, expected %v", test, i, read[i], float64(v)/scale)
+				break
+			}
+		}
+
+		checkStreamInfo(t, path, test.bits, expected)
+	}
+}
+
+func TestFlacClipping(t *testing.T) {
+	path := tempFlac(t)
+	defer os.Remove(path)
+
+	writer := NewFlacWriter()
+	writer.Overwrite, writer.Clipping = true, FailOnClip
+	if _, err := writer.WriteFile(sounds.WrapSliceAsSound([]float64{0, 1.5}), path); err == nil {
+		t.Errorf("Expected clipping to fail")
+	}
+}
+
+// checkStreamInfo compares the length and MD5 in a file's STREAMINFO to the expected samples,
+// and checks the tags are present.
+func checkStreamInfo(t *testing.T, path string, bits int, expected []int32) {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Can't read flac: %v", err)
+	}
+	if string(file[0:4]) != "fLaC" || file[4] != 0 {
+		t.Fatalf("Flac doesn't start with STREAMINFO")
+	}
+	info := file[8 : 8+34]
+	fields := binary.BigEndian.Uint64(info[10:18])
+	total, depth := fields&(1<<36-1), int(fields>>36&0x1F)+1
+	if total != uint64(len(expected)) || depth != bits {
+		t.Errorf("STREAMINFO has %d samples of %d bits, expected %d of %d", total, depth, len(expected), bits)
+	}
+
+	hash := md5.New()
+	for _, v := range expected {
+		for i := 0; i < (bits+7)/8; i++ {
+			hash.Write([]byte{byte(v >> uint(8*i))})
+		}
+	}
+	if !bytes.Equal(info[18:34], hash.Sum(nil)) {
+		t.Errorf("STREAMINFO MD5 doesn't match the samples")
+	}
+	if !bytes.Contains(file, []byte("TITLE=Round trip")) || !bytes.Contains(file, []byte("ARTIST=go-sound")) {
+		t.Errorf("Vorbis comments missing tags")
+	}
+}
+
+func tempFlac(t *testing.T) string {
+	f, err := ioutil.TempFile("", "flac_")
+	if err != nil {
+		t.Fatalf("Can't create temp file: %v", err)
+	}
+	f.Close()
+
+	path := f.Name() + ".flac"
+	os.Remove(f.Name())
+	return path
+}
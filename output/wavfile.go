@@ -2,56 +2,158 @@
 package output
 
 import (
+	"bufio"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
 	"math"
-	"os"
 
-	wav "github.com/cryptix/wav"
 	"github.com/padster/go-sound/sounds"
 )
 
-const (
-	normScale = float64(math.MaxInt16)
-)
+// WavWriter writes sounds in the .wav format, as mono PCM integers or 32 bit floats.
+type WavWriter struct {
+	// BitDepth is the size of each integer sample: 16, 24 or 32.
+	BitDepth int
 
-// WriteSoundToWav creates a file at a path, and writes the given sound in the .wav format.
-func WriteSoundToWav(s sounds.Sound, path string) error {
-	// Create file first, only if it doesn't exist:
-	if _, err := os.Stat(path); err == nil {
-		panic("File already exists! Please delete first")
-		return os.ErrExist
+	// Float writes 32 bit IEEE floats instead of integers, ignoring BitDepth and dithering.
+	Float bool
+
+	// Clipping is what happens to samples outside [-1, 1].
+	Clipping ClipPolicy
+
+	// Dither adds triangular (TPDF) noise of one least significant bit before rounding, so the
+	// error from reducing the sounds to integers becomes a steady hiss rather than distortion.
+	Dither bool
+
+	// NoiseShaping feeds the rounding error back through a second order filter, moving the
+	// dither's hiss to high frequencies where it's less audible. Only applies when dithering.
+	NoiseShaping bool
+
+	// Overwrite allows WriteFile to replace an existing file.
+	Overwrite bool
+
+	// Seed for the dither noise, so files are repeatable.
+	Seed int64
+}
+
+// NewWavWriter creates a writer for undithered 16 bit files, clipping loud samples.
+//
+// For example, to master a sound to a 24 bit file:
+//  writer := output.NewWavWriter()
+//  writer.BitDepth, writer.Dither, writer.NoiseShaping = 24, true, true
+//  stats, err := writer.WriteFile(sound, "master.wav")
+func NewWavWriter() *WavWriter {
+	return &WavWriter{
+		16,    /* BitDepth */
+		false, /* Float */
+		Clip,
+		false, /* Dither */
+		false, /* NoiseShaping */
+		false, /* Overwrite */
+		1,     /* Seed */
 	}
-	file, err := os.Create(path)
+}
+
+// WriteSoundToWav creates a file at a path, and writes the given sound in the .wav format,
+// with the default NewWavWriter settings. Fails if the file exists. Loud samples are clipped
+// silently, use WavWriter.WriteFile to find out how many were.
+func WriteSoundToWav(s sounds.Sound, path string) error {
+	_, err := NewWavWriter().WriteFile(s, path)
+	return err
+}
+
+// WriteFile creates a file at a path, and writes the sound to it.
+func (w *WavWriter) WriteFile(s sounds.Sound, path string) (WriteStats, error) {
+	file, err := createFile(path, w.Overwrite)
 	if err != nil {
-		return err
+		return WriteStats{}, err
 	}
-	defer func() {
-		file.Close()
-	}()
 
-	// Create a .wav writer for the file
-	var wf = wav.File{
-		SampleRate:      uint32(sounds.CyclesPerSecond),
-		Channels:        1,
-		SignificantBits: 16,
+	stats, err := w.Write(s, file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
 	}
-	writer, err := wf.NewWriter(file)
+	return stats, err
+}
+
+// Write writes the whole sound in the .wav format. The header is written first, then
+// updated with the final sizes once the sound has finished.
+func (w *WavWriter) Write(s sounds.Sound, out io.WriteSeeker) (WriteStats, error) {
+	tag, bits := uint16(1) /* PCM */, w.BitDepth
+	if w.Float {
+		tag, bits = 3 /* IEEE float */, 32
+	} else if bits != 16 && bits != 24 && bits != 32 {
+		return WriteStats{}, fmt.Errorf("Unsupported wav bit depth %d, must be 16, 24 or 32", bits)
+	}
+	bytesPerSample := bits / 8
+	rate := uint32(sounds.CyclesPerSecond)
+
+	start, err := out.Seek(0, io.SeekCurrent)
 	if err != nil {
+		return WriteStats{}, err
+	}
+	buffer := bufio.NewWriter(out)
+	header := []interface{}{
+		[]byte("RIFF"), uint32(0), /* size, filled in later */
+		[]byte("WAVEfmt "), uint32(16),
+		tag, uint16(1), /* channels */
+		rate, rate * uint32(bytesPerSample),
+		uint16(bytesPerSample), uint16(bits),
+		[]byte("data"), uint32(0), /* size, filled in later */
+	}
+	for _, field := range header {
+		binary.Write(buffer, binary.LittleEndian, field)
+	}
+
+	quantizer := newQuantizer(bits, w.Dither, w.NoiseShaping, w.Seed)
+	b := make([]byte, 4, 4)
+	stats, err := encodeSound(s, w.Clipping, func(sample float64) error {
+		if w.Float {
+			binary.LittleEndian.PutUint32(b, math.Float32bits(float32(sample)))
+		} else {
+			binary.LittleEndian.PutUint32(b, uint32(quantizer.quantize(sample)))
+		}
+		_, err := buffer.Write(b[:bytesPerSample])
 		return err
+	})
+	if err != nil {
+		return stats, err
 	}
-	defer writer.Close()
 
-	// Starts the sound, and accesses its sample stream.
-	s.Start()
-	samples := s.GetSamples()
-	defer s.Stop()
+	// Chunks are padded to an even length.
+	dataSize := stats.Samples * uint64(bytesPerSample)
+	if dataSize%2 == 1 {
+		buffer.WriteByte(0)
+	}
+	if dataSize+dataSize%2+36 > math.MaxUint32 {
+		return stats, errors.New("Sound too long for a .wav file")
+	}
+	if err := buffer.Flush(); err != nil {
+		return stats, err
+	}
+	end, err := out.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return stats, err
+	}
 
-	// Write a single sample at a time, as per the .wav writer API.
-	b := make([]byte, 2)
-	for sample := range samples {
-		toNumber := uint16(sample * normScale) // Inverse the read scaling
-		binary.LittleEndian.PutUint16(b, uint16(toNumber))
-		writer.WriteSample(b)
+	sizes := []struct {
+		offset int64
+		size   uint64
+	}{
+		{4, 36 + dataSize + dataSize%2},
+		{40, dataSize},
+	}
+	for _, s := range sizes {
+		binary.LittleEndian.PutUint32(b, uint32(s.size))
+		if _, err := out.Seek(start+s.offset, io.SeekStart); err != nil {
+			return stats, err
+		}
+		if _, err := out.Write(b[:4]); err != nil {
+			return stats, err
+		}
 	}
-	return nil
+	_, err = out.Seek(end, io.SeekStart)
+	return stats, err
 }
@@ -0,0 +1,151 @@
+package output
+
+// go test github.com/padster/go-sound/output
+
+import (
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/padster/go-sound/sounds"
+)
+
+var testWavSamples = []float64{0, 0.5, -0.5, 0.999, -1, 1.5, -2}
+
+func TestWavRoundTrip(t *testing.T) {
+	tests := []struct {
+		bits      int
+		float     bool
+		tolerance float64
+	}{
+		{16, false, 2.0 / 32767},
+		{24, false, 2.0 / 8388607},
+		{32, false, 2.0 / 2147483647},
+		{32, true, 1e-7},
+	}
+	for _, test := range tests {
+		writer := NewWavWriter()
+		writer.BitDepth, writer.Float = test.bits, test.float
+		read, stats := writeAndRead(t, writer, testWavSamples)
+
+		if stats.Samples != uint64(len(testWavSamples)) || stats.Clipped != 2 {
+			t.Errorf("%d bit: wrote %+v, expected %d samples with 2 clipped", test.bits, stats, len(testWavSamples))
+		}
+		if len(read) != len(testWavSamples) {
+			t.Errorf("%d bit: read %d samples, expected %d", test.bits, len(read), len(testWavSamples))
+			continue
+		}
+		for i, v := range testWavSamples {
+			expected := math.Max(-1, math.Min(1, v))
+			if math.Abs(read[i]-expected) > test.tolerance {
+				t.Errorf("%d bit: sample %d is %v, expected %v", test.bits, i, read[i], expected)
+			}
+		}
+	}
+}
+
+func TestWavFailOnClip(t *testing.T) {
+	writer := NewWavWriter()
+	writer.Clipping = FailOnClip
+	f := tempWav(t)
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	stats, err := writer.Write(sounds.WrapSliceAsSound(testWavSamples), f)
+	if err == nil || stats.Samples != 5 || stats.Clipped != 1 {
+		t.Errorf("Expected clipping to fail after 5 samples, got %+v, %v", stats, err)
+	}
+}
+
+func TestWavUnsupportedBitDepth(t *testing.T) {
+	writer := NewWavWriter()
+	writer.BitDepth = 20
+	f := tempWav(t)
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if _, err := writer.Write(sounds.WrapSliceAsSound(testWavSamples), f); err == nil {
+		t.Errorf("Expected 20 bit samples to fail")
+	}
+	if info, err := f.Stat(); err != nil || info.Size() != 0 {
+		t.Errorf("Expected nothing to be written, got %v, %v", info, err)
+	}
+}
+
+func TestWavOverwrite(t *testing.T) {
+	f := tempWav(t)
+	f.Close()
+	defer os.Remove(f.Name())
+
+	writer := NewWavWriter()
+	if _, err := writer.WriteFile(sounds.WrapSliceAsSound(testWavSamples), f.Name()); !os.IsExist(err) {
+		t.Errorf("Expected the existing file to be kept, got %v", err)
+	}
+	writer.Overwrite = true
+	if _, err := writer.WriteFile(sounds.WrapSliceAsSound(testWavSamples), f.Name()); err != nil {
+		t.Errorf("Expected the existing file to be replaced, got %v", err)
+	}
+}
+
+// Dithering a quiet sine leaves it intact on average, and noise shaping moves the error to high frequencies.
+func TestWavDither(t *testing.T) {
+	samples := make([]float64, 8192, 8192)
+	for i := range samples {
+		samples[i] = 0.7 / 32767 * math.Sin(2.0*math.Pi*440.0*float64(i)/sounds.CyclesPerSecond)
+	}
+
+	lowErrors := map[bool]float64{}
+	for _, shaped := range []bool{false, true} {
+		writer := NewWavWriter()
+		writer.Dither, writer.NoiseShaping = true, shaped
+		read, _ := writeAndRead(t, writer, samples)
+
+		// Error below ~1kHz, from a moving average over a 440Hz period.
+		window := 100
+		for i := window; i < len(read); i++ {
+			sum := 0.0
+			for j := i - window; j < i; j++ {
+				sum += read[j] - samples[j]
+			}
+			lowErrors[shaped] += math.Abs(sum / float64(window))
+		}
+	}
+	if lowErrors[true] > lowErrors[false]/4 {
+		t.Errorf("Noise shaping should reduce low frequency error, got %v shaped vs %v flat", lowErrors[true], lowErrors[false])
+	}
+}
+
+func writeAndRead(t *testing.T, writer *WavWriter, samples []float64) ([]float64, WriteStats) {
+	f := tempWav(t)
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	stats, err := writer.Write(sounds.WrapSliceAsSound(samples), f)
+	if err != nil {
+		t.Fatalf("Can't write wav: %v", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("Can't rewind wav: %v", err)
+	}
+	reader, err := sounds.NewWavReader(f)
+	if err != nil {
+		t.Fatalf("Can't read wav: %v", err)
+	}
+
+	result := []float64{}
+	frame := []float64{0}
+	for reader.ReadFrame(frame) == nil {
+		result = append(result, frame[0])
+	}
+	return result, stats
+}
+
+func tempWav(t *testing.T) *os.File {
+	f, err := ioutil.TempFile("", "wav_")
+	if err != nil {
+		t.Fatalf("Can't create temp file: %v", err)
+	}
+	return f
+}
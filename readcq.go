@@ -3,11 +3,11 @@ package main
 import (
 	"flag"
 	"fmt"
-	"runtime"
 
 	"github.com/padster/go-sound/cq"
 	f "github.com/padster/go-sound/file"
 	"github.com/padster/go-sound/output"
+	"github.com/padster/go-sound/render"
 	s "github.com/padster/go-sound/sounds"
 	"github.com/padster/go-sound/util"
 )
@@ -18,13 +18,15 @@ const (
 
 // Reads the CQ columns from file, converts back into a sound.
 func main() {
-	runtime.GOMAXPROCS(6)
-
 	// Parse flags...
 	sampleRate := s.CyclesPerSecond
 	octaves := flag.Int("octaves", 7, "Range in octaves")
 	minFreq := flag.Float64("minFreq", 55.0, "Minimum frequency")
 	bpo := flag.Int("bpo", 24, "Buckets per octave")
+	png := flag.String("png", "", "Write the spectrogram to this PNG file, rather than playing")
+	colorMap := flag.String("colors", "magma", "Colour map for -png: gray, hot, jet, viridis or magma")
+	zoom := flag.Int("zoom", 16, "Columns per pixel for -png")
+	phaseIterations := flag.Int("phaseIterations", 0, "Griffin-Lim iterations to rebuild phases (e.g. of polar files without phase), 0 to use the file's")
 	flag.Parse()
 
 	remainingArgs := flag.Args()
@@ -36,16 +38,54 @@ func main() {
 		inputFile = remainingArgs[0]
 	}
 
+	// Files with a header describe their own parameters, only legacy files need the flags.
+	// Legacy files were written from the first column output, so lag by the transform's latency.
 	params := cq.NewCQParams(sampleRate, *octaves, *minFreq, *bpo)
+	timing := cq.NewConstantQ(params).Timing()
+	if header, err := f.ReadCQFileHeader(inputFile); err == nil {
+		if params, err = header.Params(); err != nil {
+			panic(err)
+		}
+		if timing, err = header.Timing(); err != nil {
+			panic(err)
+		}
+	} else if err != f.ErrNoCQHeader {
+		panic(err)
+	}
 
-	if SHOW_SPECTROGRAM {
+	if *png != "" {
+		renderer := render.NewSpectrogramRenderer(params, timing.SamplesPerColumn, timing.Latency)
+		if colors, ok := render.ColorMaps[*colorMap]; ok {
+			renderer.ColorMap = colors
+		} else {
+			panic("Unknown colour map: " + *colorMap)
+		}
+		renderer.ColumnsPerPixel = *zoom
+		paths, err := renderer.WritePNG(*png, f.ReadCQColumns(inputFile, params))
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("Written %v\n", paths)
+	} else if SHOW_SPECTROGRAM {
 		cqChannel := f.ReadCQColumns(inputFile, params)
 		spectrogram := cq.NewSpectrogram(params)
 		columns := spectrogram.InterpolateCQChannel(cqChannel)
-		toShow := util.NewSpectrogramScreen(882, *bpo**octaves, *bpo)
+		toShow := util.NewSpectrogramScreen(882, params.BinsPerOctave*params.Octaves, params.BinsPerOctave)
 		toShow.Render(columns, 1)
+	} else if *phaseIterations > 0 {
+		columns := [][]complex128{}
+		for column := range f.ReadCQColumns(inputFile, params) {
+			columns = append(columns, column)
+		}
+		fmt.Printf("Reconstructing phases of %d columns...\n", len(columns))
+		gl := cq.NewCQGriffinLim(params)
+		gl.Iterations = *phaseIterations
+		asSound := s.WrapSliceAsSound(gl.ReconstructSamples(columns))
+		fmt.Printf("Playing...\n")
+		output.Play(asSound)
+		fmt.Printf("Done...\n")
 	} else {
-		asSound := f.ReadCQ(inputFile, params, false)
+		asSound := f.ReadCQ(inputFile, params)
 		fmt.Printf("Playing...\n")
 		output.Play(asSound)
 		fmt.Printf("Done...\n")
@@ -0,0 +1,73 @@
+// Package render draws sounds and spectrograms into images, without needing a screen.
+package render
+
+import (
+	"image/color"
+	"math"
+)
+
+// ColorMap maps values in [0, 1] to colours, interpolating between evenly spaced stops.
+type ColorMap []color.RGBA
+
+var (
+	// Gray goes from black to white, like util.SpectrogramScreen.
+	Gray = ColorMap{{0, 0, 0, 255}, {255, 255, 255, 255}}
+
+	// Hot goes from black through red and yellow to white.
+	Hot = ColorMap{{0, 0, 0, 255}, {230, 0, 0, 255}, {255, 210, 0, 255}, {255, 255, 255, 255}}
+
+	// Jet goes from dark blue through cyan and yellow to dark red.
+	Jet = ColorMap{
+		{0, 0, 128, 255}, {0, 0, 255, 255}, {0, 255, 255, 255},
+		{255, 255, 0, 255}, {255, 0, 0, 255}, {128, 0, 0, 255},
+	}
+
+	// Viridis is perceptually uniform, from dark purple through teal to yellow.
+	Viridis = ColorMap{
+		{68, 1, 84, 255}, {72, 40, 120, 255}, {62, 73, 137, 255}, {49, 104, 142, 255},
+		{38, 130, 142, 255}, {31, 158, 137, 255}, {53, 183, 121, 255}, {110, 206, 88, 255},
+		{181, 222, 43, 255}, {253, 231, 37, 255},
+	}
+
+	// Magma is perceptually uniform, from black through purple and orange to pale yellow.
+	Magma = ColorMap{
+		{0, 0, 4, 255}, {28, 16, 68, 255}, {79, 18, 123, 255}, {129, 37, 129, 255},
+		{181, 54, 122, 255}, {229, 89, 100, 255}, {251, 135, 97, 255}, {254, 194, 135, 255},
+		{252, 253, 191, 255},
+	}
+
+	// ColorMaps are all the colour maps, by name, e.g. for selection by flag.
+	ColorMaps = map[string]ColorMap{
+		"gray":    Gray,
+		"hot":     Hot,
+		"jet":     Jet,
+		"viridis": Viridis,
+		"magma":   Magma,
+	}
+)
+
+// At returns the colour for a value, clamped to [0, 1].
+func (c ColorMap) At(value float64) color.RGBA {
+	if math.IsNaN(value) || value < 0 {
+		value = 0
+	} else if value > 1 {
+		value = 1
+	}
+	at := value * float64(len(c)-1)
+	lower := int(at)
+	if lower >= len(c)-1 {
+		return c[len(c)-1]
+	}
+	f := at - float64(lower)
+	a, b := c[lower], c[lower+1]
+	return color.RGBA{
+		mix(a.R, b.R, f),
+		mix(a.G, b.G, f),
+		mix(a.B, b.B, f),
+		mix(a.A, b.A, f),
+	}
+}
+
+func mix(a uint8, b uint8, f float64) uint8 {
+	return uint8(float64(a) + f*(float64(b)-float64(a)) + 0.5)
+}
@@ -0,0 +1,309 @@
+package render
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"math/cmplx"
+	"strings"
+
+	"github.com/padster/go-sound/cq"
+)
+
+// Scale is how magnitudes are mapped onto the colour map.
+type Scale int
+
+const (
+	// Linear maps [0, Reference] magnitudes directly.
+	Linear Scale = iota
+	// Logarithmic maps log(1 + magnitude), relative to log(1 + Reference).
+	Logarithmic
+	// Decibels maps the DynamicRange dB below Reference.
+	Decibels
+)
+
+const (
+	// Space for axis labels, in pixels.
+	frequencyAxisWidth = 48
+	timeAxisHeight     = 16
+)
+
+// SpectrogramRenderer draws constant Q columns into images, highest frequency at the top.
+// Columns may be either the raw constant Q output, where lower octaves hold their last
+// value between updates, or the full height columns from a cq.Spectrogram.
+type SpectrogramRenderer struct {
+	params  cq.CQParams
+	hopSize int
+	latency int
+
+	// How magnitudes map to colours.
+	Scale     Scale
+	ColorMap  ColorMap
+	Reference float64
+	// DynamicRange is the range in dB shown below Reference, when using Decibels.
+	DynamicRange float64
+
+	// ColumnsPerPixel combines that many columns (by their maximum) per horizontal pixel.
+	ColumnsPerPixel int
+	// PixelsPerBin is the vertical size of each bin.
+	PixelsPerBin int
+
+	// OctaveGrid draws red lines at each octave, NoteGrid draws faint lines at each semitone.
+	OctaveGrid bool
+	NoteGrid   bool
+	// Labels adds time and frequency axes.
+	Labels bool
+
+	// TileWidth is the most pixel columns written to a single image.
+	TileWidth int
+
+	held []float64
+}
+
+// NewSpectrogramRenderer creates a renderer for columns generated with the given parameters,
+// that are hopSize samples apart and lag the input by latency samples.
+//
+// For example, to write a long file as a series of PNG images:
+//  spectrogram := cq.NewSpectrogram(params)
+//  renderer := render.NewSpectrogramRenderer(params, spectrogram.SamplesPerColumn(), spectrogram.OutputLatency())
+//  renderer.ColumnsPerPixel = 16
+//  paths, err := renderer.WritePNG("out.png", spectrogram.ProcessChannel(samples))
+func NewSpectrogramRenderer(params cq.CQParams, hopSize int, latency int) *SpectrogramRenderer {
+	return &SpectrogramRenderer{
+		params,
+		hopSize,
+		latency,
+		Decibels, /* Scale */
+		Magma,    /* ColorMap */
+		15.0,     /* Reference */
+		80.0,     /* DynamicRange */
+		1,        /* ColumnsPerPixel */
+		1,        /* PixelsPerBin */
+		true,     /* OctaveGrid */
+		false,    /* NoteGrid */
+		true,     /* Labels */
+		4096,     /* TileWidth */
+		nil,      /* held */
+	}
+}
+
+// Render draws all the columns into a single image.
+func (r *SpectrogramRenderer) Render(columns [][]complex128) *image.RGBA {
+	r.checkSettings()
+	r.held = nil
+	pixels := [][]float64{}
+	for at := 0; at < len(columns); at += r.ColumnsPerPixel {
+		end := minInt(at+r.ColumnsPerPixel, len(columns))
+		pixels = append(pixels, r.pixelColumn(columns[at:end]))
+	}
+	return r.drawTile(pixels, 0)
+}
+
+// WritePNG draws a stream of columns to PNG. If they need more than one tile, each is
+// written alongside path with its index appended, e.g. out-000.png, out-001.png, ...
+// It returns the paths of all images written. On failure the rest of the columns are drained,
+// so their producer can finish.
+func (r *SpectrogramRenderer) WritePNG(path string, columns <-chan []complex128) ([]string, error) {
+	r.checkSettings()
+	r.held = nil
+	paths := []string{}
+	write := func(pixels [][]float64, firstColumn int, tilePath string) error {
+		paths = append(paths, tilePath)
+		return writePNG(tilePath, r.drawTile(pixels, firstColumn))
+	}
+
+	// Tiles are only numbered once there's more than one, so one full tile is held back.
+	var pending [][]float64
+	pendingColumn, nextColumn, tileIndex := 0, 0, 0
+	pixels, group := [][]float64{}, [][]complex128{}
+	for column := range columns {
+		group = append(group, column)
+		if len(group) < r.ColumnsPerPixel {
+			continue
+		}
+		pixels = append(pixels, r.pixelColumn(group))
+		group = group[:0]
+		if len(pixels) == r.TileWidth {
+			if pending != nil {
+				if err := write(pending, pendingColumn, tileName(path, tileIndex)); err != nil {
+					go drainColumns(columns)
+					return paths, err
+				}
+				tileIndex++
+			}
+			pending, pendingColumn = pixels, nextColumn
+			nextColumn += len(pixels) * r.ColumnsPerPixel
+			pixels = [][]float64{}
+		}
+	}
+	if len(group) > 0 {
+		pixels = append(pixels, r.pixelColumn(group))
+	}
+
+	if pending == nil {
+		return paths, write(pixels, nextColumn, path)
+	}
+	if err := write(pending, pendingColumn, tileName(path, tileIndex)); err != nil {
+		return paths, err
+	}
+	if len(pixels) > 0 {
+		return paths, write(pixels, nextColumn, tileName(path, tileIndex+1))
+	}
+	return paths, nil
+}
+
+func (r *SpectrogramRenderer) checkSettings() {
+	if r.ColumnsPerPixel < 1 || r.PixelsPerBin < 1 || r.TileWidth < 1 {
+		panic("Spectrogram renderer sizes must all be at least 1")
+	}
+	if len(r.ColorMap) < 2 {
+		panic("Color maps need at least two colours")
+	}
+}
+
+// pixelColumn converts a group of columns into colour map values for each bin.
+func (r *SpectrogramRenderer) pixelColumn(group [][]complex128) []float64 {
+	height := r.params.Octaves * r.params.BinsPerOctave
+	if r.held == nil {
+		r.held = make([]float64, height, height)
+	}
+
+	result := make([]float64, height, height)
+	for _, column := range group {
+		for i := 0; i < height; i++ {
+			if i < len(column) {
+				r.held[i] = cmplx.Abs(column[i])
+				if math.IsNaN(r.held[i]) {
+					r.held[i] = 0
+				}
+			}
+			result[i] = math.Max(result[i], r.held[i])
+		}
+	}
+	for i, v := range result {
+		result[i] = r.scale(v)
+	}
+	return result
+}
+
+// scale maps a magnitude into [0, 1].
+func (r *SpectrogramRenderer) scale(magnitude float64) float64 {
+	switch r.Scale {
+	case Logarithmic:
+		return math.Log1p(magnitude) / math.Log1p(r.Reference)
+	case Decibels:
+		if magnitude <= 0 {
+			return 0
+		}
+		db := 20.0 * math.Log10(magnitude/r.Reference)
+		return 1.0 + db/r.DynamicRange
+	}
+	return magnitude / r.Reference
+}
+
+// drawTile draws scaled pixel columns, the first of which starts at firstColumn.
+func (r *SpectrogramRenderer) drawTile(pixels [][]float64, firstColumn int) *image.RGBA {
+	bpo := r.params.BinsPerOctave
+	height := r.params.Octaves * bpo
+	left, bottom := 0, 0
+	if r.Labels {
+		left, bottom = frequencyAxisWidth, timeAxisHeight
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, left+len(pixels), height*r.PixelsPerBin+bottom))
+	draw.Draw(img, img.Bounds(), image.NewUniform(black), image.ZP, draw.Src)
+
+	for x, column := range pixels {
+		for bin, v := range column {
+			c := r.ColorMap.At(v)
+			for dy := 0; dy < r.PixelsPerBin; dy++ {
+				img.SetRGBA(left+x, bin*r.PixelsPerBin+dy, c)
+			}
+		}
+	}
+
+	right := left + len(pixels)
+	if r.NoteGrid && bpo%12 == 0 {
+		faint := color.NRGBA{255, 255, 255, 48}
+		for bin := bpo / 12; bin < height; bin += bpo / 12 {
+			drawHorizontal(img, left, right, bin*r.PixelsPerBin, faint)
+		}
+	}
+	if r.OctaveGrid {
+		for bin := bpo; bin < height; bin += bpo {
+			drawHorizontal(img, left, right, bin*r.PixelsPerBin, red)
+		}
+	}
+
+	if r.Labels {
+		r.drawFrequencyAxis(img)
+		r.drawTimeAxis(img, left, len(pixels), firstColumn, height*r.PixelsPerBin)
+	}
+	return img
+}
+
+// drawFrequencyAxis labels each octave line with its frequency, the minimum frequency
+// multiplied by a power of two.
+func (r *SpectrogramRenderer) drawFrequencyAxis(img *image.RGBA) {
+	bpo := r.params.BinsPerOctave
+	for octave := 1; octave <= r.params.Octaves; octave++ {
+		y := octave * bpo * r.PixelsPerBin
+		drawLabel(img, 2, y-1, formatHz(r.params.BinFrequency(octave*bpo)), white)
+	}
+}
+
+// drawTimeAxis adds ticks and labels along the bottom of the image.
+func (r *SpectrogramRenderer) drawTimeAxis(img *image.RGBA, left int, width int, firstColumn int, top int) {
+	sampleRate := r.params.SampleRate()
+	secondsPerPixel := float64(r.hopSize*r.ColumnsPerPixel) / sampleRate
+	startSeconds := float64(firstColumn*r.hopSize-r.latency) / sampleRate
+
+	step := timeStep(1.0/secondsPerPixel, 64)
+	first := math.Ceil(startSeconds/step) * step
+	for t := first; ; t += step {
+		x := left + int((t-startSeconds)/secondsPerPixel+0.5)
+		if x >= left+width {
+			break
+		}
+		for y := top; y < top+3; y++ {
+			img.SetRGBA(x, y, white)
+		}
+		if math.Abs(t) < step/2 {
+			t = 0 // Avoid labelling -0s
+		}
+		label := formatTime(t, step)
+		if x+labelWidth(label) <= left+width {
+			drawLabel(img, x+2, top+timeAxisHeight-3, label, white)
+		}
+	}
+}
+
+// drawHorizontal blends a line of colour across [left, right) at height y.
+func drawHorizontal(img *image.RGBA, left int, right int, y int, c color.Color) {
+	line := image.Rect(left, y, right, y+1)
+	draw.Draw(img, line, image.NewUniform(c), image.ZP, draw.Over)
+}
+
+// tileName inserts a tile index before the extension of path.
+func tileName(path string, index int) string {
+	extension := ".png"
+	if strings.HasSuffix(strings.ToLower(path), extension) {
+		path = path[:len(path)-len(extension)]
+	}
+	return fmt.Sprintf("%s-%03d%s", path, index, extension)
+}
+
+// drainColumns reads the rest of a channel, so its producer can finish.
+func drainColumns(columns <-chan []complex128) {
+	for range columns {
+	}
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
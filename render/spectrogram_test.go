@@ -0,0 +1,132 @@
+package render
+
+// go test github.com/padster/go-sound/render
+
+import (
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/padster/go-sound/cq"
+)
+
+func TestTileName(t *testing.T) {
+	tests := []struct {
+		path     string
+		index    int
+		expected string
+	}{
+		{"out.png", 0, "out-000.png"},
+		{"dir/out.png", 12, "dir/out-012.png"},
+		{"OUT.PNG", 3, "OUT-003.png"},
+		{"out", 1, "out-001.png"},
+	}
+	for _, test := range tests {
+		if name := tileName(test.path, test.index); name != test.expected {
+			t.Errorf("Tile %d of %s is %s, expected %s", test.index, test.path, name, test.expected)
+		}
+	}
+}
+
+func TestWriteSpectrogramTiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spectrogram_")
+	if err != nil {
+		t.Fatalf("Can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// 24 bins high, and two columns per pixel.
+	params := cq.NewCQParams(44100, 2, 110.0, 12)
+	tests := []struct {
+		name    string
+		columns int
+		labels  bool
+		// Expected file names, and widths of the images before any labels.
+		expected []string
+		widths   []int
+	}{
+		{"one.png", 19, false, []string{"one.png"}, []int{10}},
+		{"many.png", 45, false, []string{"many-000.png", "many-001.png", "many-002.png"}, []int{10, 10, 3}},
+		{"full.png", 40, true, []string{"full-000.png", "full-001.png"}, []int{10, 10}},
+	}
+	for _, test := range tests {
+		renderer := NewSpectrogramRenderer(params, 512, 0)
+		renderer.ColumnsPerPixel, renderer.TileWidth, renderer.Labels = 2, 10, test.labels
+		paths, err := renderer.WritePNG(filepath.Join(dir, test.name), testSpectrogramColumns(test.columns, nil))
+		if err != nil {
+			t.Fatalf("%s: can't write tiles: %v", test.name, err)
+		}
+
+		expected := make([]string, len(test.expected), len(test.expected))
+		for i, name := range test.expected {
+			expected[i] = filepath.Join(dir, name)
+		}
+		if !reflect.DeepEqual(paths, expected) {
+			t.Errorf("%s: wrote %v, expected %v", test.name, paths, expected)
+			continue
+		}
+
+		for i, path := range paths {
+			width, height := test.widths[i], 24
+			if test.labels {
+				width, height = width+frequencyAxisWidth, height+timeAxisHeight
+			}
+			if bounds := readPNGBounds(t, path); bounds.Dx() != width || bounds.Dy() != height {
+				t.Errorf("%s is %dx%d, expected %dx%d", path, bounds.Dx(), bounds.Dy(), width, height)
+			}
+		}
+	}
+}
+
+func TestWriteSpectrogramDrainsOnError(t *testing.T) {
+	params := cq.NewCQParams(44100, 2, 110.0, 12)
+	renderer := NewSpectrogramRenderer(params, 512, 0)
+	renderer.TileWidth = 10
+
+	// The first tile is written, and fails, part way through the columns.
+	done := make(chan bool)
+	path := filepath.Join(os.DevNull, "missing", "out.png")
+	if _, err := renderer.WritePNG(path, testSpectrogramColumns(100, done)); err == nil {
+		t.Fatalf("Expected writing to %s to fail", path)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("Columns were left undrained after the write failed")
+	}
+}
+
+// testSpectrogramColumns sends full height columns, closing done (if given) after the last.
+func testSpectrogramColumns(count int, done chan bool) <-chan []complex128 {
+	result := make(chan []complex128)
+	go func() {
+		for i := 0; i < count; i++ {
+			column := make([]complex128, 24, 24)
+			column[i%24] = 15.0
+			result <- column
+		}
+		close(result)
+		if done != nil {
+			close(done)
+		}
+	}()
+	return result
+}
+
+func readPNGBounds(t *testing.T, path string) image.Rectangle {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Can't open %s: %v", path, err)
+	}
+	defer file.Close()
+	img, err := png.Decode(file)
+	if err != nil {
+		t.Fatalf("Can't decode %s: %v", path, err)
+	}
+	return img.Bounds()
+}
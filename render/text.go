@@ -0,0 +1,84 @@
+package render
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+var (
+	black = color.RGBA{0, 0, 0, 255}
+	white = color.RGBA{255, 255, 255, 255}
+	red   = color.RGBA{255, 0, 0, 255}
+)
+
+// Readable label intervals, in seconds.
+var timeSteps = []float64{0.01, 0.02, 0.05, 0.1, 0.2, 0.5, 1, 2, 5, 10, 15, 30, 60, 120, 300, 600}
+
+// drawLabel writes text with its baseline-left at (x, y).
+func drawLabel(img draw.Image, x int, y int, text string, c color.Color) {
+	d := &font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(c),
+		Face: basicfont.Face7x13,
+		Dot:  fixed.P(x, y),
+	}
+	d.DrawString(text)
+}
+
+// labelWidth is how many pixels wide text will be drawn.
+func labelWidth(text string) int {
+	return font.MeasureString(basicfont.Face7x13, text).Ceil()
+}
+
+// timeStep picks the smallest readable interval that keeps time labels at least minPixels apart.
+func timeStep(pixelsPerSecond float64, minPixels float64) float64 {
+	for _, step := range timeSteps {
+		if step*pixelsPerSecond >= minPixels {
+			return step
+		}
+	}
+	return timeSteps[len(timeSteps)-1]
+}
+
+// formatTime labels a time in seconds, switching to minutes:seconds for long sounds.
+func formatTime(seconds float64, step float64) string {
+	if seconds >= 60 && step >= 1 {
+		return fmt.Sprintf("%d:%02d", int(seconds)/60, int(seconds)%60)
+	}
+	switch {
+	case step < 0.1:
+		return fmt.Sprintf("%.2fs", seconds)
+	case step < 1:
+		return fmt.Sprintf("%.1fs", seconds)
+	}
+	return fmt.Sprintf("%.0fs", seconds)
+}
+
+// formatHz labels a frequency, in kHz above 1000Hz.
+func formatHz(hz float64) string {
+	if hz >= 1000 {
+		return fmt.Sprintf("%.3gk", hz/1000)
+	}
+	return fmt.Sprintf("%.0f", hz)
+}
+
+// writePNG encodes an image to a new file at path.
+func writePNG(path string, img image.Image) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(file, img); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,313 @@
+package render
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Event is something that occurred at a single sample of the values, like util.Event.
+type Event struct {
+	// (r, g, b) colours for the event.
+	R float32
+	G float32
+	B float32
+}
+
+// Line is the samples channel for the line, plus their color, like util.Line.
+type Line struct {
+	Values <-chan float64
+	R      float32
+	G      float32
+	B      float32
+}
+
+// NewLine creates a line from a channel of samples and its colour.
+func NewLine(v <-chan float64, r float32, g float32, b float32) Line {
+	return Line{v, r, g, b}
+}
+
+// envelope is the shape of one line within a single pixel column.
+type envelope struct {
+	min, max, rms float64
+	empty         bool
+}
+
+// eventMark is an event to draw at a pixel column.
+type eventMark struct {
+	x     int
+	color color.RGBA
+}
+
+// WaveformRenderer draws lines of samples into images, showing for each pixel column
+// the min/max range of the samples covered, and (more brightly) their RMS.
+type WaveformRenderer struct {
+	width           int
+	height          int
+	samplesPerPixel int
+
+	// StartSample is how many samples to skip before the first pixel column.
+	StartSample int
+
+	// Gain scales the samples vertically, so that quiet sounds can be zoomed into.
+	Gain float64
+
+	// Background colour behind the lines.
+	Background color.RGBA
+
+	// Whether to draw the min/max range, and the RMS, of each pixel column.
+	ShowRange bool
+	ShowRMS   bool
+}
+
+// NewWaveformRenderer creates a renderer for images of a given size and sample density.
+//
+// For example, to draw a sound with a beat marked in red:
+//  sound.Start()
+//  renderer := render.NewWaveformRenderer(1200, 200, 100)
+//  err := renderer.WritePNG("wave.png", []render.Line{
+//    render.NewLine(sound.GetSamples(), 1.0, 1.0, 1.0),
+//  }, features.EventChannel(beats, 120000, render.Event{1.0, 0.0, 0.0}))
+//  sound.Stop()
+func NewWaveformRenderer(width int, height int, samplesPerPixel int) *WaveformRenderer {
+	if width < 1 || height < 1 || samplesPerPixel < 1 {
+		panic("Waveform sizes must all be at least 1")
+	}
+	return &WaveformRenderer{
+		width,
+		height,
+		samplesPerPixel,
+		0,                        /* StartSample */
+		1.0,                      /* Gain */
+		color.RGBA{0, 0, 0, 255}, /* Background */
+		true,                     /* ShowRange */
+		true,                     /* ShowRMS */
+	}
+}
+
+// Render draws the lines, plus the events (either nil or an Event for each sample), into an image.
+// Samples and events past the end of the image are read and discarded in the background, so
+// whatever produces them can finish.
+func (w *WaveformRenderer) Render(lines []Line, events <-chan interface{}) *image.RGBA {
+	envelopes, marks := w.measure(lines, events)
+
+	img := image.NewRGBA(image.Rect(0, 0, w.width, w.height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(w.Background), image.ZP, draw.Src)
+
+	for i, line := range lines {
+		c := lineColor(line)
+		faded := color.NRGBA{c.R, c.G, c.B, 128}
+		for x, e := range envelopes[i] {
+			if e.empty {
+				continue
+			}
+			if w.ShowRange {
+				top, bottom := w.toY(e.max), w.toY(e.min)
+				draw.Draw(img, image.Rect(x, top, x+1, bottom+1), image.NewUniform(faded), image.ZP, draw.Over)
+			}
+			if w.ShowRMS {
+				top, bottom := w.toY(e.rms), w.toY(-e.rms)
+				draw.Draw(img, image.Rect(x, top, x+1, bottom+1), image.NewUniform(c), image.ZP, draw.Over)
+			}
+		}
+	}
+
+	for _, mark := range marks {
+		for y := 0; y < w.height; y++ {
+			img.SetRGBA(mark.x, y, mark.color)
+		}
+	}
+	return img
+}
+
+// WritePNG renders the lines and events to a PNG file.
+func (w *WaveformRenderer) WritePNG(path string, lines []Line, events <-chan interface{}) error {
+	return writePNG(path, w.Render(lines, events))
+}
+
+// WriteSVG renders the lines and events to an SVG file, with one filled path per envelope.
+func (w *WaveformRenderer) WriteSVG(path string, lines []Line, events <-chan interface{}) error {
+	envelopes, marks := w.measure(lines, events)
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(file)
+	fmt.Fprintf(out, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\">\n",
+		w.width, w.height, w.width, w.height)
+	fmt.Fprintf(out, "<rect width=\"100%%\" height=\"100%%\" fill=\"%s\"/>\n", svgColor(w.Background))
+
+	for i, line := range lines {
+		fill := svgColor(lineColor(line))
+		if w.ShowRange {
+			fmt.Fprintf(out, "<path fill=\"%s\" fill-opacity=\"0.5\" d=\"%s\"/>\n", fill,
+				w.envelopePath(envelopes[i], func(e envelope) (float64, float64) { return e.max, e.min }))
+		}
+		if w.ShowRMS {
+			fmt.Fprintf(out, "<path fill=\"%s\" d=\"%s\"/>\n", fill,
+				w.envelopePath(envelopes[i], func(e envelope) (float64, float64) { return e.rms, -e.rms }))
+		}
+	}
+	for _, mark := range marks {
+		fmt.Fprintf(out, "<line x1=\"%d.5\" y1=\"0\" x2=\"%d.5\" y2=\"%d\" stroke=\"%s\"/>\n",
+			mark.x, mark.x, w.height, svgColor(mark.color))
+	}
+	fmt.Fprintln(out, "</svg>")
+
+	if err := out.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// measure reads the samples and events covered by the image, and summarizes them per pixel column.
+// Channels are read concurrently, in case they are being written in lockstep.
+func (w *WaveformRenderer) measure(lines []Line, events <-chan interface{}) ([][]envelope, []eventMark) {
+	var wg sync.WaitGroup
+	result := make([][]envelope, len(lines), len(lines))
+	for i, line := range lines {
+		wg.Add(1)
+		go func(i int, values <-chan float64) {
+			result[i] = w.lineEnvelopes(values)
+			wg.Done()
+		}(i, line.Values)
+	}
+
+	marks := []eventMark{}
+	if events != nil {
+		wg.Add(1)
+		go func() {
+			marks = w.eventMarks(events)
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	return result, marks
+}
+
+// lineEnvelopes calculates the min, max and RMS of each pixel column's samples.
+func (w *WaveformRenderer) lineEnvelopes(values <-chan float64) []envelope {
+	result := make([]envelope, w.width, w.width)
+	for x := range result {
+		result[x].empty = true
+	}
+
+	at, end := 0, w.StartSample+w.width*w.samplesPerPixel
+	sumSquares := make([]float64, w.width, w.width)
+	counts := make([]int, w.width, w.width)
+	for sample := range values {
+		if at >= w.StartSample {
+			x := (at - w.StartSample) / w.samplesPerPixel
+			v := sample * w.Gain
+			e := &result[x]
+			if e.empty {
+				e.min, e.max, e.empty = v, v, false
+			} else {
+				e.min, e.max = math.Min(e.min, v), math.Max(e.max, v)
+			}
+			sumSquares[x] += v * v
+			counts[x]++
+		}
+		at++
+		if at == end {
+			go drainValues(values)
+			break
+		}
+	}
+
+	for x := range result {
+		if counts[x] > 0 {
+			result[x].rms = math.Sqrt(sumSquares[x] / float64(counts[x]))
+		}
+	}
+	return result
+}
+
+// eventMarks finds the pixel columns of events within the image.
+func (w *WaveformRenderer) eventMarks(events <-chan interface{}) []eventMark {
+	result := []eventMark{}
+	at, end := 0, w.StartSample+w.width*w.samplesPerPixel
+	for value := range events {
+		if e, ok := value.(Event); ok && at >= w.StartSample {
+			result = append(result, eventMark{(at - w.StartSample) / w.samplesPerPixel, eventColor(e)})
+		}
+		at++
+		if at == end {
+			go drainEvents(events)
+			break
+		}
+	}
+	return result
+}
+
+// envelopePath builds an SVG path along the upper bounds left to right, then back along the lower.
+func (w *WaveformRenderer) envelopePath(envelopes []envelope, bounds func(envelope) (float64, float64)) string {
+	upper, lower := []string{}, []string{}
+	for x, e := range envelopes {
+		if e.empty {
+			continue
+		}
+		top, bottom := bounds(e)
+		upper = append(upper, fmt.Sprintf("%d %d %d %d", x, w.toY(top), x+1, w.toY(top)))
+		lower = append(lower, fmt.Sprintf("%d %d %d %d", x+1, w.toY(bottom)+1, x, w.toY(bottom)+1))
+	}
+	if len(upper) == 0 {
+		return ""
+	}
+	for i, j := 0, len(lower)-1; i < j; i, j = i+1, j-1 {
+		lower[i], lower[j] = lower[j], lower[i]
+	}
+	return "M" + strings.Join(upper, " L") + " L" + strings.Join(lower, " L") + " Z"
+}
+
+// toY maps a sample value in [-1, 1] to a pixel row, clamped to the image.
+func (w *WaveformRenderer) toY(value float64) int {
+	y := int(math.Floor((1.0 - value) / 2.0 * float64(w.height)))
+	if y < 0 {
+		return 0
+	} else if y >= w.height {
+		return w.height - 1
+	}
+	return y
+}
+
+// drainValues reads the rest of a channel, so its producer can finish.
+func drainValues(values <-chan float64) {
+	for range values {
+	}
+}
+
+// drainEvents reads the rest of a channel, so its producer can finish.
+func drainEvents(events <-chan interface{}) {
+	for range events {
+	}
+}
+
+func lineColor(line Line) color.RGBA {
+	return toRGBA(line.R, line.G, line.B)
+}
+
+func eventColor(e Event) color.RGBA {
+	return toRGBA(e.R, e.G, e.B)
+}
+
+// toRGBA converts [0, 1] colour components, as used by OpenGL, to an opaque colour.
+func toRGBA(r float32, g float32, b float32) color.RGBA {
+	component := func(v float32) uint8 {
+		return uint8(math.Max(0, math.Min(255, float64(v)*255+0.5)))
+	}
+	return color.RGBA{component(r), component(g), component(b), 255}
+}
+
+func svgColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
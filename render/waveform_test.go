@@ -0,0 +1,150 @@
+package render
+
+// go test github.com/padster/go-sound/render
+
+import (
+	"encoding/xml"
+	"image/color"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// A 100x50 image of 1000 samples of a half amplitude sine, with an event at pixel column 50.
+const (
+	testWaveWidth    = 100
+	testWaveHeight   = 50
+	testWavePerPixel = 10
+)
+
+func TestRenderWaveform(t *testing.T) {
+	renderer := NewWaveformRenderer(testWaveWidth, testWaveHeight, testWavePerPixel)
+	img := renderer.Render([]Line{NewLine(testWaveSine(1000, nil), 1.0, 1.0, 1.0)}, testWaveEvents(1000, nil))
+	if bounds := img.Bounds(); bounds.Dx() != testWaveWidth || bounds.Dy() != testWaveHeight {
+		t.Fatalf("Image is %v, expected %dx%d", bounds, testWaveWidth, testWaveHeight)
+	}
+
+	// Each pixel column covers a whole period, from -0.4755 to 0.4755 with an RMS of 0.3536,
+	// so the range covers rows 13-36, and the RMS rows 16-33.
+	background, faded, white := color.RGBA{0, 0, 0, 255}, color.RGBA{128, 128, 128, 255}, color.RGBA{255, 255, 255, 255}
+	red := color.RGBA{255, 0, 0, 255}
+	for y := 0; y < testWaveHeight; y++ {
+		expected := background
+		if y >= 13 && y <= 36 {
+			expected = faded
+		}
+		if y >= 16 && y <= 33 {
+			expected = white
+		}
+		if c := img.RGBAAt(10, y); !closeColor(c, expected) {
+			t.Errorf("Pixel (10, %d) is %v, expected %v", y, c, expected)
+		}
+		if c := img.RGBAAt(50, y); c != red {
+			t.Errorf("Event pixel (50, %d) is %v, expected %v", y, c, red)
+		}
+	}
+}
+
+func TestWriteWaveformSVG(t *testing.T) {
+	dir, err := ioutil.TempDir("", "waveform_")
+	if err != nil {
+		t.Fatalf("Can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wave.svg")
+	renderer := NewWaveformRenderer(testWaveWidth, testWaveHeight, testWavePerPixel)
+	if err := renderer.WriteSVG(path, []Line{NewLine(testWaveSine(1000, nil), 1.0, 0.0, 0.0)}, testWaveEvents(1000, nil)); err != nil {
+		t.Fatalf("Can't write SVG: %v", err)
+	}
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Can't read SVG: %v", err)
+	}
+
+	var svg struct {
+		Width  int `xml:"width,attr"`
+		Height int `xml:"height,attr"`
+		Paths  []struct {
+			Fill    string `xml:"fill,attr"`
+			Opacity string `xml:"fill-opacity,attr"`
+		} `xml:"path"`
+		Lines []struct {
+			X1 string `xml:"x1,attr"`
+		} `xml:"line"`
+	}
+	if err := xml.Unmarshal(contents, &svg); err != nil {
+		t.Fatalf("Can't parse SVG: %v\n%s", err, contents)
+	}
+	if svg.Width != testWaveWidth || svg.Height != testWaveHeight {
+		t.Errorf("SVG is %dx%d, expected %dx%d", svg.Width, svg.Height, testWaveWidth, testWaveHeight)
+	}
+	// One faded path for the range, then a solid one for the RMS.
+	if len(svg.Paths) != 2 || svg.Paths[0].Opacity != "0.5" || svg.Paths[1].Opacity != "" ||
+		svg.Paths[0].Fill != "#ff0000" || svg.Paths[1].Fill != "#ff0000" {
+		t.Errorf("SVG paths are %+v, expected a range and an RMS path in red", svg.Paths)
+	}
+	if len(svg.Lines) != 1 || svg.Lines[0].X1 != "50.5" {
+		t.Errorf("SVG event lines are %+v, expected one at x = 50.5", svg.Lines)
+	}
+}
+
+func TestRenderDrainsWaveform(t *testing.T) {
+	valuesDone, eventsDone := make(chan bool), make(chan bool)
+	renderer := NewWaveformRenderer(testWaveWidth, testWaveHeight, testWavePerPixel)
+	renderer.Render([]Line{NewLine(testWaveSine(2000, valuesDone), 1.0, 1.0, 1.0)}, testWaveEvents(2000, eventsDone))
+
+	// The producers only finish once everything has been read, even past the image.
+	for _, done := range []chan bool{valuesDone, eventsDone} {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Errorf("Samples past the end of the image were left undrained")
+		}
+	}
+}
+
+// testWaveSine sends a half amplitude sine with a period of 10 samples, closing done (if given) after the last.
+func testWaveSine(length int, done chan bool) <-chan float64 {
+	result := make(chan float64)
+	go func() {
+		for i := 0; i < length; i++ {
+			result <- 0.5 * math.Sin(2.0*math.Pi*float64(i)/10.0)
+		}
+		close(result)
+		if done != nil {
+			close(done)
+		}
+	}()
+	return result
+}
+
+// testWaveEvents sends a red event at sample 505 and nil elsewhere, closing done (if given) after the last.
+func testWaveEvents(length int, done chan bool) <-chan interface{} {
+	result := make(chan interface{})
+	go func() {
+		for i := 0; i < length; i++ {
+			if i == 505 {
+				result <- Event{1.0, 0.0, 0.0}
+			} else {
+				result <- nil
+			}
+		}
+		close(result)
+		if done != nil {
+			close(done)
+		}
+	}()
+	return result
+}
+
+// closeColor returns whether two colours are within blending error of each other.
+func closeColor(a color.RGBA, b color.RGBA) bool {
+	near := func(x uint8, y uint8) bool {
+		return math.Abs(float64(x)-float64(y)) <= 2
+	}
+	return near(a.R, b.R) && near(a.G, b.G) && near(a.B, b.B) && a.A == b.A
+}
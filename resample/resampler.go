@@ -1,4 +1,10 @@
-package cq
+// Package resample converts samples between sample rates, using a windowed sinc filter
+// split into phases so only the non-zero input samples are multiplied.
+//
+// Output lags the input by GetLatency() samples. For example, to halve the rate of some samples:
+//  r := resample.NewResampler(44100, 22050, 50, 0.05)
+//  output := r.Process(input)
+package resample
 
 import (
 	"fmt"
@@ -247,6 +253,12 @@ func (r *Resampler) GetLatency() int {
 	return r.latency
 }
 
+// GetFilterLength returns how many source samples the filter spans, so how long it takes
+// for the output to stop depending on the zeros the buffer starts with.
+func (r *Resampler) GetFilterLength() int {
+	return r.filterLength
+}
+
 func (r *Resampler) Process(src []float64) []float64 {
 	n := len(src)
 	r.buffer = append(r.buffer, src...)
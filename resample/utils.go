@@ -0,0 +1,49 @@
+package resample
+
+import (
+	"math"
+)
+
+// Math Utils
+
+func roundUp(x float64) int {
+	return int(math.Ceil(x))
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	} else {
+		return b
+	}
+}
+
+func calcGcd(a int, b int) int {
+	if b == 0 {
+		return a
+	}
+	return calcGcd(b, a%b)
+}
+
+func bessel0(x float64) float64 {
+	b := 0.0
+	for i := 0; i < 20; i++ {
+		b += besselTerm(x, i)
+	}
+	return b
+}
+
+func besselTerm(x float64, i int) float64 {
+	if i == 0 {
+		return 1.0
+	}
+	f := float64(factorial(i))
+	return math.Pow(x/2.0, float64(i)*2.0) / (f * f)
+}
+
+func factorial(i int) int {
+	if i == 0 {
+		return 1
+	}
+	return i * factorial(i-1)
+}
@@ -3,11 +3,10 @@ package main
 import (
 	"flag"
 	"fmt"
-	"math/cmplx"
-	"runtime"
 	"time"
 
 	"github.com/padster/go-sound/cq"
+	"github.com/padster/go-sound/cq/ops"
 	f "github.com/padster/go-sound/file"
 	"github.com/padster/go-sound/output"
 	s "github.com/padster/go-sound/sounds"
@@ -15,8 +14,6 @@ import (
 
 // Runs CQ, applies some processing, and plays the result.
 func main() {
-	runtime.GOMAXPROCS(4)
-
 	// Parse flags...
 	sampleRate := s.CyclesPerSecond
 	octaves := flag.Int("octaves", 7, "Range in octaves")
@@ -55,7 +52,15 @@ func main() {
 	fmt.Printf("TODO: Skip latency (= %d) samples)\n", latency)
 	columns := constantQ.ProcessChannel(inputSound.GetSamples())
 	columns2 := constantQ2.ProcessChannel(inputSound2.GetSamples())
-	samples := cqInverse.ProcessChannel(mergeChannels(columns, columns2))
+	// Unit magnitudes with the phases of the first input, paired column by column with the second.
+	unitMagnitudes := ops.Map(func(column []complex128) []complex128 {
+		ones := make([]complex128, len(column), len(column))
+		for i := range ones {
+			ones[i] = 1
+		}
+		return ones
+	})
+	samples := cqInverse.ProcessChannel(ops.CrossSynthesis(unitMagnitudes(columns2), columns))
 	asSound := s.WrapChannelAsSound(samples)
 
 	// if outputFile != "" {
@@ -67,45 +72,3 @@ func main() {
 	elapsedSeconds := time.Since(startTime).Seconds()
 	fmt.Printf("elapsed time (not counting init): %f sec\n", elapsedSeconds)
 }
-
-func mergeChannels(in1 <-chan []complex128, in2 <-chan []complex128) chan []complex128 {
-	out := make(chan []complex128)
-	go func() {
-		fmt.Printf("Writing...\n")
-		for cIn1 := range in1 {
-			cIn2 := <-in2
-			if len(cIn1) != len(cIn2) {
-				panic("oops, columns don't match... :(")
-			}
-			cOut := make([]complex128, len(cIn1), len(cIn1))
-			for i := range cIn1 {
-				power1, angle1 := cmplx.Polar(cIn1[i])
-				power1 = 1.0
-				power2, angle2 := cmplx.Polar(cIn2[i])
-				cOut[i] = cmplx.Rect(power1, angle1)
-				// if i > 48 && i <= 72 {
-				// cOut[i] = 0
-				// }
-				// HACK variable to stop go complaining about unused variables :(
-				cIn2[i] = cmplx.Rect(power2, angle2)
-			}
-			out <- cOut
-		}
-		close(out)
-	}()
-	return out
-}
-
-func shiftChannel(buckets int, in <-chan []complex128) chan []complex128 {
-	out := make(chan []complex128)
-	go func(b int) {
-		for cIn := range in {
-			s := len(cIn)
-			cOut := make([]complex128, s, s)
-			copy(cOut, cIn[buckets:])
-			out <- cOut
-		}
-		close(out)
-	}(buckets)
-	return out
-}
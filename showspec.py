@@ -4,14 +4,25 @@
 
 import matplotlib.pylab
 import numpy as np
+import struct
 import sys
 import subprocess
 
 bins = 24
 octaves = 7
+offset = 0
+
+# Files with a header (see file.WriteCQHeader) describe their own size.
+with open("out.raw", 'rb') as f:
+    start = f.read(8)
+    if len(start) == 8 and start[:4] == 'GOCQ':
+        version, size = struct.unpack('<HH', start[4:])
+        _, octaves, _, bins = struct.unpack('<didi', f.read(24))
+        offset = 8 + size
+
 # TODO: Pass bins to go run
 # subprocess.call(["go", "run", "cqspectrogram.go"] + sys.argv[1:2])
-ys1 = np.memmap("out.raw", dtype=np.complex64, mode="r").reshape((-1, bins*octaves)).T
+ys1 = np.memmap("out.raw", dtype=np.complex64, mode="r", offset=offset).reshape((-1, bins*octaves)).T
 ys1 = np.nan_to_num(ys1.copy())
 
 # ys1[numpy.abs(ys1) < 1e-6] = 0
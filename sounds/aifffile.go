@@ -0,0 +1,27 @@
+package sounds
+
+import (
+	"os"
+)
+
+// LoadAiffAsSound loads an AIFF, or uncompressed AIFF-C, file and converts one of its channels, or
+// MixDown for the average of them all, into a Sound. Files at other sample rates are resampled.
+//
+// For example, to read the average of the channels of a local file at 'loop.aiff':
+//  sounds.LoadAiffAsSound("loop.aiff", sounds.MixDown)
+func LoadAiffAsSound(path string, channel int) Sound {
+	file, err := os.Open(path)
+	if err != nil {
+		panic(err)
+	}
+	reader, err := newAiffReader(file)
+	file.Close()
+	if err != nil {
+		panic(err)
+	}
+
+	return newDecodedFileSound("Aiff", path, channel, reader.channels, reader.sampleRate, reader.frames,
+		func(file *os.File) (frameReader, error) {
+			return newAiffReader(file)
+		})
+}
@@ -0,0 +1,187 @@
+package sounds
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// aiffReader decodes the samples of an AIFF or uncompressed AIFF-C file, one frame at a time.
+type aiffReader struct {
+	channels   int
+	sampleRate int
+	frames     int64
+
+	// How each sample is stored: big endian signed integers unless littleEndian or float.
+	littleEndian bool
+	float        bool
+
+	data       io.Reader
+	frame      []byte
+	framesLeft int64
+}
+
+// newAiffReader reads the header of an AIFF file, leaving the reader at the start of its samples.
+func newAiffReader(r io.ReadSeeker) (*aiffReader, error) {
+	header := make([]byte, 12, 12)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("Can't read aiff header: %v", err)
+	}
+	form := string(header[8:12])
+	if string(header[0:4]) != "FORM" || (form != "AIFF" && form != "AIFC") {
+		return nil, errors.New("Not an AIFF file")
+	}
+
+	var reader *aiffReader
+	bits, dataStart := 0, int64(-1)
+	for {
+		chunk := make([]byte, 8, 8)
+		if _, err := io.ReadFull(r, chunk); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("Can't read aiff chunk: %v", err)
+		}
+		id, size := string(chunk[0:4]), int64(binary.BigEndian.Uint32(chunk[4:8]))
+		start, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+
+		switch id {
+		case "COMM":
+			body := make([]byte, size, size)
+			if _, err := io.ReadFull(r, body); err != nil {
+				return nil, fmt.Errorf("Can't read aiff common chunk: %v", err)
+			}
+			if reader, bits, err = parseAiffCommon(body, form == "AIFC"); err != nil {
+				return nil, err
+			}
+		case "SSND":
+			// The samples start after an offset, which is almost always zero.
+			offset := make([]byte, 8, 8)
+			if _, err := io.ReadFull(r, offset); err != nil {
+				return nil, fmt.Errorf("Can't read aiff sound chunk: %v", err)
+			}
+			dataStart = start + 8 + int64(binary.BigEndian.Uint32(offset[0:4]))
+		}
+
+		// Chunks are padded to an even length.
+		if _, err := r.Seek(start+size+size%2, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+
+	if reader == nil {
+		return nil, errors.New("Aiff file has no common chunk")
+	}
+	// Files without samples may leave out the sound chunk.
+	if dataStart < 0 {
+		if reader.frames > 0 {
+			return nil, errors.New("Aiff file has no sound chunk")
+		}
+	} else if _, err := r.Seek(dataStart, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	reader.data = r
+	reader.frame = make([]byte, reader.channels*((bits+7)/8))
+	reader.framesLeft = reader.frames
+	return reader, nil
+}
+
+// parseAiffCommon reads a COMM chunk, returning a reader without its data, and the sample size.
+func parseAiffCommon(b []byte, compressed bool) (*aiffReader, int, error) {
+	if len(b) < 18 || (compressed && len(b) < 22) {
+		return nil, 0, errors.New("Aiff common chunk too short")
+	}
+	reader := &aiffReader{}
+	reader.channels = int(binary.BigEndian.Uint16(b[0:2]))
+	reader.frames = int64(binary.BigEndian.Uint32(b[2:6]))
+	bits := int(binary.BigEndian.Uint16(b[6:8]))
+	rate := extendedToFloat(b[8:18])
+
+	kind := "NONE"
+	if compressed {
+		kind = string(b[18:22])
+	}
+	switch kind {
+	case "NONE", "twos":
+	case "sowt":
+		reader.littleEndian = true
+	case "fl32", "FL32":
+		reader.float, bits = true, 32
+	case "fl64", "FL64":
+		reader.float, bits = true, 64
+	default:
+		return nil, 0, fmt.Errorf("Unsupported AIFF-C compression %q", kind)
+	}
+
+	switch {
+	case reader.channels < 1:
+		return nil, 0, errors.New("Aiff file has no channels")
+	case rate < 1 || rate > math.MaxInt32:
+		return nil, 0, fmt.Errorf("Invalid aiff sample rate %v", rate)
+	case bits < 1 || bits > 32 && !reader.float:
+		return nil, 0, fmt.Errorf("Unsupported aiff sample size: %d bits", bits)
+	}
+	reader.sampleRate = int(rate + 0.5)
+	return reader, bits, nil
+}
+
+// ReadFrame reads the next sample of every channel, returning io.EOF after the last.
+func (r *aiffReader) ReadFrame(frame []float64) error {
+	if r.framesLeft == 0 {
+		return io.EOF
+	}
+	if _, err := io.ReadFull(r.data, r.frame); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return io.EOF
+		}
+		return err
+	}
+	r.framesLeft--
+
+	size := len(r.frame) / r.channels
+	for c := range frame {
+		frame[c] = r.decodeSample(r.frame[c*size : (c+1)*size])
+	}
+	return nil
+}
+
+// decodeSample converts a single stored sample to [-1, 1].
+func (r *aiffReader) decodeSample(b []byte) float64 {
+	if r.float {
+		if len(b) == 4 {
+			return float64(math.Float32frombits(binary.BigEndian.Uint32(b)))
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b))
+	}
+
+	// Samples are left justified within whole bytes, so scale by the size of those.
+	value := int32(0)
+	if r.littleEndian {
+		value = int32(int8(b[len(b)-1]))
+		for i := len(b) - 2; i >= 0; i-- {
+			value = value<<8 | int32(b[i])
+		}
+	} else {
+		value = int32(int8(b[0]))
+		for _, v := range b[1:] {
+			value = value<<8 | int32(v)
+		}
+	}
+	return float64(value) / float64(int64(1)<<uint(8*len(b)-1))
+}
+
+// extendedToFloat converts an 80 bit IEEE 754 extended precision number, as used for AIFF sample rates.
+func extendedToFloat(b []byte) float64 {
+	exponent := int(binary.BigEndian.Uint16(b[0:2]) & 0x7FFF)
+	mantissa := binary.BigEndian.Uint64(b[2:10])
+	value := math.Ldexp(float64(mantissa), exponent-16383-63)
+	if b[0]&0x80 != 0 {
+		return -value
+	}
+	return value
+}
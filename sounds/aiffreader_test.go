@@ -0,0 +1,160 @@
+package sounds
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+)
+
+// buildAiff generates an AIFF file, or AIFF-C if the compression kind is given, with an
+// odd length chunk before the samples.
+func buildAiff(kind string, channels int, rate int, bits int, samples []float64) []byte {
+	var comm bytes.Buffer
+	binary.Write(&comm, binary.BigEndian, uint16(channels))
+	binary.Write(&comm, binary.BigEndian, uint32(len(samples)/channels))
+	binary.Write(&comm, binary.BigEndian, uint16(bits))
+	// 80 bit extended precision, with an explicit leading one in the mantissa.
+	exponent, mantissa := 16383+63, uint64(rate)
+	for mantissa&(1<<63) == 0 {
+		mantissa <<= 1
+		exponent--
+	}
+	binary.Write(&comm, binary.BigEndian, uint16(exponent))
+	binary.Write(&comm, binary.BigEndian, mantissa)
+	if kind != "" {
+		comm.WriteString(kind)
+		comm.Write([]byte{0, 0}) // Empty name, padded.
+	}
+
+	var ssnd bytes.Buffer
+	binary.Write(&ssnd, binary.BigEndian, []uint32{0, 0})
+	for _, v := range samples {
+		var order binary.ByteOrder = binary.BigEndian
+		if kind == "sowt" {
+			order = binary.LittleEndian
+		}
+		switch kind {
+		case "fl32":
+			binary.Write(&ssnd, order, float32(v))
+		case "fl64":
+			binary.Write(&ssnd, order, v)
+		default:
+			value := int32(v * float64(int64(1)<<uint(bits-1)))
+			b := make([]byte, 4, 4)
+			order.PutUint32(b, uint32(value<<uint(32-bits)))
+			if kind == "sowt" {
+				ssnd.Write(b[4-(bits+7)/8:])
+			} else {
+				ssnd.Write(b[:(bits+7)/8])
+			}
+		}
+	}
+
+	form := "AIFF"
+	if kind != "" {
+		form = "AIFC"
+	}
+	var body bytes.Buffer
+	body.WriteString(form)
+	for _, chunk := range []wavChunk{{"COMM", comm.Bytes(), 0}, {"NAME", []byte("odd"), 0}, {"SSND", ssnd.Bytes(), 0}} {
+		// Same layout as .wav chunks, but big endian.
+		b := chunk.bytes()
+		binary.BigEndian.PutUint32(b[4:8], uint32(len(chunk.body)))
+		body.Write(b)
+	}
+	file := append([]byte("FORM\x00\x00\x00\x00"), body.Bytes()...)
+	binary.BigEndian.PutUint32(file[4:8], uint32(body.Len()))
+	return file
+}
+
+func TestAiffEncodings(t *testing.T) {
+	tests := []struct {
+		kind string
+		bits int
+		rate int
+	}{
+		{"", 8, 44100},
+		{"", 16, 22050},
+		{"", 24, 48000},
+		{"NONE", 16, 44100},
+		{"sowt", 16, 44100},
+		{"sowt", 24, 96000},
+		{"fl32", 32, 44100},
+		{"fl64", 64, 8000},
+	}
+	for _, test := range tests {
+		file := buildAiff(test.kind, 2, test.rate, test.bits, testWavValues)
+		reader, err := newAiffReader(bytes.NewReader(file))
+		if err != nil {
+			t.Errorf("%+v: can't read: %v", test, err)
+			continue
+		}
+		if reader.channels != 2 || reader.sampleRate != test.rate || reader.frames != int64(len(testWavValues)/2) {
+			t.Errorf("%+v: read %d channels at %dHz, %d frames", test, reader.channels, reader.sampleRate, reader.frames)
+		}
+
+		frame := make([]float64, 2, 2)
+		read := []float64{}
+		for reader.ReadFrame(frame) == nil {
+			read = append(read, frame...)
+		}
+		if len(read) != len(testWavValues) {
+			t.Errorf("%+v: read %d samples, expected %d", test, len(read), len(testWavValues))
+			continue
+		}
+		for i, v := range testWavValues {
+			if read[i] != v {
+				t.Errorf("%+v: sample %d is %v, expected %v", test, i, read[i], v)
+			}
+		}
+	}
+}
+
+func TestAiffErrors(t *testing.T) {
+	valid := buildAiff("", 1, 44100, 16, testWavValues)
+	compressed := buildAiff("ulaw", 1, 44100, 16, testWavValues)
+	noComm := append([]byte{}, valid...)
+	copy(noComm[12:16], "XXXX")
+
+	for name, file := range map[string][]byte{
+		"not aiff":    append([]byte("RIFF"), valid[4:]...),
+		"compressed":  compressed,
+		"no COMM":     noComm,
+		"truncated":   valid[:20],
+		"empty input": {},
+	} {
+		if _, err := newAiffReader(bytes.NewReader(file)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestLoadAiff(t *testing.T) {
+	samples := make([]float64, 2*1000, 2*1000)
+	for i := range samples {
+		samples[i] = 0.5 * math.Sin(float64(i/2)*0.1)
+	}
+	f, err := ioutil.TempFile("", "aiff_")
+	if err != nil {
+		t.Fatalf("Can't create temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.Write(buildAiff("", 2, int(CyclesPerSecond), 16, samples))
+	f.Close()
+
+	sound := LoadAiffAsSound(f.Name(), 1)
+	sound.Start()
+	i := 0
+	for sample := range sound.GetSamples() {
+		if math.Abs(sample-samples[2*i+1]) > 1.0/32768 {
+			t.Fatalf("Sample %d is %v, expected %v", i, sample, samples[2*i+1])
+		}
+		i++
+	}
+	if i != 1000 {
+		t.Errorf("Read %d samples, expected 1000", i)
+	}
+}
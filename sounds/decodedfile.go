@@ -0,0 +1,173 @@
+package sounds
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/padster/go-sound/resample"
+)
+
+const (
+	// MixDown selects the average of all channels, rather than a single one.
+	MixDown = -1
+
+	// Number of frames read between resampling.
+	resampleChunk = 4096
+)
+
+// frameReader decodes an audio file one frame, a sample for every channel, at a time. Readers
+// that also implement io.Closer are closed once the sound finishes.
+type frameReader interface {
+	// ReadFrame reads the next sample of every channel, returning io.EOF after the last.
+	ReadFrame(frame []float64) error
+}
+
+// A decodedFileSound is parameters to the algorithm that converts a channel from an audio file into a sound.
+type decodedFileSound struct {
+	kind       string
+	path       string
+	channel    int
+	channels   int
+	sampleRate int
+	length     uint64
+
+	// open starts decoding a file from its first frame.
+	open func(file *os.File) (frameReader, error)
+}
+
+// newDecodedFileSound converts one channel of a file, or MixDown for the average of them all,
+// into a Sound. Files at other sample rates are resampled to CyclesPerSecond. A negative number
+// of frames means the length isn't known until the file has been decoded.
+func newDecodedFileSound(kind string, path string, channel int, channels int, sampleRate int, frames int64,
+	open func(file *os.File) (frameReader, error)) Sound {
+	if channel != MixDown && (channel < 0 || channel >= channels) {
+		panic(fmt.Sprintf("Unsupported channel number %d, %s has %d", channel, path, channels))
+	}
+
+	length := MaxLength
+	if frames >= 0 {
+		length = resampledLength(frames, sampleRate)
+	}
+
+	data := decodedFileSound{
+		kind,
+		path,
+		channel,
+		channels,
+		sampleRate,
+		length,
+		open,
+	}
+	return NewBaseSound(&data, length)
+}
+
+// Run generates the samples by decoding them from the file.
+func (s *decodedFileSound) Run(base *BaseSound) {
+	file, err := os.Open(s.path)
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+	reader, err := s.open(file)
+	if err != nil {
+		panic(err)
+	}
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	if s.sampleRate == int(CyclesPerSecond) {
+		frame := make([]float64, s.channels, s.channels)
+		for {
+			if err := reader.ReadFrame(frame); err != nil {
+				if err != io.EOF {
+					panic(err)
+				}
+				return
+			}
+			if !base.WriteSample(s.selected(frame)) {
+				return
+			}
+		}
+	}
+
+	// Resample chunks of frames, skipping the resampler's latency at the start, and
+	// flushing it with silence at the end.
+	resampler := resample.NewResampler(s.sampleRate, int(CyclesPerSecond), 50, 0.05)
+	skip, left := resampler.GetLatency(), s.length
+	frame := make([]float64, s.channels, s.channels)
+	chunk := make([]float64, 0, resampleChunk)
+	read, written := int64(0), uint64(0)
+	for finished := false; left > 0; {
+		chunk = chunk[:0]
+		for !finished && len(chunk) < resampleChunk {
+			if err := reader.ReadFrame(frame); err != nil {
+				if err != io.EOF {
+					panic(err)
+				}
+				finished = true
+				break
+			}
+			chunk = append(chunk, s.selected(frame))
+			read++
+		}
+		if finished {
+			chunk = append(chunk, make([]float64, resampleChunk-len(chunk))...)
+			if s.length == MaxLength {
+				// Unknown lengths are only found once the last frame has been read.
+				left = 0
+				if total := resampledLength(read, s.sampleRate); total > written {
+					left = total - written
+				}
+			}
+		}
+
+		for _, sample := range resampler.Process(chunk) {
+			if skip > 0 {
+				skip--
+				continue
+			}
+			if left == 0 || !base.WriteSample(sample) {
+				return
+			}
+			left--
+			written++
+		}
+	}
+}
+
+// resampledLength returns how many samples a number of frames at a sample rate becomes at CyclesPerSecond.
+func resampledLength(frames int64, sampleRate int) uint64 {
+	if sampleRate == int(CyclesPerSecond) {
+		return uint64(frames)
+	}
+	return uint64(math.Floor(float64(frames) * CyclesPerSecond / float64(sampleRate)))
+}
+
+// selected returns the sample from the chosen channel of a frame, or their average.
+func (s *decodedFileSound) selected(frame []float64) float64 {
+	if s.channel != MixDown {
+		return frame[s.channel]
+	}
+	sum := 0.0
+	for _, v := range frame {
+		sum += v
+	}
+	return sum / float64(len(frame))
+}
+
+// Stop cleans up this sound, in this case doing nothing, as the file is closed when Run ends.
+func (s *decodedFileSound) Stop() {}
+
+// Reset does nothing, as the file is reopened from the start each time the sound runs.
+func (s *decodedFileSound) Reset() {}
+
+// String returns the textual representation
+func (s *decodedFileSound) String() string {
+	if s.channel == MixDown {
+		return fmt.Sprintf("%s[mixdown from path %s]", s.kind, s.path)
+	}
+	return fmt.Sprintf("%s[channel %d from path %s]", s.kind, s.channel, s.path)
+}
@@ -0,0 +1,37 @@
+//go:build !libflac
+// +build !libflac
+
+package sounds
+
+import (
+	"os"
+
+	"github.com/padster/go-sound/flac"
+)
+
+// pureFlacStream decodes a .flac file with the pure Go decoder, needing no system libraries.
+type pureFlacStream struct {
+	decoder *flac.Decoder
+}
+
+// openFlac starts decoding an open .flac file, returning its sample rate, number of channels,
+// and samples per channel, 0 if unknown.
+func openFlac(file *os.File) (flacStream, int, int, int64, error) {
+	decoder, err := flac.NewDecoder(file)
+	if err != nil {
+		return nil, 0, 0, 0, err
+	}
+	info := decoder.Info()
+	return &pureFlacStream{decoder}, info.SampleRate, info.Channels, info.TotalSamples, nil
+}
+
+func (s *pureFlacStream) readFrame() ([]int32, int, int, error) {
+	frame, err := s.decoder.ReadFrame()
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return frame.Buffer, frame.Channels, frame.Depth, nil
+}
+
+// close does nothing, as the file is closed by whoever opened it.
+func (s *pureFlacStream) close() {}
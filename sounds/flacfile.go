@@ -1,107 +1,82 @@
 package sounds
 
 import (
-	"fmt"
-	"io"
 	"os"
-	"strings"
-
-	flac "github.com/cocoonlife/goflac"
-	// flac "github.com/padster/go-sound/fakeflac"
 )
 
-// A flacFileSound is parameters to the algorithm that converts a channel from a .flac file into a sound.
-type flacFileSound struct {
-	path       string
-	fileReader *flac.Decoder
+// flacStream is a source of decoded FLAC frames. By default the pure Go decoder in the
+// flac package is used, building with the libflac tag uses libFLAC through cgo instead.
+type flacStream interface {
+	// readFrame returns the next frame's interleaved samples, or io.EOF at the end of the stream.
+	readFrame() (samples []int32, channels int, depth int, err error)
+	close()
+}
+
+// flacFrameReader reads frames from a FLAC stream, which decodes a block of them at once.
+type flacFrameReader struct {
+	stream  flacStream
+	decoded []int32
+	depth   int
 }
 
 // LoadFlacAsSound loads a .flac file and converts the average of its channels to a Sound.
+// Files at other sample rates are resampled.
 //
-// For example, to read the first channel from a local file at 'piano.flac':
+// For example, to read a local file at 'piano.flac':
 //  sounds.LoadFlacAsSound("piano.flac")
 func LoadFlacAsSound(path string) Sound {
-	if !strings.HasSuffix(path, ".flac") {
-		panic("Input file must be .flac")
+	file, err := os.Open(path)
+	if err != nil {
+		panic(err)
 	}
-
-	flacReader := loadFlacReaderOrPanic(path)
-
-	if flacReader.Rate != int(CyclesPerSecond) {
-		// TODO(padster): Support more if there's a need.
-		panic("Only wav files that are 44.1kHz are supported.")
+	stream, rate, channels, total, err := openFlac(file)
+	if err == nil {
+		stream.close()
 	}
-
-	// TODO: Precalculate the duration properly.
-	durationMs := MaxLength
-
-	data := flacFileSound{
-		path,
-		flacReader,
+	file.Close()
+	if err != nil {
+		panic(err)
 	}
 
-	return NewBaseSound(&data, durationMs)
-}
-
-// Run generates the samples by extracting them out of the .flac file.
-func (s *flacFileSound) Run(base *BaseSound) {
-	frame, err := s.fileReader.ReadFrame()
-	for err != io.EOF {
-		count := len(frame.Buffer) / frame.Channels
+	// Streams may not record their length, in which case they play until the samples run out.
+	if total == 0 {
+		total = -1
+	}
 
-		for i := 0; i < count; i++ {
-			v := 0.0
-			for _, c := range frame.Buffer[i*frame.Channels : (i+1)*frame.Channels] {
-				v += floatFromBitWithDepth(c, frame.Depth)
+	return newDecodedFileSound("Flac", path, MixDown, channels, rate, total,
+		func(file *os.File) (frameReader, error) {
+			stream, _, _, _, err := openFlac(file)
+			if err != nil {
+				return nil, err
 			}
-			v = v / float64(frame.Channels)
-			if !base.WriteSample(v) {
-				err = io.EOF
-			}
-		}
+			return &flacFrameReader{stream, nil, 0}, nil
+		})
+}
 
-		if err != io.EOF {
-			frame, err = s.fileReader.ReadFrame()
+// ReadFrame reads the next sample of every channel, returning io.EOF after the last.
+func (r *flacFrameReader) ReadFrame(frame []float64) error {
+	for len(r.decoded) == 0 {
+		buffer, _, depth, err := r.stream.readFrame()
+		if err != nil {
+			return err
 		}
+		r.decoded, r.depth = buffer, depth
 	}
-}
-
-// Stop cleans up this sound, closing the reader.
-func (s *flacFileSound) Stop() {
-	s.fileReader.Close()
-}
 
-// Reset reopens the file from the start.
-func (s *flacFileSound) Reset() {
-	s.Stop()
-	s.fileReader = loadFlacReaderOrPanic(s.path)
+	for c := range frame {
+		frame[c] = floatFromBitWithDepth(r.decoded[c], r.depth)
+	}
+	r.decoded = r.decoded[len(frame):]
+	return nil
 }
 
-// String returns the textual representation
-func (s *flacFileSound) String() string {
-	return fmt.Sprintf("Flac[path %s]", s.path)
+// Close closes the stream, once the sound has finished reading it.
+func (r *flacFrameReader) Close() error {
+	r.stream.close()
+	return nil
 }
 
-// loadFlacReaderOrPanic reads a flac file and handles failure cases.
-func loadFlacReaderOrPanic(path string) *flac.Decoder {
-	_, err := os.Stat(path)
-	if err != nil {
-		panic(err)
-	}
-	fileReader, err := flac.NewDecoder(path)
-	if err != nil {
-		panic(err)
-	}
-	return fileReader
-}
-
-// HACK - should share with cq/utils.go
+// floatFromBitWithDepth converts a signed integer sample of a given bit depth to [-1, 1].
 func floatFromBitWithDepth(input int32, depth int) float64 {
-	return float64(input) / (float64(unsafeShift(depth)) - 1.0) // Hmmm..doesn't seem right?
-}
-func intFromFloatWithDepth(input float64, depth int) int32 {
-	return int32(input * (float64(unsafeShift(depth)) - 1.0))
-}
-func unsafeShift(s int) int {
-	return 1 << uint(s)
+	return float64(input) / float64(int64(1)<<uint(depth-1))
 }
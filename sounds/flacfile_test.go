@@ -0,0 +1,61 @@
+package sounds
+
+// go test github.com/padster/go-sound/sounds
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+)
+
+// The stereo fixture is 44.1kHz, so its samples are the average of the reference channels.
+func TestLoadFlacMixesDown(t *testing.T) {
+	reference := readFlacReference(t, "../flac/testdata/stereo16.raw")
+	read := collectSamples(LoadFlacAsSound("../flac/testdata/stereo16.flac"))
+	if len(read) != len(reference)/2 {
+		t.Fatalf("Read %d samples, expected %d", len(read), len(reference)/2)
+	}
+	for i, v := range read {
+		expected := float64(reference[2*i]+reference[2*i+1]) / 2 / 32768
+		if v != expected {
+			t.Fatalf("Sample %d is %v, expected %v", i, v, expected)
+		}
+	}
+}
+
+// The mono fixture is 48kHz, so is resampled, whether or not its header has the length.
+func TestLoadFlacResamples(t *testing.T) {
+	file, err := ioutil.ReadFile("../flac/testdata/mono24.flac")
+	if err != nil {
+		t.Fatalf("Can't read fixture: %v", err)
+	}
+	// The sample count is the low 36 bits of STREAMINFO's rate, channels, depth and count.
+	unknown := append([]byte{}, file...)
+	unknown[21] &= 0xF0
+	copy(unknown[22:26], []byte{0, 0, 0, 0})
+
+	frames := len(readFlacReference(t, "../flac/testdata/mono24.raw"))
+	expected := int(math.Floor(float64(frames) * CyclesPerSecond / 48000))
+	for name, contents := range map[string][]byte{"known length": file, "unknown length": unknown} {
+		path := writeTestWav(t, contents)
+		defer os.Remove(path)
+		if read := collectSamples(LoadFlacAsSound(path)); len(read) != expected {
+			t.Errorf("%s: read %d samples, expected %d", name, len(read), expected)
+		}
+	}
+}
+
+// readFlacReference reads the decoded samples of a FLAC fixture, as little-endian int32s.
+func readFlacReference(t *testing.T, path string) []int32 {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Can't read reference: %v", err)
+	}
+	samples := make([]int32, len(raw)/4, len(raw)/4)
+	for i := range samples {
+		samples[i] = int32(binary.LittleEndian.Uint32(raw[4*i:]))
+	}
+	return samples
+}
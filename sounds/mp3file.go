@@ -0,0 +1,57 @@
+package sounds
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+
+	"github.com/hajimehoshi/go-mp3"
+)
+
+// mp3FrameReader reads frames from an MP3 decoder, which always outputs 16 bit stereo.
+type mp3FrameReader struct {
+	decoded *bufio.Reader
+	sample  []byte
+}
+
+// LoadMp3AsSound loads an .mp3 file and converts one of its channels, or MixDown for the
+// average of them both, into a Sound. Mono files have two identical channels, and files
+// at other sample rates are resampled.
+//
+// For example, to read the average of the channels of a local file at 'song.mp3':
+//  sounds.LoadMp3AsSound("song.mp3", sounds.MixDown)
+func LoadMp3AsSound(path string, channel int) Sound {
+	file, err := os.Open(path)
+	if err != nil {
+		panic(err)
+	}
+	decoder, err := mp3.NewDecoder(file)
+	file.Close()
+	if err != nil {
+		panic(err)
+	}
+
+	// The length is of the decoded bytes, 4 per frame.
+	return newDecodedFileSound("Mp3", path, channel, 2, decoder.SampleRate(), decoder.Length()/4,
+		func(file *os.File) (frameReader, error) {
+			decoder, err := mp3.NewDecoder(file)
+			if err != nil {
+				return nil, err
+			}
+			return &mp3FrameReader{bufio.NewReader(decoder), make([]byte, 4, 4)}, nil
+		})
+}
+
+// ReadFrame reads the next sample of both channels, returning io.EOF after the last.
+func (r *mp3FrameReader) ReadFrame(frame []float64) error {
+	if _, err := io.ReadFull(r.decoded, r.sample); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return io.EOF
+		}
+		return err
+	}
+	frame[0] = float64(int16(binary.LittleEndian.Uint16(r.sample[0:2]))) / 32768.0
+	frame[1] = float64(int16(binary.LittleEndian.Uint16(r.sample[2:4]))) / 32768.0
+	return nil
+}
@@ -0,0 +1,57 @@
+package sounds
+
+import (
+	"os"
+
+	"github.com/jfreymuth/oggvorbis"
+)
+
+// oggFrameReader reads frames from an Ogg Vorbis decoder, which decodes many at once.
+type oggFrameReader struct {
+	reader  *oggvorbis.Reader
+	buffer  []float32
+	decoded []float32
+}
+
+// LoadOggAsSound loads an Ogg Vorbis file and converts one of its channels, or MixDown for the
+// average of them all, into a Sound. Files at other sample rates are resampled.
+//
+// For example, to read the left channel of a local file at 'song.ogg':
+//  sounds.LoadOggAsSound("song.ogg", 0)
+func LoadOggAsSound(path string, channel int) Sound {
+	file, err := os.Open(path)
+	if err != nil {
+		panic(err)
+	}
+	reader, err := oggvorbis.NewReader(file)
+	file.Close()
+	if err != nil {
+		panic(err)
+	}
+
+	return newDecodedFileSound("Ogg", path, channel, reader.Channels(), reader.SampleRate(), reader.Length(),
+		func(file *os.File) (frameReader, error) {
+			reader, err := oggvorbis.NewReader(file)
+			if err != nil {
+				return nil, err
+			}
+			return &oggFrameReader{reader, make([]float32, resampleChunk*reader.Channels()), nil}, nil
+		})
+}
+
+// ReadFrame reads the next sample of every channel, returning io.EOF after the last.
+func (r *oggFrameReader) ReadFrame(frame []float64) error {
+	for len(r.decoded) == 0 {
+		n, err := r.reader.Read(r.buffer)
+		if n == 0 && err != nil {
+			return err
+		}
+		r.decoded = r.buffer[:n]
+	}
+
+	for c := range frame {
+		frame[c] = float64(r.decoded[c])
+	}
+	r.decoded = r.decoded[len(frame):]
+	return nil
+}
@@ -0,0 +1,34 @@
+package sounds
+
+// go test github.com/padster/go-sound/sounds
+
+import (
+	"os"
+	"testing"
+
+	"github.com/jfreymuth/oggvorbis"
+)
+
+// Samples come out as the decoder produces them, across the many frames it decodes at once.
+func TestLoadOgg(t *testing.T) {
+	file, err := os.Open("testdata/test.ogg")
+	if err != nil {
+		t.Fatalf("Can't open fixture: %v", err)
+	}
+	expected, _, err := oggvorbis.ReadAll(file)
+	file.Close()
+	if err != nil {
+		t.Fatalf("Can't decode fixture: %v", err)
+	}
+
+	sound := LoadOggAsSound("testdata/test.ogg", 0)
+	read := collectSamples(sound)
+	if sound.Length() != 44100 || len(read) != len(expected) {
+		t.Fatalf("Read %d samples (length %d), expected %d", len(read), sound.Length(), len(expected))
+	}
+	for i, v := range expected {
+		if read[i] != float64(v) {
+			t.Fatalf("Sample %d is %v, expected %v", i, read[i], v)
+		}
+	}
+}
@@ -15,6 +15,20 @@ func WrapSliceAsSound(samples []float64) Sound {
 	return NewBaseSound(&data, uint64(len(samples)))
 }
 
+// RenderToSlice plays a finite sound through into memory, the opposite of WrapSliceAsSound.
+func RenderToSlice(sound Sound) []float64 {
+	if sound.Length() == MaxLength {
+		panic("Can't render an unending sound into memory")
+	}
+	samples := make([]float64, 0, sound.Length())
+	sound.Start()
+	for sample := range sound.GetSamples() {
+		samples = append(samples, sample)
+	}
+	sound.Stop()
+	return samples
+}
+
 // Run generates the samples by simply iterating through the provided slice.
 func (s *sliceSound) Run(base *BaseSound) {
 	for _, sample := range s.samples {
@@ -0,0 +1,287 @@
+package sounds
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+// WavEncoding is how the samples within a .wav file are stored.
+type WavEncoding int
+
+const (
+	// Integer samples, unsigned for 8 bits and signed for more.
+	WavPCM WavEncoding = iota
+	// IEEE floating point samples, of 32 or 64 bits.
+	WavFloat
+)
+
+const (
+	wavFormatPCM        = 0x0001
+	wavFormatFloat      = 0x0003
+	wavFormatExtensible = 0xFFFE
+
+	// Chunks larger than this are assumed to run to the end of the file, as written by streaming
+	// tools that can't seek back to fill in the size.
+	wavUnknownSize = 0xFFFFFFFF
+)
+
+// The end of the GUIDs of WAVE_FORMAT_EXTENSIBLE subformats, after the two byte format tag.
+var wavSubformatSuffix = []byte{0x00, 0x00, 0x00, 0x00, 0x10, 0x00, 0x80, 0x00, 0x00, 0xAA, 0x00, 0x38, 0x9B, 0x71}
+
+// WavFormat describes the audio within a .wav file.
+type WavFormat struct {
+	Encoding   WavEncoding
+	Channels   int
+	SampleRate int
+
+	// BitsPerSample is the size each sample is stored in, of which only the top ValidBits are
+	// used, e.g. 20 bit audio stored in 24 bits.
+	BitsPerSample int
+	ValidBits     int
+
+	// Extensible is whether the file uses WAVE_FORMAT_EXTENSIBLE, in which case ChannelMask
+	// has a bit set for the speaker position of each channel, or is 0 if unspecified.
+	Extensible  bool
+	ChannelMask uint32
+
+	// Frames is the number of samples in each channel.
+	Frames int64
+}
+
+// Duration returns how long the audio lasts.
+func (f WavFormat) Duration() time.Duration {
+	return time.Duration(float64(f.Frames) / float64(f.SampleRate) * float64(time.Second))
+}
+
+func (f WavFormat) String() string {
+	encoding := "PCM"
+	if f.Encoding == WavFloat {
+		encoding = "float"
+	}
+	return fmt.Sprintf("%d channel %d bit %s at %dHz, %v", f.Channels, f.ValidBits, encoding, f.SampleRate, f.Duration())
+}
+
+// WavReader decodes the samples of a .wav file, one frame (a sample for every channel) at a time.
+type WavReader struct {
+	format     WavFormat
+	data       io.Reader
+	frame      []byte
+	framesLeft int64
+}
+
+// NewWavReader reads the header of a .wav file, leaving the reader at the start of its samples.
+// Chunks may be in any order, and unknown ones are skipped.
+func NewWavReader(r io.ReadSeeker) (*WavReader, error) {
+	header := make([]byte, 12, 12)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("Can't read wav header: %v", err)
+	}
+	if string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return nil, errors.New("Not a RIFF WAVE file")
+	}
+
+	var format *WavFormat
+	dataStart, dataSize := int64(-1), int64(0)
+	toEnd := false
+	for !toEnd {
+		id, size, err := readChunkHeader(r)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		start, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+
+		switch id {
+		case "fmt ":
+			body := make([]byte, size, size)
+			if _, err := io.ReadFull(r, body); err != nil {
+				return nil, fmt.Errorf("Can't read wav format: %v", err)
+			}
+			if format, err = parseWavFormat(body); err != nil {
+				return nil, err
+			}
+		case "data":
+			dataStart, dataSize = start, size
+		}
+
+		// Chunks are padded to an even length. Some writers leave the size of the last chunk unset.
+		end, err := r.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, err
+		}
+		if size == wavUnknownSize || start+size > end {
+			if id == "data" {
+				dataSize = end - start
+			}
+			toEnd = true
+		} else if _, err := r.Seek(start+size+size%2, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+
+	if format == nil {
+		return nil, errors.New("Wav file has no fmt chunk")
+	}
+	if dataStart < 0 {
+		return nil, errors.New("Wav file has no data chunk")
+	}
+	if _, err := r.Seek(dataStart, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	frameSize := format.Channels * format.BitsPerSample / 8
+	format.Frames = dataSize / int64(frameSize)
+	return &WavReader{
+		*format,
+		r,
+		make([]byte, frameSize, frameSize),
+		format.Frames, /* framesLeft */
+	}, nil
+}
+
+// Format returns the format of the file being read.
+func (wr *WavReader) Format() WavFormat {
+	return wr.format
+}
+
+// ReadFrame reads the next sample of every channel, scaled to [-1, 1], into samples.
+// Returns io.EOF once every frame has been read.
+func (wr *WavReader) ReadFrame(samples []float64) error {
+	if len(samples) < wr.format.Channels {
+		panic("ReadFrame needs space for a sample from every channel")
+	}
+	if wr.framesLeft == 0 {
+		return io.EOF
+	}
+	if _, err := io.ReadFull(wr.data, wr.frame); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("Truncated wav data: %v", err)
+		}
+		return err
+	}
+	wr.framesLeft--
+
+	size := wr.format.BitsPerSample / 8
+	for c := 0; c < wr.format.Channels; c++ {
+		samples[c] = decodeWavSample(wr.frame[c*size:(c+1)*size], wr.format.Encoding)
+	}
+	return nil
+}
+
+// ReadWavFormat returns the format of a .wav file, without reading its samples.
+func ReadWavFormat(path string) (WavFormat, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return WavFormat{}, err
+	}
+	defer file.Close()
+
+	reader, err := NewWavReader(file)
+	if err != nil {
+		return WavFormat{}, err
+	}
+	return reader.Format(), nil
+}
+
+func readChunkHeader(r io.Reader) (string, int64, error) {
+	header := make([]byte, 8, 8)
+	if n, err := io.ReadFull(r, header); err != nil {
+		// Trailing padding or junk shorter than a header is ignored.
+		if n < len(header) {
+			return "", 0, io.EOF
+		}
+		return "", 0, err
+	}
+	return string(header[0:4]), int64(binary.LittleEndian.Uint32(header[4:8])), nil
+}
+
+// parseWavFormat reads a WAVEFORMATEX structure, or WAVEFORMATEXTENSIBLE.
+func parseWavFormat(body []byte) (*WavFormat, error) {
+	if len(body) < 16 {
+		return nil, fmt.Errorf("Wav format chunk too short: %d bytes", len(body))
+	}
+	tag := binary.LittleEndian.Uint16(body[0:2])
+	format := &WavFormat{
+		WavPCM,
+		int(binary.LittleEndian.Uint16(body[2:4])),
+		int(binary.LittleEndian.Uint32(body[4:8])),
+		int(binary.LittleEndian.Uint16(body[14:16])),
+		int(binary.LittleEndian.Uint16(body[14:16])), /* ValidBits */
+		false, /* Extensible */
+		0,     /* ChannelMask */
+		0,     /* Frames */
+	}
+	blockAlign := int(binary.LittleEndian.Uint16(body[12:14]))
+
+	if tag == wavFormatExtensible {
+		if len(body) < 40 {
+			return nil, fmt.Errorf("Extensible wav format chunk too short: %d bytes", len(body))
+		}
+		if !bytes.Equal(body[26:40], wavSubformatSuffix) {
+			return nil, errors.New("Unsupported extensible wav subformat")
+		}
+		format.Extensible = true
+		if valid := int(binary.LittleEndian.Uint16(body[18:20])); valid > 0 {
+			format.ValidBits = valid
+		}
+		format.ChannelMask = binary.LittleEndian.Uint32(body[20:24])
+		tag = binary.LittleEndian.Uint16(body[24:26])
+	}
+
+	switch {
+	case format.Channels < 1:
+		return nil, errors.New("Wav file has no channels")
+	case format.SampleRate < 1:
+		return nil, fmt.Errorf("Invalid wav sample rate %d", format.SampleRate)
+	case format.ValidBits > format.BitsPerSample:
+		return nil, fmt.Errorf("Wav file has %d valid bits in %d bit samples", format.ValidBits, format.BitsPerSample)
+	case blockAlign != format.Channels*format.BitsPerSample/8:
+		return nil, fmt.Errorf("Wav block align %d doesn't match %d channels of %d bits", blockAlign, format.Channels, format.BitsPerSample)
+	}
+
+	switch tag {
+	case wavFormatPCM:
+		if format.BitsPerSample%8 != 0 || format.BitsPerSample < 8 || format.BitsPerSample > 32 {
+			return nil, fmt.Errorf("Unsupported PCM wav sample size: %d bits", format.BitsPerSample)
+		}
+	case wavFormatFloat:
+		format.Encoding = WavFloat
+		if format.BitsPerSample != 32 && format.BitsPerSample != 64 {
+			return nil, fmt.Errorf("Unsupported float wav sample size: %d bits", format.BitsPerSample)
+		}
+	default:
+		return nil, fmt.Errorf("Unsupported wav format 0x%04x", tag)
+	}
+	return format, nil
+}
+
+// decodeWavSample converts a single stored sample to [-1, 1].
+func decodeWavSample(b []byte, encoding WavEncoding) float64 {
+	if encoding == WavFloat {
+		if len(b) == 4 {
+			return float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b))
+	}
+	if len(b) == 1 {
+		// 8 bit samples are the only unsigned ones.
+		return (float64(b[0]) - 128.0) / 128.0
+	}
+
+	// Little endian, so build up from the most significant byte, which carries the sign.
+	value := int32(int8(b[len(b)-1]))
+	for i := len(b) - 2; i >= 0; i-- {
+		value = value<<8 | int32(b[i])
+	}
+	return float64(value) / float64(int64(1)<<uint(8*len(b)-1))
+}
@@ -0,0 +1,274 @@
+package sounds
+
+// go test github.com/padster/go-sound/sounds
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+)
+
+// Values every encoding should represent (almost) exactly.
+var testWavValues = []float64{0, 0.5, -0.5, 0.25, -1, 0.75}
+
+// wavChunk is a fixture chunk, written with a pad byte if its body has odd length.
+type wavChunk struct {
+	id   string
+	body []byte
+	size uint32 // Overrides the size in the header, if non-zero.
+}
+
+func (c wavChunk) bytes() []byte {
+	var b bytes.Buffer
+	size := uint32(len(c.body))
+	if c.size != 0 {
+		size = c.size
+	}
+	b.WriteString(c.id)
+	binary.Write(&b, binary.LittleEndian, size)
+	b.Write(c.body)
+	if len(c.body)%2 == 1 {
+		b.WriteByte(0)
+	}
+	return b.Bytes()
+}
+
+// buildWav generates a .wav file from its chunks.
+func buildWav(chunks ...wavChunk) []byte {
+	var body bytes.Buffer
+	body.WriteString("WAVE")
+	for _, chunk := range chunks {
+		body.Write(chunk.bytes())
+	}
+	return wavChunk{"RIFF", body.Bytes(), 0}.bytes()
+}
+
+// fmtChunk generates a format chunk, as WAVE_FORMAT_EXTENSIBLE if validBits is non-zero.
+func fmtChunk(tag uint16, channels int, rate int, bits int, validBits int) wavChunk {
+	var b bytes.Buffer
+	blockAlign := channels * bits / 8
+	formatTag := tag
+	if validBits != 0 {
+		formatTag = wavFormatExtensible
+	}
+	binary.Write(&b, binary.LittleEndian, []uint16{formatTag, uint16(channels)})
+	binary.Write(&b, binary.LittleEndian, []uint32{uint32(rate), uint32(rate * blockAlign)})
+	binary.Write(&b, binary.LittleEndian, []uint16{uint16(blockAlign), uint16(bits)})
+	if validBits != 0 {
+		binary.Write(&b, binary.LittleEndian, []uint16{22, uint16(validBits)})
+		binary.Write(&b, binary.LittleEndian, uint32(0x3) /* front left and right */)
+		binary.Write(&b, binary.LittleEndian, tag)
+		b.Write(wavSubformatSuffix)
+	}
+	return wavChunk{"fmt ", b.Bytes(), 0}
+}
+
+// dataChunk encodes interleaved samples.
+func dataChunk(tag uint16, bits int, samples []float64) wavChunk {
+	var b bytes.Buffer
+	for _, v := range samples {
+		switch {
+		case tag == wavFormatFloat && bits == 32:
+			binary.Write(&b, binary.LittleEndian, float32(v))
+		case tag == wavFormatFloat:
+			binary.Write(&b, binary.LittleEndian, v)
+		case bits == 8:
+			b.WriteByte(byte(math.Min(255, 128+v*128)))
+		default:
+			scaled := int64(math.Min(v*float64(int64(1)<<uint(bits-1)), float64(int64(1)<<uint(bits-1)-1)))
+			for i := 0; i < bits/8; i++ {
+				b.WriteByte(byte(scaled >> uint(8*i)))
+			}
+		}
+	}
+	return wavChunk{"data", b.Bytes(), 0}
+}
+
+func readAllFrames(t *testing.T, file []byte) (WavFormat, [][]float64) {
+	reader, err := NewWavReader(bytes.NewReader(file))
+	if err != nil {
+		t.Fatalf("Can't read wav: %v", err)
+	}
+	format := reader.Format()
+	frames := [][]float64{}
+	for {
+		frame := make([]float64, format.Channels, format.Channels)
+		if err := reader.ReadFrame(frame); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Can't read frame %d: %v", len(frames), err)
+		}
+		frames = append(frames, frame)
+	}
+	return format, frames
+}
+
+func TestWavEncodings(t *testing.T) {
+	tests := []struct {
+		name      string
+		tag       uint16
+		bits      int
+		validBits int
+		encoding  WavEncoding
+		tolerance float64
+	}{
+		{"8 bit", wavFormatPCM, 8, 0, WavPCM, 1.0 / 128},
+		{"16 bit", wavFormatPCM, 16, 0, WavPCM, 1.0 / 32768},
+		{"24 bit", wavFormatPCM, 24, 0, WavPCM, 1.0 / 8388608},
+		{"32 bit", wavFormatPCM, 32, 0, WavPCM, 1.0 / 2147483648},
+		{"32 bit float", wavFormatFloat, 32, 0, WavFloat, 0},
+		{"64 bit float", wavFormatFloat, 64, 0, WavFloat, 0},
+		{"extensible 20 in 24 bit", wavFormatPCM, 24, 20, WavPCM, 1.0 / 8388608},
+		{"extensible float", wavFormatFloat, 32, 32, WavFloat, 0},
+	}
+
+	for _, test := range tests {
+		// Stereo, with the right channel inverted.
+		samples := []float64{}
+		for _, v := range testWavValues {
+			samples = append(samples, v, -v*0.5)
+		}
+		file := buildWav(fmtChunk(test.tag, 2, 48000, test.bits, test.validBits), dataChunk(test.tag, test.bits, samples))
+		format, frames := readAllFrames(t, file)
+
+		expected := WavFormat{test.encoding, 2, 48000, test.bits, test.bits, false, 0, int64(len(testWavValues))}
+		if test.validBits != 0 {
+			expected.ValidBits, expected.Extensible, expected.ChannelMask = test.validBits, true, 0x3
+		}
+		if format != expected {
+			t.Errorf("%s: format %+v, expected %+v", test.name, format, expected)
+			continue
+		}
+		if len(frames) != len(testWavValues) {
+			t.Errorf("%s: read %d frames, expected %d", test.name, len(frames), len(testWavValues))
+			continue
+		}
+		for i, v := range testWavValues {
+			if math.Abs(frames[i][0]-v) > test.tolerance || math.Abs(frames[i][1]+v*0.5) > test.tolerance {
+				t.Errorf("%s: frame %d is %v, expected [%v %v]", test.name, i, frames[i], v, -v*0.5)
+			}
+		}
+	}
+}
+
+func TestWavChunkLayouts(t *testing.T) {
+	format := fmtChunk(wavFormatPCM, 1, 44100, 16, 0)
+	data := dataChunk(wavFormatPCM, 16, testWavValues)
+	odd := wavChunk{"LIST", []byte("INFOodd"), 0}
+	unsized := data
+	unsized.size = wavUnknownSize
+
+	layouts := map[string][]byte{
+		"data before fmt":         buildWav(data, format),
+		"odd sized chunk skipped": buildWav(odd, format, odd, data, odd),
+		"data size unknown":       buildWav(format, unsized),
+		"trailing junk":           append(buildWav(format, data), 1, 2, 3),
+	}
+	for name, file := range layouts {
+		_, frames := readAllFrames(t, file)
+		if len(frames) != len(testWavValues) {
+			t.Errorf("%s: read %d frames, expected %d", name, len(frames), len(testWavValues))
+			continue
+		}
+		for i, v := range testWavValues {
+			if math.Abs(frames[i][0]-v) > 1.0/32768 {
+				t.Errorf("%s: frame %d is %v, expected %v", name, i, frames[i][0], v)
+			}
+		}
+	}
+}
+
+func TestWavErrors(t *testing.T) {
+	format := fmtChunk(wavFormatPCM, 1, 44100, 16, 0)
+	data := dataChunk(wavFormatPCM, 16, testWavValues)
+	broken := map[string][]byte{
+		"not riff":       append([]byte("RIFX"), buildWav(format, data)[4:]...),
+		"no fmt":         buildWav(data),
+		"no data":        buildWav(format),
+		"unknown format": buildWav(fmtChunk(0x0055 /* MP3 */, 1, 44100, 16, 0), data),
+		"12 bit":         buildWav(fmtChunk(wavFormatPCM, 1, 44100, 12, 0), data),
+		"16 bit float":   buildWav(fmtChunk(wavFormatFloat, 1, 44100, 16, 0), data),
+	}
+	for name, file := range broken {
+		if _, err := NewWavReader(bytes.NewReader(file)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestLoadWavChannels(t *testing.T) {
+	samples := []float64{}
+	for _, v := range testWavValues {
+		samples = append(samples, v, 0.5)
+	}
+	path := writeTestWav(t, buildWav(fmtChunk(wavFormatPCM, 2, 44100, 16, 0), dataChunk(wavFormatPCM, 16, samples)))
+	defer os.Remove(path)
+
+	for channel, expected := range map[int]func(float64) float64{
+		0:       func(v float64) float64 { return v },
+		1:       func(v float64) float64 { return 0.5 },
+		MixDown: func(v float64) float64 { return (v + 0.5) / 2 },
+	} {
+		read := collectSamples(LoadWavAsSound(path, channel))
+		if len(read) != len(testWavValues) {
+			t.Errorf("Channel %d: read %d samples, expected %d", channel, len(read), len(testWavValues))
+			continue
+		}
+		for i, v := range testWavValues {
+			if math.Abs(read[i]-expected(v)) > 1.0/32768 {
+				t.Errorf("Channel %d: sample %d is %v, expected %v", channel, i, read[i], expected(v))
+			}
+		}
+	}
+}
+
+// Files at other rates are resampled, keeping their duration and frequencies.
+func TestLoadWavResamples(t *testing.T) {
+	rate, hz := 22050, 441.0
+	samples := make([]float64, rate, rate)
+	for i := range samples {
+		samples[i] = 0.5 * math.Sin(2.0*math.Pi*hz*float64(i)/float64(rate))
+	}
+	path := writeTestWav(t, buildWav(fmtChunk(wavFormatFloat, 1, rate, 32, 0), dataChunk(wavFormatFloat, 32, samples)))
+	defer os.Remove(path)
+
+	sound := LoadWavAsSound(path, 0)
+	read := collectSamples(sound)
+	if sound.Length() != uint64(CyclesPerSecond) || len(read) != int(CyclesPerSecond) {
+		t.Fatalf("Resampled to %d samples (length %d), expected %d", len(read), sound.Length(), int(CyclesPerSecond))
+	}
+	// Ignore the edges, where the resampler's filter runs past the ends of the sine.
+	worst := 0.0
+	for i := 1000; i < len(read)-1000; i++ {
+		expected := 0.5 * math.Sin(2.0*math.Pi*hz*float64(i)/CyclesPerSecond)
+		worst = math.Max(worst, math.Abs(read[i]-expected))
+	}
+	if worst > 1e-3 {
+		t.Errorf("Resampled sine differs by up to %v", worst)
+	}
+}
+
+func writeTestWav(t *testing.T, file []byte) string {
+	f, err := ioutil.TempFile("", "wav_")
+	if err != nil {
+		t.Fatalf("Can't create temp file: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(file); err != nil {
+		t.Fatalf("Can't write temp file: %v", err)
+	}
+	return f.Name()
+}
+
+func collectSamples(sound Sound) []float64 {
+	result := []float64{}
+	sound.Start()
+	for sample := range sound.GetSamples() {
+		result = append(result, sample)
+	}
+	return result
+}
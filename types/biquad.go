@@ -0,0 +1,48 @@
+// A second order IIR filter for floating point values.
+package types
+
+import (
+	"math"
+)
+
+// Biquad is a second order IIR filter, in transposed direct form II, with coefficients
+// normalized so that a0 is 1.
+type Biquad struct {
+	B0, B1, B2 float64
+	A1, A2     float64
+
+	z1, z2 float64
+}
+
+// NewBiquad creates a filter with the given coefficients, normalized so that a0 is 1.
+func NewBiquad(b0 float64, b1 float64, b2 float64, a1 float64, a2 float64) *Biquad {
+	return &Biquad{b0, b1, b2, a1, a2, 0, 0}
+}
+
+// NewNotchBiquad creates a filter removing a narrow band around hz, with the given Q,
+// from the Audio EQ Cookbook by Robert Bristow-Johnson.
+func NewNotchBiquad(hz float64, q float64, sampleRate float64) *Biquad {
+	w0 := 2.0 * math.Pi * hz / sampleRate
+	alpha := math.Sin(w0) / (2.0 * q)
+	a0 := 1.0 + alpha
+	return NewBiquad(
+		1.0/a0,
+		-2.0*math.Cos(w0)/a0,
+		1.0/a0,
+		-2.0*math.Cos(w0)/a0,
+		(1.0-alpha)/a0,
+	)
+}
+
+// Process filters the next input value, returning the next output value.
+func (b *Biquad) Process(x float64) float64 {
+	y := b.B0*x + b.z1
+	b.z1 = b.B1*x - b.A1*y + b.z2
+	b.z2 = b.B2*x - b.A2*y
+	return y
+}
+
+// Reset clears the filter's state, as if it had only ever been given zeros.
+func (b *Biquad) Reset() {
+	b.z1, b.z2 = 0, 0
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"strconv"
 
+	"github.com/padster/go-sound/features"
 	s "github.com/padster/go-sound/sounds"
 )
 
@@ -157,6 +158,32 @@ func ParseChord(chord string, base uint) s.Sound {
 	return s.SumSounds(asSounds...)
 }
 
+// ChordTimelineToSound resynthesizes a chord timeline (e.g. from features.ChordRecognizer
+// or features.ReadChordTimeline) by playing each chord with ParseChord at the given base octave.
+// Gaps between chords, and sections labelled features.NoChord, are silent.
+func ChordTimelineToSound(segments []features.ChordSegment, base uint) s.Sound {
+	asSounds := make([]s.Sound, 0, len(segments))
+	at := 0
+	for _, segment := range segments {
+		if segment.StartSample > at {
+			asSounds = append(asSounds, s.NewTimedSilence(samplesToMs(segment.StartSample-at)))
+		}
+		durationMs := samplesToMs(segment.EndSample - segment.StartSample)
+		if segment.Label == features.NoChord {
+			asSounds = append(asSounds, s.NewTimedSilence(durationMs))
+		} else {
+			asSounds = append(asSounds, s.NewTimedSound(ParseChord(segment.Label, base), durationMs))
+		}
+		at = segment.EndSample
+	}
+	return s.ConcatSounds(asSounds...)
+}
+
+// samplesToMs converts a sample count into the millisecond durations used by timed sounds.
+func samplesToMs(samples int) float64 {
+	return float64(samples) * 1000.0 / s.CyclesPerSecond
+}
+
 // GuitarChord converts a standard guitar representation (e.g. "2x0232")
 // into the sound of those notes being played, assuming standard tuning.
 func GuitarChord(chord string) s.Sound {
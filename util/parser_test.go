@@ -0,0 +1,94 @@
+package util
+
+// go test github.com/padster/go-sound/util
+
+import (
+	"math"
+	"testing"
+
+	"github.com/padster/go-sound/cq"
+	"github.com/padster/go-sound/features"
+	s "github.com/padster/go-sound/sounds"
+)
+
+func TestRecognizeParsedChords(t *testing.T) {
+	tests := []struct {
+		chord string
+		// Names of chords with the same pitch classes, any of which is a match.
+		expected []string
+	}{
+		{"C", []string{"C"}},
+		{"Am", []string{"Am"}},
+		{"F#", []string{"F#"}},
+		{"G7", []string{"G7"}},
+		{"FM7", []string{"FM7"}},
+		{"Dm7", []string{"Dm7"}},
+		{"Bdim", []string{"Bdim"}},
+		{"Esus4", []string{"Esus4", "Asus2"}},
+		{"Eaug", []string{"Caug", "Eaug", "G#aug"}},
+	}
+	for _, test := range tests {
+		segments := recognizeChords(s.NewTimedSound(ParseChord(test.chord, 4), 2000))
+		if len(segments) != 1 || !containsString(test.expected, segments[0].Label) {
+			t.Errorf("%s recognized as %+v, expected one of %v", test.chord, segments, test.expected)
+		}
+	}
+}
+
+func TestChordTimelineRoundTrip(t *testing.T) {
+	second := int(s.CyclesPerSecond)
+	timeline := []features.ChordSegment{
+		{0, second, "C"},
+		{second, 2 * second, "Am"},
+		{2 * second, 5 * second / 2, features.NoChord},
+		{5 * second / 2, 4 * second, "G7"},
+	}
+	if rendered := s.RenderToSlice(ChordTimelineToSound(timeline, 4)); len(rendered) != 4*second {
+		t.Fatalf("Timeline has %d samples, expected %d", len(rendered), 4*second)
+	}
+
+	// Chords are recognized in frames of a tenth of a second.
+	frame := second / 10
+	segments := recognizeChords(ChordTimelineToSound(timeline, 4))
+	if len(segments) != len(timeline) {
+		t.Fatalf("Recognized %+v, expected %+v", segments, timeline)
+	}
+	for i, segment := range segments {
+		expected := timeline[i]
+		if segment.Label != expected.Label ||
+			math.Abs(float64(segment.StartSample-expected.StartSample)) > float64(frame) ||
+			math.Abs(float64(segment.EndSample-expected.EndSample)) > float64(frame) {
+			t.Errorf("Segment %d recognized as %+v, expected %+v to within %d samples", i, segment, expected, frame)
+		}
+	}
+}
+
+// recognizeChords returns the chord timeline of a sound. The last columns are centred past
+// its end, so segments there are dropped, and the last one is cut at the end.
+func recognizeChords(sound s.Sound) []features.ChordSegment {
+	params := cq.NewCQParams(s.CyclesPerSecond, 5, 55.0, 36)
+	spectrogram := cq.NewSpectrogram(params)
+	samples := s.RenderToSlice(sound)
+	columns := append(spectrogram.Process(samples), spectrogram.GetRemainingOutput()...)
+	chroma := features.NewChromagram(params).Process(columns)
+	cr := features.NewChordRecognizer(spectrogram.SamplesPerColumn(), spectrogram.OutputLatency(), s.CyclesPerSecond)
+	result := []features.ChordSegment{}
+	for _, segment := range cr.Recognize(chroma) {
+		if segment.StartSample < len(samples) {
+			if segment.EndSample > len(samples) {
+				segment.EndSample = len(samples)
+			}
+			result = append(result, segment)
+		}
+	}
+	return result
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -17,15 +17,19 @@ import (
 
 // Runs CQ to generate the CQ columns and writes to file.
 func main() {
-	runtime.GOMAXPROCS(6)
-
 	// Parse flags...
 	sampleRate := s.CyclesPerSecond
 	octaves := flag.Int("octaves", 7, "Range in octaves")
 	minFreq := flag.Float64("minFreq", 55.0, "Minimum frequency")
 	bpo := flag.Int("bpo", 24, "Buckets per octave")
+	q := flag.Float64("q", cq.DefaultQ, "Atom bandwidth scaling, in (0, 1]")
+	gamma := flag.Float64("gamma", 0.0, "Variable-Q bandwidth offset in Hz, 0 for constant Q")
 	zip := flag.Bool("zip", false, "Whether to zip the output")
+	codec := flag.String("codec", "complex64", "How to store values: complex64, or polar (lossy)")
+	magBits := flag.Int("magBits", 16, "Bits per magnitude for the polar codec")
+	phaseBits := flag.Int("phaseBits", 8, "Bits per phase for the polar codec, 0 to drop phase")
 	peaks := flag.Bool("peaks", false, "Whether to write CQ peaks rather than values")
+	batch := flag.Bool("batch", false, "Load the whole input, and transform chunks of it on every CPU")
 	flag.Parse()
 
 	remainingArgs := flag.Args()
@@ -46,63 +50,106 @@ func main() {
 	defer inputSound.Stop()
 
 	// minFreq, maxFreq, bpo := 110.0, 14080.0, 24
-	params := cq.NewCQParams(sampleRate, *octaves, *minFreq, *bpo)
+	params := cq.NewCQParams(sampleRate, *octaves, *minFreq, *bpo, cq.WithQ(*q), cq.WithBandwidthOffset(*gamma))
 	constantQ := cq.NewConstantQ(params)
 
 	startTime := time.Now()
-	columns := constantQ.ProcessChannel(inputSound.GetSamples())
+	var columns <-chan []complex128
+	if *batch {
+		columns = processBatch(params, inputSound.GetSamples())
+	} else {
+		columns = constantQ.ProcessChannel(inputSound.GetSamples())
+	}
 
 	if *peaks {
-		pd := &features.PeakDetector{}
-		asPeaks := pd.ProcessChannel(columns)
-		features.WritePeaks(outputFile, asPeaks)
+		pd := features.NewPeakDetector(params, constantQ.SamplesPerColumn(), constantQ.OutputLatency)
+		asPeaks := pd.ProcessColumns(constantQ.Timing().Annotate(columns))
+		if err := features.WritePeaks(outputFile, asPeaks); err != nil {
+			panic(err)
+		}
 	} else {
-		writeSamples(outputFile, *zip, constantQ.OutputLatency, columns)
+		header := f.NewCQHeader(params, constantQ.OutputLatency, f.RawColumns)
+		switch *codec {
+		case "complex64":
+		case "polar":
+			header.Codec = f.NewPolarCodec(*magBits, *phaseBits)
+		default:
+			panic("Unknown codec: " + *codec)
+		}
+		writeSamples(outputFile, *zip, header, columns)
 	}
 	elapsedSeconds := time.Since(startTime).Seconds()
 
 	fmt.Printf("elapsed time (not counting init): %f sec\n", elapsedSeconds)
 }
 
-func writeSamples(outputFile string, compress bool, latency int, samples <-chan []complex128) {
-	// BIG HACK
-	latency = 0
+// processBatch reads all the samples, then transforms them in parallel.
+func processBatch(params cq.CQParams, samples <-chan float64) <-chan []complex128 {
+	all := []float64{}
+	for sample := range samples {
+		all = append(all, sample)
+	}
+
+	result := make(chan []complex128)
+	go func() {
+		for _, column := range cq.ProcessBatch(params, all, runtime.NumCPU()) {
+			result <- column
+		}
+		close(result)
+	}()
+	return result
+}
+
+// writeSamples writes the columns to file after their header. Uncompressed files get the header's
+// column count filled in at the end, compressed ones can't be rewritten so leave it unknown.
+func writeSamples(outputFile string, compress bool, header f.CQHeader, samples <-chan []complex128) {
+	fmt.Printf("Latency = %d\n", header.Latency)
+	if !compress {
+		if err := f.WriteColumns(outputFile, header, samples); err != nil {
+			panic(err)
+		}
+		return
+	}
 
 	file, err := os.Create(outputFile)
 	if err != nil {
 		panic(err)
 	}
-	defer file.Close()
-
-	framesWritten := 0
-	maxHeight := 0
-
-	var writer io.Writer
-	if compress {
-		zip := zlib.NewWriter(file)
-		defer zip.Close()
-		writer = zip
-	} else {
-		writer = file
+	zip := zlib.NewWriter(file)
+	err = writeCompressed(zip, header, samples)
+	if closeErr := zip.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		panic(err)
 	}
+}
 
-	fmt.Printf("Latency = %d\n", latency)
+// writeCompressed writes the header then every column, including those before the input starts,
+// as the header's latency says where each one is centred.
+func writeCompressed(writer io.Writer, header f.CQHeader, samples <-chan []complex128) error {
+	columnWriter, err := f.NewCQColumnWriter(writer, header)
+	if err != nil {
+		return err
+	}
 
-	totalNumbersWritten := 0
+	framesWritten, maxHeight, totalNumbersWritten := 0, 0, 0
 	for sample := range samples {
-		if latency > 0 {
-			latency--
-		} else {
-			if len(sample) > maxHeight {
-				maxHeight = len(sample)
-			}
-			cq.WriteComplexArray(writer, sample)
-			framesWritten++
-			totalNumbersWritten += len(sample)
-			if framesWritten%10000 == 0 {
-				fmt.Printf("Written frame %d\n", framesWritten)
-			}
+		if len(sample) > maxHeight {
+			maxHeight = len(sample)
+		}
+		if err := columnWriter.Write(sample); err != nil {
+			return err
+		}
+		framesWritten++
+		totalNumbersWritten += len(sample)
+		if framesWritten%10000 == 0 {
+			fmt.Printf("Written frame %d\n", framesWritten)
 		}
 	}
 	fmt.Printf("Result: %d numbers written, %d by %d\n", totalNumbersWritten, framesWritten, maxHeight)
+	return nil
 }
@@ -0,0 +1,72 @@
+// go run writefeatures.go [--frame=2048] [--hop=512] <input> [<output.csv|output.json>]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/padster/go-sound/features"
+	f "github.com/padster/go-sound/file"
+	s "github.com/padster/go-sound/sounds"
+)
+
+// Extracts frame-based spectral and timbral features, and writes them to file.
+func main() {
+	// Parse flags...
+	sampleRate := s.CyclesPerSecond
+	frameSize := flag.Int("frame", 2048, "Frame size in samples")
+	hopSize := flag.Int("hop", 512, "Samples between the start of each frame")
+	mfccs := flag.Int("mfcc", 13, "Number of MFCC coefficients, 0 to skip")
+	flag.Parse()
+
+	remainingArgs := flag.Args()
+	if len(remainingArgs) < 1 || len(remainingArgs) > 2 {
+		panic("Required: <input> [<output>] filename arguments")
+	}
+	inputFile := remainingArgs[0]
+	outputFile := "out.features.csv"
+	if len(remainingArgs) == 2 {
+		outputFile = remainingArgs[1]
+	}
+
+	extractors := []features.Extractor{
+		features.NewRMS(),
+		features.NewZeroCrossingRate(),
+		features.NewSpectralCentroid(),
+		features.NewSpectralSpread(),
+		features.NewSpectralFlatness(),
+		features.NewSpectralRolloff(0.85),
+		features.NewSpectralFlux(),
+	}
+	if *mfccs > 0 {
+		extractors = append(extractors, features.NewMFCC(2**mfccs, *mfccs, 0, sampleRate/2))
+	}
+	fe := features.NewFeatureExtractor(*frameSize, *hopSize, sampleRate, extractors...)
+
+	inputSound := f.Read(inputFile)
+	inputSound.Start()
+	defer inputSound.Stop()
+
+	file, err := os.Create(outputFile)
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+
+	startTime := time.Now()
+	rows := fe.ProcessChannel(inputSound.GetSamples())
+	if strings.HasSuffix(outputFile, ".json") {
+		err = features.WriteFeaturesJSON(file, fe, rows)
+	} else {
+		err = features.WriteFeaturesCSV(file, fe, rows)
+	}
+	if err != nil {
+		panic(err)
+	}
+
+	elapsedSeconds := time.Since(startTime).Seconds()
+	fmt.Printf("elapsed time (not counting init): %f sec\n", elapsedSeconds)
+}